@@ -0,0 +1,60 @@
+package audit
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/pkg/errors"
+)
+
+const (
+	RunStarted  = "run_started"
+	RowWritten  = "row_written"
+	RunFinished = "run_finished"
+
+	OutcomeRotated = "rotated"
+	OutcomeFailed  = "failed"
+)
+
+// Entry is a single line of the audit log. Every entry carries the MAC of
+// the entry before it, and its own MAC covers all of its other fields, so
+// editing, reordering or removing a line breaks the chain.
+type Entry struct {
+	Sequence    uint64            `json:"seq"`
+	Type        string            `json:"type"`
+	RunID       string            `json:"run_id"`
+	Timestamp   string            `json:"timestamp"`
+	Target      string            `json:"target,omitempty"`
+	Table       string            `json:"table,omitempty"`
+	RowKey      map[string]string `json:"row_key,omitempty"`
+	ZoneID      string            `json:"zone_id,omitempty"`
+	SourceLabel string            `json:"source_label,omitempty"`
+	TargetLabel string            `json:"target_label,omitempty"`
+	Outcome     string            `json:"outcome,omitempty"`
+	Error       string            `json:"error,omitempty"`
+	Rows        uint64            `json:"rows,omitempty"`
+	PrevMAC     string            `json:"prev_mac"`
+	MAC         string            `json:"mac,omitempty"`
+}
+
+func (e Entry) computeMAC(key []byte) (string, error) {
+	e.MAC = ""
+	content, err := json.Marshal(e)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to serialize audit entry")
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(content)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+func NewRunID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", errors.Wrap(err, "unable to generate run id")
+	}
+	return hex.EncodeToString(id), nil
+}
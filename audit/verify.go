@@ -0,0 +1,94 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+)
+
+const maxEntryLength = 1 << 20
+
+type Summary struct {
+	Entries uint64
+	Runs    uint64
+	Rows    uint64
+	HeadMAC string
+	// IncompleteRuns lists runs with no run_finished entry, either because
+	// the rotator was interrupted or because the log was truncated.
+	IncompleteRuns []string
+}
+
+// Verify reads an audit log and checks every entry's MAC and its link to
+// the previous entry. It stops at the first broken link.
+func Verify(reader io.Reader, key []byte) (Summary, error) {
+	summary := Summary{}
+	runRows := map[string]uint64{}
+	var openRuns []string
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxEntryLength)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		lineNumber := summary.Entries + 1
+
+		var entry Entry
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&entry); err != nil {
+			return summary, errors.Wrapf(err, "line %d: malformed audit entry", lineNumber)
+		}
+
+		if entry.Sequence != lineNumber {
+			return summary, errors.Errorf("line %d: expected sequence %d but was %d", lineNumber, lineNumber, entry.Sequence)
+		}
+		if entry.PrevMAC != summary.HeadMAC {
+			return summary, errors.Errorf("line %d: chain broken, previous mac does not match", lineNumber)
+		}
+
+		expectedMAC, err := entry.computeMAC(key)
+		if err != nil {
+			return summary, err
+		}
+		if !hmac.Equal([]byte(expectedMAC), []byte(entry.MAC)) {
+			return summary, errors.Errorf("line %d: mac does not match entry contents", lineNumber)
+		}
+
+		switch entry.Type {
+		case RunStarted:
+			summary.Runs++
+			runRows[entry.RunID] = 0
+			openRuns = append(openRuns, entry.RunID)
+		case RowWritten:
+			summary.Rows++
+			runRows[entry.RunID]++
+		case RunFinished:
+			if entry.Rows != runRows[entry.RunID] {
+				return summary, errors.Errorf("line %d: run %s recorded %d rows but %d are present", lineNumber, entry.RunID, entry.Rows, runRows[entry.RunID])
+			}
+			openRuns = removeRun(openRuns, entry.RunID)
+		default:
+			return summary, errors.Errorf("line %d: unknown entry type '%s'", lineNumber, entry.Type)
+		}
+
+		summary.Entries++
+		summary.HeadMAC = entry.MAC
+	}
+	if err := scanner.Err(); err != nil {
+		return summary, errors.Wrap(err, "unable to read audit log")
+	}
+
+	summary.IncompleteRuns = openRuns
+	return summary, nil
+}
+
+func removeRun(runs []string, runID string) []string {
+	for i, run := range runs {
+		if run == runID {
+			return append(runs[:i], runs[i+1:]...)
+		}
+	}
+	return runs
+}
@@ -0,0 +1,103 @@
+package audit_test
+
+import (
+	"bytes"
+	. "github.com/cloudfoundry/uaa-key-rotator/audit"
+	"github.com/cloudfoundry/uaa-key-rotator/entity"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var _ = Describe("Verify", func() {
+	var (
+		tempDir string
+		key     []byte
+		lines   []string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "audit")
+		Expect(err).NotTo(HaveOccurred())
+		key = []byte("some-hmac-key")
+
+		auditPath := filepath.Join(tempDir, "audit.jsonl")
+		writer, err := Open(auditPath, key, "run-1")
+		Expect(err).NotTo(HaveOccurred())
+		for _, userID := range []string{"user-1", "user-2", "user-3"} {
+			credential := entity.MfaCredential{UserId: userID, EncryptionKeyLabel: "old-key"}
+			Expect(writer.Record("user_google_mfa_credentials", credential, credential, nil)).To(Succeed())
+		}
+		Expect(writer.Close()).To(Succeed())
+
+		content, err := ioutil.ReadFile(auditPath)
+		Expect(err).NotTo(HaveOccurred())
+		lines = strings.Split(strings.TrimSpace(string(content)), "\n")
+		Expect(lines).To(HaveLen(5))
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tempDir)
+	})
+
+	verify := func(lines []string) (Summary, error) {
+		return Verify(bytes.NewBufferString(strings.Join(lines, "\n")+"\n"), key)
+	}
+
+	It("accepts an untouched log", func() {
+		summary, err := verify(lines)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(summary.Entries).To(BeEquivalentTo(5))
+		Expect(summary.Runs).To(BeEquivalentTo(1))
+		Expect(summary.Rows).To(BeEquivalentTo(3))
+		Expect(summary.IncompleteRuns).To(BeEmpty())
+	})
+
+	It("accepts an empty log", func() {
+		summary, err := Verify(bytes.NewBuffer(nil), key)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(summary.Entries).To(BeZero())
+	})
+
+	It("rejects the log with a different key", func() {
+		key = []byte("some-other-key")
+		_, err := verify(lines)
+		Expect(err).To(MatchError("line 1: mac does not match entry contents"))
+	})
+
+	table.DescribeTable("detecting tampering",
+		func(tamper func([]string) []string, expectedError string) {
+			_, err := verify(tamper(append([]string(nil), lines...)))
+			Expect(err).To(MatchError(expectedError))
+		},
+		table.Entry("an edited field", func(l []string) []string {
+			l[2] = strings.Replace(l[2], "user-2", "user-9", 1)
+			return l
+		}, "line 3: mac does not match entry contents"),
+		table.Entry("a removed entry", func(l []string) []string {
+			return append(l[:2], l[3:]...)
+		}, "line 3: expected sequence 3 but was 4"),
+		table.Entry("reordered entries", func(l []string) []string {
+			l[1], l[2] = l[2], l[1]
+			return l
+		}, "line 2: expected sequence 2 but was 3"),
+		table.Entry("an added field", func(l []string) []string {
+			l[1] = strings.Replace(l[1], `{"seq"`, `{"note":"x","seq"`, 1)
+			return l
+		}, `line 2: malformed audit entry: json: unknown field "note"`),
+		table.Entry("a forged run_finished", func(l []string) []string {
+			return append(l[:3], strings.Replace(l[4], `"seq":5`, `"seq":4`, 1))
+		}, "line 4: chain broken, previous mac does not match"),
+	)
+
+	It("reports runs truncated from the end", func() {
+		summary, err := verify(lines[:3])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(summary.IncompleteRuns).To(ConsistOf("run-1"))
+	})
+})
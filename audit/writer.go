@@ -0,0 +1,144 @@
+package audit
+
+import (
+	"encoding/json"
+	"github.com/cloudfoundry/uaa-key-rotator/entity"
+	"github.com/pkg/errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// Writer appends entries to an audit log, continuing the chain of any
+// entries already in the file. Each entry is synced to disk before Record
+// returns.
+type Writer struct {
+	RunID string
+
+	key      []byte
+	file     *os.File
+	mutex    sync.Mutex
+	sequence uint64
+	headMAC  string
+	rows     uint64
+	now      func() time.Time
+}
+
+// Open verifies an existing audit log before appending to it, so that a
+// tampered log is never extended. A run_started entry is written for runID.
+func Open(path string, key []byte, runID string) (*Writer, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open audit log %s", path)
+	}
+
+	summary, err := Verify(file, key)
+	if err != nil {
+		file.Close()
+		return nil, errors.Wrapf(err, "refusing to append to audit log %s", path)
+	}
+
+	writer := &Writer{
+		RunID:    runID,
+		key:      key,
+		file:     file,
+		sequence: summary.Entries,
+		headMAC:  summary.HeadMAC,
+		now:      time.Now,
+	}
+
+	if err = writer.append(Entry{Type: RunStarted}); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return writer, nil
+}
+
+// Record logs the outcome of writing a rotated credential. writeErr is the
+// error returned by the updater, if any.
+func (w *Writer) Record(table string, source entity.MfaCredential, rotated entity.MfaCredential, writeErr error) error {
+	return w.RecordTarget("", table, source, rotated, writeErr)
+}
+
+// RecordTarget is Record for a row of one of several databases rotated in
+// the same run, which target names.
+func (w *Writer) RecordTarget(target string, table string, source entity.MfaCredential, rotated entity.MfaCredential, writeErr error) error {
+	entry := Entry{
+		Type:   RowWritten,
+		Target: target,
+		Table:  table,
+		RowKey: map[string]string{
+			"user_id":         source.UserId,
+			"mfa_provider_id": string(source.MfaProviderId),
+		},
+		ZoneID:      string(source.ZoneId),
+		SourceLabel: source.EncryptionKeyLabel,
+		TargetLabel: rotated.EncryptionKeyLabel,
+		Outcome:     OutcomeRotated,
+	}
+	if writeErr != nil {
+		entry.Outcome = OutcomeFailed
+		entry.Error = writeErr.Error()
+	}
+	return w.append(entry)
+}
+
+// Close writes the run_finished entry and closes the file.
+func (w *Writer) Close() error {
+	err := w.append(Entry{Type: RunFinished})
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// HeadMAC returns the MAC of the last entry written. Once the writer is
+// closed, it is the MAC a later `audit verify -head` expects the log to end
+// at, unless another run appends to it.
+func (w *Writer) HeadMAC() string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.headMAC
+}
+
+func (w *Writer) append(entry Entry) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if entry.Type == RunFinished {
+		entry.Rows = w.rows
+	}
+
+	entry.Sequence = w.sequence + 1
+	entry.RunID = w.RunID
+	entry.Timestamp = w.now().UTC().Format(time.RFC3339Nano)
+	entry.PrevMAC = w.headMAC
+
+	mac, err := entry.computeMAC(w.key)
+	if err != nil {
+		return err
+	}
+	entry.MAC = mac
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "unable to serialize audit entry")
+	}
+
+	if _, err = w.file.Write(append(line, '\n')); err != nil {
+		return errors.Wrap(err, "unable to write audit entry")
+	}
+	if err = w.file.Sync(); err != nil {
+		return errors.Wrap(err, "unable to sync audit log")
+	}
+
+	w.sequence = entry.Sequence
+	w.headMAC = mac
+	if entry.Type == RowWritten {
+		w.rows++
+	}
+	return nil
+}
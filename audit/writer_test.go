@@ -0,0 +1,173 @@
+package audit_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	. "github.com/cloudfoundry/uaa-key-rotator/audit"
+	"github.com/cloudfoundry/uaa-key-rotator/entity"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var _ = Describe("Writer", func() {
+	var (
+		tempDir   string
+		auditPath string
+		key       []byte
+		source    entity.MfaCredential
+		rotated   entity.MfaCredential
+	)
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "audit")
+		Expect(err).NotTo(HaveOccurred())
+		auditPath = filepath.Join(tempDir, "audit.jsonl")
+		key = []byte("some-hmac-key")
+
+		source = entity.MfaCredential{
+			UserId:             "some-user-id",
+			MfaProviderId:      "some-provider-id",
+			ZoneId:             "some-zone-id",
+			EncryptionKeyLabel: "old-key",
+			SecretKey:          "some-secret-ciphertext",
+		}
+		rotated = source
+		rotated.EncryptionKeyLabel = "active-key"
+		rotated.SecretKey = "some-rotated-ciphertext"
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tempDir)
+	})
+
+	readEntries := func() []Entry {
+		content, err := ioutil.ReadFile(auditPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		var entries []Entry
+		for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+			var entry Entry
+			Expect(json.Unmarshal([]byte(line), &entry)).To(Succeed())
+			entries = append(entries, entry)
+		}
+		return entries
+	}
+
+	writeRun := func(runID string, outcomes ...error) {
+		writer, err := Open(auditPath, key, runID)
+		Expect(err).NotTo(HaveOccurred())
+		for _, outcome := range outcomes {
+			Expect(writer.Record("user_google_mfa_credentials", source, rotated, outcome)).To(Succeed())
+		}
+		Expect(writer.Close()).To(Succeed())
+	}
+
+	It("records one entry per row between run markers", func() {
+		writeRun("run-1", nil, errors.New("some write error"))
+
+		entries := readEntries()
+		Expect(entries).To(HaveLen(4))
+
+		Expect(entries[0].Type).To(Equal(RunStarted))
+		Expect(entries[0].RunID).To(Equal("run-1"))
+		Expect(entries[0].PrevMAC).To(BeEmpty())
+
+		Expect(entries[1].Type).To(Equal(RowWritten))
+		Expect(entries[1].Table).To(Equal("user_google_mfa_credentials"))
+		Expect(entries[1].RowKey).To(Equal(map[string]string{
+			"user_id":         "some-user-id",
+			"mfa_provider_id": "some-provider-id",
+		}))
+		Expect(entries[1].ZoneID).To(Equal("some-zone-id"))
+		Expect(entries[1].SourceLabel).To(Equal("old-key"))
+		Expect(entries[1].TargetLabel).To(Equal("active-key"))
+		Expect(entries[1].Outcome).To(Equal(OutcomeRotated))
+		Expect(entries[1].Timestamp).NotTo(BeEmpty())
+
+		Expect(entries[2].Outcome).To(Equal(OutcomeFailed))
+		Expect(entries[2].Error).To(Equal("some write error"))
+
+		Expect(entries[3].Type).To(Equal(RunFinished))
+		Expect(entries[3].Rows).To(BeEquivalentTo(2))
+
+		for i, entry := range entries {
+			Expect(entry.Sequence).To(BeEquivalentTo(i + 1))
+			if i > 0 {
+				Expect(entry.PrevMAC).To(Equal(entries[i-1].MAC))
+			}
+		}
+	})
+
+	It("records the target of a row from one of several databases", func() {
+		writer, err := Open(auditPath, key, "run-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(writer.RecordTarget("uaa-east", "user_google_mfa_credentials", source, rotated, nil)).To(Succeed())
+		Expect(writer.Close()).To(Succeed())
+
+		entries := readEntries()
+		Expect(entries[1].Target).To(Equal("uaa-east"))
+
+		auditFile, err := os.Open(auditPath)
+		Expect(err).NotTo(HaveOccurred())
+		defer auditFile.Close()
+
+		_, err = Verify(auditFile, key)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("never records credential values", func() {
+		writeRun("run-1", nil)
+
+		content, err := ioutil.ReadFile(auditPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).NotTo(ContainSubstring("ciphertext"))
+		Expect(string(content)).NotTo(ContainSubstring("some-hmac-key"))
+	})
+
+	It("continues the chain across runs", func() {
+		writeRun("run-1", nil)
+		writeRun("run-2", nil, nil)
+
+		entries := readEntries()
+		Expect(entries).To(HaveLen(7))
+		Expect(entries[3].RunID).To(Equal("run-2"))
+		Expect(entries[3].PrevMAC).To(Equal(entries[2].MAC))
+
+		auditFile, err := os.Open(auditPath)
+		Expect(err).NotTo(HaveOccurred())
+		defer auditFile.Close()
+
+		summary, err := Verify(auditFile, key)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(summary.Runs).To(BeEquivalentTo(2))
+		Expect(summary.Rows).To(BeEquivalentTo(3))
+		Expect(summary.HeadMAC).To(Equal(entries[6].MAC))
+	})
+
+	It("refuses to append to a tampered log", func() {
+		writeRun("run-1", nil)
+
+		content, err := ioutil.ReadFile(auditPath)
+		Expect(err).NotTo(HaveOccurred())
+		tampered := bytes.Replace(content, []byte("old-key"), []byte("new-key"), 1)
+		Expect(ioutil.WriteFile(auditPath, tampered, 0600)).To(Succeed())
+
+		_, err = Open(auditPath, key, "run-2")
+		Expect(err).To(MatchError(ContainSubstring("refusing to append to audit log")))
+
+		after, err := ioutil.ReadFile(auditPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(after).To(Equal(tampered))
+	})
+
+	It("fails when the log cannot be opened", func() {
+		_, err := Open(filepath.Join(tempDir, "missing", "audit.jsonl"), key, "run-1")
+		Expect(err).To(MatchError(ContainSubstring("unable to open audit log")))
+	})
+})
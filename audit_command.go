@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/cloudfoundry/uaa-key-rotator/audit"
+	"github.com/cloudfoundry/uaa-key-rotator/config"
+	"io"
+	"os"
+)
+
+const auditUsage = `usage: uaa-key-rotator audit verify -config <path> [-file <audit log>] [-head <mac>] [-allow-incomplete]`
+
+// auditCommand implements "audit verify", which checks the HMAC chain of an
+// audit log. -head additionally checks the log ends at a MAC recorded
+// elsewhere, which detects truncation of whole runs from the end. A run with
+// no run_finished entry fails verification unless -allow-incomplete is set,
+// as the log may have been truncated within it.
+func auditCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 || args[0] != "verify" {
+		fmt.Fprintln(stderr, auditUsage)
+		return 2
+	}
+
+	flags := flag.NewFlagSet("audit verify", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := flags.String("config", "", "Path to uaa key rotator config file")
+	auditPath := flags.String("file", "", "Path to the audit log (defaults to audit.file in the config)")
+	expectedHead := flags.String("head", "", "MAC the last entry is expected to have, as logged and reported when the last run finished")
+	allowIncomplete := flags.Bool("allow-incomplete", false, "Pass a log with runs that have no run_finished entry, such as runs that were killed")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	configFile, err := os.Open(*configPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to open config: %s\n", err)
+		return 1
+	}
+	defer configFile.Close()
+
+	rotatorConfig, err := config.New(configFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to parse config: %s\n", err)
+		return 1
+	}
+	rotatorConfig.WipePassphrases()
+
+	if *auditPath == "" {
+		*auditPath = rotatorConfig.Audit.File
+	}
+	if *auditPath == "" || rotatorConfig.Audit.HMACKey == "" {
+		fmt.Fprintln(stderr, "audit log file and audit.hmacKey must be configured")
+		return 1
+	}
+
+	auditFile, err := os.Open(*auditPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to open audit log: %s\n", err)
+		return 1
+	}
+	defer auditFile.Close()
+
+	summary, err := audit.Verify(auditFile, []byte(rotatorConfig.Audit.HMACKey))
+	if err != nil {
+		fmt.Fprintf(stderr, "audit log verification FAILED: %s\n", err)
+		return 1
+	}
+
+	if *expectedHead != "" && *expectedHead != summary.HeadMAC {
+		fmt.Fprintf(stderr, "audit log verification FAILED: last mac is %s but %s was expected; the log has been truncated\n", summary.HeadMAC, *expectedHead)
+		return 1
+	}
+
+	if len(summary.IncompleteRuns) > 0 && !*allowIncomplete {
+		for _, runID := range summary.IncompleteRuns {
+			fmt.Fprintf(stderr, "audit log verification FAILED: run %s has no run_finished entry; it was interrupted or the log was truncated\n", runID)
+		}
+		fmt.Fprintln(stderr, "pass -allow-incomplete if those runs are known to have been interrupted")
+		return 1
+	}
+	for _, runID := range summary.IncompleteRuns {
+		fmt.Fprintf(stdout, "warning: run %s has no run_finished entry; it was interrupted or the log was truncated\n", runID)
+	}
+	fmt.Fprintf(stdout, "audit log OK: %d entries, %d runs, %d rows\n", summary.Entries, summary.Runs, summary.Rows)
+	fmt.Fprintf(stdout, "head mac: %s\n", summary.HeadMAC)
+	return 0
+}
@@ -0,0 +1,97 @@
+package main_test
+
+import (
+	"encoding/json"
+	"github.com/cloudfoundry/uaa-key-rotator/audit"
+	"github.com/cloudfoundry/uaa-key-rotator/config"
+	"github.com/cloudfoundry/uaa-key-rotator/entity"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+var _ = Describe("Audit command", func() {
+	var configDir, configPath, auditPath string
+	hmacKey := []byte("some-hmac-key")
+
+	writeRun := func(runID string, finished bool) string {
+		writer, err := audit.Open(auditPath, hmacKey, runID)
+		Expect(err).NotTo(HaveOccurred())
+		credential := entity.MfaCredential{UserId: "user-1", EncryptionKeyLabel: "old-key"}
+		Expect(writer.Record("user_google_mfa_credentials", credential, credential, nil)).To(Succeed())
+		if finished {
+			Expect(writer.Close()).To(Succeed())
+		}
+		return writer.HeadMAC()
+	}
+
+	BeforeEach(func() {
+		var err error
+		configDir, err = ioutil.TempDir(os.TempDir(), "rotator_config")
+		Expect(err).NotTo(HaveOccurred())
+		configPath = filepath.Join(configDir, "config.json")
+		auditPath = filepath.Join(configDir, "audit.jsonl")
+
+		jsonConfig, err := json.Marshal(config.RotatorConfig{
+			ActiveKeyLabel:   oldKey.Label,
+			EncryptionKeys:   []config.EncryptionKey{oldKey},
+			DatabaseHostname: "unused",
+			DatabasePort:     "1",
+			DatabaseScheme:   "postgres",
+			DatabaseName:     "uaa",
+			DatabaseUsername: "uaa",
+			Audit:            config.AuditConfig{File: auditPath, HMACKey: string(hmacKey)},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ioutil.WriteFile(configPath, jsonConfig, 0600)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(configDir)
+	})
+
+	run := func(args ...string) *gexec.Session {
+		session, err := gexec.Start(exec.Command(uaaRotatorBuildPath, append([]string{"audit", "verify", "-config", configPath}, args...)...), GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(session, 10).Should(gexec.Exit())
+		return session
+	}
+
+	It("should pass a log whose runs all finished, and print its head mac", func() {
+		headMAC := writeRun("run-1", true)
+
+		session := run("-head", headMAC)
+		Expect(session.ExitCode()).To(Equal(0))
+		Expect(session.Out).To(gbytes.Say("head mac: %s", headMAC))
+	})
+
+	It("should fail a log truncated at a run boundary given the head mac", func() {
+		writeRun("run-1", true)
+		content, err := ioutil.ReadFile(auditPath)
+		Expect(err).NotTo(HaveOccurred())
+		headMAC := writeRun("run-2", true)
+		Expect(ioutil.WriteFile(auditPath, content, 0600)).To(Succeed())
+
+		session := run("-head", headMAC)
+		Expect(session.ExitCode()).To(Equal(1))
+		Expect(session.Err).To(gbytes.Say("the log has been truncated"))
+	})
+
+	It("should fail a log with an incomplete run unless allowed", func() {
+		writeRun("run-1", true)
+		writeRun("run-2", false)
+
+		session := run()
+		Expect(session.ExitCode()).To(Equal(1))
+		Expect(session.Err).To(gbytes.Say("run run-2 has no run_finished entry"))
+
+		session = run("-allow-incomplete")
+		Expect(session.ExitCode()).To(Equal(0))
+		Expect(session.Out).To(gbytes.Say("warning: run run-2 has no run_finished entry"))
+	})
+})
@@ -0,0 +1,152 @@
+package backup_test
+
+import (
+	"database/sql"
+	. "github.com/cloudfoundry/uaa-key-rotator/backup"
+	"github.com/cloudfoundry/uaa-key-rotator/entity"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var _ = Describe("Backup", func() {
+	var (
+		tempDir    string
+		backupPath string
+		key        []byte
+		original   entity.MfaCredential
+		rotated    entity.MfaCredential
+	)
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "backup")
+		Expect(err).NotTo(HaveOccurred())
+		backupPath = filepath.Join(tempDir, "backup.enc")
+		key = []byte("some-backup-key")
+
+		original = entity.MfaCredential{
+			UserId:                  "some-user-id",
+			MfaProviderId:           "some-provider-id",
+			ZoneId:                  "some-zone-id",
+			EncryptionKeyLabel:      "old-key",
+			SecretKey:               "some-secret-ciphertext",
+			ScratchCodes:            sql.NullString{String: "some-scratch-codes-ciphertext", Valid: true},
+			ValidationCode:          sql.NullInt64{Int64: 123456, Valid: true},
+			EncryptedValidationCode: sql.NullString{},
+		}
+		rotated = original
+		rotated.EncryptionKeyLabel = "active-key"
+		rotated.SecretKey = "some-rotated-ciphertext"
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tempDir)
+	})
+
+	writeRun := func(runID string, rows int) {
+		writer, err := Open(backupPath, key, runID)
+		Expect(err).NotTo(HaveOccurred())
+		for i := 0; i < rows; i++ {
+			Expect(writer.Record("uaa-east", original, rotated)).To(Succeed())
+		}
+		Expect(writer.Close()).To(Succeed())
+	}
+
+	readEntries := func(key []byte) ([]Entry, error) {
+		backupFile, err := os.Open(backupPath)
+		Expect(err).NotTo(HaveOccurred())
+		defer backupFile.Close()
+		return Read(backupFile, key)
+	}
+
+	It("should read back the exact values it backed up", func() {
+		writeRun("run-1", 1)
+
+		entries, err := readEntries(key)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].RunID).To(Equal("run-1"))
+		Expect(entries[0].Target).To(Equal("uaa-east"))
+		Expect(entries[0].Timestamp).NotTo(BeEmpty())
+		Expect(entries[0].Original.Credential()).To(Equal(original))
+		Expect(entries[0].Rotated.Credential()).To(Equal(rotated))
+	})
+
+	It("should not hold the values in the clear", func() {
+		writeRun("run-1", 1)
+
+		content, err := ioutil.ReadFile(backupPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).NotTo(ContainSubstring("some-secret-ciphertext"))
+		Expect(string(content)).NotTo(ContainSubstring("some-user-id"))
+
+		info, err := os.Stat(backupPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+	})
+
+	It("should append later runs to the same file", func() {
+		writeRun("run-1", 2)
+		writeRun("run-2", 1)
+
+		entries, err := readEntries(key)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(3))
+		Expect(entries[2].RunID).To(Equal("run-2"))
+	})
+
+	It("should refuse a key the file was not written with", func() {
+		writeRun("run-1", 1)
+
+		_, err := Open(backupPath, []byte("some-other-key"), "run-2")
+		Expect(err).To(MatchError(ContainSubstring("the backup key does not match the key the file was written with")))
+
+		_, err = readEntries([]byte("some-other-key"))
+		Expect(err).To(MatchError("the backup key does not match the key the file was written with"))
+	})
+
+	It("should detect a tampered entry", func() {
+		writeRun("run-1", 2)
+
+		content, err := ioutil.ReadFile(backupPath)
+		Expect(err).NotTo(HaveOccurred())
+		lines := strings.Split(string(content), "\n")
+		lines[2] = "A" + lines[2][1:]
+		if lines[2] == strings.Split(string(content), "\n")[2] {
+			lines[2] = "B" + lines[2][1:]
+		}
+		Expect(ioutil.WriteFile(backupPath, []byte(strings.Join(lines, "\n")), 0600)).To(Succeed())
+
+		_, err = readEntries(key)
+		Expect(err).To(MatchError(HavePrefix("backup line 3")))
+	})
+
+	It("should keep only the last entry of each row of a run", func() {
+		entries := []Entry{
+			{RunID: "run-1", Original: Row{UserID: "user-1", SecretKey: "first-claim"}},
+			{RunID: "run-1", Original: Row{UserID: "user-2"}},
+			{RunID: "run-1", Target: "uaa-west", Original: Row{UserID: "user-1"}},
+			{RunID: "run-1", Original: Row{UserID: "user-1", SecretKey: "second-claim"}},
+			{RunID: "run-2", Original: Row{UserID: "user-1"}},
+		}
+		Expect(Latest(entries)).To(Equal([]Entry{entries[1], entries[2], entries[3], entries[4]}))
+	})
+
+	Describe("Filter", func() {
+		entry := Entry{RunID: "run-1", Target: "uaa-east", Original: Row{ZoneID: "zone-1"}}
+
+		It("should match every entry when empty", func() {
+			Expect(Filter{}.Matches(entry)).To(BeTrue())
+		})
+
+		It("should match any of the values of each field", func() {
+			Expect(Filter{RunIDs: []string{"run-2", "run-1"}, ZoneIDs: []string{"zone-1"}}.Matches(entry)).To(BeTrue())
+			Expect(Filter{RunIDs: []string{"run-1"}, ZoneIDs: []string{"zone-2"}}.Matches(entry)).To(BeFalse())
+			Expect(Filter{Targets: []string{"uaa-west"}}.Matches(entry)).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,179 @@
+package backup
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/cloudfoundry/uaa-key-rotator/crypto"
+	"github.com/cloudfoundry/uaa-key-rotator/entity"
+	"github.com/pkg/errors"
+)
+
+// Entry backs up one row as it was before it was rotated, along with the
+// values it was rotated to, so that a restore can tell whether the row has
+// changed since.
+type Entry struct {
+	RunID     string `json:"run_id"`
+	Timestamp string `json:"timestamp"`
+	Target    string `json:"target,omitempty"`
+	Original  Row    `json:"original"`
+	Rotated   Row    `json:"rotated"`
+}
+
+// Row holds the columns of a user_google_mfa_credentials row. Nil fields
+// are NULL.
+type Row struct {
+	UserID                  string  `json:"user_id"`
+	MfaProviderID           string  `json:"mfa_provider_id"`
+	ZoneID                  string  `json:"zone_id"`
+	EncryptionKeyLabel      string  `json:"encryption_key_label"`
+	SecretKey               string  `json:"secret_key"`
+	ScratchCodes            *string `json:"scratch_codes"`
+	EncryptedValidationCode *string `json:"encrypted_validation_code"`
+	ValidationCode          *int64  `json:"validation_code"`
+}
+
+func NewRow(credential entity.MfaCredential) Row {
+	row := Row{
+		UserID:             credential.UserId,
+		MfaProviderID:      string(credential.MfaProviderId),
+		ZoneID:             string(credential.ZoneId),
+		EncryptionKeyLabel: credential.EncryptionKeyLabel,
+		SecretKey:          credential.SecretKey,
+	}
+	if credential.ScratchCodes.Valid {
+		row.ScratchCodes = &credential.ScratchCodes.String
+	}
+	if credential.EncryptedValidationCode.Valid {
+		row.EncryptedValidationCode = &credential.EncryptedValidationCode.String
+	}
+	if credential.ValidationCode.Valid {
+		row.ValidationCode = &credential.ValidationCode.Int64
+	}
+	return row
+}
+
+func (r Row) Credential() entity.MfaCredential {
+	credential := entity.MfaCredential{
+		UserId:             r.UserID,
+		MfaProviderId:      entity.Char(r.MfaProviderID),
+		ZoneId:             entity.Char(r.ZoneID),
+		EncryptionKeyLabel: r.EncryptionKeyLabel,
+		SecretKey:          r.SecretKey,
+	}
+	if r.ScratchCodes != nil {
+		credential.ScratchCodes = sql.NullString{String: *r.ScratchCodes, Valid: true}
+	}
+	if r.EncryptedValidationCode != nil {
+		credential.EncryptedValidationCode = sql.NullString{String: *r.EncryptedValidationCode, Valid: true}
+	}
+	if r.ValidationCode != nil {
+		credential.ValidationCode = sql.NullInt64{Int64: *r.ValidationCode, Valid: true}
+	}
+	return credential
+}
+
+const (
+	format  = "uaa-key-rotator-backup"
+	version = 1
+
+	// checkValue is sealed into the header, so that a wrong key is
+	// rejected before any entry is written or read.
+	checkValue = "uaa-key-rotator backup key check"
+)
+
+// header is the first line of a backup file, in the clear. Every other line
+// is an Entry sealed with AES-GCM, under a key derived from the operator's
+// key and the salt of the file.
+type header struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+	Salt    []byte `json:"salt"`
+	Check   string `json:"check"`
+}
+
+func newHeader(key []byte) (header, cipher.AEAD, error) {
+	salt, err := crypto.UaaSaltGenerator{}.GetSalt()
+	if err != nil {
+		return header{}, nil, errors.Wrap(err, "unable to generate a salt")
+	}
+
+	aead, err := newAEAD(salt, key)
+	if err != nil {
+		return header{}, nil, err
+	}
+
+	check, err := seal(aead, []byte(checkValue))
+	if err != nil {
+		return header{}, nil, err
+	}
+	return header{Format: format, Version: version, Salt: salt, Check: check}, aead, nil
+}
+
+// open checks the header was written with key, and returns the cipher for
+// the entries that follow it.
+func (h header) open(key []byte) (cipher.AEAD, error) {
+	if h.Format != format || h.Version != version {
+		return nil, errors.Errorf("not a version %d backup file", version)
+	}
+
+	aead, err := newAEAD(h.Salt, key)
+	if err != nil {
+		return nil, err
+	}
+
+	check, err := unseal(aead, h.Check)
+	if err != nil || string(check) != checkValue {
+		return nil, errors.New("the backup key does not match the key the file was written with")
+	}
+	return aead, nil
+}
+
+func newAEAD(salt []byte, key []byte) (cipher.AEAD, error) {
+	derivedKey := crypto.GenerateKey(salt, key)
+	defer crypto.Zero(derivedKey)
+
+	block, err := aes.NewCipher(derivedKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create backup cipher")
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal returns the nonce and ciphertext of plainText, base64 encoded.
+func seal(aead cipher.AEAD, plainText []byte) (string, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", errors.Wrap(err, "unable to generate a nonce")
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, plainText, nil)), nil
+}
+
+func unseal(aead cipher.AEAD, sealed string) ([]byte, error) {
+	content, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, errors.Wrap(err, "malformed entry")
+	}
+	if len(content) < aead.NonceSize() {
+		return nil, errors.New("malformed entry")
+	}
+
+	plainText, err := aead.Open(nil, content[:aead.NonceSize()], content[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, crypto.ErrAuthenticationFailed
+	}
+	return plainText, nil
+}
+
+func sealEntry(aead cipher.AEAD, entry Entry) (string, error) {
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to serialize backup entry")
+	}
+	defer crypto.Zero(content)
+
+	return seal(aead, content)
+}
@@ -0,0 +1,111 @@
+package backup
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/cloudfoundry/uaa-key-rotator/crypto"
+	"github.com/pkg/errors"
+	"io"
+	"strings"
+)
+
+// Read decrypts every entry of a backup file, in the order they were
+// written. It fails on the first entry that was not written with key, or
+// that has been tampered with.
+func Read(r io.Reader, key []byte) ([]Entry, error) {
+	reader := bufio.NewReader(r)
+	fileHeader, err := readHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := fileHeader.open(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNumber := 2; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		content, err := unseal(aead, line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "backup line %d", lineNumber)
+		}
+
+		var entry Entry
+		err = json.Unmarshal(content, &entry)
+		crypto.Zero(content)
+		if err != nil {
+			return nil, errors.Wrapf(err, "backup line %d: malformed entry", lineNumber)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "unable to read backup file")
+	}
+
+	return entries, nil
+}
+
+func readHeader(reader *bufio.Reader) (header, error) {
+	line, err := reader.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return header{}, errors.Wrap(err, "unable to read backup header")
+	}
+
+	var fileHeader header
+	if err := json.Unmarshal(line, &fileHeader); err != nil {
+		return header{}, errors.Wrap(err, "malformed backup header")
+	}
+	return fileHeader, nil
+}
+
+// Latest leaves out each entry that is followed by another of the same run,
+// target and user. A claiming rotator backs a row up again when it claims it
+// again after a rolled back batch, and only the last of its entries can have
+// been committed.
+func Latest(entries []Entry) []Entry {
+	type rowKey struct {
+		runID, target, userID string
+	}
+	last := map[rowKey]int{}
+	for i, entry := range entries {
+		last[rowKey{entry.RunID, entry.Target, entry.Original.UserID}] = i
+	}
+
+	var latest []Entry
+	for i, entry := range entries {
+		if last[rowKey{entry.RunID, entry.Target, entry.Original.UserID}] == i {
+			latest = append(latest, entry)
+		}
+	}
+	return latest
+}
+
+// Filter selects the entries to restore. Empty fields do not filter.
+type Filter struct {
+	RunIDs  []string
+	ZoneIDs []string
+	Targets []string
+}
+
+func (f Filter) Matches(entry Entry) bool {
+	return matches(f.RunIDs, entry.RunID) && matches(f.ZoneIDs, entry.Original.ZoneID) && matches(f.Targets, entry.Target)
+}
+
+func matches(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package backup
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"encoding/json"
+	"github.com/cloudfoundry/uaa-key-rotator/entity"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Writer appends entries to a backup file, keeping the header of any
+// entries already in it. Each entry is synced to disk before Record
+// returns, so a row is never updated before it is backed up.
+type Writer struct {
+	RunID string
+
+	aead  cipher.AEAD
+	file  *os.File
+	mutex sync.Mutex
+	now   func() time.Time
+}
+
+// Open starts a new backup file at path, or appends to an existing one
+// written with the same key.
+func Open(path string, key []byte, runID string) (*Writer, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open backup file %s", path)
+	}
+
+	aead, err := openOrWriteHeader(file, key)
+	if err != nil {
+		file.Close()
+		return nil, errors.Wrapf(err, "unable to open backup file %s", path)
+	}
+
+	return &Writer{
+		RunID: runID,
+		aead:  aead,
+		file:  file,
+		now:   time.Now,
+	}, nil
+}
+
+func openOrWriteHeader(file *os.File, key []byte) (cipher.AEAD, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Size() == 0 {
+		fileHeader, aead, err := newHeader(key)
+		if err != nil {
+			return nil, err
+		}
+		line, err := json.Marshal(fileHeader)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to serialize backup header")
+		}
+		if _, err = file.Write(append(line, '\n')); err != nil {
+			return nil, errors.Wrap(err, "unable to write backup header")
+		}
+		return aead, nil
+	}
+
+	fileHeader, err := readHeader(bufio.NewReader(io.NewSectionReader(file, 0, info.Size())))
+	if err != nil {
+		return nil, err
+	}
+	return fileHeader.open(key)
+}
+
+// Record backs up a row before it is updated from original to rotated.
+func (w *Writer) Record(target string, original entity.MfaCredential, rotated entity.MfaCredential) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	line, err := sealEntry(w.aead, Entry{
+		RunID:     w.RunID,
+		Timestamp: w.now().UTC().Format(time.RFC3339Nano),
+		Target:    target,
+		Original:  NewRow(original),
+		Rotated:   NewRow(rotated),
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err = w.file.Write([]byte(line + "\n")); err != nil {
+		return errors.Wrap(err, "unable to write backup entry")
+	}
+	if err = w.file.Sync(); err != nil {
+		return errors.Wrap(err, "unable to sync backup file")
+	}
+	return nil
+}
+
+func (w *Writer) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.file.Close()
+}
@@ -0,0 +1,136 @@
+package main_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/cloudfoundry/uaa-key-rotator/config"
+	db2 "github.com/cloudfoundry/uaa-key-rotator/db"
+	"github.com/cloudfoundry/uaa-key-rotator/db/testutils"
+	"github.com/cloudfoundry/uaa-key-rotator/runner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+// failingWritesQueryer fails every statement of its transactions that binds
+// userID, as a database refusing the write of that row would.
+type failingWritesQueryer struct {
+	db2.Queryer
+	userID string
+}
+
+func (q failingWritesQueryer) BeginTxx(ctx context.Context, opts *sql.TxOptions) (db2.Tx, error) {
+	tx, err := q.Queryer.BeginTxx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return failingWritesTx{Tx: tx, userID: q.userID}, nil
+}
+
+type failingWritesTx struct {
+	db2.Tx
+	userID string
+}
+
+func (t failingWritesTx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	for _, arg := range args {
+		if arg == t.userID {
+			return nil, errors.New("forced write failure")
+		}
+	}
+	return t.Tx.ExecContext(ctx, query, args...)
+}
+
+var _ = Describe("Claiming rotators", func() {
+	var (
+		userIDs []string
+		options runner.Options
+	)
+
+	BeforeEach(func() {
+		secretKey := encryptPlainText("secret-key", string(oldKey.Passphrase))
+
+		insertSQL, err := db2.RebindForSQLDialect(`insert into user_google_mfa_credentials(
+			user_id, secret_key, mfa_provider_id, zone_id, encryption_key_label) values(?, ?, ?, ?, ?)`, testutils.Scheme)
+		Expect(err).NotTo(HaveOccurred())
+
+		userIDs = nil
+		for i := 1; i <= 6; i++ {
+			userID := fmt.Sprintf("claim-user-%d", i)
+			_, err = db.Exec(insertSQL, userID, secretKey, "mfa_provider_id", "zone_id", oldKey.Label)
+			Expect(err).NotTo(HaveOccurred())
+			userIDs = append(userIDs, userID)
+		}
+
+		options = runner.Options{
+			Config: &config.RotatorConfig{
+				ActiveKeyLabel:   activeKey.Label,
+				EncryptionKeys:   []config.EncryptionKey{activeKey, oldKey},
+				DatabaseHostname: testutils.Hostname,
+				DatabaseName:     testutils.DBName,
+				DatabasePort:     testutils.Port,
+				DatabaseScheme:   testutils.Scheme,
+				DatabaseUsername: testutils.Username,
+				DatabasePassword: testutils.Password,
+			},
+			Queryer: failingWritesQueryer{
+				Queryer: db2.DbAwareQuerier{DB: db, DBScheme: testutils.Scheme},
+				userID:  "claim-user-2",
+			},
+			Claim:          true,
+			ClaimBatchSize: 2,
+		}
+		options.RowFilter.UserIDs = userIDs
+		options.RowFilter.Limit = 4
+	})
+
+	AfterEach(func() {
+		query, err := db2.RebindForSQLDialect(`delete from user_google_mfa_credentials where user_id like ?`, testutils.Scheme)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = db.Exec(query, "claim-user-%")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Context("when the failure budget stops the pass", func() {
+		BeforeEach(func() {
+			query, err := db2.RebindForSQLDialect(`update user_google_mfa_credentials set secret_key = ? where user_id = ?`, testutils.Scheme)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = db.Exec(query, encryptPlainText("secret-key", "not-the-old-passphrase"), "claim-user-1")
+			Expect(err).NotTo(HaveOccurred())
+
+			options.Queryer = db2.DbAwareQuerier{DB: db, DBScheme: testutils.Scheme}
+			options.Strict = true
+			options.ClaimBatchSize = 6
+			options.RowFilter.Limit = 0
+		})
+
+		It("should roll back the rest of the claimed batch", func() {
+			report, err := runner.Run(context.Background(), options)
+			Expect(err).To(MatchError(ContainSubstring("failure budget")))
+			Expect(report.Targets[0].RowsFailed).To(Equal(int64(1)))
+			Expect(report.Targets[0].RowsWritten).To(Equal(int64(0)))
+
+			query, err := db2.RebindForSQLDialect(`select count(*) from user_google_mfa_credentials
+				where user_id like ? and encryption_key_label = ?`, testutils.Scheme)
+			Expect(err).NotTo(HaveOccurred())
+			var rotated int
+			Expect(db.QueryRow(query, "claim-user-%", activeKey.Label).Scan(&rotated)).To(Succeed())
+			Expect(rotated).To(Equal(0))
+		})
+	})
+
+	It("should give the rows of a rolled back batch back to the limit", func() {
+		report, err := runner.Run(context.Background(), options)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Targets[0].RowsFailed).To(Equal(int64(1)))
+		Expect(report.Targets[0].RowsWritten).To(Equal(int64(3)))
+
+		query, err := db2.RebindForSQLDialect(`select count(*) from user_google_mfa_credentials
+			where user_id like ? and encryption_key_label = ?`, testutils.Scheme)
+		Expect(err).NotTo(HaveOccurred())
+		var rotated int
+		Expect(db.QueryRow(query, "claim-user-%", activeKey.Label).Scan(&rotated)).To(Succeed())
+		Expect(rotated).To(Equal(3))
+	})
+})
@@ -2,28 +2,254 @@ package config
 
 import (
 	"encoding/json"
+	"github.com/cloudfoundry/uaa-key-rotator/crypto"
 	"github.com/pkg/errors"
 	"gopkg.in/validator.v2"
 	"io"
 	"io/ioutil"
+	"time"
 )
 
 type EncryptionKey struct {
-	Label      string      `json:"label" validate:"nonzero"`
-	Passphrase json.Number `json:"passphrase" validate:"nonzero"`
+	Label      string     `json:"label" validate:"nonzero"`
+	Passphrase Passphrase `json:"passphrase" validate:"nonzero"`
 }
 
+// Passphrase accepts both JSON strings and numbers, as operators commonly
+// configure purely numeric passphrases without quoting them. It is held as
+// bytes so that it can be wiped once the rotator is finished with it.
+type Passphrase []byte
+
+func (p *Passphrase) UnmarshalJSON(data []byte) error {
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err == nil {
+		*p = Passphrase(number)
+		return nil
+	}
+
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+	*p = Passphrase(str)
+	return nil
+}
+
+func (p Passphrase) MarshalJSON() ([]byte, error) {
+	return json.Marshal(string(p))
+}
+
+func (p Passphrase) Wipe() {
+	crypto.Zero(p)
+}
+
+type KeyProviderConfig struct {
+	Type              string   `json:"type"`
+	URL               string   `json:"url"`
+	Token             string   `json:"token"`
+	CACert            string   `json:"caCert"`
+	ClientCert        string   `json:"clientCert"`
+	ClientKey         string   `json:"clientKey"`
+	SkipSSLValidation bool     `json:"skipSSLValidation"`
+	PathPrefix        string   `json:"pathPrefix"`
+	VaultMount        string   `json:"vaultMount"`
+	VaultKVVersion    int      `json:"vaultKvVersion"`
+	VaultField        string   `json:"vaultField"`
+	VaultNamespace    string   `json:"vaultNamespace"`
+	CacheTTL          string   `json:"cacheTTL"`
+	Labels            []string `json:"labels"`
+}
+
+type LoggingConfig struct {
+	Level         string `json:"level"`
+	Format        string `json:"format"`
+	Destination   string `json:"destination"`
+	File          string `json:"file"`
+	SyslogAddress string `json:"syslogAddress"`
+	SyslogTag     string `json:"syslogTag"`
+	HashUserIDs   bool   `json:"hashUserIds"`
+}
+
+// AuditConfig enables the audit log. HMACKey keys the chain of entries and
+// is needed again to verify the log.
+type AuditConfig struct {
+	File    string `json:"file"`
+	HMACKey string `json:"hmacKey"`
+}
+
+// BackupConfig enables the backup of the original values of each row
+// before it is rotated. Key encrypts the backup file and is needed again to
+// restore from it.
+type BackupConfig struct {
+	File string `json:"file"`
+	Key  string `json:"key"`
+}
+
+// RetryConfig bounds the retries of transient database errors. Durations are
+// Go durations such as "100ms", and fields left unset take their defaults.
+type RetryConfig struct {
+	MaxAttempts    int    `json:"maxAttempts"`
+	InitialBackoff string `json:"initialBackoff"`
+	MaxBackoff     string `json:"maxBackoff"`
+	StartupTimeout string `json:"startupTimeout"`
+}
+
+// Validate checks the retry options. It is called by New.
+func (c RetryConfig) Validate() error {
+	if c.MaxAttempts < 0 {
+		return errors.New("Retry.MaxAttempts: must not be negative")
+	}
+
+	for _, duration := range []struct{ name, value string }{
+		{"InitialBackoff", c.InitialBackoff},
+		{"MaxBackoff", c.MaxBackoff},
+		{"StartupTimeout", c.StartupTimeout},
+	} {
+		if duration.value == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(duration.value); err != nil {
+			return errors.Errorf("Retry.%s: %s", duration.name, err)
+		}
+	}
+
+	return nil
+}
+
+// FailureBudgetConfig stops a pass once too many of its rows fail. Each
+// limit is the most failures it allows, and is off when zero.
+// MaxFailurePercent applies to the last Window rows handled, once that many
+// have been.
+type FailureBudgetConfig struct {
+	MaxFailures            int     `json:"maxFailures"`
+	MaxFailurePercent      float64 `json:"maxFailurePercent"`
+	Window                 int     `json:"window"`
+	MaxConsecutiveFailures int     `json:"maxConsecutiveFailures"`
+}
+
+// Validate checks the failure budget. It is called by New.
+func (c FailureBudgetConfig) Validate() error {
+	switch {
+	case c.MaxFailures < 0:
+		return errors.New("FailureBudget.MaxFailures: must not be negative")
+	case c.MaxFailurePercent < 0 || c.MaxFailurePercent > 100:
+		return errors.New("FailureBudget.MaxFailurePercent: must be between 0 and 100")
+	case c.Window < 0:
+		return errors.New("FailureBudget.Window: must not be negative")
+	case c.MaxConsecutiveFailures < 0:
+		return errors.New("FailureBudget.MaxConsecutiveFailures: must not be negative")
+	}
+	return nil
+}
+
+const (
+	LogLevelDebug = "debug"
+	LogLevelInfo  = "info"
+	LogLevelError = "error"
+	LogLevelFatal = "fatal"
+
+	LogFormatLager   = "lager"
+	LogFormatRFC3339 = "rfc3339"
+	LogFormatText    = "text"
+
+	LogDestinationStdout = "stdout"
+	LogDestinationFile   = "file"
+	LogDestinationSyslog = "syslog"
+)
+
+// Validate defaults any unset logging options and checks the rest. It is
+// called by New, and again by callers that override options from flags.
+func (c *LoggingConfig) Validate() error {
+	if c.Level == "" {
+		c.Level = LogLevelInfo
+	}
+	if c.Format == "" {
+		c.Format = LogFormatLager
+	}
+	if c.Destination == "" {
+		c.Destination = LogDestinationStdout
+	}
+
+	switch c.Level {
+	case LogLevelDebug, LogLevelInfo, LogLevelError, LogLevelFatal:
+	default:
+		return errors.Errorf("Logging.Level: unknown log level '%s'", c.Level)
+	}
+
+	switch c.Format {
+	case LogFormatLager, LogFormatRFC3339, LogFormatText:
+	default:
+		return errors.Errorf("Logging.Format: unknown log format '%s'", c.Format)
+	}
+
+	switch c.Destination {
+	case LogDestinationStdout, LogDestinationSyslog:
+	case LogDestinationFile:
+		if c.File == "" {
+			return errors.New("Logging.File: zero value")
+		}
+	default:
+		return errors.Errorf("Logging.Destination: unknown log destination '%s'", c.Destination)
+	}
+
+	return nil
+}
+
+const (
+	StaticKeyProvider  = "static"
+	CredHubKeyProvider = "credhub"
+	VaultKeyProvider   = "vault"
+)
+
 type RotatorConfig struct {
-	ActiveKeyLabel            string          `json:"activeKeyLabel" validate:"nonzero"`
-	EncryptionKeys            []EncryptionKey `json:"encryptionKeys" validate:"nonzero"`
-	DatabaseHostname          string          `json:"databaseHostname" validate:"nonzero"`
-	DatabasePort              string          `json:"databasePort" validate:"nonzero"`
-	DatabaseScheme            string          `json:"databaseScheme" validate:"nonzero"`
-	DatabaseName              string          `json:"databaseName" validate:"nonzero"`
-	DatabaseUsername          string          `json:"databaseUsername" validate:"nonzero"`
-	DatabasePassword          string          `json:"databasePassword"`
-	DatabaseTlsEnabled        bool            `json:"databaseTlsEnabled"`
-	DatabaseSkipSSLValidation bool            `json:"databaseSkipSSLValidation"`
+	ActiveKeyLabel            string              `json:"activeKeyLabel" validate:"nonzero"`
+	EncryptionKeys            []EncryptionKey     `json:"encryptionKeys"`
+	KeyProvider               KeyProviderConfig   `json:"keyProvider"`
+	Logging                   LoggingConfig       `json:"logging"`
+	Audit                     AuditConfig         `json:"audit"`
+	Backup                    BackupConfig        `json:"backup"`
+	Retry                     RetryConfig         `json:"retry"`
+	FailureBudget             FailureBudgetConfig `json:"failureBudget"`
+	DatabaseHostname          string              `json:"databaseHostname" validate:"nonzero"`
+	DatabasePort              string              `json:"databasePort" validate:"nonzero"`
+	DatabaseScheme            string              `json:"databaseScheme" validate:"nonzero"`
+	DatabaseName              string              `json:"databaseName" validate:"nonzero"`
+	DatabaseUsername          string              `json:"databaseUsername" validate:"nonzero"`
+	DatabasePassword          string              `json:"databasePassword"`
+	DatabaseTlsEnabled        bool                `json:"databaseTlsEnabled"`
+	DatabaseSkipSSLValidation bool                `json:"databaseSkipSSLValidation"`
+	DatabaseMaxOpenConns      int                 `json:"databaseMaxOpenConns"`
+	DatabaseMaxIdleConns      int                 `json:"databaseMaxIdleConns"`
+	DatabaseConnMaxLifetime   string              `json:"databaseConnMaxLifetime"`
+	DatabaseStatementTimeout  string              `json:"databaseStatementTimeout"`
+	Targets                   []TargetConfig      `json:"targets"`
+	Parallelism               int                 `json:"parallelism"`
+}
+
+// TargetConfig is one of several databases rotated from the same config.
+// Database settings a target leaves empty are taken from the top level of
+// the config. A target listing encryptionKeys or a keyProvider has a key set
+// of its own, and otherwise uses the top level one.
+type TargetConfig struct {
+	Name                      string             `json:"name"`
+	ActiveKeyLabel            string             `json:"activeKeyLabel"`
+	EncryptionKeys            []EncryptionKey    `json:"encryptionKeys"`
+	KeyProvider               *KeyProviderConfig `json:"keyProvider"`
+	DatabaseHostname          string             `json:"databaseHostname"`
+	DatabasePort              string             `json:"databasePort"`
+	DatabaseScheme            string             `json:"databaseScheme"`
+	DatabaseName              string             `json:"databaseName"`
+	DatabaseUsername          string             `json:"databaseUsername"`
+	DatabasePassword          string             `json:"databasePassword"`
+	DatabaseTlsEnabled        *bool              `json:"databaseTlsEnabled"`
+	DatabaseSkipSSLValidation *bool              `json:"databaseSkipSSLValidation"`
+}
+
+// Target is the complete config of one database to rotate. Name is empty
+// when the config does not list targets.
+type Target struct {
+	Name   string
+	Config *RotatorConfig
 }
 
 func New(rotatorConfigReader io.Reader) (*RotatorConfig, error) {
@@ -32,6 +258,7 @@ func New(rotatorConfigReader io.Reader) (*RotatorConfig, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "Unable to read config")
 	}
+	defer crypto.Zero(rotatorConfigContent)
 
 	rotatorConfig := &RotatorConfig{}
 	err = json.Unmarshal(rotatorConfigContent, rotatorConfig)
@@ -39,14 +266,226 @@ func New(rotatorConfigReader io.Reader) (*RotatorConfig, error) {
 		return nil, errors.Wrap(err, "Malformed JSON provided.")
 	}
 
-	err = validator.Validate(rotatorConfig)
+	if len(rotatorConfig.Targets) == 0 {
+		err = validateTarget(rotatorConfig)
+	} else {
+		err = validateTargets(rotatorConfig)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid config.")
+	}
+
+	err = rotatorConfig.Logging.Validate()
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid config.")
+	}
+
+	err = validateDatabasePool(rotatorConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid config.")
+	}
+
+	err = rotatorConfig.Retry.Validate()
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid config.")
+	}
+
+	err = rotatorConfig.FailureBudget.Validate()
 	if err != nil {
 		return nil, errors.Wrap(err, "Invalid config.")
 	}
 
+	if rotatorConfig.Audit.File != "" && rotatorConfig.Audit.HMACKey == "" {
+		return nil, errors.New("Invalid config.: Audit.HMACKey: zero value")
+	}
+
+	if rotatorConfig.Backup.File != "" && rotatorConfig.Backup.Key == "" {
+		return nil, errors.New("Invalid config.: Backup.Key: zero value")
+	}
+
 	if rotatorConfig.DatabaseScheme == "postgresql" {
 		rotatorConfig.DatabaseScheme = "postgres"
 	}
 
 	return rotatorConfig, nil
 }
+
+func validateTarget(rotatorConfig *RotatorConfig) error {
+	err := validator.Validate(rotatorConfig)
+	if err != nil {
+		return err
+	}
+
+	return validateKeyProvider(rotatorConfig)
+}
+
+// validateTargets validates each target as it will be rotated, with the
+// settings it takes from the top level filled in.
+func validateTargets(rotatorConfig *RotatorConfig) error {
+	if rotatorConfig.Parallelism < 0 {
+		return errors.New("Parallelism: must not be negative")
+	}
+
+	names := map[string]bool{}
+	for i, target := range rotatorConfig.Targets {
+		if target.Name == "" {
+			return errors.Errorf("Targets[%d].Name: zero value", i)
+		}
+		if names[target.Name] {
+			return errors.Errorf("Targets[%d].Name: duplicate target '%s'", i, target.Name)
+		}
+		names[target.Name] = true
+	}
+
+	for _, target := range rotatorConfig.ResolveTargets() {
+		err := validateTarget(target.Config)
+		target.Config.WipePassphrases()
+		if err != nil {
+			return errors.Wrapf(err, "Targets[%s]", target.Name)
+		}
+	}
+
+	return nil
+}
+
+// ResolveTargets returns the complete config of each database to rotate, in
+// the order they are listed. A config without targets is its own single,
+// unnamed target. Every target holds its own copy of its passphrases, to be
+// wiped once it has been rotated.
+func (c *RotatorConfig) ResolveTargets() []Target {
+	if len(c.Targets) == 0 {
+		return []Target{{Config: c}}
+	}
+
+	var targets []Target
+	for _, target := range c.Targets {
+		resolved := *c
+		resolved.Targets = nil
+		resolved.Parallelism = 0
+		resolved.EncryptionKeys = copyEncryptionKeys(c.EncryptionKeys)
+
+		overrideString(&resolved.ActiveKeyLabel, target.ActiveKeyLabel)
+		if len(target.EncryptionKeys) > 0 || target.KeyProvider != nil {
+			resolved.EncryptionKeys = copyEncryptionKeys(target.EncryptionKeys)
+			resolved.KeyProvider = KeyProviderConfig{}
+			if target.KeyProvider != nil {
+				resolved.KeyProvider = *target.KeyProvider
+			}
+		}
+		if resolved.KeyProvider.Type == "" {
+			resolved.KeyProvider.Type = StaticKeyProvider
+		}
+
+		overrideString(&resolved.DatabaseHostname, target.DatabaseHostname)
+		overrideString(&resolved.DatabasePort, target.DatabasePort)
+		overrideString(&resolved.DatabaseScheme, target.DatabaseScheme)
+		overrideString(&resolved.DatabaseName, target.DatabaseName)
+		overrideString(&resolved.DatabaseUsername, target.DatabaseUsername)
+		overrideString(&resolved.DatabasePassword, target.DatabasePassword)
+		if target.DatabaseTlsEnabled != nil {
+			resolved.DatabaseTlsEnabled = *target.DatabaseTlsEnabled
+		}
+		if target.DatabaseSkipSSLValidation != nil {
+			resolved.DatabaseSkipSSLValidation = *target.DatabaseSkipSSLValidation
+		}
+		if resolved.DatabaseScheme == "postgresql" {
+			resolved.DatabaseScheme = "postgres"
+		}
+
+		targets = append(targets, Target{Name: target.Name, Config: &resolved})
+	}
+	return targets
+}
+
+func copyEncryptionKeys(keys []EncryptionKey) []EncryptionKey {
+	var copied []EncryptionKey
+	for _, key := range keys {
+		copied = append(copied, EncryptionKey{
+			Label:      key.Label,
+			Passphrase: append(Passphrase(nil), key.Passphrase...),
+		})
+	}
+	return copied
+}
+
+func overrideString(value *string, override string) {
+	if override != "" {
+		*value = override
+	}
+}
+
+// minDatabaseConns is the fewest connections a rotation can make progress
+// with: one holding the advisory lock, one streaming the rows to rotate and
+// one writing them.
+const minDatabaseConns = 3
+
+func validateDatabasePool(rotatorConfig *RotatorConfig) error {
+	if rotatorConfig.DatabaseMaxOpenConns != 0 && rotatorConfig.DatabaseMaxOpenConns < minDatabaseConns {
+		return errors.Errorf("DatabaseMaxOpenConns: must be 0 for no limit, or at least %d", minDatabaseConns)
+	}
+	if rotatorConfig.DatabaseMaxIdleConns < 0 {
+		return errors.New("DatabaseMaxIdleConns: must not be negative")
+	}
+
+	for _, duration := range []struct{ name, value string }{
+		{"DatabaseConnMaxLifetime", rotatorConfig.DatabaseConnMaxLifetime},
+		{"DatabaseStatementTimeout", rotatorConfig.DatabaseStatementTimeout},
+	} {
+		if duration.value == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(duration.value); err != nil {
+			return errors.Errorf("%s: %s", duration.name, err)
+		}
+	}
+
+	return nil
+}
+
+func validateKeyProvider(rotatorConfig *RotatorConfig) error {
+	keyProvider := &rotatorConfig.KeyProvider
+
+	if keyProvider.Type == "" {
+		keyProvider.Type = StaticKeyProvider
+	}
+
+	if keyProvider.CacheTTL != "" {
+		if _, err := time.ParseDuration(keyProvider.CacheTTL); err != nil {
+			return errors.Errorf("KeyProvider.CacheTTL: %s", err)
+		}
+	}
+
+	switch keyProvider.Type {
+	case StaticKeyProvider:
+		if len(rotatorConfig.EncryptionKeys) == 0 {
+			return errors.New("EncryptionKeys: zero value")
+		}
+	case CredHubKeyProvider, VaultKeyProvider:
+		if keyProvider.URL == "" {
+			return errors.New("KeyProvider.URL: zero value")
+		}
+		if keyProvider.Token == "" && keyProvider.ClientCert == "" {
+			return errors.New("KeyProvider: either token or clientCert must be provided")
+		}
+		if (keyProvider.ClientCert == "") != (keyProvider.ClientKey == "") {
+			return errors.New("KeyProvider: clientCert and clientKey must be provided together")
+		}
+	default:
+		return errors.Errorf("KeyProvider.Type: unknown key provider '%s'", keyProvider.Type)
+	}
+
+	return nil
+}
+
+// WipePassphrases zeroes every configured passphrase. The config must not be
+// used to resolve keys afterwards.
+func (c *RotatorConfig) WipePassphrases() {
+	for _, key := range c.EncryptionKeys {
+		key.Passphrase.Wipe()
+	}
+	for _, target := range c.Targets {
+		for _, key := range target.EncryptionKeys {
+			key.Passphrase.Wipe()
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/onsi/gomega/gbytes"
 	"io/ioutil"
 	"os"
+	"strings"
 )
 
 var (
@@ -54,8 +55,8 @@ var _ = Describe("Config", func() {
 		Expect(err).ToNot(HaveOccurred())
 		Expect(rotatorConfig.ActiveKeyLabel).To(Equal("key1"))
 		Expect(rotatorConfig.EncryptionKeys).To(ConsistOf(
-			config.EncryptionKey{Label: "active-key", Passphrase: "secret"},
-			config.EncryptionKey{Label: "active-key1", Passphrase: "123"},
+			config.EncryptionKey{Label: "active-key", Passphrase: config.Passphrase("secret")},
+			config.EncryptionKey{Label: "active-key1", Passphrase: config.Passphrase("123")},
 		))
 		Expect(rotatorConfig.DatabaseHostname).To(Equal("localhost"))
 		Expect(rotatorConfig.DatabasePort).To(Equal("5432"))
@@ -67,6 +68,169 @@ var _ = Describe("Config", func() {
 		Expect(rotatorConfig.DatabaseSkipSSLValidation).To(BeTrue())
 	})
 
+	It("should default the logging config", func() {
+		rotatorConfig, err := config.New(tempConfigFile)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rotatorConfig.Logging).To(Equal(config.LoggingConfig{
+			Level:       config.LogLevelInfo,
+			Format:      config.LogFormatLager,
+			Destination: config.LogDestinationStdout,
+		}))
+	})
+
+	Context("when logging is configured", func() {
+		writeLoggingConfig := func(loggingConfig string) {
+			content := strings.Replace(configFileContent, `"activeKeyLabel"`, `"logging": `+loggingConfig+`, "activeKeyLabel"`, 1)
+			Expect(ioutil.WriteFile(tempConfigFile.Name(), []byte(content), os.ModePerm)).To(Succeed())
+		}
+
+		It("should unmarshal the logging config", func() {
+			writeLoggingConfig(`{"level": "debug", "format": "text", "destination": "file", "file": "/var/log/rotator.log", "hashUserIds": true}`)
+
+			rotatorConfig, err := config.New(tempConfigFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rotatorConfig.Logging).To(Equal(config.LoggingConfig{
+				Level:       config.LogLevelDebug,
+				Format:      config.LogFormatText,
+				Destination: config.LogDestinationFile,
+				File:        "/var/log/rotator.log",
+				HashUserIDs: true,
+			}))
+		})
+
+		table.DescribeTable("invalid logging fields", func(logging string, errorDescription string) {
+			writeLoggingConfig(logging)
+			_, err := config.New(tempConfigFile)
+			Expect(err).To(MatchError(errorDescription))
+		},
+			table.Entry("unknown level", `{"level": "trace"}`, "Invalid config.: Logging.Level: unknown log level 'trace'"),
+			table.Entry("unknown format", `{"format": "xml"}`, "Invalid config.: Logging.Format: unknown log format 'xml'"),
+			table.Entry("unknown destination", `{"destination": "kafka"}`, "Invalid config.: Logging.Destination: unknown log destination 'kafka'"),
+			table.Entry("file destination without file", `{"destination": "file"}`, "Invalid config.: Logging.File: zero value"),
+		)
+	})
+
+	Context("when an audit log is configured", func() {
+		It("should require an hmac key", func() {
+			content := strings.Replace(configFileContent, `"activeKeyLabel"`, `"audit": {"file": "/var/log/audit.jsonl"}, "activeKeyLabel"`, 1)
+			_, err := config.New(strings.NewReader(content))
+			Expect(err).To(MatchError("Invalid config.: Audit.HMACKey: zero value"))
+		})
+
+		It("should unmarshal the audit config", func() {
+			content := strings.Replace(configFileContent, `"activeKeyLabel"`, `"audit": {"file": "/var/log/audit.jsonl", "hmacKey": "some-key"}, "activeKeyLabel"`, 1)
+			rotatorConfig, err := config.New(strings.NewReader(content))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rotatorConfig.Audit).To(Equal(config.AuditConfig{File: "/var/log/audit.jsonl", HMACKey: "some-key"}))
+		})
+	})
+
+	Context("when a backup is configured", func() {
+		It("should require a key", func() {
+			content := strings.Replace(configFileContent, `"activeKeyLabel"`, `"backup": {"file": "/var/vcap/store/backup.enc"}, "activeKeyLabel"`, 1)
+			_, err := config.New(strings.NewReader(content))
+			Expect(err).To(MatchError("Invalid config.: Backup.Key: zero value"))
+		})
+
+		It("should unmarshal the backup config", func() {
+			content := strings.Replace(configFileContent, `"activeKeyLabel"`, `"backup": {"file": "/var/vcap/store/backup.enc", "key": "backup-key"}, "activeKeyLabel"`, 1)
+			rotatorConfig, err := config.New(strings.NewReader(content))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rotatorConfig.Backup).To(Equal(config.BackupConfig{File: "/var/vcap/store/backup.enc", Key: "backup-key"}))
+		})
+	})
+
+	Context("when the database pool is configured", func() {
+		writePoolConfig := func(poolConfig string) string {
+			return strings.Replace(configFileContent, `"activeKeyLabel"`, poolConfig+`, "activeKeyLabel"`, 1)
+		}
+
+		It("should unmarshal the pool config", func() {
+			content := writePoolConfig(`"databaseMaxOpenConns": 10, "databaseMaxIdleConns": 4, "databaseConnMaxLifetime": "5m", "databaseStatementTimeout": "30s"`)
+			rotatorConfig, err := config.New(strings.NewReader(content))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rotatorConfig.DatabaseMaxOpenConns).To(Equal(10))
+			Expect(rotatorConfig.DatabaseMaxIdleConns).To(Equal(4))
+			Expect(rotatorConfig.DatabaseConnMaxLifetime).To(Equal("5m"))
+			Expect(rotatorConfig.DatabaseStatementTimeout).To(Equal("30s"))
+		})
+
+		table.DescribeTable("invalid pool fields", func(poolConfig string, errorDescription string) {
+			_, err := config.New(strings.NewReader(writePoolConfig(poolConfig)))
+			Expect(err).To(MatchError(errorDescription))
+		},
+			table.Entry("too few connections", `"databaseMaxOpenConns": 2`, "Invalid config.: DatabaseMaxOpenConns: must be 0 for no limit, or at least 3"),
+			table.Entry("negative idle connections", `"databaseMaxIdleConns": -1`, "Invalid config.: DatabaseMaxIdleConns: must not be negative"),
+			table.Entry("invalid lifetime", `"databaseConnMaxLifetime": "forever"`, `Invalid config.: DatabaseConnMaxLifetime: time: invalid duration "forever"`),
+			table.Entry("invalid statement timeout", `"databaseStatementTimeout": "soon"`, `Invalid config.: DatabaseStatementTimeout: time: invalid duration "soon"`),
+		)
+	})
+
+	Context("when database retries are configured", func() {
+		writeRetryConfig := func(retryConfig string) string {
+			return strings.Replace(configFileContent, `"activeKeyLabel"`, `"retry": `+retryConfig+`, "activeKeyLabel"`, 1)
+		}
+
+		It("should unmarshal the retry config", func() {
+			content := writeRetryConfig(`{"maxAttempts": 3, "initialBackoff": "50ms", "maxBackoff": "2s", "startupTimeout": "1m"}`)
+			rotatorConfig, err := config.New(strings.NewReader(content))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rotatorConfig.Retry).To(Equal(config.RetryConfig{
+				MaxAttempts:    3,
+				InitialBackoff: "50ms",
+				MaxBackoff:     "2s",
+				StartupTimeout: "1m",
+			}))
+		})
+
+		table.DescribeTable("invalid retry fields", func(retryConfig string, errorDescription string) {
+			_, err := config.New(strings.NewReader(writeRetryConfig(retryConfig)))
+			Expect(err).To(MatchError(errorDescription))
+		},
+			table.Entry("negative attempts", `{"maxAttempts": -1}`, "Invalid config.: Retry.MaxAttempts: must not be negative"),
+			table.Entry("invalid backoff", `{"initialBackoff": "soon"}`, `Invalid config.: Retry.InitialBackoff: time: invalid duration "soon"`),
+			table.Entry("invalid startup timeout", `{"startupTimeout": "1 minute"}`, `Invalid config.: Retry.StartupTimeout: time: unknown unit " minute" in duration "1 minute"`),
+		)
+	})
+
+	Context("when a failure budget is configured", func() {
+		writeFailureBudgetConfig := func(budgetConfig string) string {
+			return strings.Replace(configFileContent, `"activeKeyLabel"`, `"failureBudget": `+budgetConfig+`, "activeKeyLabel"`, 1)
+		}
+
+		It("should unmarshal the failure budget config", func() {
+			content := writeFailureBudgetConfig(`{"maxFailures": 100, "maxFailurePercent": 2.5, "window": 1000, "maxConsecutiveFailures": 20}`)
+			rotatorConfig, err := config.New(strings.NewReader(content))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rotatorConfig.FailureBudget).To(Equal(config.FailureBudgetConfig{
+				MaxFailures:            100,
+				MaxFailurePercent:      2.5,
+				Window:                 1000,
+				MaxConsecutiveFailures: 20,
+			}))
+		})
+
+		table.DescribeTable("invalid failure budget fields", func(budgetConfig string, errorDescription string) {
+			_, err := config.New(strings.NewReader(writeFailureBudgetConfig(budgetConfig)))
+			Expect(err).To(MatchError(errorDescription))
+		},
+			table.Entry("negative failures", `{"maxFailures": -1}`, "Invalid config.: FailureBudget.MaxFailures: must not be negative"),
+			table.Entry("percent over 100", `{"maxFailurePercent": 101}`, "Invalid config.: FailureBudget.MaxFailurePercent: must be between 0 and 100"),
+			table.Entry("negative window", `{"window": -1}`, "Invalid config.: FailureBudget.Window: must not be negative"),
+			table.Entry("negative consecutive failures", `{"maxConsecutiveFailures": -1}`, "Invalid config.: FailureBudget.MaxConsecutiveFailures: must not be negative"),
+		)
+	})
+
+	It("should wipe passphrases on request", func() {
+		rotatorConfig, err := config.New(tempConfigFile)
+		Expect(err).ToNot(HaveOccurred())
+
+		rotatorConfig.WipePassphrases()
+
+		Expect(rotatorConfig.EncryptionKeys[0].Passphrase).To(Equal(config.Passphrase(make([]byte, len("secret")))))
+		Expect(rotatorConfig.EncryptionKeys[1].Passphrase).To(Equal(config.Passphrase(make([]byte, len("123")))))
+	})
+
 	Context("when given an invalid json config file", func() {
 		var requiredFields map[string]interface{}
 
@@ -115,6 +279,196 @@ var _ = Describe("Config", func() {
 
 	})
 
+	Context("when a key provider is configured", func() {
+		var requiredFields map[string]interface{}
+
+		BeforeEach(func() {
+			requiredFields = map[string]interface{}{
+				"activeKeyLabel":   "active-key-value",
+				"databaseHostname": "db-hostname",
+				"databasePort":     "db-port",
+				"databaseScheme":   "db-scheme",
+				"databaseName":     "db-name",
+				"databaseUsername": "db-username",
+				"keyProvider": map[string]interface{}{
+					"type":       "credhub",
+					"url":        "https://credhub.service.cf.internal:8844",
+					"token":      "some-token",
+					"pathPrefix": "/uaa/keys",
+					"cacheTTL":   "1m",
+				},
+			}
+		})
+
+		It("should not require encryption keys", func() {
+			jsonBytes, err := json.Marshal(requiredFields)
+			Expect(err).NotTo(HaveOccurred())
+
+			rotatorConfig, err := config.New(gbytes.BufferWithBytes(jsonBytes))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rotatorConfig.EncryptionKeys).To(BeEmpty())
+			Expect(rotatorConfig.KeyProvider).To(Equal(config.KeyProviderConfig{
+				Type:       config.CredHubKeyProvider,
+				URL:        "https://credhub.service.cf.internal:8844",
+				Token:      "some-token",
+				PathPrefix: "/uaa/keys",
+				CacheTTL:   "1m",
+			}))
+		})
+
+		table.DescribeTable("invalid key provider fields", func(invalidKey string, invalidValue interface{}, errorDescription string) {
+			keyProvider := cloneMap(requiredFields["keyProvider"].(map[string]interface{}))
+			keyProvider[invalidKey] = invalidValue
+			cfg := cloneMap(requiredFields)
+			cfg["keyProvider"] = keyProvider
+
+			jsonBytes, err := json.Marshal(cfg)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = config.New(gbytes.BufferWithBytes(jsonBytes))
+			Expect(err).To(MatchError(errorDescription))
+		},
+			table.Entry("unknown type", "type", "keychain", "Invalid config.: KeyProvider.Type: unknown key provider 'keychain'"),
+			table.Entry("missing url", "url", "", "Invalid config.: KeyProvider.URL: zero value"),
+			table.Entry("missing credentials", "token", "", "Invalid config.: KeyProvider: either token or clientCert must be provided"),
+			table.Entry("client cert without key", "clientCert", "some-cert", "Invalid config.: KeyProvider: clientCert and clientKey must be provided together"),
+			table.Entry("invalid cache ttl", "cacheTTL", "forever", `Invalid config.: KeyProvider.CacheTTL: time: invalid duration "forever"`),
+		)
+
+		Context("when the static key provider is used", func() {
+			It("should require encryption keys", func() {
+				cfg := cloneMap(requiredFields)
+				cfg["keyProvider"] = map[string]interface{}{"type": "static"}
+
+				jsonBytes, err := json.Marshal(cfg)
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = config.New(gbytes.BufferWithBytes(jsonBytes))
+				Expect(err).To(MatchError("Invalid config.: EncryptionKeys: zero value"))
+			})
+		})
+	})
+
+	Context("when several database targets are configured", func() {
+		var targetsConfig map[string]interface{}
+
+		BeforeEach(func() {
+			targetsConfig = map[string]interface{}{
+				"activeKeyLabel": "shared-key",
+				"encryptionKeys": []map[string]string{
+					{"label": "shared-key", "passphrase": "shared-secret"},
+				},
+				"databasePort":     "5432",
+				"databaseScheme":   "postgresql",
+				"databaseUsername": "admin",
+				"parallelism":      2,
+				"targets": []map[string]interface{}{
+					{
+						"name":             "uaa-east",
+						"databaseHostname": "east.example.com",
+						"databaseName":     "uaa",
+					},
+					{
+						"name":               "uaa-west",
+						"activeKeyLabel":     "west-key",
+						"encryptionKeys":     []map[string]string{{"label": "west-key", "passphrase": "west-secret"}},
+						"databaseHostname":   "west.example.com",
+						"databaseName":       "uaadb",
+						"databaseScheme":     "mysql",
+						"databasePort":       "3306",
+						"databaseTlsEnabled": true,
+					},
+				},
+			}
+		})
+
+		newConfig := func() (*config.RotatorConfig, error) {
+			jsonBytes, err := json.Marshal(targetsConfig)
+			Expect(err).NotTo(HaveOccurred())
+			return config.New(gbytes.BufferWithBytes(jsonBytes))
+		}
+
+		It("should resolve each target, filling in settings from the top level", func() {
+			rotatorConfig, err := newConfig()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rotatorConfig.Parallelism).To(Equal(2))
+
+			targets := rotatorConfig.ResolveTargets()
+			Expect(targets).To(HaveLen(2))
+
+			east := targets[0]
+			Expect(east.Name).To(Equal("uaa-east"))
+			Expect(east.Config.ActiveKeyLabel).To(Equal("shared-key"))
+			Expect(east.Config.EncryptionKeys).To(Equal([]config.EncryptionKey{{Label: "shared-key", Passphrase: config.Passphrase("shared-secret")}}))
+			Expect(east.Config.DatabaseHostname).To(Equal("east.example.com"))
+			Expect(east.Config.DatabasePort).To(Equal("5432"))
+			Expect(east.Config.DatabaseScheme).To(Equal("postgres"))
+			Expect(east.Config.DatabaseName).To(Equal("uaa"))
+			Expect(east.Config.DatabaseUsername).To(Equal("admin"))
+			Expect(east.Config.DatabaseTlsEnabled).To(BeFalse())
+			Expect(east.Config.Targets).To(BeEmpty())
+
+			west := targets[1]
+			Expect(west.Name).To(Equal("uaa-west"))
+			Expect(west.Config.ActiveKeyLabel).To(Equal("west-key"))
+			Expect(west.Config.EncryptionKeys).To(Equal([]config.EncryptionKey{{Label: "west-key", Passphrase: config.Passphrase("west-secret")}}))
+			Expect(west.Config.KeyProvider.Type).To(Equal(config.StaticKeyProvider))
+			Expect(west.Config.DatabaseScheme).To(Equal("mysql"))
+			Expect(west.Config.DatabasePort).To(Equal("3306"))
+			Expect(west.Config.DatabaseTlsEnabled).To(BeTrue())
+		})
+
+		It("should give each target passphrases of its own to wipe", func() {
+			rotatorConfig, err := newConfig()
+			Expect(err).NotTo(HaveOccurred())
+
+			targets := rotatorConfig.ResolveTargets()
+			targets[0].Config.WipePassphrases()
+			Expect(rotatorConfig.EncryptionKeys[0].Passphrase).To(Equal(config.Passphrase("shared-secret")))
+
+			rotatorConfig.WipePassphrases()
+			Expect(rotatorConfig.Targets[1].EncryptionKeys[0].Passphrase).To(Equal(config.Passphrase(make([]byte, len("west-secret")))))
+		})
+
+		It("should resolve a config without targets to itself", func() {
+			delete(targetsConfig, "targets")
+			targetsConfig["databaseHostname"] = "localhost"
+			targetsConfig["databaseName"] = "uaa"
+
+			rotatorConfig, err := newConfig()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rotatorConfig.ResolveTargets()).To(Equal([]config.Target{{Config: rotatorConfig}}))
+		})
+
+		table.DescribeTable("invalid targets", func(mutate func(targets []map[string]interface{}), errorDescription string) {
+			targets := targetsConfig["targets"].([]map[string]interface{})
+			mutate(targets)
+
+			_, err := newConfig()
+			Expect(err).To(MatchError(errorDescription))
+		},
+			table.Entry("missing name", func(targets []map[string]interface{}) {
+				delete(targets[0], "name")
+			}, "Invalid config.: Targets[0].Name: zero value"),
+			table.Entry("duplicate name", func(targets []map[string]interface{}) {
+				targets[1]["name"] = "uaa-east"
+			}, "Invalid config.: Targets[1].Name: duplicate target 'uaa-east'"),
+			table.Entry("missing database setting", func(targets []map[string]interface{}) {
+				delete(targets[1], "databaseHostname")
+			}, "Invalid config.: Targets[uaa-west]: DatabaseHostname: zero value"),
+			table.Entry("key set without keys", func(targets []map[string]interface{}) {
+				targets[1]["keyProvider"] = map[string]interface{}{"type": "static"}
+				delete(targets[1], "encryptionKeys")
+			}, "Invalid config.: Targets[uaa-west]: EncryptionKeys: zero value"),
+		)
+
+		It("should reject a negative parallelism", func() {
+			targetsConfig["parallelism"] = -1
+			_, err := newConfig()
+			Expect(err).To(MatchError("Invalid config.: Parallelism: must not be negative"))
+		})
+	})
+
 	Context("Given invalid rotator config", func() {
 		Context("when malformed json is provided", func() {
 			BeforeEach(func() {
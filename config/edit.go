@@ -0,0 +1,70 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/cloudfoundry/uaa-key-rotator/crypto"
+	"github.com/pkg/errors"
+)
+
+// AddEncryptionKey adds key to the top level encryptionKeys of the config
+// in content, making it the active key if activate is set, and returns the
+// edited config. Every other setting is kept as it was, though the fields of
+// the edited config are written in alphabetical order.
+func AddEncryptionKey(content []byte, key EncryptionKey, activate bool) ([]byte, error) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return nil, errors.Wrap(err, "Malformed JSON provided.")
+	}
+
+	var keys []json.RawMessage
+	if existing, ok := fields["encryptionKeys"]; ok && string(existing) != "null" {
+		if err := json.Unmarshal(existing, &keys); err != nil {
+			return nil, errors.Wrap(err, "Malformed encryptionKeys")
+		}
+	}
+	for _, existing := range keys {
+		var existingKey struct {
+			Label string `json:"label"`
+		}
+		if err := json.Unmarshal(existing, &existingKey); err != nil {
+			return nil, errors.Wrap(err, "Malformed encryptionKeys")
+		}
+		if existingKey.Label == key.Label {
+			return nil, errors.Errorf("the config already has a key labelled '%s'", key.Label)
+		}
+	}
+
+	newKey, err := json.Marshal(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to marshal key")
+	}
+	defer crypto.Zero(newKey)
+	keys = append(keys, newKey)
+
+	fields["encryptionKeys"], err = json.Marshal(keys)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to marshal encryptionKeys")
+	}
+	defer crypto.Zero(fields["encryptionKeys"])
+
+	if activate {
+		fields["activeKeyLabel"], err = json.Marshal(key.Label)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to marshal activeKeyLabel")
+		}
+	}
+
+	compact, err := json.Marshal(fields)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to marshal config")
+	}
+	defer crypto.Zero(compact)
+
+	var edited bytes.Buffer
+	if err := json.Indent(&edited, compact, "", "  "); err != nil {
+		return nil, errors.Wrap(err, "unable to format config")
+	}
+	edited.WriteByte('\n')
+	return edited.Bytes(), nil
+}
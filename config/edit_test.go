@@ -0,0 +1,67 @@
+package config_test
+
+import (
+	"bytes"
+	"github.com/cloudfoundry/uaa-key-rotator/config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AddEncryptionKey", func() {
+	var content []byte
+
+	BeforeEach(func() {
+		content = []byte(`{
+			"activeKeyLabel": "old-key",
+			"encryptionKeys": [{"label": "old-key", "passphrase": 123}],
+			"databaseHostname": "localhost",
+			"databasePort": "5432",
+			"databaseName": "uaadb",
+			"databaseScheme": "postgres",
+			"databaseUsername": "admin",
+			"databaseTlsEnabled": true
+		}`)
+	})
+
+	It("should add the key, make it active and keep every other setting", func() {
+		edited, err := config.AddEncryptionKey(content, config.EncryptionKey{Label: "new-key", Passphrase: config.Passphrase("secret")}, true)
+		Expect(err).NotTo(HaveOccurred())
+
+		rotatorConfig, err := config.New(bytes.NewReader(edited))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rotatorConfig.ActiveKeyLabel).To(Equal("new-key"))
+		Expect(rotatorConfig.EncryptionKeys).To(Equal([]config.EncryptionKey{
+			{Label: "old-key", Passphrase: config.Passphrase("123")},
+			{Label: "new-key", Passphrase: config.Passphrase("secret")},
+		}))
+		Expect(rotatorConfig.DatabaseHostname).To(Equal("localhost"))
+		Expect(rotatorConfig.DatabaseTlsEnabled).To(BeTrue())
+		Expect(string(edited)).To(ContainSubstring(`"passphrase": 123`))
+	})
+
+	It("should leave the active key alone unless asked to switch", func() {
+		edited, err := config.AddEncryptionKey(content, config.EncryptionKey{Label: "new-key", Passphrase: config.Passphrase("secret")}, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		rotatorConfig, err := config.New(bytes.NewReader(edited))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rotatorConfig.ActiveKeyLabel).To(Equal("old-key"))
+		Expect(rotatorConfig.EncryptionKeys).To(HaveLen(2))
+	})
+
+	It("should add the first key of a config without keys", func() {
+		edited, err := config.AddEncryptionKey([]byte(`{"activeKeyLabel": "x"}`), config.EncryptionKey{Label: "new-key", Passphrase: config.Passphrase("secret")}, true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(edited)).To(ContainSubstring(`"label": "new-key"`))
+	})
+
+	It("should refuse a label the config already has", func() {
+		_, err := config.AddEncryptionKey(content, config.EncryptionKey{Label: "old-key", Passphrase: config.Passphrase("secret")}, true)
+		Expect(err).To(MatchError("the config already has a key labelled 'old-key'"))
+	})
+
+	It("should refuse malformed JSON", func() {
+		_, err := config.AddEncryptionKey([]byte(`{`), config.EncryptionKey{Label: "new-key"}, true)
+		Expect(err).To(MatchError(ContainSubstring("Malformed JSON provided.")))
+	})
+})
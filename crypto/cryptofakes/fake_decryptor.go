@@ -21,6 +21,19 @@ type FakeDecryptor struct {
 		result1 string
 		result2 error
 	}
+	DecryptBytesStub        func(encryptedValue crypto.EncryptedValue) ([]byte, error)
+	decryptBytesMutex       sync.RWMutex
+	decryptBytesArgsForCall []struct {
+		encryptedValue crypto.EncryptedValue
+	}
+	decryptBytesReturns struct {
+		result1 []byte
+		result2 error
+	}
+	decryptBytesReturnsOnCall map[int]struct {
+		result1 []byte
+		result2 error
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
@@ -76,11 +89,64 @@ func (fake *FakeDecryptor) DecryptReturnsOnCall(i int, result1 string, result2 e
 	}{result1, result2}
 }
 
+func (fake *FakeDecryptor) DecryptBytes(encryptedValue crypto.EncryptedValue) ([]byte, error) {
+	fake.decryptBytesMutex.Lock()
+	ret, specificReturn := fake.decryptBytesReturnsOnCall[len(fake.decryptBytesArgsForCall)]
+	fake.decryptBytesArgsForCall = append(fake.decryptBytesArgsForCall, struct {
+		encryptedValue crypto.EncryptedValue
+	}{encryptedValue})
+	fake.recordInvocation("DecryptBytes", []interface{}{encryptedValue})
+	fake.decryptBytesMutex.Unlock()
+	if fake.DecryptBytesStub != nil {
+		return fake.DecryptBytesStub(encryptedValue)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.decryptBytesReturns.result1, fake.decryptBytesReturns.result2
+}
+
+func (fake *FakeDecryptor) DecryptBytesCallCount() int {
+	fake.decryptBytesMutex.RLock()
+	defer fake.decryptBytesMutex.RUnlock()
+	return len(fake.decryptBytesArgsForCall)
+}
+
+func (fake *FakeDecryptor) DecryptBytesArgsForCall(i int) crypto.EncryptedValue {
+	fake.decryptBytesMutex.RLock()
+	defer fake.decryptBytesMutex.RUnlock()
+	return fake.decryptBytesArgsForCall[i].encryptedValue
+}
+
+func (fake *FakeDecryptor) DecryptBytesReturns(result1 []byte, result2 error) {
+	fake.DecryptBytesStub = nil
+	fake.decryptBytesReturns = struct {
+		result1 []byte
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeDecryptor) DecryptBytesReturnsOnCall(i int, result1 []byte, result2 error) {
+	fake.DecryptBytesStub = nil
+	if fake.decryptBytesReturnsOnCall == nil {
+		fake.decryptBytesReturnsOnCall = make(map[int]struct {
+			result1 []byte
+			result2 error
+		})
+	}
+	fake.decryptBytesReturnsOnCall[i] = struct {
+		result1 []byte
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeDecryptor) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
 	fake.decryptMutex.RLock()
 	defer fake.decryptMutex.RUnlock()
+	fake.decryptBytesMutex.RLock()
+	defer fake.decryptBytesMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
@@ -21,6 +21,19 @@ type FakeEncryptor struct {
 		result1 crypto.EncryptedValue
 		result2 error
 	}
+	EncryptBytesStub        func(plainText []byte) (crypto.EncryptedValue, error)
+	encryptBytesMutex       sync.RWMutex
+	encryptBytesArgsForCall []struct {
+		plainText []byte
+	}
+	encryptBytesReturns struct {
+		result1 crypto.EncryptedValue
+		result2 error
+	}
+	encryptBytesReturnsOnCall map[int]struct {
+		result1 crypto.EncryptedValue
+		result2 error
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
@@ -76,11 +89,69 @@ func (fake *FakeEncryptor) EncryptReturnsOnCall(i int, result1 crypto.EncryptedV
 	}{result1, result2}
 }
 
+func (fake *FakeEncryptor) EncryptBytes(plainText []byte) (crypto.EncryptedValue, error) {
+	var plainTextCopy []byte
+	if plainText != nil {
+		plainTextCopy = make([]byte, len(plainText))
+		copy(plainTextCopy, plainText)
+	}
+	fake.encryptBytesMutex.Lock()
+	ret, specificReturn := fake.encryptBytesReturnsOnCall[len(fake.encryptBytesArgsForCall)]
+	fake.encryptBytesArgsForCall = append(fake.encryptBytesArgsForCall, struct {
+		plainText []byte
+	}{plainTextCopy})
+	fake.recordInvocation("EncryptBytes", []interface{}{plainTextCopy})
+	fake.encryptBytesMutex.Unlock()
+	if fake.EncryptBytesStub != nil {
+		return fake.EncryptBytesStub(plainText)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.encryptBytesReturns.result1, fake.encryptBytesReturns.result2
+}
+
+func (fake *FakeEncryptor) EncryptBytesCallCount() int {
+	fake.encryptBytesMutex.RLock()
+	defer fake.encryptBytesMutex.RUnlock()
+	return len(fake.encryptBytesArgsForCall)
+}
+
+func (fake *FakeEncryptor) EncryptBytesArgsForCall(i int) []byte {
+	fake.encryptBytesMutex.RLock()
+	defer fake.encryptBytesMutex.RUnlock()
+	return fake.encryptBytesArgsForCall[i].plainText
+}
+
+func (fake *FakeEncryptor) EncryptBytesReturns(result1 crypto.EncryptedValue, result2 error) {
+	fake.EncryptBytesStub = nil
+	fake.encryptBytesReturns = struct {
+		result1 crypto.EncryptedValue
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeEncryptor) EncryptBytesReturnsOnCall(i int, result1 crypto.EncryptedValue, result2 error) {
+	fake.EncryptBytesStub = nil
+	if fake.encryptBytesReturnsOnCall == nil {
+		fake.encryptBytesReturnsOnCall = make(map[int]struct {
+			result1 crypto.EncryptedValue
+			result2 error
+		})
+	}
+	fake.encryptBytesReturnsOnCall[i] = struct {
+		result1 crypto.EncryptedValue
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeEncryptor) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
 	fake.encryptMutex.RLock()
 	defer fake.encryptMutex.RUnlock()
+	fake.encryptBytesMutex.RLock()
+	defer fake.encryptBytesMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
@@ -3,37 +3,68 @@ package crypto
 import (
 	"crypto/aes"
 	"crypto/cipher"
-	"errors"
+	"fmt"
 )
 
 //go:generate counterfeiter . Decryptor
 type Decryptor interface {
 	Decrypt(encryptedValue EncryptedValue) (string, error)
+	DecryptBytes(encryptedValue EncryptedValue) ([]byte, error)
 }
 
 type UAADecryptor struct {
-	Passphrase string
+	Passphrase []byte
 }
 
+var _ Wiper = UAADecryptor{}
+
 func (d UAADecryptor) Decrypt(encryptedValue EncryptedValue) (string, error) {
+	plainText, err := d.DecryptBytes(encryptedValue)
+	if err != nil {
+		return "", err
+	}
+	defer Zero(plainText)
+
+	return string(plainText), nil
+}
+
+// DecryptBytes returns the plaintext in a buffer owned by the caller, who
+// should Zero it once it is no longer needed.
+func (d UAADecryptor) DecryptBytes(encryptedValue EncryptedValue) ([]byte, error) {
 	if len(encryptedValue.CipherValue) == 0 {
-		return "", errors.New("unable to decrypt due to empty CipherText")
+		return nil, MalformedEnvelopeError{Reason: "unable to decrypt due to empty CipherText"}
 	}
 
-	aes, err := aes.NewCipher(GenerateKey(encryptedValue.Salt, d.Passphrase))
+	aesGcm, err := newGCM(encryptedValue.Salt, d.Passphrase)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	aesGcm, err := cipher.NewGCM(aes)
-	if err != nil {
-		return "", err
+	if len(encryptedValue.Nonce) != aesGcm.NonceSize() {
+		return nil, MalformedEnvelopeError{Reason: fmt.Sprintf("nonce should be exactly %d bytes in length but was %d", aesGcm.NonceSize(), len(encryptedValue.Nonce))}
 	}
 
+	// Open only fails when the GCM tag does not authenticate the ciphertext.
 	plainText, err := aesGcm.Open(nil, encryptedValue.Nonce, encryptedValue.CipherValue, nil)
 	if err != nil {
-		return "", err
+		return nil, ErrAuthenticationFailed
 	}
 
-	return string(plainText), nil
+	return plainText, nil
+}
+
+func (d UAADecryptor) Wipe() {
+	Zero(d.Passphrase)
+}
+
+func newGCM(salt []byte, passphrase []byte) (cipher.AEAD, error) {
+	key := GenerateKey(salt, passphrase)
+	defer Zero(key)
+
+	aes, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(aes)
 }
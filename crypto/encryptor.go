@@ -1,8 +1,6 @@
 package crypto
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
 	"github.com/pkg/errors"
 )
 
@@ -17,11 +15,13 @@ type NonceGenerator interface {
 }
 
 type UAAEncryptor struct {
-	Passphrase     string
+	Passphrase     []byte
 	SaltGenerator  SaltGenerator
 	NonceGenerator NonceGenerator
 }
 
+var _ Wiper = UAAEncryptor{}
+
 type EncryptedValue struct {
 	Salt        []byte
 	Nonce       []byte
@@ -31,9 +31,17 @@ type EncryptedValue struct {
 //go:generate counterfeiter . Encryptor
 type Encryptor interface {
 	Encrypt(plainText string) (EncryptedValue, error)
+	EncryptBytes(plainText []byte) (EncryptedValue, error)
 }
 
 func (e UAAEncryptor) Encrypt(plainText string) (EncryptedValue, error) {
+	plainTextBytes := []byte(plainText)
+	defer Zero(plainTextBytes)
+
+	return e.EncryptBytes(plainTextBytes)
+}
+
+func (e UAAEncryptor) EncryptBytes(plainText []byte) (EncryptedValue, error) {
 	salt, err := e.SaltGenerator.GetSalt()
 	if err != nil {
 		return EncryptedValue{}, errors.Wrap(err, "unable to generate a salt")
@@ -43,16 +51,15 @@ func (e UAAEncryptor) Encrypt(plainText string) (EncryptedValue, error) {
 		return EncryptedValue{}, errors.Wrap(err, "unable to generate a nonce")
 	}
 
-	aes, err := aes.NewCipher(GenerateKey(salt, e.Passphrase))
-	if err != nil {
-		return EncryptedValue{}, err
-	}
-
-	aesGcm, err := cipher.NewGCM(aes)
+	aesGcm, err := newGCM(salt, e.Passphrase)
 	if err != nil {
 		return EncryptedValue{}, err
 	}
 
-	cipherValue := aesGcm.Seal(nil, nonce, []byte(plainText), nil)
+	cipherValue := aesGcm.Seal(nil, nonce, plainText, nil)
 	return EncryptedValue{salt, nonce, cipherValue}, nil
 }
+
+func (e UAAEncryptor) Wipe() {
+	Zero(e.Passphrase)
+}
@@ -2,10 +2,12 @@ package crypto_test
 
 import (
 	"bytes"
+	"fmt"
 	. "github.com/cloudfoundry/uaa-key-rotator/crypto"
 	"github.com/cloudfoundry/uaa-key-rotator/crypto/cryptofakes"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
 )
 
 var _ = Describe("UAAEncryptor", func() {
@@ -18,9 +20,6 @@ var _ = Describe("UAAEncryptor", func() {
 	var saltGenerator *cryptofakes.FakeSaltGenerator
 	var nonceGenerator *cryptofakes.FakeNonceGenerator
 
-	var cipherSaltAccessor *cryptofakes.FakeCipherSaltAccessor
-	var cipherNonceAccessor *cryptofakes.FakeCipherNonceAccessor
-
 	BeforeEach(func() {
 		salt = bytes.Repeat([]byte("s"), 32)
 		nonce = bytes.Repeat([]byte("n"), 12)
@@ -28,18 +27,12 @@ var _ = Describe("UAAEncryptor", func() {
 		saltGenerator = &cryptofakes.FakeSaltGenerator{}
 		nonceGenerator = &cryptofakes.FakeNonceGenerator{}
 
-		cipherSaltAccessor = &cryptofakes.FakeCipherSaltAccessor{}
-		cipherNonceAccessor = &cryptofakes.FakeCipherNonceAccessor{}
-
 		saltGenerator.GetSaltReturns(salt, nil)
 		nonceGenerator.GetNonceReturns(nonce, nil)
-
-		cipherSaltAccessor.GetSaltReturns(salt, nil)
-		cipherNonceAccessor.GetNonceReturns(nonce, nil)
 	})
 
 	JustBeforeEach(func() {
-		passphrase := "passphrase"
+		passphrase := []byte("passphrase")
 		decryptor = UAADecryptor{
 			Passphrase: passphrase,
 		}
@@ -75,13 +68,84 @@ var _ = Describe("UAAEncryptor", func() {
 			Expect(decryptedData).To(Equal(plainText))
 		})
 
+		It("should decrypt into a byte buffer", func() {
+			encryptedData, err := encryptor.EncryptBytes([]byte("data-to-encrypt"))
+			Expect(err).NotTo(HaveOccurred())
+
+			decryptedData, err := decryptor.DecryptBytes(encryptedData)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(decryptedData).To(Equal([]byte("data-to-encrypt")))
+		})
+
+		It("should interoperate between the string and byte variants", func() {
+			encryptedData, err := encryptor.Encrypt("data-to-encrypt")
+			Expect(err).NotTo(HaveOccurred())
+
+			decryptedData, err := decryptor.DecryptBytes(encryptedData)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(decryptedData).To(Equal([]byte("data-to-encrypt")))
+		})
+
 		Context("when empty ciphervalue is provided", func() {
 			It("should return a meaningful error", func() {
 				_, err := decryptor.Decrypt(EncryptedValue{})
 				Expect(err).To(HaveOccurred())
 				Expect(err).To(MatchError("unable to decrypt due to empty CipherText"))
+				Expect(IsMalformedEnvelope(err)).To(BeTrue())
+				Expect(IsAuthenticationFailure(err)).To(BeFalse())
 			})
+		})
+
+		Context("when the value was encrypted under a different passphrase", func() {
+			It("should report an authentication failure", func() {
+				encryptedData, err := encryptor.Encrypt("data-to-encrypt")
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = UAADecryptor{Passphrase: []byte("another-passphrase")}.DecryptBytes(encryptedData)
+				Expect(err).To(MatchError("cipher: message authentication failed"))
+				Expect(IsAuthenticationFailure(err)).To(BeTrue())
+				Expect(IsMalformedEnvelope(err)).To(BeFalse())
+			})
+		})
+
+		Context("when the nonce has the wrong length", func() {
+			It("should report a malformed envelope", func() {
+				encryptedData, err := encryptor.Encrypt("data-to-encrypt")
+				Expect(err).NotTo(HaveOccurred())
+				encryptedData.Nonce = encryptedData.Nonce[:4]
+
+				_, err = decryptor.DecryptBytes(encryptedData)
+				Expect(err).To(MatchError("nonce should be exactly 12 bytes in length but was 4"))
+				Expect(IsMalformedEnvelope(err)).To(BeTrue())
+			})
+		})
+
+		Context("when the error has been wrapped", func() {
+			It("should still match with errors.Is and errors.As", func() {
+				_, err := decryptor.Decrypt(EncryptedValue{})
+				wrapped := fmt.Errorf("unable to rotate: %w", errors.Wrap(err, "unable to decrypt"))
+				Expect(errors.Is(wrapped, ErrMalformedEnvelope)).To(BeTrue())
+				Expect(errors.Is(wrapped, ErrAuthenticationFailed)).To(BeFalse())
+
+				var malformed MalformedEnvelopeError
+				Expect(errors.As(wrapped, &malformed)).To(BeTrue())
+				Expect(malformed.Reason).To(Equal("unable to decrypt due to empty CipherText"))
+
+				Expect(errors.Is(errors.Wrap(ErrAuthenticationFailed, "unable to decrypt"), ErrAuthenticationFailed)).To(BeTrue())
+			})
+		})
+	})
+
+	Describe("Wipe", func() {
+		It("should zero the passphrase held by the encryptor and decryptor", func() {
+			decryptorPassphrase := []byte("passphrase")
+			encryptorPassphrase := []byte("passphrase")
+
+			UAADecryptor{Passphrase: decryptorPassphrase}.Wipe()
+			UAAEncryptor{Passphrase: encryptorPassphrase}.Wipe()
 
+			Expect(decryptorPassphrase).To(Equal(make([]byte, 10)))
+			Expect(encryptorPassphrase).To(Equal(make([]byte, 10)))
 		})
 	})
 })
@@ -0,0 +1,35 @@
+package crypto
+
+import (
+	"github.com/pkg/errors"
+)
+
+// ErrAuthenticationFailed is returned when a ciphertext fails GCM
+// authentication, which almost always means it was encrypted under a
+// different key.
+var ErrAuthenticationFailed = errors.New("cipher: message authentication failed")
+
+// ErrMalformedEnvelope matches every MalformedEnvelopeError with errors.Is.
+var ErrMalformedEnvelope = errors.New("malformed envelope")
+
+// MalformedEnvelopeError is returned for stored values that cannot hold a
+// UAA envelope at all, so no key could decrypt them.
+type MalformedEnvelopeError struct {
+	Reason string
+}
+
+func (e MalformedEnvelopeError) Error() string {
+	return e.Reason
+}
+
+func (e MalformedEnvelopeError) Is(target error) bool {
+	return target == ErrMalformedEnvelope
+}
+
+func IsAuthenticationFailure(err error) bool {
+	return errors.Is(err, ErrAuthenticationFailed)
+}
+
+func IsMalformedEnvelope(err error) bool {
+	return errors.Is(err, ErrMalformedEnvelope)
+}
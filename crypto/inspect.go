@@ -0,0 +1,79 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// EnvelopeInspection describes the layout of a stored value without
+// decrypting it. Problems lists why the value is not a valid UAA envelope,
+// and is empty for one that is.
+type EnvelopeInspection struct {
+	Length            int
+	Nonce             []byte
+	Salt              []byte
+	CipherValueLength int
+	PlainTextLength   int
+	Problems          []string
+}
+
+func (i EnvelopeInspection) Valid() bool {
+	return len(i.Problems) == 0
+}
+
+// InspectEnvelope decodes a stored value as UAAEnvelopeCodec does, but
+// reports every part it can find instead of stopping at the first problem.
+func InspectEnvelope(storedValue string) EnvelopeInspection {
+	var inspection EnvelopeInspection
+
+	if trimmed := strings.TrimSpace(storedValue); trimmed != storedValue {
+		inspection.Problems = append(inspection.Problems, "value has leading or trailing whitespace")
+		storedValue = trimmed
+	}
+
+	envelope, err := base64.StdEncoding.DecodeString(storedValue)
+	if err != nil {
+		inspection.Problems = append(inspection.Problems, fmt.Sprintf("envelope is not valid base64: %s", err))
+		for _, encoding := range []struct {
+			name     string
+			encoding *base64.Encoding
+		}{
+			{"unpadded base64", base64.RawStdEncoding},
+			{"URL-safe base64", base64.URLEncoding},
+			{"unpadded URL-safe base64", base64.RawURLEncoding},
+		} {
+			if envelope, err = encoding.encoding.DecodeString(storedValue); err == nil {
+				inspection.Problems = append(inspection.Problems, fmt.Sprintf("envelope is %s; UAA writes padded standard base64", encoding.name))
+				break
+			}
+		}
+		if err != nil {
+			return inspection
+		}
+	}
+
+	inspection.Length = len(envelope)
+	if len(envelope) < uaaMinEnvelopeLength {
+		inspection.Problems = append(inspection.Problems, fmt.Sprintf("envelope should be at least %d bytes in length but was %d", uaaMinEnvelopeLength, len(envelope)))
+	}
+
+	inspection.Nonce = envelope[:minInt(len(envelope), UAANonceLength)]
+	if len(envelope) > UAANonceLength {
+		inspection.Salt = envelope[UAANonceLength:minInt(len(envelope), UAANonceLength+UAASaltLength)]
+	}
+	if len(envelope) > UAANonceLength+UAASaltLength {
+		inspection.CipherValueLength = len(envelope) - UAANonceLength - UAASaltLength
+	}
+	if inspection.CipherValueLength > GCMTagLength {
+		inspection.PlainTextLength = inspection.CipherValueLength - GCMTagLength
+	}
+	return inspection
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,56 @@
+package crypto_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	. "github.com/cloudfoundry/uaa-key-rotator/crypto"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("InspectEnvelope", func() {
+	var nonce, salt, cipherValue, envelope []byte
+
+	BeforeEach(func() {
+		nonce = bytes.Repeat([]byte("n"), 12)
+		salt = bytes.Repeat([]byte("s"), 32)
+		cipherValue = bytes.Repeat([]byte("x"), 26)
+		envelope = append(append(append([]byte{}, nonce...), salt...), cipherValue...)
+	})
+
+	It("should report the parts of a valid envelope", func() {
+		inspection := InspectEnvelope(base64.StdEncoding.EncodeToString(envelope))
+		Expect(inspection.Valid()).To(BeTrue())
+		Expect(inspection.Length).To(Equal(70))
+		Expect(inspection.Nonce).To(Equal(nonce))
+		Expect(inspection.Salt).To(Equal(salt))
+		Expect(inspection.CipherValueLength).To(Equal(26))
+		Expect(inspection.PlainTextLength).To(Equal(10))
+	})
+
+	It("should report URL-safe base64 and whitespace, and still find the parts", func() {
+		envelope[0] = 0xfb
+		inspection := InspectEnvelope(base64.RawURLEncoding.EncodeToString(envelope) + "\n")
+		Expect(inspection.Valid()).To(BeFalse())
+		Expect(inspection.Problems).To(ConsistOf(
+			"value has leading or trailing whitespace",
+			ContainSubstring("envelope is not valid base64"),
+			"envelope is unpadded URL-safe base64; UAA writes padded standard base64",
+		))
+		Expect(inspection.CipherValueLength).To(Equal(26))
+	})
+
+	It("should report an envelope that is too short", func() {
+		inspection := InspectEnvelope(base64.StdEncoding.EncodeToString(envelope[:20]))
+		Expect(inspection.Problems).To(ConsistOf("envelope should be at least 60 bytes in length but was 20"))
+		Expect(inspection.Nonce).To(Equal(nonce))
+		Expect(inspection.Salt).To(HaveLen(8))
+		Expect(inspection.CipherValueLength).To(BeZero())
+	})
+
+	It("should report a value that is not base64 at all", func() {
+		inspection := InspectEnvelope("not base64!")
+		Expect(inspection.Problems).To(ConsistOf(ContainSubstring("envelope is not valid base64")))
+		Expect(inspection.Length).To(BeZero())
+	})
+})
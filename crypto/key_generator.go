@@ -10,6 +10,8 @@ const (
 	ShaNumberIterations = 65536
 )
 
-func GenerateKey(salt []byte, passphrase string) []byte {
-	return pbkdf2.Key([]byte(passphrase), salt, ShaNumberIterations, AES256KeyLength, sha256.New)
+// GenerateKey derives the AES key for a value. Callers should Zero the
+// returned key once the cipher has been constructed.
+func GenerateKey(salt []byte, passphrase []byte) []byte {
+	return pbkdf2.Key(passphrase, salt, ShaNumberIterations, AES256KeyLength, sha256.New)
 }
@@ -0,0 +1,66 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+const (
+	UAANonceLength = 12
+	UAASaltLength  = 32
+	GCMTagLength   = 16
+
+	uaaMinEnvelopeLength = UAANonceLength + UAASaltLength + GCMTagLength
+)
+
+// EnvelopeCodec converts between the value stored in a database column and
+// its nonce, salt and ciphertext parts.
+//
+//go:generate counterfeiter . EnvelopeCodec
+type EnvelopeCodec interface {
+	Decode(storedValue string) (EncryptedValue, error)
+	Encode(value EncryptedValue) (string, error)
+}
+
+// UAAEnvelopeCodec implements the layout used by UAA's EncryptionService:
+// base64(nonce[12] || salt[32] || ciphertext), where the ciphertext carries
+// the 16 byte GCM authentication tag.
+type UAAEnvelopeCodec struct{}
+
+var _ EnvelopeCodec = UAAEnvelopeCodec{}
+
+func (UAAEnvelopeCodec) Decode(storedValue string) (EncryptedValue, error) {
+	envelope, err := base64.StdEncoding.DecodeString(storedValue)
+	if err != nil {
+		return EncryptedValue{}, MalformedEnvelopeError{Reason: fmt.Sprintf("envelope is not valid base64: %s", err)}
+	}
+
+	if len(envelope) < uaaMinEnvelopeLength {
+		return EncryptedValue{}, MalformedEnvelopeError{Reason: fmt.Sprintf("envelope should be at least %d bytes in length but was %d", uaaMinEnvelopeLength, len(envelope))}
+	}
+
+	return EncryptedValue{
+		Nonce:       envelope[:UAANonceLength],
+		Salt:        envelope[UAANonceLength : UAANonceLength+UAASaltLength],
+		CipherValue: envelope[UAANonceLength+UAASaltLength:],
+	}, nil
+}
+
+func (UAAEnvelopeCodec) Encode(value EncryptedValue) (string, error) {
+	if len(value.Nonce) != UAANonceLength {
+		return "", fmt.Errorf("nonce should be exactly %d bytes in length but was %d", UAANonceLength, len(value.Nonce))
+	}
+	if len(value.Salt) != UAASaltLength {
+		return "", fmt.Errorf("salt should be exactly %d bytes in length but was %d", UAASaltLength, len(value.Salt))
+	}
+	if len(value.CipherValue) < GCMTagLength {
+		return "", fmt.Errorf("cipher value should be at least %d bytes in length but was %d", GCMTagLength, len(value.CipherValue))
+	}
+
+	var envelope []byte
+	envelope = append(envelope, value.Nonce...)
+	envelope = append(envelope, value.Salt...)
+	envelope = append(envelope, value.CipherValue...)
+
+	return base64.StdEncoding.EncodeToString(envelope), nil
+}
@@ -0,0 +1,96 @@
+package crypto_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	. "github.com/cloudfoundry/uaa-key-rotator/crypto"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("UAAEnvelopeCodec", func() {
+	var codec UAAEnvelopeCodec
+	var nonce, salt, cipherValue []byte
+
+	BeforeEach(func() {
+		codec = UAAEnvelopeCodec{}
+		nonce = bytes.Repeat([]byte("n"), 12)
+		salt = bytes.Repeat([]byte("s"), 32)
+		cipherValue = bytes.Repeat([]byte("x"), 52)
+	})
+
+	Describe("Decode", func() {
+		It("should split a stored value into nonce, salt and cipher value", func() {
+			storedValue := base64.StdEncoding.EncodeToString(append(append(append([]byte{}, nonce...), salt...), cipherValue...))
+
+			encryptedValue, err := codec.Decode(storedValue)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(encryptedValue.Nonce).To(Equal(nonce))
+			Expect(encryptedValue.Salt).To(Equal(salt))
+			Expect(encryptedValue.CipherValue).To(Equal(cipherValue))
+		})
+
+		It("should reject values that are not base64", func() {
+			_, err := codec.Decode("not base64!")
+			Expect(err).To(MatchError(ContainSubstring("envelope is not valid base64")))
+			Expect(IsMalformedEnvelope(err)).To(BeTrue())
+		})
+
+		table.DescribeTable("Given envelopes that are too short", func(envelopeSize int) {
+			storedValue := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("n"), envelopeSize))
+
+			_, err := codec.Decode(storedValue)
+			Expect(err).To(HaveOccurred())
+			Expect(IsMalformedEnvelope(err)).To(BeTrue())
+		},
+			table.Entry("empty", 0),
+			table.Entry("nonce only", 12),
+			table.Entry("nonce and salt only", 44),
+			table.Entry("cipher value shorter than the gcm tag", 59),
+		)
+
+		It("should report the expected and actual length", func() {
+			_, err := codec.Decode(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("n"), 45)))
+			Expect(err).To(MatchError("envelope should be at least 60 bytes in length but was 45"))
+		})
+	})
+
+	Describe("Encode", func() {
+		It("should produce the UAA layout", func() {
+			storedValue, err := codec.Encode(EncryptedValue{Nonce: nonce, Salt: salt, CipherValue: cipherValue})
+			Expect(err).NotTo(HaveOccurred())
+
+			envelope, err := base64.StdEncoding.DecodeString(storedValue)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(envelope[:12]).To(Equal(nonce))
+			Expect(envelope[12:44]).To(Equal(salt))
+			Expect(envelope[44:]).To(Equal(cipherValue))
+		})
+
+		It("should round trip through Decode", func() {
+			original := EncryptedValue{Nonce: nonce, Salt: salt, CipherValue: cipherValue}
+			storedValue, err := codec.Encode(original)
+			Expect(err).NotTo(HaveOccurred())
+
+			decoded, err := codec.Decode(storedValue)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(decoded).To(Equal(original))
+		})
+
+		table.DescribeTable("Given invalid parts", func(nonceSize, saltSize, cipherSize int, errorDescription string) {
+			_, err := codec.Encode(EncryptedValue{
+				Nonce:       bytes.Repeat([]byte("n"), nonceSize),
+				Salt:        bytes.Repeat([]byte("s"), saltSize),
+				CipherValue: bytes.Repeat([]byte("x"), cipherSize),
+			})
+			Expect(err).To(MatchError(errorDescription))
+		},
+			table.Entry("short nonce", 11, 32, 16, "nonce should be exactly 12 bytes in length but was 11"),
+			table.Entry("long nonce", 13, 32, 16, "nonce should be exactly 12 bytes in length but was 13"),
+			table.Entry("short salt", 12, 31, 16, "salt should be exactly 32 bytes in length but was 31"),
+			table.Entry("long salt", 12, 33, 16, "salt should be exactly 32 bytes in length but was 33"),
+			table.Entry("missing gcm tag", 12, 32, 15, "cipher value should be at least 16 bytes in length but was 15"),
+		)
+	})
+})
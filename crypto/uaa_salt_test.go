@@ -1,10 +1,8 @@
 package crypto_test
 
 import (
-	"bytes"
 	. "github.com/cloudfoundry/uaa-key-rotator/crypto"
 	. "github.com/onsi/ginkgo"
-	"github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 )
 
@@ -32,37 +30,4 @@ var _ = Describe("UaaSalt", func() {
 			Expect(salt1).ToNot(Equal(salt2))
 		})
 	})
-
-	Describe("salt accessor", func() {
-		var saltAccessor UaaSaltAccessor
-		BeforeEach(func() {
-			saltAccessor = UaaSaltAccessor{}
-
-		})
-
-		It("should access salt from cipher value", func() {
-
-			cipherValue := []byte{}
-			cipherValue = append(cipherValue, bytes.Repeat([]byte("n"), 12)...)
-			cipherValue = append(cipherValue, bytes.Repeat([]byte("s"), 32)...)
-			cipherValue = append(cipherValue, bytes.Repeat([]byte("x"), 52)...)
-
-			salt, _ := saltAccessor.GetSalt(cipherValue)
-
-			Expect(salt).To(Equal(bytes.Repeat([]byte("s"), 32)))
-
-		})
-
-		table.DescribeTable("Given invalid salt values", func(saltSize int) {
-			cipherValue := []byte{}
-			cipherValue = append(cipherValue, bytes.Repeat([]byte("n"), 12)...)
-			cipherValue = append(cipherValue, bytes.Repeat([]byte("s"), saltSize)...)
-			_, err := saltAccessor.GetSalt(cipherValue)
-
-			Expect(err).To(HaveOccurred())
-			Expect(err).To(MatchError("cipher should be more than 45 bytes"))
-		},
-			table.Entry("nonce value really below", 1),
-			table.Entry("nonce value just below", 31))
-	})
 })
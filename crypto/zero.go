@@ -0,0 +1,15 @@
+package crypto
+
+// Zero overwrites b so that plaintext and key material do not linger in
+// memory once they are no longer needed.
+func Zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// Wiper is implemented by values holding key material that can be zeroed
+// once the caller is finished with them.
+type Wiper interface {
+	Wipe()
+}
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"github.com/cloudfoundry/uaa-key-rotator/entity"
+	"github.com/pkg/errors"
+)
+
+// GoogleMfaCredentialsDBClaimer hands out the rows to rotate in batches to
+// any number of rotators at once. Each batch is locked with FOR UPDATE SKIP
+// LOCKED by the transaction that claimed it, so no two rotators ever hold
+// the same row, and a row that has been rotated and committed no longer
+// matches. It needs Postgres, or MySQL 8 or later.
+type GoogleMfaCredentialsDBClaimer struct {
+	DB               Queryer
+	ActiveKeyLabel   string
+	IncludeActiveKey bool
+	Filter           RowFilter
+	Legacy           LegacySelection
+}
+
+// Claim is a batch of rows locked by an open transaction. The rows should be
+// rotated and written through Tx, which then must be committed, or rolled
+// back to hand the rows back. Next is the cursor to claim the rows after
+// them.
+type Claim struct {
+	Tx   Tx
+	Rows []entity.MfaCredential
+	Next ClaimCursor
+}
+
+// ClaimCursor is where claiming left off. Rows are claimed in user_id order,
+// so a cursor past the rows a rotator gave up on keeps them out of its later
+// claims. The zero ClaimCursor starts from the first row.
+type ClaimCursor struct {
+	batch int
+	after string
+}
+
+// Claim locks up to limit rows to rotate that no other transaction holds,
+// starting after cursor. Filter.Limit is not applied; the caller bounds the
+// rows it claims. An empty Claim, with no transaction, means that nothing is
+// left to claim after cursor. The claim is rolled back if ctx is cancelled
+// before it is committed.
+func (c GoogleMfaCredentialsDBClaimer) Claim(ctx context.Context, limit int, cursor ClaimCursor) (Claim, error) {
+	tx, err := c.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return Claim{}, errors.Wrap(err, "Claim failed to begin transaction")
+	}
+
+	fetcher := GoogleMfaCredentialsDBFetcher{
+		DB:               tx,
+		ActiveKeyLabel:   c.ActiveKeyLabel,
+		IncludeActiveKey: c.IncludeActiveKey,
+		Filter:           c.Filter,
+		Legacy:           c.Legacy,
+	}
+
+	userIDBatches := [][]string{nil}
+	if len(c.Filter.UserIDs) > 0 {
+		userIDBatches = batchIDs(c.Filter.UserIDs, maxIDsPerQuery)
+	}
+
+	for batch := cursor.batch; batch < len(userIDBatches); batch++ {
+		after := ""
+		if batch == cursor.batch {
+			after = cursor.after
+		}
+		query, args := fetcher.rowsToRotateQuery(userIDBatches[batch], after)
+		query += fmt.Sprintf(" order by user_id limit %d for update skip locked", limit)
+
+		rows, err := fetcher.fetch(ctx, query, args)
+		if err != nil {
+			tx.Rollback()
+			return Claim{}, errors.Wrap(err, "Claim failed")
+		}
+		if len(rows) > 0 {
+			next := ClaimCursor{batch: batch, after: rows[len(rows)-1].UserId}
+			return Claim{Tx: tx, Rows: rows, Next: next}, nil
+		}
+	}
+
+	if err = tx.Rollback(); err != nil {
+		return Claim{}, errors.Wrap(err, "Claim failed to end transaction")
+	}
+	return Claim{}, nil
+}
@@ -0,0 +1,159 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	. "github.com/cloudfoundry/uaa-key-rotator/db"
+	"github.com/cloudfoundry/uaa-key-rotator/db/dbfakes"
+	"github.com/cloudfoundry/uaa-key-rotator/db/testutils"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Claimer", func() {
+	var claimer GoogleMfaCredentialsDBClaimer
+
+	BeforeEach(func() {
+		_, err := db.Exec(`delete from user_google_mfa_credentials`)
+		Expect(err).NotTo(HaveOccurred())
+
+		insertGoogleMfaCredential("1", "not-activeKeyLabel")
+		insertGoogleMfaCredential("2", "not-activeKeyLabel")
+		insertGoogleMfaCredential("3", "not-activeKeyLabel")
+		insertGoogleMfaCredential("4", "activeKeyLabel")
+
+		claimer = GoogleMfaCredentialsDBClaimer{
+			DB:             DbAwareQuerier{DB: db, DBScheme: testutils.Scheme},
+			ActiveKeyLabel: "activeKeyLabel",
+		}
+	})
+
+	userIDs := func(claim Claim) []string {
+		var ids []string
+		for _, row := range claim.Rows {
+			ids = append(ids, row.UserId)
+		}
+		return ids
+	}
+
+	It("should never hand the same row to two open claims", func() {
+		first, err := claimer.Claim(context.Background(), 2, ClaimCursor{})
+		Expect(err).NotTo(HaveOccurred())
+		defer first.Tx.Rollback()
+		Expect(userIDs(first)).To(Equal([]string{"1", "2"}))
+
+		second, err := claimer.Claim(context.Background(), 2, ClaimCursor{})
+		Expect(err).NotTo(HaveOccurred())
+		defer second.Tx.Rollback()
+		Expect(userIDs(second)).To(Equal([]string{"3"}))
+	})
+
+	It("should not claim rows again once they are rotated and committed", func() {
+		claim, err := claimer.Claim(context.Background(), 2, ClaimCursor{})
+		Expect(err).NotTo(HaveOccurred())
+
+		updater := GoogleMfaCredentialsDBUpdater{DB: claim.Tx}
+		for _, row := range claim.Rows {
+			row.EncryptionKeyLabel = "activeKeyLabel"
+			Expect(updater.Write(context.Background(), row)).To(Succeed())
+		}
+		Expect(claim.Tx.Commit()).To(Succeed())
+
+		claim, err = claimer.Claim(context.Background(), 2, ClaimCursor{})
+		Expect(err).NotTo(HaveOccurred())
+		defer claim.Tx.Rollback()
+		Expect(userIDs(claim)).To(Equal([]string{"3"}))
+	})
+
+	It("should hand rolled back rows out again", func() {
+		claim, err := claimer.Claim(context.Background(), 2, ClaimCursor{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(claim.Tx.Rollback()).To(Succeed())
+
+		claim, err = claimer.Claim(context.Background(), 2, ClaimCursor{})
+		Expect(err).NotTo(HaveOccurred())
+		defer claim.Tx.Rollback()
+		Expect(userIDs(claim)).To(Equal([]string{"1", "2"}))
+	})
+
+	It("should claim only the rows after the cursor", func() {
+		first, err := claimer.Claim(context.Background(), 1, ClaimCursor{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(userIDs(first)).To(Equal([]string{"1"}))
+		Expect(first.Tx.Rollback()).To(Succeed())
+
+		claim, err := claimer.Claim(context.Background(), 2, first.Next)
+		Expect(err).NotTo(HaveOccurred())
+		defer claim.Tx.Rollback()
+		Expect(userIDs(claim)).To(Equal([]string{"2", "3"}))
+	})
+
+	It("should return an empty claim once nothing is left after the cursor", func() {
+		claim, err := claimer.Claim(context.Background(), 3, ClaimCursor{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(claim.Tx.Rollback()).To(Succeed())
+
+		claim, err = claimer.Claim(context.Background(), 2, claim.Next)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(claim).To(Equal(Claim{}))
+	})
+
+	It("should carry the cursor across batches of user ids", func() {
+		claimer.Filter.UserIDs = []string{"3", "4"}
+		for i := 0; i < 1000; i++ {
+			claimer.Filter.UserIDs = append(claimer.Filter.UserIDs, fmt.Sprintf("missing-%d", i))
+		}
+		claimer.Filter.UserIDs = append(claimer.Filter.UserIDs, "1")
+
+		first, err := claimer.Claim(context.Background(), 2, ClaimCursor{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(userIDs(first)).To(Equal([]string{"3"}))
+		Expect(first.Tx.Rollback()).To(Succeed())
+
+		second, err := claimer.Claim(context.Background(), 2, first.Next)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(userIDs(second)).To(Equal([]string{"1"}))
+		Expect(second.Tx.Rollback()).To(Succeed())
+
+		claim, err := claimer.Claim(context.Background(), 2, second.Next)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(claim).To(Equal(Claim{}))
+	})
+
+	Context("with a fake database", func() {
+		var (
+			queryer *dbfakes.FakeQueryer
+			tx      *dbfakes.FakeTx
+		)
+
+		BeforeEach(func() {
+			queryer = &dbfakes.FakeQueryer{}
+			tx = &dbfakes.FakeTx{}
+			queryer.BeginTxxReturns(tx, nil)
+			tx.QueryxContextReturns(nil, errors.New("cannot query table"))
+
+			claimer = GoogleMfaCredentialsDBClaimer{
+				DB:             queryer,
+				ActiveKeyLabel: "activeKeyLabel",
+				Filter:         RowFilter{ZoneIDs: []string{"zone-1"}},
+			}
+		})
+
+		It("should lock the rows it selects, skipping rows locked elsewhere", func() {
+			_, err := claimer.Claim(context.Background(), 50, ClaimCursor{})
+			Expect(err).To(MatchError("Claim failed: RowsToRotate failed to query table: cannot query table"))
+
+			_, query, args := tx.QueryxContextArgsForCall(0)
+			Expect(query).To(ContainSubstring("where encryption_key_label <> ? and encryption_key_label <> '' and zone_id in (?) order by user_id limit 50 for update skip locked"))
+			Expect(args).To(Equal([]interface{}{"activeKeyLabel", "zone-1"}))
+			Expect(tx.RollbackCallCount()).To(Equal(1))
+		})
+
+		It("should return an error when the transaction cannot begin", func() {
+			queryer.BeginTxxReturns(nil, errors.New("too many connections"))
+			_, err := claimer.Claim(context.Background(), 50, ClaimCursor{})
+			Expect(err).To(MatchError("Claim failed to begin transaction: too many connections"))
+		})
+	})
+})
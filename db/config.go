@@ -3,7 +3,9 @@ package db
 import (
 	"fmt"
 	"github.com/cloudfoundry/uaa-key-rotator/config"
+	"github.com/jmoiron/sqlx"
 	"strconv"
+	"time"
 )
 
 func ConnectionURI(rotatorConfig *config.RotatorConfig) (string, error) {
@@ -60,3 +62,22 @@ func ConnectionURI(rotatorConfig *config.RotatorConfig) (string, error) {
 	}
 	return connStr, nil
 }
+
+// ConfigurePool applies the connection pool settings of the config to
+// dbConn. Settings left unset keep the database/sql defaults.
+func ConfigurePool(dbConn *sqlx.DB, rotatorConfig *config.RotatorConfig) {
+	dbConn.SetMaxOpenConns(rotatorConfig.DatabaseMaxOpenConns)
+	if rotatorConfig.DatabaseMaxIdleConns > 0 {
+		dbConn.SetMaxIdleConns(rotatorConfig.DatabaseMaxIdleConns)
+	}
+	if lifetime, err := time.ParseDuration(rotatorConfig.DatabaseConnMaxLifetime); err == nil {
+		dbConn.SetConnMaxLifetime(lifetime)
+	}
+}
+
+// StatementTimeout returns the configured bound on each statement, or zero
+// for none.
+func StatementTimeout(rotatorConfig *config.RotatorConfig) time.Duration {
+	timeout, _ := time.ParseDuration(rotatorConfig.DatabaseStatementTimeout)
+	return timeout
+}
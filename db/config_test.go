@@ -1,10 +1,13 @@
 package db_test
 
 import (
+	"database/sql"
 	"github.com/cloudfoundry/uaa-key-rotator/config"
 	db2 "github.com/cloudfoundry/uaa-key-rotator/db"
+	"github.com/jmoiron/sqlx"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"time"
 )
 
 var _ = Describe("Config", func() {
@@ -106,4 +109,24 @@ var _ = Describe("Config", func() {
 			})
 		})
 	})
+
+	Describe("pool", func() {
+		It("should apply the pool settings", func() {
+			rotatorConfig.DatabaseMaxOpenConns = 7
+			rotatorConfig.DatabaseStatementTimeout = "30s"
+
+			nativeDBConn, err := sql.Open("postgres", "postgres://localhost:9876/uaa")
+			Expect(err).NotTo(HaveOccurred())
+			dbConn := sqlx.NewDb(nativeDBConn, "postgres")
+			defer dbConn.Close()
+
+			db2.ConfigurePool(dbConn, rotatorConfig)
+			Expect(dbConn.Stats().MaxOpenConnections).To(Equal(7))
+			Expect(db2.StatementTimeout(rotatorConfig)).To(Equal(30 * time.Second))
+		})
+
+		It("should leave the statement timeout off when unset", func() {
+			Expect(db2.StatementTimeout(rotatorConfig)).To(BeZero())
+		})
+	})
 })
@@ -1,23 +1,122 @@
 package db
 
 import (
+	"context"
+	"database/sql"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
+	"time"
 )
 
+// DbAwareQuerier rebinds queries for its database. A non-zero
+// StatementTimeout bounds each statement: an ExecContext until it returns,
+// and a QueryxContext until its rows are closed, so that reading them is
+// bounded as well.
 type DbAwareQuerier struct {
-	DB       *sqlx.DB
-	DBScheme string
+	DB               *sqlx.DB
+	DBScheme         string
+	StatementTimeout time.Duration
 }
 
 func (q DbAwareQuerier) Close() error {
 	return q.DB.Close()
 }
 
-func (q DbAwareQuerier) Queryx(query string, args ...interface{}) (*sqlx.Rows, error) {
+func (q DbAwareQuerier) QueryxContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
 	reboundQuery, err := RebindForSQLDialect(query, q.DBScheme)
 	if err != nil {
 		return nil, errors.Wrap(err, "Unable to query")
 	}
-	return q.DB.Queryx(reboundQuery, args...)
+
+	ctx, cancel := withStatementTimeout(ctx, q.StatementTimeout)
+	rows, err := q.DB.QueryxContext(ctx, reboundQuery, args...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &Rows{Rows: rows, cancel: cancel}, nil
+}
+
+func (q DbAwareQuerier) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	reboundQuery, err := RebindForSQLDialect(query, q.DBScheme)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to execute")
+	}
+
+	ctx, cancel := withStatementTimeout(ctx, q.StatementTimeout)
+	defer cancel()
+	return q.DB.ExecContext(ctx, reboundQuery, args...)
+}
+
+func (q DbAwareQuerier) BeginTxx(ctx context.Context, opts *sql.TxOptions) (Tx, error) {
+	if _, err := RebindForSQLDialect("", q.DBScheme); err != nil {
+		return nil, errors.Wrap(err, "Unable to begin transaction")
+	}
+	tx, err := q.DB.BeginTxx(ctx, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to begin transaction")
+	}
+	return DbAwareTx{Tx: tx, DBScheme: q.DBScheme, StatementTimeout: q.StatementTimeout}, nil
+}
+
+// DbAwareTx is a transaction that rebinds queries for its database, as
+// DbAwareQuerier does.
+type DbAwareTx struct {
+	Tx               *sqlx.Tx
+	DBScheme         string
+	StatementTimeout time.Duration
+}
+
+func (t DbAwareTx) QueryxContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	reboundQuery, err := RebindForSQLDialect(query, t.DBScheme)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to query")
+	}
+
+	ctx, cancel := withStatementTimeout(ctx, t.StatementTimeout)
+	rows, err := t.Tx.QueryxContext(ctx, reboundQuery, args...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &Rows{Rows: rows, cancel: cancel}, nil
+}
+
+func (t DbAwareTx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	reboundQuery, err := RebindForSQLDialect(query, t.DBScheme)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to execute")
+	}
+
+	ctx, cancel := withStatementTimeout(ctx, t.StatementTimeout)
+	defer cancel()
+	return t.Tx.ExecContext(ctx, reboundQuery, args...)
+}
+
+func (t DbAwareTx) Commit() error {
+	return t.Tx.Commit()
+}
+
+func (t DbAwareTx) Rollback() error {
+	return t.Tx.Rollback()
+}
+
+// Rows are the rows of a query. Closing them ends the statement timeout
+// bounding the query.
+type Rows struct {
+	*sqlx.Rows
+	cancel context.CancelFunc
+}
+
+func (r *Rows) Close() error {
+	err := r.Rows.Close()
+	r.cancel()
+	return err
+}
+
+func withStatementTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
 }
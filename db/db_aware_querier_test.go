@@ -1,9 +1,12 @@
 package db_test
 
 import (
+	"context"
 	db2 "github.com/cloudfoundry/uaa-key-rotator/db"
+	"github.com/cloudfoundry/uaa-key-rotator/db/testutils"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"time"
 )
 
 var _ = Describe("DbAwareQuerier", func() {
@@ -21,9 +24,71 @@ var _ = Describe("DbAwareQuerier", func() {
 		})
 
 		It("should return an error", func() {
-			_, err := dbAwareQuerier.Queryx("")
+			_, err := dbAwareQuerier.QueryxContext(context.Background(), "")
 			Expect(err).To(HaveOccurred())
 			Expect(err).To(MatchError("Unable to query: Unrecognized DB dialect 'unknown'"))
 		})
+
+		It("should not execute a statement", func() {
+			_, err := dbAwareQuerier.ExecContext(context.Background(), "")
+			Expect(err).To(MatchError("Unable to execute: Unrecognized DB dialect 'unknown'"))
+		})
+
+		It("should not take an advisory lock", func() {
+			_, err := dbAwareQuerier.AdvisoryLock(context.Background(), "some-lock", 0)
+			Expect(err).To(MatchError("Unable to lock: Unrecognized DB dialect 'unknown'"))
+		})
+	})
+
+	Context("with a statement timeout", func() {
+		var sleepQuery string
+
+		BeforeEach(func() {
+			dbAwareQuerier = db2.DbAwareQuerier{DB: db, DBScheme: testutils.Scheme, StatementTimeout: 200 * time.Millisecond}
+			sleepQuery = `select pg_sleep(5)`
+			if testutils.Scheme == "mysql" {
+				sleepQuery = `select sleep(5)`
+			}
+		})
+
+		It("should cancel a slow query", func() {
+			start := time.Now()
+			rows, err := dbAwareQuerier.QueryxContext(context.Background(), sleepQuery)
+			if err == nil {
+				for rows.Next() {
+				}
+				err = rows.Err()
+				rows.Close()
+			}
+			Expect(err).To(HaveOccurred())
+			Expect(time.Since(start)).To(BeNumerically("<", 2*time.Second))
+		})
+
+		It("should cancel a slow query within a transaction", func() {
+			tx, err := dbAwareQuerier.BeginTxx(context.Background(), nil)
+			Expect(err).NotTo(HaveOccurred())
+			defer tx.Rollback()
+
+			start := time.Now()
+			rows, err := tx.QueryxContext(context.Background(), sleepQuery)
+			if err == nil {
+				for rows.Next() {
+				}
+				err = rows.Err()
+				rows.Close()
+			}
+			Expect(err).To(HaveOccurred())
+			Expect(time.Since(start)).To(BeNumerically("<", 2*time.Second))
+		})
+
+		It("should leave a quick query readable until its rows are closed", func() {
+			rows, err := dbAwareQuerier.QueryxContext(context.Background(), `select 1`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rows.Next()).To(BeTrue())
+			var one int
+			Expect(rows.Scan(&one)).To(Succeed())
+			Expect(one).To(Equal(1))
+			Expect(rows.Close()).To(Succeed())
+		})
 	})
 })
@@ -29,17 +29,24 @@ var _ = BeforeSuite(func() {
 })
 
 func insertGoogleMfaCredential(userId string, activeKeyLabel string) entity.MfaCredential {
+	return insertGoogleMfaCredentialInZone(userId, activeKeyLabel, "zone_id")
+}
+
+func insertGoogleMfaCredentialInZone(userId string, activeKeyLabel string, zoneId string) entity.MfaCredential {
 	mfaCredential := entity.MfaCredential{
 		UserId:                  userId,
 		SecretKey:               "secret-key",
-		ScratchCodes:            "scratch_codes",
+		ScratchCodes:            sql.NullString{String: "scratch_codes", Valid: true},
 		MfaProviderId:           "mfa_provider_id",
-		ZoneId:                  "zone_id",
+		ZoneId:                  entity.Char(zoneId),
 		EncryptionKeyLabel:      activeKeyLabel,
-		EncryptedValidationCode: "encrypted_validation_code",
+		EncryptedValidationCode: sql.NullString{String: "encrypted_validation_code", Valid: true},
 		ValidationCode:          sql.NullInt64{Int64: 1234, Valid: true},
 	}
+	return insertMfaCredential(mfaCredential)
+}
 
+func insertMfaCredential(mfaCredential entity.MfaCredential) entity.MfaCredential {
 	insertSQL, err := db2.RebindForSQLDialect(`insert into user_google_mfa_credentials(
 		user_id, 
 		secret_key, 
@@ -2,25 +2,87 @@
 package dbfakes
 
 import (
+	"context"
+	"database/sql"
 	"sync"
+	"time"
 
 	"github.com/cloudfoundry/uaa-key-rotator/db"
-	"github.com/jmoiron/sqlx"
 )
 
 type FakeQueryer struct {
-	QueryxStub        func(query string, args ...interface{}) (*sqlx.Rows, error)
-	queryxMutex       sync.RWMutex
-	queryxArgsForCall []struct {
+	QueryxContextStub        func(ctx context.Context, query string, args ...interface{}) (*db.Rows, error)
+	queryxContextMutex       sync.RWMutex
+	queryxContextArgsForCall []struct {
+		ctx   context.Context
 		query string
 		args  []interface{}
 	}
-	queryxReturns struct {
-		result1 *sqlx.Rows
+	queryxContextReturns struct {
+		result1 *db.Rows
 		result2 error
 	}
-	queryxReturnsOnCall map[int]struct {
-		result1 *sqlx.Rows
+	queryxContextReturnsOnCall map[int]struct {
+		result1 *db.Rows
+		result2 error
+	}
+	ExecContextStub        func(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	execContextMutex       sync.RWMutex
+	execContextArgsForCall []struct {
+		ctx   context.Context
+		query string
+		args  []interface{}
+	}
+	execContextReturns struct {
+		result1 sql.Result
+		result2 error
+	}
+	execContextReturnsOnCall map[int]struct {
+		result1 sql.Result
+		result2 error
+	}
+	BeginTxxStub        func(ctx context.Context, opts *sql.TxOptions) (db.Tx, error)
+	beginTxxMutex       sync.RWMutex
+	beginTxxArgsForCall []struct {
+		ctx  context.Context
+		opts *sql.TxOptions
+	}
+	beginTxxReturns struct {
+		result1 db.Tx
+		result2 error
+	}
+	beginTxxReturnsOnCall map[int]struct {
+		result1 db.Tx
+		result2 error
+	}
+	AdvisoryLockStub        func(ctx context.Context, name string, wait time.Duration) (db.AdvisoryLock, error)
+	advisoryLockMutex       sync.RWMutex
+	advisoryLockArgsForCall []struct {
+		ctx  context.Context
+		name string
+		wait time.Duration
+	}
+	advisoryLockReturns struct {
+		result1 db.AdvisoryLock
+		result2 error
+	}
+	advisoryLockReturnsOnCall map[int]struct {
+		result1 db.AdvisoryLock
+		result2 error
+	}
+	SharedAdvisoryLockStub        func(ctx context.Context, name string, wait time.Duration) (db.AdvisoryLock, error)
+	sharedAdvisoryLockMutex       sync.RWMutex
+	sharedAdvisoryLockArgsForCall []struct {
+		ctx  context.Context
+		name string
+		wait time.Duration
+	}
+	sharedAdvisoryLockReturns struct {
+		result1 db.AdvisoryLock
+		result2 error
+	}
+	sharedAdvisoryLockReturnsOnCall map[int]struct {
+		result1 db.AdvisoryLock
 		result2 error
 	}
 	CloseStub        func() error
@@ -36,54 +98,266 @@ type FakeQueryer struct {
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *FakeQueryer) Queryx(query string, args ...interface{}) (*sqlx.Rows, error) {
-	fake.queryxMutex.Lock()
-	ret, specificReturn := fake.queryxReturnsOnCall[len(fake.queryxArgsForCall)]
-	fake.queryxArgsForCall = append(fake.queryxArgsForCall, struct {
+func (fake *FakeQueryer) QueryxContext(ctx context.Context, query string, args ...interface{}) (*db.Rows, error) {
+	fake.queryxContextMutex.Lock()
+	ret, specificReturn := fake.queryxContextReturnsOnCall[len(fake.queryxContextArgsForCall)]
+	fake.queryxContextArgsForCall = append(fake.queryxContextArgsForCall, struct {
+		ctx   context.Context
+		query string
+		args  []interface{}
+	}{ctx, query, args})
+	fake.recordInvocation("QueryxContext", []interface{}{ctx, query, args})
+	fake.queryxContextMutex.Unlock()
+	if fake.QueryxContextStub != nil {
+		return fake.QueryxContextStub(ctx, query, args...)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.queryxContextReturns.result1, fake.queryxContextReturns.result2
+}
+
+func (fake *FakeQueryer) QueryxContextCallCount() int {
+	fake.queryxContextMutex.RLock()
+	defer fake.queryxContextMutex.RUnlock()
+	return len(fake.queryxContextArgsForCall)
+}
+
+func (fake *FakeQueryer) QueryxContextArgsForCall(i int) (context.Context, string, []interface{}) {
+	fake.queryxContextMutex.RLock()
+	defer fake.queryxContextMutex.RUnlock()
+	return fake.queryxContextArgsForCall[i].ctx, fake.queryxContextArgsForCall[i].query, fake.queryxContextArgsForCall[i].args
+}
+
+func (fake *FakeQueryer) QueryxContextReturns(result1 *db.Rows, result2 error) {
+	fake.QueryxContextStub = nil
+	fake.queryxContextReturns = struct {
+		result1 *db.Rows
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeQueryer) QueryxContextReturnsOnCall(i int, result1 *db.Rows, result2 error) {
+	fake.QueryxContextStub = nil
+	if fake.queryxContextReturnsOnCall == nil {
+		fake.queryxContextReturnsOnCall = make(map[int]struct {
+			result1 *db.Rows
+			result2 error
+		})
+	}
+	fake.queryxContextReturnsOnCall[i] = struct {
+		result1 *db.Rows
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeQueryer) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	fake.execContextMutex.Lock()
+	ret, specificReturn := fake.execContextReturnsOnCall[len(fake.execContextArgsForCall)]
+	fake.execContextArgsForCall = append(fake.execContextArgsForCall, struct {
+		ctx   context.Context
 		query string
 		args  []interface{}
-	}{query, args})
-	fake.recordInvocation("Queryx", []interface{}{query, args})
-	fake.queryxMutex.Unlock()
-	if fake.QueryxStub != nil {
-		return fake.QueryxStub(query, args...)
+	}{ctx, query, args})
+	fake.recordInvocation("ExecContext", []interface{}{ctx, query, args})
+	fake.execContextMutex.Unlock()
+	if fake.ExecContextStub != nil {
+		return fake.ExecContextStub(ctx, query, args...)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.execContextReturns.result1, fake.execContextReturns.result2
+}
+
+func (fake *FakeQueryer) ExecContextCallCount() int {
+	fake.execContextMutex.RLock()
+	defer fake.execContextMutex.RUnlock()
+	return len(fake.execContextArgsForCall)
+}
+
+func (fake *FakeQueryer) ExecContextArgsForCall(i int) (context.Context, string, []interface{}) {
+	fake.execContextMutex.RLock()
+	defer fake.execContextMutex.RUnlock()
+	return fake.execContextArgsForCall[i].ctx, fake.execContextArgsForCall[i].query, fake.execContextArgsForCall[i].args
+}
+
+func (fake *FakeQueryer) ExecContextReturns(result1 sql.Result, result2 error) {
+	fake.ExecContextStub = nil
+	fake.execContextReturns = struct {
+		result1 sql.Result
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeQueryer) ExecContextReturnsOnCall(i int, result1 sql.Result, result2 error) {
+	fake.ExecContextStub = nil
+	if fake.execContextReturnsOnCall == nil {
+		fake.execContextReturnsOnCall = make(map[int]struct {
+			result1 sql.Result
+			result2 error
+		})
+	}
+	fake.execContextReturnsOnCall[i] = struct {
+		result1 sql.Result
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeQueryer) BeginTxx(ctx context.Context, opts *sql.TxOptions) (db.Tx, error) {
+	fake.beginTxxMutex.Lock()
+	ret, specificReturn := fake.beginTxxReturnsOnCall[len(fake.beginTxxArgsForCall)]
+	fake.beginTxxArgsForCall = append(fake.beginTxxArgsForCall, struct {
+		ctx  context.Context
+		opts *sql.TxOptions
+	}{ctx, opts})
+	fake.recordInvocation("BeginTxx", []interface{}{ctx, opts})
+	fake.beginTxxMutex.Unlock()
+	if fake.BeginTxxStub != nil {
+		return fake.BeginTxxStub(ctx, opts)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.beginTxxReturns.result1, fake.beginTxxReturns.result2
+}
+
+func (fake *FakeQueryer) BeginTxxCallCount() int {
+	fake.beginTxxMutex.RLock()
+	defer fake.beginTxxMutex.RUnlock()
+	return len(fake.beginTxxArgsForCall)
+}
+
+func (fake *FakeQueryer) BeginTxxArgsForCall(i int) (context.Context, *sql.TxOptions) {
+	fake.beginTxxMutex.RLock()
+	defer fake.beginTxxMutex.RUnlock()
+	return fake.beginTxxArgsForCall[i].ctx, fake.beginTxxArgsForCall[i].opts
+}
+
+func (fake *FakeQueryer) BeginTxxReturns(result1 db.Tx, result2 error) {
+	fake.BeginTxxStub = nil
+	fake.beginTxxReturns = struct {
+		result1 db.Tx
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeQueryer) BeginTxxReturnsOnCall(i int, result1 db.Tx, result2 error) {
+	fake.BeginTxxStub = nil
+	if fake.beginTxxReturnsOnCall == nil {
+		fake.beginTxxReturnsOnCall = make(map[int]struct {
+			result1 db.Tx
+			result2 error
+		})
+	}
+	fake.beginTxxReturnsOnCall[i] = struct {
+		result1 db.Tx
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeQueryer) AdvisoryLock(ctx context.Context, name string, wait time.Duration) (db.AdvisoryLock, error) {
+	fake.advisoryLockMutex.Lock()
+	ret, specificReturn := fake.advisoryLockReturnsOnCall[len(fake.advisoryLockArgsForCall)]
+	fake.advisoryLockArgsForCall = append(fake.advisoryLockArgsForCall, struct {
+		ctx  context.Context
+		name string
+		wait time.Duration
+	}{ctx, name, wait})
+	fake.recordInvocation("AdvisoryLock", []interface{}{ctx, name, wait})
+	fake.advisoryLockMutex.Unlock()
+	if fake.AdvisoryLockStub != nil {
+		return fake.AdvisoryLockStub(ctx, name, wait)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.advisoryLockReturns.result1, fake.advisoryLockReturns.result2
+}
+
+func (fake *FakeQueryer) AdvisoryLockCallCount() int {
+	fake.advisoryLockMutex.RLock()
+	defer fake.advisoryLockMutex.RUnlock()
+	return len(fake.advisoryLockArgsForCall)
+}
+
+func (fake *FakeQueryer) AdvisoryLockArgsForCall(i int) (context.Context, string, time.Duration) {
+	fake.advisoryLockMutex.RLock()
+	defer fake.advisoryLockMutex.RUnlock()
+	return fake.advisoryLockArgsForCall[i].ctx, fake.advisoryLockArgsForCall[i].name, fake.advisoryLockArgsForCall[i].wait
+}
+
+func (fake *FakeQueryer) AdvisoryLockReturns(result1 db.AdvisoryLock, result2 error) {
+	fake.AdvisoryLockStub = nil
+	fake.advisoryLockReturns = struct {
+		result1 db.AdvisoryLock
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeQueryer) AdvisoryLockReturnsOnCall(i int, result1 db.AdvisoryLock, result2 error) {
+	fake.AdvisoryLockStub = nil
+	if fake.advisoryLockReturnsOnCall == nil {
+		fake.advisoryLockReturnsOnCall = make(map[int]struct {
+			result1 db.AdvisoryLock
+			result2 error
+		})
+	}
+	fake.advisoryLockReturnsOnCall[i] = struct {
+		result1 db.AdvisoryLock
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeQueryer) SharedAdvisoryLock(ctx context.Context, name string, wait time.Duration) (db.AdvisoryLock, error) {
+	fake.sharedAdvisoryLockMutex.Lock()
+	ret, specificReturn := fake.sharedAdvisoryLockReturnsOnCall[len(fake.sharedAdvisoryLockArgsForCall)]
+	fake.sharedAdvisoryLockArgsForCall = append(fake.sharedAdvisoryLockArgsForCall, struct {
+		ctx  context.Context
+		name string
+		wait time.Duration
+	}{ctx, name, wait})
+	fake.recordInvocation("SharedAdvisoryLock", []interface{}{ctx, name, wait})
+	fake.sharedAdvisoryLockMutex.Unlock()
+	if fake.SharedAdvisoryLockStub != nil {
+		return fake.SharedAdvisoryLockStub(ctx, name, wait)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2
 	}
-	return fake.queryxReturns.result1, fake.queryxReturns.result2
+	return fake.sharedAdvisoryLockReturns.result1, fake.sharedAdvisoryLockReturns.result2
 }
 
-func (fake *FakeQueryer) QueryxCallCount() int {
-	fake.queryxMutex.RLock()
-	defer fake.queryxMutex.RUnlock()
-	return len(fake.queryxArgsForCall)
+func (fake *FakeQueryer) SharedAdvisoryLockCallCount() int {
+	fake.sharedAdvisoryLockMutex.RLock()
+	defer fake.sharedAdvisoryLockMutex.RUnlock()
+	return len(fake.sharedAdvisoryLockArgsForCall)
 }
 
-func (fake *FakeQueryer) QueryxArgsForCall(i int) (string, []interface{}) {
-	fake.queryxMutex.RLock()
-	defer fake.queryxMutex.RUnlock()
-	return fake.queryxArgsForCall[i].query, fake.queryxArgsForCall[i].args
+func (fake *FakeQueryer) SharedAdvisoryLockArgsForCall(i int) (context.Context, string, time.Duration) {
+	fake.sharedAdvisoryLockMutex.RLock()
+	defer fake.sharedAdvisoryLockMutex.RUnlock()
+	return fake.sharedAdvisoryLockArgsForCall[i].ctx, fake.sharedAdvisoryLockArgsForCall[i].name, fake.sharedAdvisoryLockArgsForCall[i].wait
 }
 
-func (fake *FakeQueryer) QueryxReturns(result1 *sqlx.Rows, result2 error) {
-	fake.QueryxStub = nil
-	fake.queryxReturns = struct {
-		result1 *sqlx.Rows
+func (fake *FakeQueryer) SharedAdvisoryLockReturns(result1 db.AdvisoryLock, result2 error) {
+	fake.SharedAdvisoryLockStub = nil
+	fake.sharedAdvisoryLockReturns = struct {
+		result1 db.AdvisoryLock
 		result2 error
 	}{result1, result2}
 }
 
-func (fake *FakeQueryer) QueryxReturnsOnCall(i int, result1 *sqlx.Rows, result2 error) {
-	fake.QueryxStub = nil
-	if fake.queryxReturnsOnCall == nil {
-		fake.queryxReturnsOnCall = make(map[int]struct {
-			result1 *sqlx.Rows
+func (fake *FakeQueryer) SharedAdvisoryLockReturnsOnCall(i int, result1 db.AdvisoryLock, result2 error) {
+	fake.SharedAdvisoryLockStub = nil
+	if fake.sharedAdvisoryLockReturnsOnCall == nil {
+		fake.sharedAdvisoryLockReturnsOnCall = make(map[int]struct {
+			result1 db.AdvisoryLock
 			result2 error
 		})
 	}
-	fake.queryxReturnsOnCall[i] = struct {
-		result1 *sqlx.Rows
+	fake.sharedAdvisoryLockReturnsOnCall[i] = struct {
+		result1 db.AdvisoryLock
 		result2 error
 	}{result1, result2}
 }
@@ -131,8 +405,16 @@ func (fake *FakeQueryer) CloseReturnsOnCall(i int, result1 error) {
 func (fake *FakeQueryer) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
-	fake.queryxMutex.RLock()
-	defer fake.queryxMutex.RUnlock()
+	fake.queryxContextMutex.RLock()
+	defer fake.queryxContextMutex.RUnlock()
+	fake.execContextMutex.RLock()
+	defer fake.execContextMutex.RUnlock()
+	fake.beginTxxMutex.RLock()
+	defer fake.beginTxxMutex.RUnlock()
+	fake.advisoryLockMutex.RLock()
+	defer fake.advisoryLockMutex.RUnlock()
+	fake.sharedAdvisoryLockMutex.RLock()
+	defer fake.sharedAdvisoryLockMutex.RUnlock()
 	fake.closeMutex.RLock()
 	defer fake.closeMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
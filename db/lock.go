@@ -0,0 +1,346 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/pkg/errors"
+	"hash/fnv"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// rotatorLockPrefix starts the name of the rotator lock of every database.
+const rotatorLockPrefix = "uaa-key-rotator"
+
+// maxLockNameLength is the longest lock name MySQL's GET_LOCK accepts.
+const maxLockNameLength = 64
+
+// lockRetryInterval is how often a lock held elsewhere is asked for again
+// while waiting for it.
+const lockRetryInterval = 500 * time.Millisecond
+
+// mysqlSharedSlots bounds the number of sessions that can hold a shared lock
+// at once on MySQL, which has no shared named locks. Each holds one of as
+// many named locks, which an exclusive lock checks are all free.
+const mysqlSharedSlots = 64
+
+// ErrLockHeld is the cause of the error returned when another session holds
+// the advisory lock.
+var ErrLockHeld = errors.New("lock is held by another session")
+
+// RotatorLockName names the advisory lock a rotator holds on databaseName
+// for the whole of a run, so that two rotators never rotate the same rows at
+// once. GET_LOCK names are shared by every database of a MySQL server, so
+// the name includes the database, for rotators of different databases on
+// one server not to lock each other out.
+func RotatorLockName(databaseName string) string {
+	return fitLockName(rotatorLockPrefix+":"+databaseName, maxLockNameLength)
+}
+
+// AdvisoryLock is a named lock held by a database session of its own. It is
+// held until Release, or until that session ends.
+type AdvisoryLock interface {
+	Release() error
+}
+
+// LockHolder describes the database session holding an advisory lock.
+// Fields the database does not report are left empty.
+type LockHolder struct {
+	ConnectionID int64
+	User         string
+	Host         string
+	Application  string
+	Since        string
+}
+
+func (h LockHolder) String() string {
+	description := []string{fmt.Sprintf("connection %d", h.ConnectionID)}
+	for _, field := range []struct{ name, value string }{
+		{"user", h.User},
+		{"host", h.Host},
+		{"application", h.Application},
+		{"since", h.Since},
+	} {
+		if field.value != "" {
+			description = append(description, fmt.Sprintf("%s %s", field.name, field.value))
+		}
+	}
+	return strings.Join(description, ", ")
+}
+
+// AdvisoryLock takes the named lock exclusively on a dedicated connection,
+// using pg_try_advisory_lock on Postgres and GET_LOCK on MySQL. When the lock
+// is held elsewhere, shared or not, it waits up to wait for it, then fails
+// with ErrLockHeld and a description of the session holding it.
+func (q DbAwareQuerier) AdvisoryLock(ctx context.Context, name string, wait time.Duration) (AdvisoryLock, error) {
+	return q.advisoryLock(ctx, name, false, wait)
+}
+
+// SharedAdvisoryLock takes the named lock in shared mode, which any number of
+// sessions can hold at once but none while it is held exclusively, using
+// pg_try_advisory_lock_shared on Postgres. MySQL has no shared locks, so
+// each holder takes one of mysqlSharedSlots named locks derived from name
+// instead. It waits and fails as AdvisoryLock does.
+func (q DbAwareQuerier) SharedAdvisoryLock(ctx context.Context, name string, wait time.Duration) (AdvisoryLock, error) {
+	return q.advisoryLock(ctx, name, true, wait)
+}
+
+func (q DbAwareQuerier) advisoryLock(ctx context.Context, name string, shared bool, wait time.Duration) (AdvisoryLock, error) {
+	if q.DBScheme != "postgres" && q.DBScheme != "mysql" {
+		return nil, errors.Errorf("Unable to lock: Unrecognized DB dialect '%s'", q.DBScheme)
+	}
+
+	conn, err := q.DB.Conn(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to lock")
+	}
+
+	lock := &sessionLock{conn: conn, scheme: q.DBScheme, name: name, shared: shared, timeout: q.StatementTimeout}
+	acquired, err := lock.acquire(ctx, wait)
+	if err == nil && !acquired {
+		err = lock.heldError(ctx)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return lock, nil
+}
+
+// sessionLock is an advisory lock held by conn. On MySQL, held is the name
+// of the lock taken, and busy the name of the lock last found held by
+// another session. timeout bounds each of its queries, as StatementTimeout
+// does those of a DbAwareQuerier.
+type sessionLock struct {
+	conn    *sql.Conn
+	scheme  string
+	name    string
+	shared  bool
+	timeout time.Duration
+	held    string
+	busy    string
+}
+
+func (l *sessionLock) acquire(ctx context.Context, wait time.Duration) (bool, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		acquired, err := l.tryAcquire(ctx)
+		if err != nil {
+			return false, errors.Wrapf(err, "Unable to lock %s", l.name)
+		}
+		remaining := time.Until(deadline)
+		if acquired || remaining <= 0 {
+			return acquired, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return false, errors.Wrapf(ctx.Err(), "Unable to lock %s", l.name)
+		case <-time.After(minDuration(remaining, lockRetryInterval)):
+		}
+	}
+}
+
+func (l *sessionLock) tryAcquire(ctx context.Context) (bool, error) {
+	if l.scheme == "mysql" {
+		if l.shared {
+			return l.tryAcquireMySQLShared(ctx)
+		}
+		return l.tryAcquireMySQL(ctx)
+	}
+
+	query := `select pg_try_advisory_lock(?)`
+	if l.shared {
+		query = `select pg_try_advisory_lock_shared(?)`
+	}
+	var acquired bool
+	err := l.queryRow(ctx, query, l.key()).Scan(&acquired)
+	return acquired, err
+}
+
+// tryAcquireMySQL takes the named lock, then gives it back unless every
+// shared slot is free. As a shared holder takes its slot before checking the
+// named lock is free, the two never both succeed.
+func (l *sessionLock) tryAcquireMySQL(ctx context.Context) (bool, error) {
+	acquired, err := l.getLock(ctx, l.name)
+	if err != nil || !acquired {
+		l.busy = l.name
+		return false, err
+	}
+
+	for i := 0; i < mysqlSharedSlots; i++ {
+		slot := l.slotName(i)
+		used, err := l.isUsedLock(ctx, slot)
+		if err == nil && !used {
+			continue
+		}
+		if releaseErr := l.releaseLock(ctx, l.name); err == nil {
+			err = releaseErr
+		}
+		l.busy = slot
+		return false, err
+	}
+
+	l.held = l.name
+	return true, nil
+}
+
+// tryAcquireMySQLShared takes the first free shared slot, then gives it back
+// unless the named lock is free.
+func (l *sessionLock) tryAcquireMySQLShared(ctx context.Context) (bool, error) {
+	for i := 0; i < mysqlSharedSlots; i++ {
+		slot := l.slotName(i)
+		acquired, err := l.getLock(ctx, slot)
+		if err != nil {
+			return false, err
+		}
+		if !acquired {
+			continue
+		}
+
+		used, err := l.isUsedLock(ctx, l.name)
+		if err == nil && !used {
+			l.held = slot
+			return true, nil
+		}
+		if releaseErr := l.releaseLock(ctx, slot); err == nil {
+			err = releaseErr
+		}
+		l.busy = l.name
+		return false, err
+	}
+	return false, errors.Errorf("all %d shared slots are held", mysqlSharedSlots)
+}
+
+func (l *sessionLock) getLock(ctx context.Context, name string) (bool, error) {
+	var acquired sql.NullInt64
+	if err := l.queryRow(ctx, `select get_lock(?, 0)`, name).Scan(&acquired); err != nil {
+		return false, err
+	}
+	if !acquired.Valid {
+		return false, errors.New("get_lock failed")
+	}
+	return acquired.Int64 == 1, nil
+}
+
+func (l *sessionLock) isUsedLock(ctx context.Context, name string) (bool, error) {
+	var connectionID sql.NullInt64
+	err := l.queryRow(ctx, `select is_used_lock(?)`, name).Scan(&connectionID)
+	return connectionID.Valid, err
+}
+
+func (l *sessionLock) releaseLock(ctx context.Context, name string) error {
+	var released sql.NullInt64
+	return l.queryRow(ctx, `select release_lock(?)`, name).Scan(&released)
+}
+
+func (l *sessionLock) slotName(slot int) string {
+	suffix := fmt.Sprintf(":shared:%d", slot)
+	return fitLockName(l.name, maxLockNameLength-len(suffix)) + suffix
+}
+
+func (l *sessionLock) heldError(ctx context.Context) error {
+	holder, err := l.holder(ctx)
+	if err != nil {
+		return errors.Wrapf(ErrLockHeld, "advisory lock %s is held by an unknown session (%s)", l.name, err)
+	}
+	return errors.Wrapf(ErrLockHeld, "advisory lock %s is held by %s", l.name, holder)
+}
+
+func (l *sessionLock) holder(ctx context.Context) (LockHolder, error) {
+	var holder LockHolder
+	var err error
+	if l.scheme == "mysql" {
+		err = l.queryRow(ctx, `select id, user, host from information_schema.processlist
+			where id = is_used_lock(?)`, l.busy).Scan(&holder.ConnectionID, &holder.User, &holder.Host)
+	} else {
+		key := uint64(l.key())
+		err = l.queryRow(ctx, `select a.pid, coalesce(a.usename, ''), coalesce(host(a.client_addr), ''), coalesce(a.application_name, ''), a.backend_start::text
+			from pg_locks l join pg_stat_activity a on a.pid = l.pid
+			where l.locktype = 'advisory' and l.granted and l.objsubid = 1
+			and l.classid::bigint = ? and l.objid::bigint = ?
+			and l.database = (select oid from pg_database where datname = current_database())`,
+			int64(key>>32), int64(key&math.MaxUint32)).Scan(&holder.ConnectionID, &holder.User, &holder.Host, &holder.Application, &holder.Since)
+	}
+	if err == sql.ErrNoRows {
+		return holder, errors.New("the lock was released while looking up its holder")
+	}
+	return holder, err
+}
+
+func (l *sessionLock) Release() error {
+	var err error
+	if l.scheme == "mysql" {
+		err = l.releaseLock(context.Background(), l.held)
+	} else {
+		query := `select pg_advisory_unlock(?)`
+		if l.shared {
+			query = `select pg_advisory_unlock_shared(?)`
+		}
+		var released sql.NullBool
+		err = l.queryRow(context.Background(), query, l.key()).Scan(&released)
+	}
+
+	closeErr := l.conn.Close()
+	if err != nil {
+		return errors.Wrapf(err, "Unable to release lock %s", l.name)
+	}
+	if closeErr != nil {
+		return errors.Wrapf(closeErr, "Unable to release lock %s", l.name)
+	}
+	return nil
+}
+
+func (l *sessionLock) queryRow(ctx context.Context, query string, args ...interface{}) lockRow {
+	reboundQuery, _ := RebindForSQLDialect(query, l.scheme)
+	ctx, cancel := withStatementTimeout(ctx, l.timeout)
+	return lockRow{Row: l.conn.QueryRowContext(ctx, reboundQuery, args...), cancel: cancel}
+}
+
+// lockRow is the row of a lock query, whose timeout ends once it is scanned.
+type lockRow struct {
+	*sql.Row
+	cancel context.CancelFunc
+}
+
+func (r lockRow) Scan(dest ...interface{}) error {
+	defer r.cancel()
+	return r.Row.Scan(dest...)
+}
+
+// key maps the lock name onto the 64 bit key space of Postgres advisory
+// locks.
+func (l *sessionLock) key() int64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(l.name))
+	return int64(hash.Sum64())
+}
+
+// fitLockName shortens a name longer than max bytes to a prefix of it
+// followed by a hash of the whole name, so that names sharing a long prefix
+// still differ.
+func fitLockName(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+	hash := fnv.New64a()
+	hash.Write([]byte(name))
+	suffix := fmt.Sprintf("#%016x", hash.Sum64())
+
+	end := max - len(suffix)
+	for end > 0 && !utf8.RuneStart(name[end]) {
+		end--
+	}
+	return name[:end] + suffix
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
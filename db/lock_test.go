@@ -0,0 +1,150 @@
+package db_test
+
+import (
+	"context"
+	db2 "github.com/cloudfoundry/uaa-key-rotator/db"
+	"github.com/cloudfoundry/uaa-key-rotator/db/testutils"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+var _ = Describe("AdvisoryLock", func() {
+	var (
+		querier  db2.DbAwareQuerier
+		lockName string
+		held     db2.AdvisoryLock
+	)
+
+	BeforeEach(func() {
+		querier = db2.DbAwareQuerier{DB: db, DBScheme: testutils.Scheme}
+		lockName = "uaa-key-rotator-test-" + getRandomTimestamp()
+
+		var err error
+		held, err = querier.AdvisoryLock(context.Background(), lockName, 0)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		if held != nil {
+			Expect(held.Release()).To(Succeed())
+		}
+	})
+
+	It("should fail fast and describe the holder while the lock is held", func() {
+		start := time.Now()
+		_, err := querier.AdvisoryLock(context.Background(), lockName, 0)
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		Expect(errors.Cause(err)).To(Equal(db2.ErrLockHeld))
+		Expect(err).To(MatchError(MatchRegexp("advisory lock %s is held by connection \\d+", lockName)))
+	})
+
+	It("should be available once released", func() {
+		Expect(held.Release()).To(Succeed())
+		held = nil
+
+		lock, err := querier.AdvisoryLock(context.Background(), lockName, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lock.Release()).To(Succeed())
+	})
+
+	It("should wait for a lock released within the timeout", func() {
+		go func() {
+			defer GinkgoRecover()
+			time.Sleep(500 * time.Millisecond)
+			Expect(held.Release()).To(Succeed())
+		}()
+
+		lock, err := querier.AdvisoryLock(context.Background(), lockName, 5*time.Second)
+		held = nil
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lock.Release()).To(Succeed())
+	})
+
+	It("should give up once the timeout has passed", func() {
+		start := time.Now()
+		_, err := querier.AdvisoryLock(context.Background(), lockName, time.Second)
+		Expect(time.Since(start)).To(BeNumerically(">=", 900*time.Millisecond))
+		Expect(errors.Cause(err)).To(Equal(db2.ErrLockHeld))
+	})
+
+	Context("shared", func() {
+		It("should be refused while the lock is held exclusively", func() {
+			_, err := querier.SharedAdvisoryLock(context.Background(), lockName, 0)
+			Expect(errors.Cause(err)).To(Equal(db2.ErrLockHeld))
+			Expect(err).To(MatchError(MatchRegexp("advisory lock %s is held by connection \\d+", lockName)))
+		})
+
+		Context("once the exclusive lock is released", func() {
+			BeforeEach(func() {
+				Expect(held.Release()).To(Succeed())
+				held = nil
+			})
+
+			It("should be held by several sessions at once", func() {
+				first, err := querier.SharedAdvisoryLock(context.Background(), lockName, 0)
+				Expect(err).NotTo(HaveOccurred())
+				defer first.Release()
+
+				second, err := querier.SharedAdvisoryLock(context.Background(), lockName, 0)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(second.Release()).To(Succeed())
+			})
+
+			It("should refuse the exclusive lock until every shared holder releases it", func() {
+				first, err := querier.SharedAdvisoryLock(context.Background(), lockName, 0)
+				Expect(err).NotTo(HaveOccurred())
+				second, err := querier.SharedAdvisoryLock(context.Background(), lockName, 0)
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = querier.AdvisoryLock(context.Background(), lockName, 0)
+				Expect(errors.Cause(err)).To(Equal(db2.ErrLockHeld))
+				Expect(err).To(MatchError(MatchRegexp("advisory lock %s is held by connection \\d+", lockName)))
+
+				Expect(first.Release()).To(Succeed())
+				_, err = querier.AdvisoryLock(context.Background(), lockName, 0)
+				Expect(errors.Cause(err)).To(Equal(db2.ErrLockHeld))
+
+				Expect(second.Release()).To(Succeed())
+				held, err = querier.AdvisoryLock(context.Background(), lockName, 0)
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+	})
+
+	Context("the rotator lock", func() {
+		It("should be named after the database, within the length GET_LOCK accepts", func() {
+			Expect(db2.RotatorLockName("uaa")).To(Equal("uaa-key-rotator:uaa"))
+
+			long := db2.RotatorLockName(strings.Repeat("a", 80) + "-east")
+			Expect(long).To(HaveLen(64))
+			Expect(long).To(HavePrefix("uaa-key-rotator:aaaa"))
+			Expect(db2.RotatorLockName(strings.Repeat("a", 80) + "-west")).NotTo(Equal(long))
+		})
+
+		It("should not lock out the rotator of another database on the same server", func() {
+			east, err := querier.AdvisoryLock(context.Background(), db2.RotatorLockName(lockName+"-east"), 0)
+			Expect(err).NotTo(HaveOccurred())
+			defer east.Release()
+
+			west, err := querier.AdvisoryLock(context.Background(), db2.RotatorLockName(lockName+"-west"), 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(west.Release()).To(Succeed())
+
+			_, err = querier.AdvisoryLock(context.Background(), db2.RotatorLockName(lockName+"-east"), 0)
+			Expect(errors.Cause(err)).To(Equal(db2.ErrLockHeld))
+		})
+
+		It("should not lock out claiming rotators of another database on the same server", func() {
+			east, err := querier.AdvisoryLock(context.Background(), db2.RotatorLockName(strings.Repeat("a", 80)+lockName+"-east"), 0)
+			Expect(err).NotTo(HaveOccurred())
+			defer east.Release()
+
+			west, err := querier.SharedAdvisoryLock(context.Background(), db2.RotatorLockName(strings.Repeat("a", 80)+lockName+"-west"), 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(west.Release()).To(Succeed())
+		})
+	})
+})
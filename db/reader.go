@@ -1,47 +1,153 @@
 package db
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
 	"github.com/cloudfoundry/uaa-key-rotator/entity"
-	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
+	"strings"
+	"time"
 )
 
 //go:generate counterfeiter . Queryer
 type Queryer interface {
-	Queryx(query string, args ...interface{}) (*sqlx.Rows, error)
+	QueryxContext(ctx context.Context, query string, args ...interface{}) (*Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	BeginTxx(ctx context.Context, opts *sql.TxOptions) (Tx, error)
+	AdvisoryLock(ctx context.Context, name string, wait time.Duration) (AdvisoryLock, error)
+	SharedAdvisoryLock(ctx context.Context, name string, wait time.Duration) (AdvisoryLock, error)
 	Close() error
 }
 
+// RowQueryer runs statements either on their own or within a transaction.
+type RowQueryer interface {
+	QueryxContext(ctx context.Context, query string, args ...interface{}) (*Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+//go:generate counterfeiter . Tx
+
+// Tx is a transaction begun by a Queryer. It is rolled back if the context
+// it was begun with is cancelled before it is committed.
+type Tx interface {
+	QueryxContext(ctx context.Context, query string, args ...interface{}) (*Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	Commit() error
+	Rollback() error
+}
+
+// encryption_key_label is NULL in rows written before UAA encrypted MFA
+// credentials, and is read as empty.
+const selectGoogleMfaCredentialsQuery = `select user_id, mfa_provider_id, zone_id, validation_code, scratch_codes, coalesce(encryption_key_label, '') as encryption_key_label, encrypted_validation_code, secret_key
+		 from user_google_mfa_credentials`
+
+// maxIDsPerQuery bounds the number of user ids bound into a single query,
+// keeping well clear of the bind parameter limits of both databases.
+const maxIDsPerQuery = 1000
+
+// rowsPerPage is how many rows each query of RowsToRotate reads. The rows of
+// a page are read in full before they are rotated, so that the statement
+// timeout bounds each query rather than the whole pass.
+const rowsPerPage = 1000
+
+// RowFilter narrows the rows selected for rotation. Empty fields do not
+// filter, and a zero Limit selects every matching row.
+type RowFilter struct {
+	ZoneIDs      []string
+	UserIDs      []string
+	SourceLabels []string
+	Limit        int
+}
+
+// LegacySelection selects rows that still hold plaintext, instead of rows
+// encrypted with a key other than the active one.
+type LegacySelection struct {
+	// PlaintextRows selects rows with an empty or NULL encryption_key_label,
+	// whose values were never encrypted.
+	PlaintextRows bool
+	// ValidationCodes selects rows that still hold a plaintext validation_code.
+	ValidationCodes bool
+}
+
+func (s LegacySelection) Enabled() bool {
+	return s.PlaintextRows || s.ValidationCodes
+}
+
+// Fetcher selects the credentials to rotate, and reads back a credential
+// once it is written. GoogleMfaCredentialsDBFetcher reads them from the
+// database.
+type Fetcher interface {
+	RowsToRotate(ctx context.Context) (<-chan entity.MfaCredential, <-chan error)
+	Row(ctx context.Context, userID string) (entity.MfaCredential, error)
+}
+
+// GoogleMfaCredentialsDBFetcher selects the rows encrypted with a key other
+// than ActiveKeyLabel. IncludeActiveKey selects the rows labelled with the
+// active key as well, for trial decryption to find their columns encrypted
+// with another key.
 type GoogleMfaCredentialsDBFetcher struct {
-	DB             Queryer
-	ActiveKeyLabel string
+	DB               RowQueryer
+	ActiveKeyLabel   string
+	IncludeActiveKey bool
+	Filter           RowFilter
+	Legacy           LegacySelection
 }
 
-func (gdb GoogleMfaCredentialsDBFetcher) RowsToRotate() (<-chan entity.MfaCredential, <-chan error) {
+// RowsToRotate streams the rows to rotate, in user_id order, until they run
+// out or ctx is cancelled, which also aborts the query in progress. The rows
+// are read a page at a time, each page after the last user_id of the one
+// before.
+func (gdb GoogleMfaCredentialsDBFetcher) RowsToRotate(ctx context.Context) (<-chan entity.MfaCredential, <-chan error) {
 	var mfaCredentialChan = make(chan entity.MfaCredential)
 	var errChan = make(chan error)
 
 	go func() {
-		rows, err := gdb.DB.Queryx(
-			`select user_id, mfa_provider_id, zone_id, validation_code, scratch_codes, encryption_key_label, encrypted_validation_code, secret_key
-		 from user_google_mfa_credentials
-         where encryption_key_label <> ?`,
-			gdb.ActiveKeyLabel)
-		if err != nil {
-			errChan <- errors.Wrap(err, "RowsToRotate failed to query table")
-			return
+		userIDBatches := [][]string{nil}
+		if len(gdb.Filter.UserIDs) > 0 {
+			userIDBatches = batchIDs(gdb.Filter.UserIDs, maxIDsPerQuery)
 		}
 
-		defer rows.Close() // untested
+		remaining := gdb.Filter.Limit
+		for _, userIDs := range userIDBatches {
+			after := ""
+			for {
+				pageSize := rowsPerPage
+				if gdb.Filter.Limit > 0 && remaining < pageSize {
+					pageSize = remaining
+				}
+				query, args := gdb.rowsToRotateQuery(userIDs, after)
+				query += fmt.Sprintf(" order by user_id limit %d", pageSize)
+
+				page, err := gdb.fetch(ctx, query, args)
+				if err != nil {
+					select {
+					case errChan <- err:
+					case <-ctx.Done():
+					}
+					return
+				}
 
-		for rows.Next() {
-			mfaCredential := entity.MfaCredential{}
-			err = rows.StructScan(&mfaCredential)
-			if err != nil {
-				errChan <- errors.Wrap(err, "Unable to deserialize db response")
-				return
+				for _, mfaCredential := range page {
+					select {
+					case mfaCredentialChan <- mfaCredential:
+					case <-ctx.Done():
+						return
+					}
+				}
+
+				if gdb.Filter.Limit > 0 {
+					remaining -= len(page)
+					if remaining <= 0 {
+						close(mfaCredentialChan)
+						return
+					}
+				}
+				if len(page) < pageSize {
+					break
+				}
+				after = page[len(page)-1].UserId
 			}
-			mfaCredentialChan <- mfaCredential
 		}
 
 		close(mfaCredentialChan)
@@ -49,3 +155,116 @@ func (gdb GoogleMfaCredentialsDBFetcher) RowsToRotate() (<-chan entity.MfaCreden
 
 	return mfaCredentialChan, errChan
 }
+
+// Row reads the current state of a single credential, for example to check
+// what was written after an update.
+func (gdb GoogleMfaCredentialsDBFetcher) Row(ctx context.Context, userID string) (entity.MfaCredential, error) {
+	rows, err := gdb.DB.QueryxContext(ctx, selectGoogleMfaCredentialsQuery+`
+         where user_id = ?`, userID)
+	if err != nil {
+		return entity.MfaCredential{}, errors.Wrap(err, "Row failed to query table")
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return entity.MfaCredential{}, errors.Wrap(err, "Row failed to query table")
+		}
+		return entity.MfaCredential{}, errors.Errorf("no mfa credential found for user %s", userID)
+	}
+
+	mfaCredential := entity.MfaCredential{}
+	if err = rows.StructScan(&mfaCredential); err != nil {
+		return entity.MfaCredential{}, errors.Wrap(err, "Unable to deserialize db response")
+	}
+	return mfaCredential, nil
+}
+
+// fetch reads every row selected by query.
+func (gdb GoogleMfaCredentialsDBFetcher) fetch(ctx context.Context, query string, args []interface{}) ([]entity.MfaCredential, error) {
+	rows, err := gdb.DB.QueryxContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "RowsToRotate failed to query table")
+	}
+
+	defer rows.Close() // untested
+
+	var mfaCredentials []entity.MfaCredential
+	for rows.Next() {
+		mfaCredential := entity.MfaCredential{}
+		err = rows.StructScan(&mfaCredential)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to deserialize db response")
+		}
+		mfaCredentials = append(mfaCredentials, mfaCredential)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "RowsToRotate failed to query table")
+	}
+	return mfaCredentials, nil
+}
+
+// rowsToRotateQuery selects the rows to rotate among userIDs, if any, whose
+// user_id comes after after, if set. Callers add the order and limit.
+func (gdb GoogleMfaCredentialsDBFetcher) rowsToRotateQuery(userIDs []string, after string) (string, []interface{}) {
+	query, args := gdb.selectionQuery()
+
+	query, args = appendInClause(query, args, "zone_id", gdb.Filter.ZoneIDs)
+	query, args = appendInClause(query, args, "user_id", userIDs)
+	query, args = appendInClause(query, args, "encryption_key_label", gdb.Filter.SourceLabels)
+
+	if after != "" {
+		query += " and user_id > ?"
+		args = append(args, after)
+	}
+
+	return query, args
+}
+
+// selectionQuery selects rows encrypted with a key other than the active
+// one, or with any key when IncludeActiveKey is set. Plaintext rows are left
+// for a legacy migration, which selects them instead.
+func (gdb GoogleMfaCredentialsDBFetcher) selectionQuery() (string, []interface{}) {
+	if !gdb.Legacy.Enabled() && gdb.IncludeActiveKey {
+		return selectGoogleMfaCredentialsQuery + `
+         where encryption_key_label <> ''`, nil
+	}
+	if !gdb.Legacy.Enabled() {
+		return selectGoogleMfaCredentialsQuery + `
+         where encryption_key_label <> ? and encryption_key_label <> ''`, []interface{}{gdb.ActiveKeyLabel}
+	}
+
+	var conditions []string
+	if gdb.Legacy.PlaintextRows {
+		conditions = append(conditions, "coalesce(encryption_key_label, '') = ''")
+	}
+	if gdb.Legacy.ValidationCodes {
+		conditions = append(conditions, "validation_code is not null")
+	}
+	return selectGoogleMfaCredentialsQuery + `
+         where (` + strings.Join(conditions, " or ") + `)`, nil
+}
+
+func appendInClause(query string, args []interface{}, column string, values []string) (string, []interface{}) {
+	if len(values) == 0 {
+		return query, args
+	}
+
+	placeholders := make([]string, len(values))
+	for i, value := range values {
+		placeholders[i] = "?"
+		args = append(args, value)
+	}
+
+	return fmt.Sprintf("%s and %s in (%s)", query, column, strings.Join(placeholders, ", ")), args
+}
+
+func batchIDs(ids []string, size int) [][]string {
+	var batches [][]string
+	for len(ids) > size {
+		batches = append(batches, ids[:size])
+		ids = ids[size:]
+	}
+	return append(batches, ids)
+}
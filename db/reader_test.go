@@ -1,8 +1,10 @@
 package db_test
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	. "github.com/cloudfoundry/uaa-key-rotator/db"
 	"github.com/cloudfoundry/uaa-key-rotator/db/dbfakes"
 	"github.com/cloudfoundry/uaa-key-rotator/db/testutils"
@@ -37,7 +39,7 @@ var _ = Describe("Postgresql", func() {
 		var mfaCredentials <-chan entity.MfaCredential
 		var errChan <-chan error
 
-		mfaCredentials, errChan = googleMfaCredentialsDB.RowsToRotate()
+		mfaCredentials, errChan = googleMfaCredentialsDB.RowsToRotate(context.Background())
 		Consistently(errChan).ShouldNot(Receive())
 
 		var mfaCredential entity.MfaCredential
@@ -48,10 +50,10 @@ var _ = Describe("Postgresql", func() {
 				MfaProviderId:           entity.Char("mfa_provider_id"),
 				ZoneId:                  entity.Char("zone_id"),
 				ValidationCode:          sql.NullInt64{Int64: 1234, Valid: true},
-				ScratchCodes:            "scratch_codes",
+				ScratchCodes:            sql.NullString{String: "scratch_codes", Valid: true},
 				SecretKey:               "secret-key",
 				EncryptionKeyLabel:      "not-activeKeyLabel",
-				EncryptedValidationCode: "encrypted_validation_code",
+				EncryptedValidationCode: sql.NullString{String: "encrypted_validation_code", Valid: true},
 			},
 		))
 
@@ -63,21 +65,206 @@ var _ = Describe("Postgresql", func() {
 				ZoneId:                  entity.Char("zone_id"),
 				ValidationCode:          sql.NullInt64{Int64: 1234, Valid: true},
 				SecretKey:               "secret-key",
-				ScratchCodes:            "scratch_codes",
+				ScratchCodes:            sql.NullString{String: "scratch_codes", Valid: true},
 				EncryptionKeyLabel:      "not-activeKeyLabel",
-				EncryptedValidationCode: "encrypted_validation_code",
+				EncryptedValidationCode: sql.NullString{String: "encrypted_validation_code", Valid: true},
 			},
 		))
 
 	})
 
+	Describe("Row", func() {
+		It("should return the credential for the given user", func() {
+			mfaCredential, err := googleMfaCredentialsDB.Row(context.Background(), "3")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mfaCredential.UserId).To(Equal("3"))
+			Expect(mfaCredential.EncryptionKeyLabel).To(Equal("activeKeyLabel"))
+		})
+
+		It("should return an error when the user has no credential", func() {
+			_, err := googleMfaCredentialsDB.Row(context.Background(), "missing")
+			Expect(err).To(MatchError("no mfa credential found for user missing"))
+		})
+	})
+
+	Context("when encrypted columns are NULL or empty", func() {
+		BeforeEach(func() {
+			nullColumns := insertGoogleMfaCredential("5", "not-activeKeyLabel")
+			nullColumns.ScratchCodes = sql.NullString{}
+			nullColumns.EncryptedValidationCode = sql.NullString{}
+			emptyColumns := insertGoogleMfaCredential("6", "not-activeKeyLabel")
+			emptyColumns.ScratchCodes = sql.NullString{String: "", Valid: true}
+			emptyColumns.EncryptedValidationCode = sql.NullString{String: "", Valid: true}
+
+			updater := GoogleMfaCredentialsDBUpdater{DB: googleMfaCredentialsDB.DB}
+			Expect(updater.Write(context.Background(), nullColumns)).To(Succeed())
+			Expect(updater.Write(context.Background(), emptyColumns)).To(Succeed())
+		})
+
+		It("should distinguish NULL from empty columns", func() {
+			nullColumns, err := googleMfaCredentialsDB.Row(context.Background(), "5")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nullColumns.ScratchCodes).To(Equal(sql.NullString{}))
+			Expect(nullColumns.EncryptedValidationCode).To(Equal(sql.NullString{}))
+			Expect(nullColumns.SecretKey).To(Equal("secret-key"))
+
+			emptyColumns, err := googleMfaCredentialsDB.Row(context.Background(), "6")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(emptyColumns.ScratchCodes).To(Equal(sql.NullString{String: "", Valid: true}))
+			Expect(emptyColumns.EncryptedValidationCode).To(Equal(sql.NullString{String: "", Valid: true}))
+		})
+
+		It("should select them for rotation", func() {
+			mfaCredentials, errChan := googleMfaCredentialsDB.RowsToRotate(context.Background())
+
+			var userIDs []string
+			for mfaCredential := range mfaCredentials {
+				userIDs = append(userIDs, mfaCredential.UserId)
+			}
+			Consistently(errChan).ShouldNot(Receive())
+			Expect(userIDs).To(ConsistOf("1", "2", "5", "6"))
+		})
+	})
+
+	Context("when the zone and provider columns are NULL", func() {
+		BeforeEach(func() {
+			insertGoogleMfaCredential("5", "not-activeKeyLabel")
+
+			clearColumnsSQL, err := RebindForSQLDialect(`update user_google_mfa_credentials set zone_id = null, mfa_provider_id = null where user_id = ?`, testutils.Scheme)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = db.Exec(clearColumnsSQL, "5")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should read them as empty", func() {
+			mfaCredential, err := googleMfaCredentialsDB.Row(context.Background(), "5")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mfaCredential.ZoneId).To(BeEmpty())
+			Expect(mfaCredential.MfaProviderId).To(BeEmpty())
+		})
+
+		It("should select them for rotation", func() {
+			mfaCredentials, errChan := googleMfaCredentialsDB.RowsToRotate(context.Background())
+
+			var userIDs []string
+			for mfaCredential := range mfaCredentials {
+				userIDs = append(userIDs, mfaCredential.UserId)
+			}
+			Consistently(errChan).ShouldNot(Receive())
+			Expect(userIDs).To(ConsistOf("1", "2", "5"))
+		})
+	})
+
+	Context("when rows were never encrypted", func() {
+		BeforeEach(func() {
+			insertGoogleMfaCredential("5", "")
+			insertGoogleMfaCredential("6", "")
+
+			clearLabelSQL, err := RebindForSQLDialect(`update user_google_mfa_credentials set encryption_key_label = null, validation_code = null where user_id = ?`, testutils.Scheme)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = db.Exec(clearLabelSQL, "6")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		receiveUserIDs := func() []string {
+			mfaCredentials, errChan := googleMfaCredentialsDB.RowsToRotate(context.Background())
+
+			var userIDs []string
+			for mfaCredential := range mfaCredentials {
+				userIDs = append(userIDs, mfaCredential.UserId)
+			}
+			Expect(errChan).NotTo(Receive())
+			return userIDs
+		}
+
+		It("should not select them for rotation", func() {
+			Expect(receiveUserIDs()).To(ConsistOf("1", "2"))
+		})
+
+		It("should select them for a plaintext migration", func() {
+			googleMfaCredentialsDB.Legacy = LegacySelection{PlaintextRows: true}
+			Expect(receiveUserIDs()).To(ConsistOf("5", "6"))
+		})
+
+		It("should read a NULL label as empty", func() {
+			mfaCredential, err := googleMfaCredentialsDB.Row(context.Background(), "6")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mfaCredential.EncryptionKeyLabel).To(BeEmpty())
+			Expect(mfaCredential.ValidationCode).To(Equal(sql.NullInt64{}))
+		})
+
+		It("should select rows holding a plaintext validation code", func() {
+			googleMfaCredentialsDB.Legacy = LegacySelection{ValidationCodes: true}
+			Expect(receiveUserIDs()).To(ConsistOf("1", "2", "3", "4", "5"))
+		})
+	})
+
+	Context("when rows are filtered", func() {
+		BeforeEach(func() {
+			insertGoogleMfaCredentialInZone("5", "other-label", "canary-zone")
+			insertGoogleMfaCredentialInZone("6", "not-activeKeyLabel", "canary-zone")
+		})
+
+		receiveUserIDs := func() []string {
+			mfaCredentials, errChan := googleMfaCredentialsDB.RowsToRotate(context.Background())
+
+			var userIDs []string
+			for mfaCredential := range mfaCredentials {
+				userIDs = append(userIDs, mfaCredential.UserId)
+			}
+			Expect(errChan).NotTo(Receive())
+			return userIDs
+		}
+
+		It("should only return rows in the given zones", func() {
+			googleMfaCredentialsDB.Filter = RowFilter{ZoneIDs: []string{"canary-zone"}}
+			Expect(receiveUserIDs()).To(ConsistOf("5", "6"))
+		})
+
+		It("should only return rows for the given users", func() {
+			googleMfaCredentialsDB.Filter = RowFilter{UserIDs: []string{"1", "3", "6"}}
+			Expect(receiveUserIDs()).To(ConsistOf("1", "6"))
+		})
+
+		It("should only return rows encrypted with the given labels", func() {
+			googleMfaCredentialsDB.Filter = RowFilter{SourceLabels: []string{"other-label"}}
+			Expect(receiveUserIDs()).To(ConsistOf("5"))
+		})
+
+		It("should combine filters", func() {
+			googleMfaCredentialsDB.Filter = RowFilter{ZoneIDs: []string{"canary-zone"}, SourceLabels: []string{"not-activeKeyLabel"}}
+			Expect(receiveUserIDs()).To(ConsistOf("6"))
+		})
+
+		It("should also return rows labelled with the active key for trial decryption", func() {
+			googleMfaCredentialsDB.IncludeActiveKey = true
+			Expect(receiveUserIDs()).To(ConsistOf("1", "2", "3", "4", "5", "6"))
+		})
+
+		It("should return at most limit rows", func() {
+			googleMfaCredentialsDB.Filter = RowFilter{Limit: 2}
+			Expect(receiveUserIDs()).To(Equal([]string{"1", "2"}))
+		})
+
+		It("should apply the limit across batches of user ids", func() {
+			userIDs := []string{"6", "5"}
+			for i := 0; i < 1500; i++ {
+				userIDs = append(userIDs, fmt.Sprintf("missing-%d", i))
+			}
+			userIDs = append(userIDs, "1", "2")
+
+			googleMfaCredentialsDB.Filter = RowFilter{UserIDs: userIDs, Limit: 3}
+			Expect(receiveUserIDs()).To(Equal([]string{"5", "6", "1"}))
+		})
+	})
+
 	Describe("FakeDB", func() {
 		var queryer *dbfakes.FakeQueryer
 
 		Context("error during querying mfa table", func() {
 			BeforeEach(func() {
 				queryer = &dbfakes.FakeQueryer{}
-				queryer.QueryxReturns(nil, errors.New("cannot query table"))
+				queryer.QueryxContextReturns(nil, errors.New("cannot query table"))
 				googleMfaCredentialsDB = GoogleMfaCredentialsDBFetcher{
 					DB:             queryer,
 					ActiveKeyLabel: "activeKeyLabel",
@@ -85,11 +272,39 @@ var _ = Describe("Postgresql", func() {
 			})
 
 			It("should return a meaningful error", func() {
-				_, errChan := googleMfaCredentialsDB.RowsToRotate()
+				_, errChan := googleMfaCredentialsDB.RowsToRotate(context.Background())
 				var err error
 				Eventually(errChan).Should(Receive(&err))
 				Expect(err).To(MatchError("RowsToRotate failed to query table: cannot query table"))
 			})
+
+			It("should push filters down into the query", func() {
+				googleMfaCredentialsDB.Filter = RowFilter{
+					ZoneIDs:      []string{"zone-1", "zone-2"},
+					UserIDs:      []string{"user-1"},
+					SourceLabels: []string{"compromised-key"},
+					Limit:        10,
+				}
+
+				_, errChan := googleMfaCredentialsDB.RowsToRotate(context.Background())
+				Eventually(errChan).Should(Receive())
+
+				Expect(queryer.QueryxContextCallCount()).To(Equal(1))
+				_, query, args := queryer.QueryxContextArgsForCall(0)
+				Expect(query).To(ContainSubstring("where encryption_key_label <> ? and encryption_key_label <> '' and zone_id in (?, ?) and user_id in (?) and encryption_key_label in (?) order by user_id limit 10"))
+				Expect(args).To(Equal([]interface{}{"activeKeyLabel", "zone-1", "zone-2", "user-1", "compromised-key"}))
+			})
+
+			It("should not leave out the active key for trial decryption", func() {
+				googleMfaCredentialsDB.IncludeActiveKey = true
+
+				_, errChan := googleMfaCredentialsDB.RowsToRotate(context.Background())
+				Eventually(errChan).Should(Receive())
+
+				_, query, args := queryer.QueryxContextArgsForCall(0)
+				Expect(query).To(ContainSubstring("where encryption_key_label <> '' order by user_id"))
+				Expect(args).To(BeEmpty())
+			})
 		})
 	})
 
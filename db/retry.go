@@ -0,0 +1,241 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"github.com/cloudfoundry/uaa-key-rotator/config"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"io"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// DefaultRetryPolicy is used for the retry options left unset in config.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	StartupTimeout: 30 * time.Second,
+}
+
+// RetryPolicy bounds the retries of transient database errors. The backoff
+// doubles with each attempt, up to MaxBackoff, and is jittered so that the
+// workers of a run do not retry in lockstep. StartupTimeout bounds the wait
+// for the database to become reachable when the rotator starts.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	StartupTimeout time.Duration
+}
+
+// NewRetryPolicy returns DefaultRetryPolicy with the options set in config
+// applied. The config must have been validated.
+func NewRetryPolicy(retryConfig config.RetryConfig) RetryPolicy {
+	policy := DefaultRetryPolicy
+	if retryConfig.MaxAttempts > 0 {
+		policy.MaxAttempts = retryConfig.MaxAttempts
+	}
+	for _, duration := range []struct {
+		value  string
+		target *time.Duration
+	}{
+		{retryConfig.InitialBackoff, &policy.InitialBackoff},
+		{retryConfig.MaxBackoff, &policy.MaxBackoff},
+		{retryConfig.StartupTimeout, &policy.StartupTimeout},
+	} {
+		if parsed, err := time.ParseDuration(duration.value); err == nil {
+			*duration.target = parsed
+		}
+	}
+	return policy
+}
+
+// ErrTransientDB matches, with errors.Is, the transient database errors that
+// were retried until the retry policy gave up on them.
+var ErrTransientDB = errors.New("transient database error")
+
+// transientError is a transient database error that was given up on. It
+// keeps the driver error as its cause, so that IsRetryable still sees it.
+type transientError struct {
+	message string
+	cause   error
+}
+
+func (e transientError) Error() string {
+	return e.message + ": " + e.cause.Error()
+}
+
+func (e transientError) Is(target error) bool {
+	return target == ErrTransientDB
+}
+
+func (e transientError) Unwrap() error {
+	return e.cause
+}
+
+func (e transientError) Cause() error {
+	return e.cause
+}
+
+// IsRetryable reports whether err is a transient database error, which the
+// same statement is likely to get past when tried again: a deadlock, a lock
+// wait timeout, a serialization failure or a lost connection. Any other
+// error, such as a constraint violation or a syntax error, is fatal.
+func IsRetryable(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case 1040, // too many connections
+			1053, // server shutdown in progress
+			1205, // lock wait timeout exceeded
+			1213: // deadlock found
+			return true
+		}
+		return false
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "08", // connection exception
+			"40", // transaction rollback, including serialization failures and deadlocks
+			"53": // insufficient resources
+			return true
+		}
+		switch pqErr.Code {
+		case "57P01", // admin_shutdown
+			"57P02", // crash_shutdown
+			"57P03": // cannot_connect_now
+			return true
+		}
+		return false
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+
+	for _, transient := range []error{driver.ErrBadConn, mysql.ErrInvalidConn, io.EOF, io.ErrUnexpectedEOF} {
+		if errors.Is(err, transient) {
+			return true
+		}
+	}
+	return false
+}
+
+// Backoff returns the jittered delay before the given retry, counting from
+// one: between half of and the whole exponential backoff.
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < retry && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// Do runs op until it succeeds, fails with an error that is not retryable,
+// or has been tried MaxAttempts times. onRetry, if set, is told of each
+// error that is about to be retried.
+func (p RetryPolicy) Do(ctx context.Context, op func() error, onRetry func(attempt int, err error)) error {
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || !IsRetryable(err) {
+			return err
+		}
+		if attempt >= p.MaxAttempts {
+			return transientError{message: fmt.Sprintf("giving up after %d attempts", attempt), cause: err}
+		}
+
+		if onRetry != nil {
+			onRetry(attempt, err)
+		}
+		if err := sleep(ctx, p.Backoff(attempt)); err != nil {
+			return err
+		}
+	}
+}
+
+// WaitForStartup runs op, usually a ping, until it succeeds, fails with an
+// error that is not retryable, or StartupTimeout has passed.
+func (p RetryPolicy) WaitForStartup(ctx context.Context, op func() error, onRetry func(attempt int, err error)) error {
+	deadline := time.Now().Add(p.StartupTimeout)
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || !IsRetryable(err) {
+			return err
+		}
+
+		backoff := p.Backoff(attempt)
+		if time.Now().Add(backoff).After(deadline) {
+			return transientError{message: fmt.Sprintf("database still unavailable after %s", p.StartupTimeout), cause: err}
+		}
+
+		if onRetry != nil {
+			onRetry(attempt, err)
+		}
+		if err := sleep(ctx, backoff); err != nil {
+			return err
+		}
+	}
+}
+
+func sleep(ctx context.Context, duration time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(duration):
+		return nil
+	}
+}
+
+// RetryingQueryer retries the statements and transactions begun through a
+// Queryer that fail with a retryable error. Statements within a transaction
+// are not retried, as the transaction is aborted along with them.
+type RetryingQueryer struct {
+	Queryer
+	Policy  RetryPolicy
+	OnRetry func(attempt int, err error)
+}
+
+func (q RetryingQueryer) QueryxContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	var rows *Rows
+	err := q.Policy.Do(ctx, func() error {
+		var err error
+		rows, err = q.Queryer.QueryxContext(ctx, query, args...)
+		return err
+	}, q.OnRetry)
+	return rows, err
+}
+
+func (q RetryingQueryer) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	var result sql.Result
+	err := q.Policy.Do(ctx, func() error {
+		var err error
+		result, err = q.Queryer.ExecContext(ctx, query, args...)
+		return err
+	}, q.OnRetry)
+	return result, err
+}
+
+func (q RetryingQueryer) BeginTxx(ctx context.Context, opts *sql.TxOptions) (Tx, error) {
+	var tx Tx
+	err := q.Policy.Do(ctx, func() error {
+		var err error
+		tx, err = q.Queryer.BeginTxx(ctx, opts)
+		return err
+	}, q.OnRetry)
+	return tx, err
+}
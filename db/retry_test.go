@@ -0,0 +1,167 @@
+package db_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"github.com/cloudfoundry/uaa-key-rotator/config"
+	. "github.com/cloudfoundry/uaa-key-rotator/db"
+	"github.com/cloudfoundry/uaa-key-rotator/db/dbfakes"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	"net"
+	"time"
+)
+
+var _ = Describe("Retry", func() {
+	var policy RetryPolicy
+
+	BeforeEach(func() {
+		policy = RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     4 * time.Millisecond,
+			StartupTimeout: 50 * time.Millisecond,
+		}
+	})
+
+	table.DescribeTable("classifying errors", func(err error, retryable bool) {
+		Expect(IsRetryable(err)).To(Equal(retryable))
+		Expect(IsRetryable(errors.Wrap(err, "Unable to update mfa db record"))).To(Equal(retryable))
+	},
+		table.Entry("mysql deadlock", &mysql.MySQLError{Number: 1213}, true),
+		table.Entry("mysql lock wait timeout", &mysql.MySQLError{Number: 1205}, true),
+		table.Entry("mysql duplicate key", &mysql.MySQLError{Number: 1062}, false),
+		table.Entry("mysql invalid connection", mysql.ErrInvalidConn, true),
+		table.Entry("postgres serialization failure", &pq.Error{Code: "40001"}, true),
+		table.Entry("postgres deadlock", &pq.Error{Code: "40P01"}, true),
+		table.Entry("postgres connection failure", &pq.Error{Code: "08006"}, true),
+		table.Entry("postgres admin shutdown", &pq.Error{Code: "57P01"}, true),
+		table.Entry("postgres syntax error", &pq.Error{Code: "42601"}, false),
+		table.Entry("postgres bad password", &pq.Error{Code: "28P01"}, false),
+		table.Entry("bad connection", driver.ErrBadConn, true),
+		table.Entry("refused connection", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, true),
+		table.Entry("anything else", errors.New("some error"), false),
+	)
+
+	It("should keep the backoff within its jitter and bounds", func() {
+		policy.InitialBackoff = 100 * time.Millisecond
+		policy.MaxBackoff = time.Second
+
+		for i := 0; i < 20; i++ {
+			Expect(policy.Backoff(1)).To(BeNumerically("~", 75*time.Millisecond, 25*time.Millisecond))
+			Expect(policy.Backoff(3)).To(BeNumerically("~", 300*time.Millisecond, 100*time.Millisecond))
+			Expect(policy.Backoff(10)).To(BeNumerically("~", 750*time.Millisecond, 250*time.Millisecond))
+		}
+	})
+
+	It("should take the options set in config over the defaults", func() {
+		policy := NewRetryPolicy(config.RetryConfig{MaxAttempts: 2, MaxBackoff: "1s"})
+		Expect(policy).To(Equal(RetryPolicy{
+			MaxAttempts:    2,
+			InitialBackoff: DefaultRetryPolicy.InitialBackoff,
+			MaxBackoff:     time.Second,
+			StartupTimeout: DefaultRetryPolicy.StartupTimeout,
+		}))
+	})
+
+	Describe("Do", func() {
+		It("should retry retryable errors until it succeeds", func() {
+			var retried []int
+			attempts := 0
+			err := policy.Do(context.Background(), func() error {
+				attempts++
+				if attempts < 3 {
+					return &mysql.MySQLError{Number: 1213, Message: "Deadlock found"}
+				}
+				return nil
+			}, func(attempt int, err error) {
+				retried = append(retried, attempt)
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(retried).To(Equal([]int{1, 2}))
+		})
+
+		It("should give up once its attempts are used up", func() {
+			attempts := 0
+			err := policy.Do(context.Background(), func() error {
+				attempts++
+				return &pq.Error{Code: "40001", Message: "could not serialize access"}
+			}, nil)
+			Expect(attempts).To(Equal(3))
+			Expect(err).To(MatchError("giving up after 3 attempts: pq: could not serialize access"))
+			Expect(errors.Is(errors.Wrap(err, "unable to rotate"), ErrTransientDB)).To(BeTrue())
+			Expect(IsRetryable(err)).To(BeTrue())
+		})
+
+		It("should not retry a fatal error", func() {
+			attempts := 0
+			err := policy.Do(context.Background(), func() error {
+				attempts++
+				return &pq.Error{Code: "42601", Message: "syntax error"}
+			}, nil)
+			Expect(attempts).To(Equal(1))
+			Expect(err).To(MatchError("pq: syntax error"))
+			Expect(errors.Is(err, ErrTransientDB)).To(BeFalse())
+		})
+
+		It("should stop waiting when the context is cancelled", func() {
+			policy.InitialBackoff = time.Hour
+			policy.MaxBackoff = time.Hour
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			err := policy.Do(ctx, func() error { return driver.ErrBadConn }, nil)
+			Expect(err).To(Equal(context.Canceled))
+		})
+	})
+
+	Describe("WaitForStartup", func() {
+		It("should give up once the startup timeout has passed", func() {
+			start := time.Now()
+			err := policy.WaitForStartup(context.Background(), func() error {
+				return &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+			}, nil)
+			Expect(err).To(MatchError("database still unavailable after 50ms: dial: connection refused"))
+			Expect(errors.Is(err, ErrTransientDB)).To(BeTrue())
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		})
+	})
+
+	Describe("RetryingQueryer", func() {
+		var (
+			queryer  *dbfakes.FakeQueryer
+			retrying RetryingQueryer
+		)
+
+		BeforeEach(func() {
+			queryer = &dbfakes.FakeQueryer{}
+			retrying = RetryingQueryer{Queryer: queryer, Policy: policy}
+		})
+
+		It("should retry a statement that hit a deadlock", func() {
+			queryer.ExecContextReturnsOnCall(0, nil, &mysql.MySQLError{Number: 1213})
+			queryer.ExecContextReturnsOnCall(1, nil, nil)
+
+			_, err := retrying.ExecContext(context.Background(), "update user_google_mfa_credentials set secret_key = ?", "some-key")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(queryer.ExecContextCallCount()).To(Equal(2))
+			_, query, args := queryer.ExecContextArgsForCall(1)
+			Expect(query).To(Equal("update user_google_mfa_credentials set secret_key = ?"))
+			Expect(args).To(Equal([]interface{}{"some-key"}))
+		})
+
+		It("should retry beginning a transaction", func() {
+			queryer.BeginTxxReturnsOnCall(0, nil, driver.ErrBadConn)
+			queryer.BeginTxxReturnsOnCall(1, &dbfakes.FakeTx{}, nil)
+
+			tx, err := retrying.BeginTxx(context.Background(), nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tx).NotTo(BeNil())
+			Expect(queryer.BeginTxxCallCount()).To(Equal(2))
+		})
+	})
+})
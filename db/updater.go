@@ -1,33 +1,107 @@
 package db
 
 import (
+	"context"
 	"github.com/cloudfoundry/uaa-key-rotator/entity"
 	"github.com/pkg/errors"
 )
 
+const GoogleMfaCredentialsTable = "user_google_mfa_credentials"
+
 var updateGoogleMfaCredentialQuery = `update
 user_google_mfa_credentials
 set secret_key = ?,
 scratch_codes = ?,
 encryption_key_label = ?,
-encrypted_validation_code = ?
+encrypted_validation_code = ?,
+validation_code = ?
 where user_id = ?`
 
+// Updater writes rotated credentials. GoogleMfaCredentialsDBUpdater writes
+// them to the database. Swap writes only over a row that has not changed
+// since it was read.
+type Updater interface {
+	Write(ctx context.Context, credential entity.MfaCredential) error
+	Swap(ctx context.Context, current entity.MfaCredential, replacement entity.MfaCredential) error
+}
+
+// ErrWriteFailed matches, with errors.Is, every error returned when the
+// database fails to write a credential.
+var ErrWriteFailed = errors.New("Unable to update mfa db record")
+
+// writeError is a failed write, with the database error as its cause.
+type writeError struct {
+	cause error
+}
+
+func (e writeError) Error() string {
+	return ErrWriteFailed.Error() + ": " + e.cause.Error()
+}
+
+func (e writeError) Is(target error) bool {
+	return target == ErrWriteFailed
+}
+
+func (e writeError) Unwrap() error {
+	return e.cause
+}
+
+func (e writeError) Cause() error {
+	return e.cause
+}
+
 type GoogleMfaCredentialsDBUpdater struct {
-	DB Queryer
+	DB RowQueryer
 }
 
-func (gdb GoogleMfaCredentialsDBUpdater) Write(credential entity.MfaCredential) error {
-	rs, err := gdb.DB.Queryx(updateGoogleMfaCredentialQuery,
+func (gdb GoogleMfaCredentialsDBUpdater) Write(ctx context.Context, credential entity.MfaCredential) error {
+	_, err := gdb.DB.ExecContext(ctx, updateGoogleMfaCredentialQuery,
 		credential.SecretKey,
 		credential.ScratchCodes,
 		credential.EncryptionKeyLabel,
 		credential.EncryptedValidationCode,
+		credential.ValidationCode,
 		credential.UserId,
 	)
 	if err != nil {
-		return errors.Wrap(err, "Unable to update mfa db record")
+		return writeError{cause: err}
+	}
+	return nil
+}
+
+// ErrWriteConflict is returned by Swap when the row no longer holds the
+// values it was expected to.
+var ErrWriteConflict = errors.New("the record has changed since it was read")
+
+// A NULL encryption_key_label is read as empty, so it is compared as empty.
+var swapGoogleMfaCredentialQuery = updateGoogleMfaCredentialQuery + `
+and coalesce(encryption_key_label, '') = ?
+and secret_key = ?`
+
+// Swap writes replacement over a row only while the row still holds the key
+// label and secret key of current, as read, and returns ErrWriteConflict
+// otherwise.
+func (gdb GoogleMfaCredentialsDBUpdater) Swap(ctx context.Context, current entity.MfaCredential, replacement entity.MfaCredential) error {
+	result, err := gdb.DB.ExecContext(ctx, swapGoogleMfaCredentialQuery,
+		replacement.SecretKey,
+		replacement.ScratchCodes,
+		replacement.EncryptionKeyLabel,
+		replacement.EncryptedValidationCode,
+		replacement.ValidationCode,
+		current.UserId,
+		current.EncryptionKeyLabel,
+		current.SecretKey,
+	)
+	if err != nil {
+		return writeError{cause: err}
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return writeError{cause: err}
+	}
+	if rows == 0 {
+		return ErrWriteConflict
 	}
-	defer rs.Close()
 	return nil
 }
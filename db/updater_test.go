@@ -1,6 +1,8 @@
 package db_test
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	db2 "github.com/cloudfoundry/uaa-key-rotator/db"
 	"github.com/cloudfoundry/uaa-key-rotator/db/dbfakes"
@@ -47,18 +49,18 @@ var _ = Describe("Writer", func() {
 			MfaProviderId:           defaultMfaCredential.MfaProviderId,
 			ZoneId:                  defaultMfaCredential.ZoneId,
 			ValidationCode:          defaultMfaCredential.ValidationCode,
-			ScratchCodes:            getRandomTimestamp(),
+			ScratchCodes:            sql.NullString{String: getRandomTimestamp(), Valid: true},
 			SecretKey:               getRandomTimestamp(),
 			EncryptionKeyLabel:      getRandomTimestamp(),
-			EncryptedValidationCode: getRandomTimestamp(),
+			EncryptedValidationCode: sql.NullString{String: getRandomTimestamp(), Valid: true},
 		}
 		mfaCredentialId3 := insertGoogleMfaCredential("userid_3", "activeKeyLabel")
 
-		err = credentialsDBUpdater.Write(updatedMfaCredential)
+		err = credentialsDBUpdater.Write(context.Background(), updatedMfaCredential)
 		Expect(err).NotTo(HaveOccurred())
 
 		var errChan <-chan error
-		mfaCredentials, errChan := credentialsDB.RowsToRotate()
+		mfaCredentials, errChan := credentialsDB.RowsToRotate(context.Background())
 		Consistently(errChan).ShouldNot(Receive())
 
 		var rotatedMfaCredential1 entity.MfaCredential
@@ -69,19 +71,95 @@ var _ = Describe("Writer", func() {
 		Eventually([]entity.MfaCredential{rotatedMfaCredential1, rotatedMfaCredential2}).Should(ConsistOf(mfaCredentialId3, updatedMfaCredential))
 	})
 
+	It("should write NULL and empty encrypted columns", func() {
+		updatedMfaCredential := defaultMfaCredential
+		updatedMfaCredential.SecretKey = getRandomTimestamp()
+		updatedMfaCredential.EncryptionKeyLabel = "some-active-key-label"
+		updatedMfaCredential.ScratchCodes = sql.NullString{}
+		updatedMfaCredential.EncryptedValidationCode = sql.NullString{String: "", Valid: true}
+
+		err := credentialsDBUpdater.Write(context.Background(), updatedMfaCredential)
+		Expect(err).NotTo(HaveOccurred())
+
+		storedMfaCredential, err := credentialsDB.Row(context.Background(), updatedMfaCredential.UserId)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(storedMfaCredential).To(Equal(updatedMfaCredential))
+	})
+
+	It("should clear a plaintext validation code", func() {
+		updatedMfaCredential := defaultMfaCredential
+		updatedMfaCredential.EncryptedValidationCode = sql.NullString{String: getRandomTimestamp(), Valid: true}
+		updatedMfaCredential.ValidationCode = sql.NullInt64{}
+
+		err := credentialsDBUpdater.Write(context.Background(), updatedMfaCredential)
+		Expect(err).NotTo(HaveOccurred())
+
+		storedMfaCredential, err := credentialsDB.Row(context.Background(), updatedMfaCredential.UserId)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(storedMfaCredential.ValidationCode).To(Equal(sql.NullInt64{}))
+		Expect(storedMfaCredential.EncryptedValidationCode).To(Equal(updatedMfaCredential.EncryptedValidationCode))
+	})
+
+	Describe("Swap", func() {
+		var restoredMfaCredential entity.MfaCredential
+
+		BeforeEach(func() {
+			restoredMfaCredential = defaultMfaCredential
+			restoredMfaCredential.SecretKey = getRandomTimestamp()
+			restoredMfaCredential.EncryptionKeyLabel = "old-key-label"
+		})
+
+		It("should write over a row that still holds the expected values", func() {
+			err := credentialsDBUpdater.Swap(context.Background(), defaultMfaCredential, restoredMfaCredential)
+			Expect(err).NotTo(HaveOccurred())
+
+			storedMfaCredential, err := credentialsDB.Row(context.Background(), defaultMfaCredential.UserId)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(storedMfaCredential).To(Equal(restoredMfaCredential))
+		})
+
+		It("should leave a row that has changed alone", func() {
+			changedMfaCredential := defaultMfaCredential
+			changedMfaCredential.SecretKey = "some-other-secret-key"
+
+			err := credentialsDBUpdater.Swap(context.Background(), changedMfaCredential, restoredMfaCredential)
+			Expect(err).To(Equal(db2.ErrWriteConflict))
+
+			storedMfaCredential, err := credentialsDB.Row(context.Background(), defaultMfaCredential.UserId)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(storedMfaCredential).To(Equal(defaultMfaCredential))
+		})
+
+		It("should match a NULL key label as the empty label it is read as", func() {
+			clearLabelSQL, err := db2.RebindForSQLDialect(`update user_google_mfa_credentials set encryption_key_label = null where user_id = ?`, testutils.Scheme)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = db.Exec(clearLabelSQL, defaultMfaCredential.UserId)
+			Expect(err).NotTo(HaveOccurred())
+
+			plaintextMfaCredential, err := credentialsDB.Row(context.Background(), defaultMfaCredential.UserId)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(plaintextMfaCredential.EncryptionKeyLabel).To(BeEmpty())
+
+			err = credentialsDBUpdater.Swap(context.Background(), plaintextMfaCredential, restoredMfaCredential)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
 	Describe("when db error occurs", func() {
 		var mockDb *dbfakes.FakeQueryer
 		BeforeEach(func() {
 			mockDb = &dbfakes.FakeQueryer{}
-			mockDb.QueryxReturns(nil, errors.New("some db error"))
+			mockDb.ExecContextReturns(nil, errors.New("some db error"))
 			credentialsDBUpdater = db2.GoogleMfaCredentialsDBUpdater{
 				DB: mockDb,
 			}
 		})
 		It("should return meaningful error", func() {
-			err := credentialsDBUpdater.Write(entity.MfaCredential{})
+			err := credentialsDBUpdater.Write(context.Background(), entity.MfaCredential{})
 			Expect(err).To(HaveOccurred())
 			Expect(err).To(MatchError("Unable to update mfa db record: some db error"))
+			Expect(errors.Is(err, db2.ErrWriteFailed)).To(BeTrue())
+			Expect(errors.Is(err, db2.ErrTransientDB)).To(BeFalse())
 		})
 	})
 })
@@ -0,0 +1,144 @@
+package dump
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"github.com/cloudfoundry/uaa-key-rotator/entity"
+	"github.com/pkg/errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// CSVNull is how a CSV dump writes NULL, as MySQL exports it. PostgreSQL
+// reads and writes it with COPY ... WITH (FORMAT csv, NULL '\N').
+const CSVNull = `\N`
+
+func (t *Table) readCSV(r io.Reader) error {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err == io.EOF {
+		return errors.New("the dump is empty")
+	}
+	if err != nil {
+		return err
+	}
+
+	index := map[string]int{}
+	for i, column := range header {
+		index[column] = i
+	}
+	for _, column := range columns {
+		if _, ok := index[column]; !ok {
+			return errors.Errorf("the dump has no %s column", column)
+		}
+	}
+	t.header = header
+
+	for number := 1; ; number++ {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		credential, err := parseCSVRow(fields, index)
+		if err != nil {
+			return errors.Wrapf(err, "row %d", number)
+		}
+		if err = t.add(number, &row{credential: credential, fields: fields}); err != nil {
+			return err
+		}
+	}
+}
+
+func parseCSVRow(fields []string, index map[string]int) (entity.MfaCredential, error) {
+	field := func(column string) string {
+		return fields[index[column]]
+	}
+
+	credential := entity.MfaCredential{
+		UserId:                  field(ColumnUserID),
+		MfaProviderId:           entity.Char(strings.Trim(field(ColumnMfaProviderID), " ")),
+		ZoneId:                  entity.Char(strings.Trim(field(ColumnZoneID), " ")),
+		SecretKey:               field(ColumnSecretKey),
+		ScratchCodes:            csvNullString(field(ColumnScratchCodes)),
+		EncryptedValidationCode: csvNullString(field(ColumnEncryptedValidationCode)),
+	}
+
+	// A NULL label is read as empty, as the database fetcher reads it.
+	if label := field(ColumnEncryptionKeyLabel); label != CSVNull {
+		credential.EncryptionKeyLabel = label
+	}
+
+	if validationCode := field(ColumnValidationCode); validationCode != CSVNull {
+		code, err := strconv.ParseInt(validationCode, 10, 64)
+		if err != nil {
+			return entity.MfaCredential{}, errors.Errorf("validation_code: %s is not a number", validationCode)
+		}
+		credential.ValidationCode = sql.NullInt64{Int64: code, Valid: true}
+	}
+
+	return credential, nil
+}
+
+func csvNullString(field string) sql.NullString {
+	if field == CSVNull {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: field, Valid: true}
+}
+
+func (t *Table) saveCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(t.header); err != nil {
+		return err
+	}
+
+	for _, r := range t.rows {
+		fields := r.fields
+		if r.written {
+			fields = append([]string(nil), r.fields...)
+			for i, column := range t.header {
+				if value, ok := csvValue(r.credential, column); ok {
+					fields[i] = value
+				}
+			}
+		}
+		if err := writer.Write(fields); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// csvValue returns the value of one of the columns the updater sets.
+func csvValue(credential entity.MfaCredential, column string) (string, bool) {
+	switch column {
+	case ColumnSecretKey:
+		return credential.SecretKey, true
+	case ColumnEncryptionKeyLabel:
+		return credential.EncryptionKeyLabel, true
+	case ColumnScratchCodes:
+		return csvNullableString(credential.ScratchCodes), true
+	case ColumnEncryptedValidationCode:
+		return csvNullableString(credential.EncryptedValidationCode), true
+	case ColumnValidationCode:
+		if !credential.ValidationCode.Valid {
+			return CSVNull, true
+		}
+		return strconv.FormatInt(credential.ValidationCode.Int64, 10), true
+	}
+	return "", false
+}
+
+func csvNullableString(value sql.NullString) string {
+	if !value.Valid {
+		return CSVNull
+	}
+	return value.String
+}
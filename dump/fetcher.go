@@ -0,0 +1,79 @@
+package dump
+
+import (
+	"context"
+	"github.com/cloudfoundry/uaa-key-rotator/db"
+	"github.com/cloudfoundry/uaa-key-rotator/entity"
+)
+
+// Fetcher selects the rows of a dump to rotate, as the database fetcher
+// selects the rows of the table.
+type Fetcher struct {
+	Table            *Table
+	ActiveKeyLabel   string
+	IncludeActiveKey bool
+	Filter           db.RowFilter
+	Legacy           db.LegacySelection
+}
+
+// RowsToRotate streams the selected rows, in the order of the dump, until
+// they run out or ctx is cancelled. Reading a dump that is already loaded
+// cannot fail, so nothing is ever sent on the error channel.
+func (f Fetcher) RowsToRotate(ctx context.Context) (<-chan entity.MfaCredential, <-chan error) {
+	var mfaCredentialChan = make(chan entity.MfaCredential)
+	var errChan = make(chan error)
+
+	go func() {
+		sent := 0
+		for _, credential := range f.Table.Credentials() {
+			if f.Filter.Limit > 0 && sent >= f.Filter.Limit {
+				break
+			}
+			if !f.selects(credential) {
+				continue
+			}
+
+			select {
+			case mfaCredentialChan <- credential:
+				sent++
+			case <-ctx.Done():
+				return
+			}
+		}
+		close(mfaCredentialChan)
+	}()
+
+	return mfaCredentialChan, errChan
+}
+
+func (f Fetcher) Row(ctx context.Context, userID string) (entity.MfaCredential, error) {
+	return f.Table.Row(ctx, userID)
+}
+
+func (f Fetcher) selects(credential entity.MfaCredential) bool {
+	if !contains(f.Filter.ZoneIDs, string(credential.ZoneId)) ||
+		!contains(f.Filter.UserIDs, credential.UserId) ||
+		!contains(f.Filter.SourceLabels, credential.EncryptionKeyLabel) {
+		return false
+	}
+
+	if !f.Legacy.Enabled() {
+		return (f.IncludeActiveKey || credential.EncryptionKeyLabel != f.ActiveKeyLabel) && credential.EncryptionKeyLabel != ""
+	}
+	return (f.Legacy.PlaintextRows && credential.EncryptionKeyLabel == "") ||
+		(f.Legacy.ValidationCodes && credential.ValidationCode.Valid)
+}
+
+// contains reports whether value is one of values. Empty values do not
+// filter.
+func contains(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package dump_test
+
+import (
+	"context"
+	"github.com/cloudfoundry/uaa-key-rotator/db"
+	. "github.com/cloudfoundry/uaa-key-rotator/dump"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"strings"
+)
+
+var _ = Describe("Fetcher", func() {
+	const csvDump = `user_id,mfa_provider_id,zone_id,validation_code,scratch_codes,secret_key,encryption_key_label,encrypted_validation_code
+user-1,provider,zone-1,\N,\N,c2VjcmV0,old-key,\N
+user-2,provider,zone-2,\N,\N,c2VjcmV0,old-key,\N
+user-3,provider,zone-1,\N,\N,c2VjcmV0,active-key,\N
+user-4,provider,zone-1,1234,\N,c2VjcmV0,\N,\N
+user-5,provider,zone-1,\N,\N,c2VjcmV0,other-key,\N
+`
+
+	var fetcher Fetcher
+
+	BeforeEach(func() {
+		dumpTable, err := Read(strings.NewReader(csvDump), FormatCSV)
+		Expect(err).NotTo(HaveOccurred())
+		fetcher = Fetcher{Table: dumpTable, ActiveKeyLabel: "active-key"}
+	})
+
+	userIDs := func() []string {
+		credentials, errChan := fetcher.RowsToRotate(context.Background())
+		var ids []string
+		for credential := range credentials {
+			ids = append(ids, credential.UserId)
+		}
+		Consistently(errChan).ShouldNot(Receive())
+		return ids
+	}
+
+	It("should select rows encrypted with a key other than the active one", func() {
+		Expect(userIDs()).To(Equal([]string{"user-1", "user-2", "user-5"}))
+	})
+
+	It("should apply the row filter", func() {
+		fetcher.Filter = db.RowFilter{ZoneIDs: []string{"zone-1"}, SourceLabels: []string{"old-key", "other-key"}, Limit: 1}
+		Expect(userIDs()).To(Equal([]string{"user-1"}))
+	})
+
+	It("should select legacy rows for a migration instead", func() {
+		fetcher.Legacy = db.LegacySelection{PlaintextRows: true}
+		Expect(userIDs()).To(Equal([]string{"user-4"}))
+	})
+})
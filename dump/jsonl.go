@@ -0,0 +1,155 @@
+package dump
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"github.com/cloudfoundry/uaa-key-rotator/entity"
+	"github.com/pkg/errors"
+	"io"
+	"strings"
+)
+
+// maxJSONLLine bounds a single row of a JSONL dump.
+const maxJSONLLine = 1024 * 1024
+
+func (t *Table) readJSONL(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxJSONLLine)
+
+	number := 0
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		number++
+
+		credential, err := parseJSONLRow(line)
+		if err != nil {
+			return errors.Wrapf(err, "row %d", number)
+		}
+		if err = t.add(number, &row{credential: credential, line: append([]byte(nil), line...)}); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if number == 0 {
+		return errors.New("the dump is empty")
+	}
+	return nil
+}
+
+func parseJSONLRow(line []byte) (entity.MfaCredential, error) {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(line, &object); err != nil {
+		return entity.MfaCredential{}, errors.Wrap(err, "malformed row")
+	}
+	for _, column := range columns {
+		if _, ok := object[column]; !ok {
+			return entity.MfaCredential{}, errors.Errorf("no %s column", column)
+		}
+	}
+
+	var values struct {
+		UserID             string  `json:"user_id"`
+		MfaProviderID      string  `json:"mfa_provider_id"`
+		ZoneID             string  `json:"zone_id"`
+		ValidationCode     *int64  `json:"validation_code"`
+		SecretKey          string  `json:"secret_key"`
+		EncryptionKeyLabel *string `json:"encryption_key_label"`
+	}
+	if err := json.Unmarshal(line, &values); err != nil {
+		return entity.MfaCredential{}, errors.Wrap(err, "malformed row")
+	}
+
+	credential := entity.MfaCredential{
+		UserId:        values.UserID,
+		MfaProviderId: entity.Char(strings.Trim(values.MfaProviderID, " ")),
+		ZoneId:        entity.Char(strings.Trim(values.ZoneID, " ")),
+		SecretKey:     values.SecretKey,
+	}
+	if values.EncryptionKeyLabel != nil {
+		credential.EncryptionKeyLabel = *values.EncryptionKeyLabel
+	}
+	if values.ValidationCode != nil {
+		credential.ValidationCode = sql.NullInt64{Int64: *values.ValidationCode, Valid: true}
+	}
+
+	var err error
+	if credential.ScratchCodes, err = jsonNullString(object[ColumnScratchCodes]); err != nil {
+		return entity.MfaCredential{}, errors.Wrap(err, ColumnScratchCodes)
+	}
+	if credential.EncryptedValidationCode, err = jsonNullString(object[ColumnEncryptedValidationCode]); err != nil {
+		return entity.MfaCredential{}, errors.Wrap(err, ColumnEncryptedValidationCode)
+	}
+	return credential, nil
+}
+
+func jsonNullString(value json.RawMessage) (sql.NullString, error) {
+	var str *string
+	if err := json.Unmarshal(value, &str); err != nil {
+		return sql.NullString{}, err
+	}
+	if str == nil {
+		return sql.NullString{}, nil
+	}
+	return sql.NullString{String: *str, Valid: true}, nil
+}
+
+func (t *Table) saveJSONL(w io.Writer) error {
+	writer := bufio.NewWriter(w)
+	for _, r := range t.rows {
+		line := r.line
+		if r.written {
+			var err error
+			if line, err = rotatedJSONLLine(r); err != nil {
+				return err
+			}
+		}
+		if _, err := writer.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// rotatedJSONLLine rewrites the columns the updater sets, keeping every
+// other column of the row as it was read.
+func rotatedJSONLLine(r *row) ([]byte, error) {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(r.line, &object); err != nil {
+		return nil, err
+	}
+
+	credential := r.credential
+	values := map[string]interface{}{
+		ColumnSecretKey:               credential.SecretKey,
+		ColumnEncryptionKeyLabel:      credential.EncryptionKeyLabel,
+		ColumnScratchCodes:            nullableString(credential.ScratchCodes),
+		ColumnEncryptedValidationCode: nullableString(credential.EncryptedValidationCode),
+		ColumnValidationCode:          nil,
+	}
+	if credential.ValidationCode.Valid {
+		values[ColumnValidationCode] = credential.ValidationCode.Int64
+	}
+
+	for column, value := range values {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		object[column] = encoded
+	}
+	return json.Marshal(object)
+}
+
+func nullableString(value sql.NullString) interface{} {
+	if !value.Valid {
+		return nil
+	}
+	return value.String
+}
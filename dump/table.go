@@ -0,0 +1,193 @@
+// Package dump reads and writes exported dumps of the
+// user_google_mfa_credentials table, so that they can be rotated without
+// access to the database.
+package dump
+
+import (
+	"context"
+	"github.com/cloudfoundry/uaa-key-rotator/db"
+	"github.com/cloudfoundry/uaa-key-rotator/entity"
+	"github.com/pkg/errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+// The columns a dump must have. Any other column is carried through to the
+// rotated dump unchanged.
+const (
+	ColumnUserID                  = "user_id"
+	ColumnMfaProviderID           = "mfa_provider_id"
+	ColumnZoneID                  = "zone_id"
+	ColumnValidationCode          = "validation_code"
+	ColumnScratchCodes            = "scratch_codes"
+	ColumnSecretKey               = "secret_key"
+	ColumnEncryptionKeyLabel      = "encryption_key_label"
+	ColumnEncryptedValidationCode = "encrypted_validation_code"
+)
+
+var columns = []string{
+	ColumnUserID,
+	ColumnMfaProviderID,
+	ColumnZoneID,
+	ColumnValidationCode,
+	ColumnScratchCodes,
+	ColumnSecretKey,
+	ColumnEncryptionKeyLabel,
+	ColumnEncryptedValidationCode,
+}
+
+// FormatFromPath guesses the format of a dump from its file extension.
+func FormatFromPath(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return FormatCSV, nil
+	case ".jsonl", ".ndjson", ".json":
+		return FormatJSONL, nil
+	}
+	return "", errors.Errorf("unable to tell the format of %s; use a .csv or .jsonl file", path)
+}
+
+// Table is a dump held in memory. It is the updater of a rotation of the
+// dump: Write changes the rotated columns of a row as the database updater
+// would, and Save writes the whole dump out again, with every row it did
+// not write left as it was read.
+type Table struct {
+	Format string
+
+	mutex    sync.Mutex
+	header   []string
+	rows     []*row
+	byUserID map[string]*row
+}
+
+type row struct {
+	credential entity.MfaCredential
+	written    bool
+	// fields holds a CSV row as it was read, and line a JSONL row.
+	fields []string
+	line   []byte
+}
+
+// Read loads a dump. Every user id must be unique, as it is in the table.
+func Read(r io.Reader, format string) (*Table, error) {
+	table := &Table{Format: format, byUserID: map[string]*row{}}
+
+	var err error
+	switch format {
+	case FormatCSV:
+		err = table.readCSV(r)
+	case FormatJSONL:
+		err = table.readJSONL(r)
+	default:
+		return nil, errors.Errorf("unknown dump format '%s'", format)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read dump")
+	}
+	return table, nil
+}
+
+func (t *Table) add(number int, r *row) error {
+	userID := r.credential.UserId
+	if userID == "" {
+		return errors.Errorf("row %d: user_id is empty", number)
+	}
+	if _, ok := t.byUserID[userID]; ok {
+		return errors.Errorf("row %d: duplicate user_id %s", number, userID)
+	}
+	t.rows = append(t.rows, r)
+	t.byUserID[userID] = r
+	return nil
+}
+
+// Save writes the dump, in its format, with the rows written since it was
+// read rotated.
+func (t *Table) Save(w io.Writer) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var err error
+	switch t.Format {
+	case FormatCSV:
+		err = t.saveCSV(w)
+	default:
+		err = t.saveJSONL(w)
+	}
+	if err != nil {
+		return errors.Wrap(err, "unable to write dump")
+	}
+	return nil
+}
+
+// Credentials returns every row of the dump, in the order they were read.
+func (t *Table) Credentials() []entity.MfaCredential {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	credentials := make([]entity.MfaCredential, len(t.rows))
+	for i, r := range t.rows {
+		credentials[i] = r.credential
+	}
+	return credentials
+}
+
+// Row returns the current state of a row, as the database fetcher does.
+func (t *Table) Row(ctx context.Context, userID string) (entity.MfaCredential, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	r, ok := t.byUserID[userID]
+	if !ok {
+		return entity.MfaCredential{}, errors.Errorf("no mfa credential found for user %s", userID)
+	}
+	return r.credential, nil
+}
+
+// Write sets the columns of a row that the database updater sets.
+func (t *Table) Write(ctx context.Context, credential entity.MfaCredential) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	r, ok := t.byUserID[credential.UserId]
+	if !ok {
+		return errors.Errorf("Unable to update mfa dump record: no mfa credential found for user %s", credential.UserId)
+	}
+
+	r.write(credential)
+	return nil
+}
+
+// Swap writes replacement over a row only while the row still holds the key
+// label and secret key of current, as the database updater does, and
+// returns db.ErrWriteConflict otherwise.
+func (t *Table) Swap(ctx context.Context, current entity.MfaCredential, replacement entity.MfaCredential) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	r, ok := t.byUserID[current.UserId]
+	if !ok {
+		return errors.Errorf("Unable to update mfa dump record: no mfa credential found for user %s", current.UserId)
+	}
+	if r.credential.EncryptionKeyLabel != current.EncryptionKeyLabel || r.credential.SecretKey != current.SecretKey {
+		return db.ErrWriteConflict
+	}
+
+	r.write(replacement)
+	return nil
+}
+
+func (r *row) write(credential entity.MfaCredential) {
+	r.credential.SecretKey = credential.SecretKey
+	r.credential.ScratchCodes = credential.ScratchCodes
+	r.credential.EncryptionKeyLabel = credential.EncryptionKeyLabel
+	r.credential.EncryptedValidationCode = credential.EncryptedValidationCode
+	r.credential.ValidationCode = credential.ValidationCode
+	r.written = true
+}
@@ -0,0 +1,161 @@
+package dump_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"github.com/cloudfoundry/uaa-key-rotator/db"
+	. "github.com/cloudfoundry/uaa-key-rotator/dump"
+	"github.com/cloudfoundry/uaa-key-rotator/entity"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"strings"
+)
+
+var _ = Describe("Table", func() {
+	const csvDump = `user_id,mfa_provider_id,zone_id,validation_code,scratch_codes,secret_key,encryption_key_label,encrypted_validation_code,active
+user-1,provider-1  ,uaa,\N,c2NyYXRjaA==,c2VjcmV0,old-key,,true
+user-2,provider-1,uaa,123456,\N,"c2VjcmV0,Mg==",\N,\N,false
+`
+
+	const jsonlDump = `{"user_id":"user-1","mfa_provider_id":"provider-1","zone_id":"uaa","validation_code":null,"scratch_codes":"c2NyYXRjaA==","secret_key":"c2VjcmV0","encryption_key_label":"old-key","encrypted_validation_code":"","active":true}
+{"active":false,"user_id":"user-2","mfa_provider_id":"provider-1","zone_id":"uaa","validation_code":123456,"scratch_codes":null,"secret_key":"c2VjcmV0Mg==","encryption_key_label":null,"encrypted_validation_code":null}
+`
+
+	var rotated entity.MfaCredential
+
+	BeforeEach(func() {
+		rotated = entity.MfaCredential{
+			UserId:                  "user-1",
+			EncryptionKeyLabel:      "active-key",
+			SecretKey:               "cm90YXRlZA==",
+			ScratchCodes:            sql.NullString{String: "cm90YXRlZA==", Valid: true},
+			EncryptedValidationCode: sql.NullString{},
+		}
+	})
+
+	save := func(dumpTable *Table) string {
+		buffer := &bytes.Buffer{}
+		Expect(dumpTable.Save(buffer)).To(Succeed())
+		return buffer.String()
+	}
+
+	Context("with a CSV dump", func() {
+		var dumpTable *Table
+
+		BeforeEach(func() {
+			var err error
+			dumpTable, err = Read(strings.NewReader(csvDump), FormatCSV)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should read each row as the database fetcher does", func() {
+			Expect(dumpTable.Credentials()).To(Equal([]entity.MfaCredential{
+				{
+					UserId:                  "user-1",
+					MfaProviderId:           "provider-1",
+					ZoneId:                  "uaa",
+					ScratchCodes:            sql.NullString{String: "c2NyYXRjaA==", Valid: true},
+					SecretKey:               "c2VjcmV0",
+					EncryptionKeyLabel:      "old-key",
+					EncryptedValidationCode: sql.NullString{String: "", Valid: true},
+				},
+				{
+					UserId:         "user-2",
+					MfaProviderId:  "provider-1",
+					ZoneId:         "uaa",
+					ValidationCode: sql.NullInt64{Int64: 123456, Valid: true},
+					SecretKey:      "c2VjcmV0,Mg==",
+				},
+			}))
+		})
+
+		It("should save the dump as it was read when nothing was written", func() {
+			Expect(save(dumpTable)).To(Equal(csvDump))
+		})
+
+		It("should save the columns written, leaving the rest of the dump alone", func() {
+			Expect(dumpTable.Write(context.Background(), rotated)).To(Succeed())
+
+			stored, err := dumpTable.Row(context.Background(), "user-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stored.SecretKey).To(Equal("cm90YXRlZA=="))
+			Expect(stored.MfaProviderId).To(Equal(entity.Char("provider-1")))
+
+			lines := strings.Split(save(dumpTable), "\n")
+			Expect(lines[1]).To(Equal(`user-1,provider-1  ,uaa,\N,cm90YXRlZA==,cm90YXRlZA==,active-key,\N,true`))
+			Expect(lines[2]).To(Equal(`user-2,provider-1,uaa,123456,\N,"c2VjcmV0,Mg==",\N,\N,false`))
+		})
+	})
+
+	Context("with a JSONL dump", func() {
+		var dumpTable *Table
+
+		BeforeEach(func() {
+			var err error
+			dumpTable, err = Read(strings.NewReader(jsonlDump), FormatJSONL)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should read NULLs as the database fetcher does", func() {
+			credentials := dumpTable.Credentials()
+			Expect(credentials).To(HaveLen(2))
+			Expect(credentials[0].EncryptedValidationCode).To(Equal(sql.NullString{String: "", Valid: true}))
+			Expect(credentials[0].ValidationCode.Valid).To(BeFalse())
+			Expect(credentials[1].EncryptionKeyLabel).To(Equal(""))
+			Expect(credentials[1].ScratchCodes.Valid).To(BeFalse())
+			Expect(credentials[1].ValidationCode).To(Equal(sql.NullInt64{Int64: 123456, Valid: true}))
+		})
+
+		It("should save the rows it did not write byte for byte", func() {
+			Expect(dumpTable.Write(context.Background(), rotated)).To(Succeed())
+
+			lines := strings.Split(save(dumpTable), "\n")
+			Expect(lines[0]).To(Equal(`{"active":true,"encrypted_validation_code":null,"encryption_key_label":"active-key","mfa_provider_id":"provider-1","scratch_codes":"cm90YXRlZA==","secret_key":"cm90YXRlZA==","user_id":"user-1","validation_code":null,"zone_id":"uaa"}`))
+			Expect(lines[1]).To(Equal(strings.Split(jsonlDump, "\n")[1]))
+		})
+	})
+
+	It("should refuse to write a row that is not in the dump", func() {
+		dumpTable, err := Read(strings.NewReader(csvDump), FormatCSV)
+		Expect(err).NotTo(HaveOccurred())
+
+		rotated.UserId = "user-3"
+		Expect(dumpTable.Write(context.Background(), rotated)).To(MatchError("Unable to update mfa dump record: no mfa credential found for user user-3"))
+	})
+
+	It("should only swap a row that still holds the expected key label and secret key", func() {
+		dumpTable, err := Read(strings.NewReader(csvDump), FormatCSV)
+		Expect(err).NotTo(HaveOccurred())
+		original := dumpTable.Credentials()[0]
+
+		changed := original
+		changed.SecretKey = "c29tZXRoaW5nIGVsc2U="
+		Expect(dumpTable.Swap(context.Background(), changed, rotated)).To(Equal(db.ErrWriteConflict))
+		Expect(dumpTable.Credentials()[0]).To(Equal(original))
+
+		Expect(dumpTable.Swap(context.Background(), original, rotated)).To(Succeed())
+		Expect(dumpTable.Credentials()[0].SecretKey).To(Equal(rotated.SecretKey))
+	})
+
+	table.DescribeTable("invalid dumps", func(content string, format string, errorDescription string) {
+		_, err := Read(strings.NewReader(content), format)
+		Expect(err).To(MatchError(errorDescription))
+	},
+		table.Entry("empty", "", FormatCSV, "unable to read dump: the dump is empty"),
+		table.Entry("missing column", "user_id,secret_key\nuser-1,c2VjcmV0\n", FormatCSV, "unable to read dump: the dump has no mfa_provider_id column"),
+		table.Entry("duplicate user", strings.Replace(csvDump, "user-2", "user-1", 1), FormatCSV, "unable to read dump: row 2: duplicate user_id user-1"),
+		table.Entry("bad validation code", strings.Replace(csvDump, "123456", "abc", 1), FormatCSV, "unable to read dump: row 2: validation_code: abc is not a number"),
+		table.Entry("missing JSON column", `{"user_id":"user-1"}`, FormatJSONL, "unable to read dump: row 1: no mfa_provider_id column"),
+		table.Entry("unknown format", csvDump, "xml", "unknown dump format 'xml'"),
+	)
+
+	table.DescribeTable("FormatFromPath", func(path string, format string) {
+		Expect(FormatFromPath(path)).To(Equal(format))
+	},
+		table.Entry("csv", "/tmp/dump.CSV", FormatCSV),
+		table.Entry("jsonl", "dump.jsonl", FormatJSONL),
+		table.Entry("ndjson", "dump.ndjson", FormatJSONL),
+	)
+})
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/cloudfoundry/uaa-key-rotator/dump"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// loadDump reads a dump of user_google_mfa_credentials, in format or else
+// the format its extension names.
+func loadDump(path string, format string) (*dump.Table, error) {
+	if format == "" {
+		var err error
+		if format, err = dump.FormatFromPath(path); err != nil {
+			return nil, err
+		}
+	}
+
+	dumpFile, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open dump")
+	}
+	defer dumpFile.Close()
+
+	return dump.Read(dumpFile, format)
+}
+
+// saveDump writes a rotated dump next to path first, and only moves it into
+// place once it is complete, so that a failed write never leaves half a
+// dump for the DBA to load.
+func saveDump(table *dump.Table, path string) error {
+	tempFile, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "unable to create rotated dump")
+	}
+	defer os.Remove(tempFile.Name())
+
+	if err = table.Save(tempFile); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err = tempFile.Sync(); err != nil {
+		tempFile.Close()
+		return errors.Wrap(err, "unable to sync rotated dump")
+	}
+	if err = tempFile.Close(); err != nil {
+		return errors.Wrap(err, "unable to close rotated dump")
+	}
+	return errors.Wrap(os.Rename(tempFile.Name(), path), "unable to move rotated dump into place")
+}
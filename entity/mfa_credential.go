@@ -7,21 +7,27 @@ import (
 	"strings"
 )
 
+// MfaCredential is a row of user_google_mfa_credentials. ScratchCodes and
+// EncryptedValidationCode may be NULL in older UAA data.
 type MfaCredential struct {
-	UserId                  string        `db:"user_id"`
-	MfaProviderId           Char          `db:"mfa_provider_id"`
-	ValidationCode          sql.NullInt64 `db:"validation_code"`
-	ScratchCodes            string        `db:"scratch_codes"`
-	SecretKey               string        `db:"secret_key"`
-	EncryptionKeyLabel      string        `db:"encryption_key_label"`
-	EncryptedValidationCode string        `db:"encrypted_validation_code"`
-	ZoneId                  Char          `db:"zone_id"`
+	UserId                  string         `db:"user_id"`
+	MfaProviderId           Char           `db:"mfa_provider_id"`
+	ValidationCode          sql.NullInt64  `db:"validation_code"`
+	ScratchCodes            sql.NullString `db:"scratch_codes"`
+	SecretKey               string         `db:"secret_key"`
+	EncryptionKeyLabel      string         `db:"encryption_key_label"`
+	EncryptedValidationCode sql.NullString `db:"encrypted_validation_code"`
+	ZoneId                  Char           `db:"zone_id"`
 }
 
+// Char is a CHAR column, read without its padding. A NULL column is read as
+// empty.
 type Char string
 
 func (g *Char) Scan(src interface{}) error {
 	switch src.(type) {
+	case nil:
+		*g = ""
 	case string:
 		s := src.(string)
 		*g = Char(strings.Trim(s, " "))
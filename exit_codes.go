@@ -0,0 +1,59 @@
+package main
+
+import (
+	"github.com/cloudfoundry/uaa-key-rotator/runner"
+)
+
+// Exit codes of a run that failed, by the category of its error. 2 is left
+// to the subcommands, which exit with it when given invalid arguments.
+const (
+	exitFailure              = 1
+	exitUnknownKey           = 3
+	exitAuthenticationFailed = 4
+	exitMalformedEnvelope    = 5
+	exitVerificationFailed   = 6
+	exitWriteConflict        = 7
+	exitTransientDB          = 8
+	exitWriteFailed          = 9
+)
+
+var exitCodes = map[runner.FailureCategory]int{
+	runner.FailureUnknownKey:           exitUnknownKey,
+	runner.FailureAuthenticationFailed: exitAuthenticationFailed,
+	runner.FailureMalformedEnvelope:    exitMalformedEnvelope,
+	runner.FailureVerificationFailed:   exitVerificationFailed,
+	runner.FailureWriteConflict:        exitWriteConflict,
+	runner.FailureTransientDB:          exitTransientDB,
+	runner.FailureWriteFailed:          exitWriteFailed,
+}
+
+// failureCategory returns the category of the error a run failed with. A run
+// whose targets failed for different reasons has no single category. A run
+// that failed no target, but some of whose rows failed, has the category
+// most of those rows had.
+func failureCategory(report runner.Report, err error) runner.FailureCategory {
+	var category runner.FailureCategory
+	for _, target := range report.Targets {
+		if target.Succeeded {
+			continue
+		}
+		if category != "" && category != target.ErrorCategory {
+			return runner.FailureOther
+		}
+		category = target.ErrorCategory
+	}
+	if category == "" {
+		category = report.DominantRowFailure()
+	}
+	if category == "" {
+		category = runner.Categorize(err)
+	}
+	return category
+}
+
+func exitCode(category runner.FailureCategory) int {
+	if code, ok := exitCodes[category]; ok {
+		return code
+	}
+	return exitFailure
+}
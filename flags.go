@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"github.com/cloudfoundry/uaa-key-rotator/quarantine"
+	"github.com/pkg/errors"
+	"os"
+	"strings"
+)
+
+// stringSliceFlag collects the values of a flag that may be repeated.
+type stringSliceFlag []string
+
+func (f *stringSliceFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringSliceFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// readIDsFile reads one id per line, skipping blank lines and # comments.
+func readIDsFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open ids file")
+	}
+	defer file.Close()
+
+	var ids []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		id := strings.TrimSpace(scanner.Text())
+		if id == "" || strings.HasPrefix(id, "#") {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "unable to read ids file")
+	}
+
+	return ids, nil
+}
+
+// readQuarantineFile reads the user ids of the rows in a quarantine file,
+// by target.
+func readQuarantineFile(path string) (map[string][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open quarantine file")
+	}
+	defer file.Close()
+
+	entries, err := quarantine.Read(file)
+	if err != nil {
+		return nil, err
+	}
+	return quarantine.UserIDsByTarget(entries), nil
+}
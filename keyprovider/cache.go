@@ -0,0 +1,74 @@
+package keyprovider
+
+import (
+	"context"
+	"github.com/cloudfoundry/uaa-key-rotator/crypto"
+	"sync"
+	"time"
+)
+
+const DefaultCacheTTL = 5 * time.Minute
+
+type cachedPassphrase struct {
+	passphrase []byte
+	expiresAt  time.Time
+}
+
+// CachingKeyProvider remembers passphrases resolved by the wrapped provider
+// for TTL so that every row does not cost a round trip to the secret store.
+// Lookup failures are never cached, and callers always receive their own
+// copy of the passphrase so that wiping it does not affect the cache.
+type CachingKeyProvider struct {
+	provider KeyProvider
+	ttl      time.Duration
+
+	mutex sync.Mutex
+	cache map[string]cachedPassphrase
+}
+
+func NewCachingKeyProvider(provider KeyProvider, ttl time.Duration) *CachingKeyProvider {
+	return &CachingKeyProvider{
+		provider: provider,
+		ttl:      ttl,
+		cache:    map[string]cachedPassphrase{},
+	}
+}
+
+func (p *CachingKeyProvider) Passphrase(ctx context.Context, label string) ([]byte, error) {
+	p.mutex.Lock()
+	entry, found := p.cache[label]
+	if found && time.Now().Before(entry.expiresAt) {
+		passphrase := append([]byte(nil), entry.passphrase...)
+		p.mutex.Unlock()
+		return passphrase, nil
+	}
+	p.mutex.Unlock()
+
+	passphrase, err := p.provider.Passphrase(ctx, label)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	if previous, found := p.cache[label]; found {
+		crypto.Zero(previous.passphrase)
+	}
+	p.cache[label] = cachedPassphrase{
+		passphrase: append([]byte(nil), passphrase...),
+		expiresAt:  time.Now().Add(p.ttl),
+	}
+	p.mutex.Unlock()
+
+	return passphrase, nil
+}
+
+// Wipe zeroes and forgets every cached passphrase.
+func (p *CachingKeyProvider) Wipe() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for label, entry := range p.cache {
+		crypto.Zero(entry.passphrase)
+		delete(p.cache, label)
+	}
+}
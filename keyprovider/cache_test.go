@@ -0,0 +1,106 @@
+package keyprovider_test
+
+import (
+	"context"
+	"errors"
+	"github.com/cloudfoundry/uaa-key-rotator/crypto"
+	"github.com/cloudfoundry/uaa-key-rotator/keyprovider"
+	"github.com/cloudfoundry/uaa-key-rotator/keyprovider/keyproviderfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+var _ = Describe("CachingKeyProvider", func() {
+	var fakeProvider *keyproviderfakes.FakeKeyProvider
+	var cachingProvider *keyprovider.CachingKeyProvider
+	var ttl time.Duration
+
+	BeforeEach(func() {
+		fakeProvider = &keyproviderfakes.FakeKeyProvider{}
+		fakeProvider.PassphraseReturns([]byte("passphrase"), nil)
+		ttl = time.Hour
+	})
+
+	JustBeforeEach(func() {
+		cachingProvider = keyprovider.NewCachingKeyProvider(fakeProvider, ttl)
+	})
+
+	It("should only resolve a label once within the ttl", func() {
+		for i := 0; i < 3; i++ {
+			passphrase, err := cachingProvider.Passphrase(context.Background(), "key-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(passphrase).To(Equal([]byte("passphrase")))
+		}
+
+		Expect(fakeProvider.PassphraseCallCount()).To(Equal(1))
+		_, label := fakeProvider.PassphraseArgsForCall(0)
+		Expect(label).To(Equal("key-1"))
+	})
+
+	It("should cache each label separately", func() {
+		_, err := cachingProvider.Passphrase(context.Background(), "key-1")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = cachingProvider.Passphrase(context.Background(), "key-2")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(fakeProvider.PassphraseCallCount()).To(Equal(2))
+	})
+
+	It("should hand out copies so callers can wipe them", func() {
+		passphrase, err := cachingProvider.Passphrase(context.Background(), "key-1")
+		Expect(err).NotTo(HaveOccurred())
+		crypto.Zero(passphrase)
+
+		passphrase, err = cachingProvider.Passphrase(context.Background(), "key-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(passphrase).To(Equal([]byte("passphrase")))
+	})
+
+	Context("when wiped", func() {
+		It("should forget every cached passphrase", func() {
+			_, err := cachingProvider.Passphrase(context.Background(), "key-1")
+			Expect(err).NotTo(HaveOccurred())
+
+			cachingProvider.Wipe()
+
+			_, err = cachingProvider.Passphrase(context.Background(), "key-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeProvider.PassphraseCallCount()).To(Equal(2))
+		})
+	})
+
+	Context("when the ttl has expired", func() {
+		BeforeEach(func() {
+			ttl = 10 * time.Millisecond
+		})
+
+		It("should resolve the label again", func() {
+			_, err := cachingProvider.Passphrase(context.Background(), "key-1")
+			Expect(err).NotTo(HaveOccurred())
+
+			time.Sleep(2 * ttl)
+			fakeProvider.PassphraseReturns([]byte("new-passphrase"), nil)
+
+			passphrase, err := cachingProvider.Passphrase(context.Background(), "key-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(passphrase).To(Equal([]byte("new-passphrase")))
+			Expect(fakeProvider.PassphraseCallCount()).To(Equal(2))
+		})
+	})
+
+	Context("when the wrapped provider fails", func() {
+		BeforeEach(func() {
+			fakeProvider.PassphraseReturnsOnCall(0, nil, errors.New("store unavailable"))
+		})
+
+		It("should not cache the failure", func() {
+			_, err := cachingProvider.Passphrase(context.Background(), "key-1")
+			Expect(err).To(MatchError("store unavailable"))
+
+			passphrase, err := cachingProvider.Passphrase(context.Background(), "key-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(passphrase).To(Equal([]byte("passphrase")))
+		})
+	})
+})
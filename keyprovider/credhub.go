@@ -0,0 +1,93 @@
+package keyprovider
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/cloudfoundry/uaa-key-rotator/config"
+	"github.com/cloudfoundry/uaa-key-rotator/crypto"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// CredHubKeyProvider reads passphrases stored as CredHub credentials named
+// <PathPrefix>/<label>. Either Token (a UAA bearer token) or a client
+// certificate on Client is used to authenticate.
+type CredHubKeyProvider struct {
+	Client     *http.Client
+	URL        string
+	Token      string
+	PathPrefix string
+}
+
+var _ KeyProvider = CredHubKeyProvider{}
+
+type credHubDataResponse struct {
+	Data []struct {
+		Type  string       `json:"type"`
+		Value credHubValue `json:"value"`
+	} `json:"data"`
+}
+
+// credHubValue accepts both plain string values (password, value) and
+// the {"password": ...} shape returned for user credentials. It is decoded
+// straight into bytes, so that the passphrase can be wiped.
+type credHubValue config.Passphrase
+
+func (v *credHubValue) UnmarshalJSON(data []byte) error {
+	var passphrase config.Passphrase
+	if err := json.Unmarshal(data, &passphrase); err == nil {
+		*v = credHubValue(passphrase)
+		return nil
+	}
+
+	var user struct {
+		Password config.Passphrase `json:"password"`
+	}
+	if err := json.Unmarshal(data, &user); err != nil {
+		return err
+	}
+	*v = credHubValue(user.Password)
+	return nil
+}
+
+func (p CredHubKeyProvider) Passphrase(ctx context.Context, label string) ([]byte, error) {
+	name := path.Join("/", p.PathPrefix, label)
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(p.URL, "/")+"/api/v1/data?"+url.Values{
+		"name":    {name},
+		"current": {"true"},
+	}.Encode(), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to build credhub request")
+	}
+	req = req.WithContext(ctx)
+	if p.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.Token)
+	}
+
+	var response credHubDataResponse
+	if err = getJSON(p.Client, req, &response); err != nil {
+		if err == ErrKeyNotFound {
+			return nil, err
+		}
+		return nil, errors.Wrapf(err, "unable to fetch credhub credential %s", name)
+	}
+
+	if len(response.Data) == 0 {
+		return nil, ErrKeyNotFound
+	}
+
+	for _, credential := range response.Data[1:] {
+		crypto.Zero(credential.Value)
+	}
+
+	passphrase := []byte(response.Data[0].Value)
+	if len(passphrase) == 0 {
+		return nil, errors.Errorf("credhub credential %s has an empty value", name)
+	}
+
+	return passphrase, nil
+}
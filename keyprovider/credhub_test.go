@@ -0,0 +1,180 @@
+package keyprovider_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/cloudfoundry/uaa-key-rotator/config"
+	"github.com/cloudfoundry/uaa-key-rotator/keyprovider"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+)
+
+var _ = Describe("CredHubKeyProvider", func() {
+	var server *httptest.Server
+	var handler http.HandlerFunc
+	var requests []*http.Request
+	var provider keyprovider.CredHubKeyProvider
+
+	BeforeEach(func() {
+		requests = nil
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"data":[{"type":"password","name":"/uaa/keys/key-1","value":"credhub-passphrase"}]}`))
+		}
+	})
+
+	JustBeforeEach(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r)
+			handler(w, r)
+		}))
+
+		provider = keyprovider.CredHubKeyProvider{
+			Client:     server.Client(),
+			URL:        server.URL,
+			Token:      "some-token",
+			PathPrefix: "/uaa/keys",
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should fetch the current value of the credential named after the label", func() {
+		passphrase, err := provider.Passphrase(context.Background(), "key-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(passphrase).To(Equal([]byte("credhub-passphrase")))
+
+		Expect(requests).To(HaveLen(1))
+		Expect(requests[0].Method).To(Equal(http.MethodGet))
+		Expect(requests[0].URL.Path).To(Equal("/api/v1/data"))
+		Expect(requests[0].URL.Query().Get("name")).To(Equal("/uaa/keys/key-1"))
+		Expect(requests[0].URL.Query().Get("current")).To(Equal("true"))
+		Expect(requests[0].Header.Get("Authorization")).To(Equal("Bearer some-token"))
+	})
+
+	Context("when the credential is a user credential", func() {
+		BeforeEach(func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"data":[{"type":"user","value":{"username":"uaa","password":"user-passphrase"}}]}`))
+			}
+		})
+
+		It("should use the password", func() {
+			passphrase, err := provider.Passphrase(context.Background(), "key-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(passphrase).To(Equal([]byte("user-passphrase")))
+		})
+	})
+
+	Context("when the credential does not exist", func() {
+		BeforeEach(func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"error":"The request could not be completed because the credential does not exist or you do not have sufficient authorization."}`))
+			}
+		})
+
+		It("should return ErrKeyNotFound", func() {
+			_, err := provider.Passphrase(context.Background(), "key-1")
+			Expect(err).To(Equal(keyprovider.ErrKeyNotFound))
+		})
+	})
+
+	Context("when credhub rejects the request", func() {
+		BeforeEach(func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+		})
+
+		It("should return a meaningful error", func() {
+			_, err := provider.Passphrase(context.Background(), "key-1")
+			Expect(err).To(MatchError("unable to fetch credhub credential /uaa/keys/key-1: unexpected status code 401"))
+		})
+	})
+
+	Context("when credhub returns malformed json", func() {
+		BeforeEach(func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"data":`))
+			}
+		})
+
+		It("should return a meaningful error", func() {
+			_, err := provider.Passphrase(context.Background(), "key-1")
+			Expect(err).To(MatchError(ContainSubstring("unable to fetch credhub credential /uaa/keys/key-1: malformed response")))
+		})
+	})
+
+	Context("when the context is cancelled", func() {
+		It("should not complete the lookup", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			_, err := provider.Passphrase(ctx, "key-1")
+			Expect(err).To(MatchError(ContainSubstring("context canceled")))
+		})
+	})
+
+	Context("when mutual tls is required", func() {
+		var tlsServer *httptest.Server
+		var clientCert, clientKey string
+
+		JustBeforeEach(func() {
+			var clientX509 *x509.Certificate
+			clientCert, clientKey, clientX509 = generateClientCert()
+
+			clientCAs := x509.NewCertPool()
+			clientCAs.AddCert(clientX509)
+
+			tlsServer = httptest.NewUnstartedServer(handler)
+			tlsServer.TLS = &tls.Config{
+				ClientAuth: tls.RequireAndVerifyClientCert,
+				ClientCAs:  clientCAs,
+			}
+			tlsServer.StartTLS()
+		})
+
+		AfterEach(func() {
+			tlsServer.Close()
+		})
+
+		It("should authenticate with the configured client certificate", func() {
+			client, err := keyprovider.NewHTTPClient(config.KeyProviderConfig{
+				CACert:     serverCACert(tlsServer),
+				ClientCert: clientCert,
+				ClientKey:  clientKey,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			provider = keyprovider.CredHubKeyProvider{
+				Client:     client,
+				URL:        tlsServer.URL,
+				PathPrefix: "/uaa/keys",
+			}
+
+			passphrase, err := provider.Passphrase(context.Background(), "key-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(passphrase).To(Equal([]byte("credhub-passphrase")))
+		})
+
+		It("should fail without a client certificate", func() {
+			client, err := keyprovider.NewHTTPClient(config.KeyProviderConfig{
+				CACert: serverCACert(tlsServer),
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			provider = keyprovider.CredHubKeyProvider{
+				Client: client,
+				URL:    tlsServer.URL,
+			}
+
+			_, err = provider.Passphrase(context.Background(), "key-1")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
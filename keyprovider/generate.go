@@ -0,0 +1,54 @@
+package keyprovider
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/cloudfoundry/uaa-key-rotator/config"
+	"github.com/cloudfoundry/uaa-key-rotator/crypto"
+	"github.com/pkg/errors"
+	"time"
+)
+
+// PassphraseBytes is how many random bytes a generated passphrase holds:
+// 256 bits, as much as the AES key derived from it.
+const PassphraseBytes = 32
+
+// NewKey generates a key with a random passphrase and a label that is none
+// of existing. A dated label is "key-" and the date of now, with a suffix
+// if a key was already added that day; otherwise the label is random.
+func NewKey(existing []string, dated bool, now time.Time) (config.EncryptionKey, error) {
+	taken := map[string]bool{}
+	for _, label := range existing {
+		taken[label] = true
+	}
+
+	var label string
+	if dated {
+		label = "key-" + now.UTC().Format("2006-01-02")
+		for i := 2; taken[label]; i++ {
+			label = fmt.Sprintf("key-%s-%d", now.UTC().Format("2006-01-02"), i)
+		}
+	} else {
+		for label == "" || taken[label] {
+			suffix := make([]byte, 4)
+			if _, err := rand.Read(suffix); err != nil {
+				return config.EncryptionKey{}, errors.Wrap(err, "unable to generate a key label")
+			}
+			label = "key-" + hex.EncodeToString(suffix)
+		}
+	}
+
+	random := make([]byte, PassphraseBytes)
+	if _, err := rand.Read(random); err != nil {
+		return config.EncryptionKey{}, errors.Wrap(err, "unable to generate a passphrase")
+	}
+	defer crypto.Zero(random)
+
+	// URL-safe base64 needs no quoting or escaping in JSON, YAML or a shell.
+	passphrase := make(config.Passphrase, base64.RawURLEncoding.EncodedLen(len(random)))
+	base64.RawURLEncoding.Encode(passphrase, random)
+
+	return config.EncryptionKey{Label: label, Passphrase: passphrase}, nil
+}
@@ -0,0 +1,35 @@
+package keyprovider_test
+
+import (
+	. "github.com/cloudfoundry/uaa-key-rotator/keyprovider"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+var _ = Describe("NewKey", func() {
+	now := time.Date(2026, 10, 19, 23, 30, 0, 0, time.UTC)
+
+	It("should generate a random label and a high entropy passphrase", func() {
+		key, err := NewKey(nil, false, now)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(key.Label).To(MatchRegexp(`^key-[0-9a-f]{8}$`))
+		Expect(key.Passphrase).To(HaveLen(43))
+		Expect(string(key.Passphrase)).To(MatchRegexp(`^[A-Za-z0-9_-]+$`))
+
+		other, err := NewKey(nil, false, now)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(other.Label).NotTo(Equal(key.Label))
+		Expect(other.Passphrase).NotTo(Equal(key.Passphrase))
+	})
+
+	It("should date the label, avoiding labels already taken", func() {
+		key, err := NewKey([]string{"old-key"}, true, now)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(key.Label).To(Equal("key-2026-10-19"))
+
+		key, err = NewKey([]string{"key-2026-10-19", "key-2026-10-19-2"}, true, now)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(key.Label).To(Equal("key-2026-10-19-3"))
+	})
+})
@@ -0,0 +1,76 @@
+package keyprovider
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"github.com/cloudfoundry/uaa-key-rotator/config"
+	"github.com/cloudfoundry/uaa-key-rotator/crypto"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const httpClientTimeout = 30 * time.Second
+
+// NewHTTPClient builds a client for talking to a secret store. When a client
+// certificate is configured it is presented for mTLS; CACert is used in
+// place of the system roots when set.
+func NewHTTPClient(providerConfig config.KeyProviderConfig) (*http.Client, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: providerConfig.SkipSSLValidation,
+	}
+
+	if providerConfig.CACert != "" {
+		caPool := x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM([]byte(providerConfig.CACert)) {
+			return nil, errors.New("unable to parse ca cert")
+		}
+		tlsConfig.RootCAs = caPool
+	}
+
+	if providerConfig.ClientCert != "" {
+		clientCert, err := tls.X509KeyPair([]byte(providerConfig.ClientCert), []byte(providerConfig.ClientKey))
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to parse client cert/key pair")
+		}
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+	}
+
+	return &http.Client{
+		Timeout: httpClientTimeout,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}, nil
+}
+
+func getJSON(client *http.Client, req *http.Request, response interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "unable to read response")
+	}
+	defer crypto.Zero(body)
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return ErrKeyNotFound
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	if err = json.Unmarshal(body, response); err != nil {
+		return errors.Wrap(err, "malformed response")
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package keyprovider
+
+import (
+	"context"
+	"github.com/cloudfoundry/uaa-key-rotator/config"
+	"github.com/pkg/errors"
+	"time"
+)
+
+var ErrKeyNotFound = errors.New("key not found")
+
+// KeyProvider resolves a key label to its passphrase. The returned buffer
+// belongs to the caller, which may wipe it once finished.
+//
+//go:generate counterfeiter . KeyProvider
+type KeyProvider interface {
+	Passphrase(ctx context.Context, label string) ([]byte, error)
+}
+
+func FromConfig(rotatorConfig *config.RotatorConfig) (KeyProvider, error) {
+	providerConfig := rotatorConfig.KeyProvider
+
+	var provider KeyProvider
+	switch providerConfig.Type {
+	case "", config.StaticKeyProvider:
+		return StaticKeyProvider{EncryptionKeys: rotatorConfig.EncryptionKeys}, nil
+	case config.CredHubKeyProvider:
+		client, err := NewHTTPClient(providerConfig)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to configure credhub client")
+		}
+		provider = CredHubKeyProvider{
+			Client:     client,
+			URL:        providerConfig.URL,
+			Token:      providerConfig.Token,
+			PathPrefix: providerConfig.PathPrefix,
+		}
+	case config.VaultKeyProvider:
+		client, err := NewHTTPClient(providerConfig)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to configure vault client")
+		}
+		provider = VaultKeyProvider{
+			Client:    client,
+			URL:       providerConfig.URL,
+			Token:     providerConfig.Token,
+			Namespace: providerConfig.VaultNamespace,
+			Mount:     providerConfig.VaultMount,
+			Path:      providerConfig.PathPrefix,
+			KVVersion: providerConfig.VaultKVVersion,
+			Field:     providerConfig.VaultField,
+		}
+	default:
+		return nil, errors.Errorf("unknown key provider '%s'", providerConfig.Type)
+	}
+
+	ttl := DefaultCacheTTL
+	if providerConfig.CacheTTL != "" {
+		var err error
+		ttl, err = time.ParseDuration(providerConfig.CacheTTL)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid key provider cache ttl")
+		}
+	}
+
+	return NewCachingKeyProvider(provider, ttl), nil
+}
+
+// Labels lists every key label the rotator knows about: the static
+// encryption keys, or KeyProvider.Labels for remote providers, which cannot
+// be enumerated. The active key label is always included.
+func Labels(rotatorConfig *config.RotatorConfig) []string {
+	labels := []string{rotatorConfig.ActiveKeyLabel}
+	seen := map[string]bool{rotatorConfig.ActiveKeyLabel: true}
+
+	configured := rotatorConfig.KeyProvider.Labels
+	if rotatorConfig.KeyProvider.Type == "" || rotatorConfig.KeyProvider.Type == config.StaticKeyProvider {
+		configured = nil
+		for _, key := range rotatorConfig.EncryptionKeys {
+			configured = append(configured, key.Label)
+		}
+	}
+
+	for _, label := range configured {
+		if !seen[label] {
+			seen[label] = true
+			labels = append(labels, label)
+		}
+	}
+	return labels
+}
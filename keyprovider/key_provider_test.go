@@ -0,0 +1,41 @@
+package keyprovider_test
+
+import (
+	"github.com/cloudfoundry/uaa-key-rotator/config"
+	"github.com/cloudfoundry/uaa-key-rotator/keyprovider"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Labels", func() {
+	var rotatorConfig *config.RotatorConfig
+
+	BeforeEach(func() {
+		rotatorConfig = &config.RotatorConfig{
+			ActiveKeyLabel: "key-2",
+			EncryptionKeys: []config.EncryptionKey{
+				{Label: "key-1", Passphrase: config.Passphrase("passphrase1")},
+				{Label: "key-2", Passphrase: config.Passphrase("passphrase2")},
+			},
+			KeyProvider: config.KeyProviderConfig{Labels: []string{"ignored"}},
+		}
+	})
+
+	It("should list the static encryption keys, active key first", func() {
+		Expect(keyprovider.Labels(rotatorConfig)).To(Equal([]string{"key-2", "key-1"}))
+	})
+
+	Context("when a remote key provider is configured", func() {
+		BeforeEach(func() {
+			rotatorConfig.EncryptionKeys = nil
+			rotatorConfig.KeyProvider = config.KeyProviderConfig{
+				Type:   config.VaultKeyProvider,
+				Labels: []string{"key-0", "key-1", "key-2"},
+			}
+		})
+
+		It("should list the configured labels", func() {
+			Expect(keyprovider.Labels(rotatorConfig)).To(Equal([]string{"key-2", "key-0", "key-1"}))
+		})
+	})
+})
@@ -0,0 +1,13 @@
+package keyprovider_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestKeyProvider(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "KeyProvider Suite")
+}
@@ -0,0 +1,22 @@
+package keyprovider
+
+import (
+	"context"
+	"github.com/cloudfoundry/uaa-key-rotator/config"
+)
+
+type StaticKeyProvider struct {
+	EncryptionKeys []config.EncryptionKey
+}
+
+var _ KeyProvider = StaticKeyProvider{}
+
+func (p StaticKeyProvider) Passphrase(ctx context.Context, label string) ([]byte, error) {
+	for _, key := range p.EncryptionKeys {
+		if key.Label == label {
+			return append([]byte(nil), key.Passphrase...), nil
+		}
+	}
+
+	return nil, ErrKeyNotFound
+}
@@ -0,0 +1,35 @@
+package keyprovider_test
+
+import (
+	"context"
+	"github.com/cloudfoundry/uaa-key-rotator/config"
+	"github.com/cloudfoundry/uaa-key-rotator/keyprovider"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("StaticKeyProvider", func() {
+	var provider keyprovider.StaticKeyProvider
+
+	BeforeEach(func() {
+		provider = keyprovider.StaticKeyProvider{
+			EncryptionKeys: []config.EncryptionKey{
+				{Label: "key-1", Passphrase: config.Passphrase("passphrase1")},
+				{Label: "key-2", Passphrase: config.Passphrase("123")},
+			},
+		}
+	})
+
+	It("should return the passphrase for a configured label", func() {
+		passphrase, err := provider.Passphrase(context.Background(), "key-2")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(passphrase).To(Equal([]byte("123")))
+	})
+
+	Context("when the label is not configured", func() {
+		It("should return ErrKeyNotFound", func() {
+			_, err := provider.Passphrase(context.Background(), "key-3")
+			Expect(err).To(Equal(keyprovider.ErrKeyNotFound))
+		})
+	})
+})
@@ -0,0 +1,112 @@
+package keyprovider
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/cloudfoundry/uaa-key-rotator/config"
+	"github.com/cloudfoundry/uaa-key-rotator/crypto"
+	"github.com/pkg/errors"
+	"net/http"
+	"path"
+	"strings"
+)
+
+const (
+	defaultVaultMount = "secret"
+	defaultVaultField = "passphrase"
+)
+
+// VaultKeyProvider reads passphrases from a Vault KV secrets engine. Each
+// label is a secret at <Mount>/<Path>/<label> holding the passphrase in
+// Field. Both KV version 1 and 2 (the default) are supported.
+type VaultKeyProvider struct {
+	Client    *http.Client
+	URL       string
+	Token     string
+	Namespace string
+	Mount     string
+	Path      string
+	KVVersion int
+	Field     string
+}
+
+var _ KeyProvider = VaultKeyProvider{}
+
+type vaultSecretResponse struct {
+	Data json.RawMessage `json:"data"`
+}
+
+func (p VaultKeyProvider) Passphrase(ctx context.Context, label string) ([]byte, error) {
+	mount := p.Mount
+	if mount == "" {
+		mount = defaultVaultMount
+	}
+	field := p.Field
+	if field == "" {
+		field = defaultVaultField
+	}
+
+	secretPath := path.Join(mount, p.Path, label)
+	if p.KVVersion != 1 {
+		secretPath = path.Join(mount, "data", p.Path, label)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(p.URL, "/")+"/v1/"+secretPath, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to build vault request")
+	}
+	req = req.WithContext(ctx)
+	if p.Token != "" {
+		req.Header.Set("X-Vault-Token", p.Token)
+	}
+	if p.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.Namespace)
+	}
+
+	var response vaultSecretResponse
+	if err = getJSON(p.Client, req, &response); err != nil {
+		if err == ErrKeyNotFound {
+			return nil, err
+		}
+		return nil, errors.Wrapf(err, "unable to fetch vault secret %s", secretPath)
+	}
+	defer crypto.Zero(response.Data)
+
+	secretData := response.Data
+	if p.KVVersion != 1 {
+		var versioned vaultSecretResponse
+		if err = json.Unmarshal(secretData, &versioned); err != nil {
+			return nil, errors.Wrapf(err, "malformed vault secret %s", secretPath)
+		}
+		defer crypto.Zero(versioned.Data)
+		secretData = versioned.Data
+	}
+
+	// The fields are kept raw rather than decoded into strings, as the
+	// passphrase among them must be decoded into bytes that can be wiped.
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(secretData, &fields); err != nil {
+		return nil, errors.Wrapf(err, "malformed vault secret %s", secretPath)
+	}
+	defer func() {
+		for _, value := range fields {
+			crypto.Zero(value)
+		}
+	}()
+
+	// KV v2 returns data: null for deleted or destroyed versions.
+	if fields == nil {
+		return nil, ErrKeyNotFound
+	}
+
+	value, ok := fields[field]
+	if !ok || len(value) == 0 || value[0] != '"' {
+		return nil, errors.Errorf("vault secret %s has no '%s' field", secretPath, field)
+	}
+	var passphrase config.Passphrase
+	if err = json.Unmarshal(value, &passphrase); err != nil || len(passphrase) == 0 {
+		return nil, errors.Errorf("vault secret %s has no '%s' field", secretPath, field)
+	}
+
+	return passphrase, nil
+}
@@ -0,0 +1,126 @@
+package keyprovider_test
+
+import (
+	"context"
+	"github.com/cloudfoundry/uaa-key-rotator/keyprovider"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+)
+
+var _ = Describe("VaultKeyProvider", func() {
+	var server *httptest.Server
+	var handler http.HandlerFunc
+	var requests []*http.Request
+	var provider keyprovider.VaultKeyProvider
+
+	BeforeEach(func() {
+		requests = nil
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"data":{"data":{"passphrase":"vault-passphrase"},"metadata":{"version":3}}}`))
+		}
+		provider = keyprovider.VaultKeyProvider{
+			Token:     "some-vault-token",
+			Namespace: "some-namespace",
+			Path:      "uaa/keys",
+		}
+	})
+
+	JustBeforeEach(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r)
+			handler(w, r)
+		}))
+
+		provider.Client = server.Client()
+		provider.URL = server.URL
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should read the passphrase field from a kv v2 secret", func() {
+		passphrase, err := provider.Passphrase(context.Background(), "key-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(passphrase).To(Equal([]byte("vault-passphrase")))
+
+		Expect(requests).To(HaveLen(1))
+		Expect(requests[0].URL.Path).To(Equal("/v1/secret/data/uaa/keys/key-1"))
+		Expect(requests[0].Header.Get("X-Vault-Token")).To(Equal("some-vault-token"))
+		Expect(requests[0].Header.Get("X-Vault-Namespace")).To(Equal("some-namespace"))
+	})
+
+	Context("when using a kv v1 mount and a custom field", func() {
+		BeforeEach(func() {
+			provider.KVVersion = 1
+			provider.Mount = "kv"
+			provider.Field = "value"
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"data":{"value":"v1-passphrase"}}`))
+			}
+		})
+
+		It("should read the configured field", func() {
+			passphrase, err := provider.Passphrase(context.Background(), "key-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(passphrase).To(Equal([]byte("v1-passphrase")))
+			Expect(requests[0].URL.Path).To(Equal("/v1/kv/uaa/keys/key-1"))
+		})
+	})
+
+	Context("when the secret does not exist", func() {
+		BeforeEach(func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"errors":[]}`))
+			}
+		})
+
+		It("should return ErrKeyNotFound", func() {
+			_, err := provider.Passphrase(context.Background(), "key-1")
+			Expect(err).To(Equal(keyprovider.ErrKeyNotFound))
+		})
+	})
+
+	Context("when the latest version has been deleted", func() {
+		BeforeEach(func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"data":{"data":null,"metadata":{"deletion_time":"2018-03-22T02:24:06.945319214Z"}}}`))
+			}
+		})
+
+		It("should return ErrKeyNotFound", func() {
+			_, err := provider.Passphrase(context.Background(), "key-1")
+			Expect(err).To(Equal(keyprovider.ErrKeyNotFound))
+		})
+	})
+
+	Context("when the secret does not contain the field", func() {
+		BeforeEach(func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"data":{"data":{"password":"wrong-field"}}}`))
+			}
+		})
+
+		It("should return a meaningful error", func() {
+			_, err := provider.Passphrase(context.Background(), "key-1")
+			Expect(err).To(MatchError("vault secret secret/data/uaa/keys/key-1 has no 'passphrase' field"))
+		})
+	})
+
+	Context("when vault denies access", func() {
+		BeforeEach(func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(`{"errors":["permission denied"]}`))
+			}
+		})
+
+		It("should return a meaningful error", func() {
+			_, err := provider.Passphrase(context.Background(), "key-1")
+			Expect(err).To(MatchError("unable to fetch vault secret secret/data/uaa/keys/key-1: unexpected status code 403"))
+		})
+	})
+})
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/cloudfoundry/uaa-key-rotator/config"
+	"github.com/cloudfoundry/uaa-key-rotator/crypto"
+	"github.com/cloudfoundry/uaa-key-rotator/keyprovider"
+	"github.com/cloudfoundry/uaa-key-rotator/logging"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+)
+
+const keysUsage = `usage: uaa-key-rotator keys new -config <path> [-dated] [-format json|yaml] [-write] [-activate=false]
+       uaa-key-rotator keys list -config <path>`
+
+const (
+	keysFormatJSON = "json"
+	keysFormatYAML = "yaml"
+)
+
+// keysCommand implements "keys new", which generates a key and either prints
+// it as a config snippet or adds it to the config, and "keys list", which
+// lists the configured keys without their passphrases.
+func keysCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "new":
+			return keysNewCommand(args[1:], stdout, stderr)
+		case "list":
+			return keysListCommand(args[1:], stdout, stderr)
+		}
+	}
+	fmt.Fprintln(stderr, keysUsage)
+	return 2
+}
+
+func keysNewCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("keys new", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := flags.String("config", "", "Path to uaa key rotator config file")
+	dated := flags.Bool("dated", false, "Label the key with today's date rather than at random")
+	format := flags.String("format", keysFormatJSON, "Print the key as a rotator config snippet (json) or a uaa.yml snippet (yaml)")
+	write := flags.Bool("write", false, "Add the key to the config file, after backing it up, instead of printing it")
+	activate := flags.Bool("activate", true, "Make the new key the active key")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *format != keysFormatJSON && *format != keysFormatYAML {
+		fmt.Fprintf(stderr, "unknown format '%s', expected json or yaml\n", *format)
+		return 2
+	}
+
+	content, err := ioutil.ReadFile(*configPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to open config: %s\n", err)
+		return 1
+	}
+	defer crypto.Zero(content)
+
+	rotatorConfig, err := config.New(bytes.NewReader(content))
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to parse config: %s\n", err)
+		return 1
+	}
+	existing := configuredLabels(rotatorConfig)
+	rotatorConfig.WipePassphrases()
+
+	key, err := keyprovider.NewKey(existing, *dated, time.Now())
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer key.Passphrase.Wipe()
+
+	if !*write {
+		snippet, err := keySnippet(key, *activate, *format)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		defer crypto.Zero(snippet)
+		stdout.Write(snippet)
+		return 0
+	}
+
+	if rotatorConfig.KeyProvider.Type != "" && rotatorConfig.KeyProvider.Type != config.StaticKeyProvider {
+		fmt.Fprintf(stderr, "keys are held by the %s key provider, so the new key must be stored there; leave out -write to print it\n", rotatorConfig.KeyProvider.Type)
+		return 1
+	}
+
+	backupPath, err := addKeyToConfig(*configPath, content, key, *activate)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	fmt.Fprintf(stderr, "added key %s to %s, which was backed up to %s\n", key.Label, *configPath, backupPath)
+	if *activate {
+		for _, target := range rotatorConfig.Targets {
+			if target.ActiveKeyLabel != "" || len(target.EncryptionKeys) > 0 || target.KeyProvider != nil {
+				fmt.Fprintf(stderr, "target %s has keys of its own, and still rotates to its own active key\n", target.Name)
+			}
+		}
+	}
+	return 0
+}
+
+func keysListCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("keys list", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := flags.String("config", "", "Path to uaa key rotator config file")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	configFile, err := os.Open(*configPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to open config: %s\n", err)
+		return 1
+	}
+	defer configFile.Close()
+
+	rotatorConfig, err := config.New(configFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to parse config: %s\n", err)
+		return 1
+	}
+	defer rotatorConfig.WipePassphrases()
+
+	table := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	for _, target := range rotatorConfig.ResolveTargets() {
+		if target.Name != "" {
+			fmt.Fprintf(table, "target %s:\n", target.Name)
+		}
+		listKeys(table, target.Config)
+		if target.Name != "" {
+			target.Config.WipePassphrases()
+		}
+	}
+	table.Flush()
+	return 0
+}
+
+// listKeys writes a line for each key of a config, marking the active key.
+// Passphrases are masked, and those of a remote key provider are not known.
+func listKeys(w io.Writer, rotatorConfig *config.RotatorConfig) {
+	passphrases := map[string]bool{}
+	for _, key := range rotatorConfig.EncryptionKeys {
+		passphrases[key.Label] = len(key.Passphrase) > 0
+	}
+	remote := rotatorConfig.KeyProvider.Type != "" && rotatorConfig.KeyProvider.Type != config.StaticKeyProvider
+
+	for _, label := range keyprovider.Labels(rotatorConfig) {
+		marker := " "
+		if label == rotatorConfig.ActiveKeyLabel {
+			marker = "*"
+		}
+
+		passphrase := logging.Redacted
+		switch {
+		case remote:
+			passphrase = "held by " + rotatorConfig.KeyProvider.Type
+		case !passphrases[label]:
+			passphrase = "missing"
+		}
+		fmt.Fprintf(w, "%s %s\t%s\n", marker, label, passphrase)
+	}
+}
+
+// configuredLabels returns the labels of the keys of every target of a
+// config, so that a new key can be labelled apart from all of them.
+func configuredLabels(rotatorConfig *config.RotatorConfig) []string {
+	labels := keyprovider.Labels(rotatorConfig)
+	for _, target := range rotatorConfig.ResolveTargets() {
+		labels = append(labels, keyprovider.Labels(target.Config)...)
+		if target.Name != "" {
+			target.Config.WipePassphrases()
+		}
+	}
+	return labels
+}
+
+// keySnippet formats a key as a rotator config snippet, or as the
+// encryption section of uaa.yml.
+func keySnippet(key config.EncryptionKey, activate bool, format string) ([]byte, error) {
+	if format == keysFormatYAML {
+		var encryption yaml.MapSlice
+		if activate {
+			encryption = append(encryption, yaml.MapItem{Key: "active_key_label", Value: key.Label})
+		}
+		encryption = append(encryption, yaml.MapItem{Key: "encryption_keys", Value: []yaml.MapSlice{{
+			{Key: "label", Value: key.Label},
+			{Key: "passphrase", Value: string(key.Passphrase)},
+		}}})
+
+		snippet, err := yaml.Marshal(yaml.MapSlice{{Key: "encryption", Value: encryption}})
+		return snippet, errors.Wrap(err, "unable to format key")
+	}
+
+	snippet := struct {
+		ActiveKeyLabel string                 `json:"activeKeyLabel,omitempty"`
+		EncryptionKeys []config.EncryptionKey `json:"encryptionKeys"`
+	}{EncryptionKeys: []config.EncryptionKey{key}}
+	if activate {
+		snippet.ActiveKeyLabel = key.Label
+	}
+	formatted, err := json.MarshalIndent(snippet, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to format key")
+	}
+	return append(formatted, '\n'), nil
+}
+
+// addKeyToConfig copies the config at path to a timestamped backup next to
+// it, then replaces it with the config with key added. It returns the path
+// of the backup.
+func addKeyToConfig(path string, content []byte, key config.EncryptionKey, activate bool) (string, error) {
+	edited, err := config.AddEncryptionKey(content, key, activate)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to add key to config")
+	}
+	defer crypto.Zero(edited)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to open config")
+	}
+
+	backupPath := path + ".bak-" + time.Now().UTC().Format("20060102T150405Z")
+	backupFile, err := os.OpenFile(backupPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to back up config")
+	}
+	if _, err = backupFile.Write(content); err == nil {
+		err = backupFile.Sync()
+	}
+	if closeErr := backupFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "unable to back up config")
+	}
+
+	tempFile, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return "", errors.Wrap(err, "unable to write config")
+	}
+	defer os.Remove(tempFile.Name())
+
+	if err = tempFile.Chmod(info.Mode().Perm()); err == nil {
+		if _, err = tempFile.Write(edited); err == nil {
+			err = tempFile.Sync()
+		}
+	}
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "unable to write config")
+	}
+	return backupPath, errors.Wrap(os.Rename(tempFile.Name(), path), "unable to move config into place")
+}
@@ -0,0 +1,85 @@
+package main_test
+
+import (
+	"encoding/json"
+	"github.com/cloudfoundry/uaa-key-rotator/config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gexec"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+var _ = Describe("Keys command", func() {
+	var configDir, configPath string
+
+	BeforeEach(func() {
+		jsonConfig, err := json.Marshal(config.RotatorConfig{
+			ActiveKeyLabel:   oldKey.Label,
+			EncryptionKeys:   []config.EncryptionKey{oldKey},
+			DatabaseHostname: "unused",
+			DatabasePort:     "1",
+			DatabaseScheme:   "postgres",
+			DatabaseName:     "uaa",
+			DatabaseUsername: "uaa",
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		configDir, err = ioutil.TempDir(os.TempDir(), "rotator_config")
+		Expect(err).NotTo(HaveOccurred())
+		configPath = filepath.Join(configDir, "config.json")
+		Expect(ioutil.WriteFile(configPath, jsonConfig, 0600)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(configDir)
+	})
+
+	run := func(args ...string) *gexec.Session {
+		session, err := gexec.Start(exec.Command(uaaRotatorBuildPath, args...), GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(session, 10).Should(gexec.Exit())
+		return session
+	}
+
+	It("should print a new key without changing the config", func() {
+		original, err := ioutil.ReadFile(configPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		session := run("keys", "new", "-config", configPath)
+		Expect(session.ExitCode()).To(Equal(0))
+
+		var snippet config.RotatorConfig
+		Expect(json.Unmarshal(session.Out.Contents(), &snippet)).To(Succeed())
+		Expect(snippet.EncryptionKeys).To(HaveLen(1))
+		Expect(snippet.ActiveKeyLabel).To(Equal(snippet.EncryptionKeys[0].Label))
+		Expect(snippet.EncryptionKeys[0].Passphrase).To(HaveLen(43))
+
+		Expect(ioutil.ReadFile(configPath)).To(Equal(original))
+	})
+
+	It("should add a new active key to the config, after backing it up", func() {
+		session := run("keys", "new", "-config", configPath, "-dated", "-write")
+		Expect(session.ExitCode()).To(Equal(0))
+		Expect(session.Out.Contents()).To(BeEmpty())
+
+		configFile, err := os.Open(configPath)
+		Expect(err).NotTo(HaveOccurred())
+		defer configFile.Close()
+		rotatorConfig, err := config.New(configFile)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rotatorConfig.ActiveKeyLabel).To(HavePrefix("key-"))
+		Expect(rotatorConfig.EncryptionKeys).To(HaveLen(2))
+		Expect(rotatorConfig.EncryptionKeys[0]).To(Equal(oldKey))
+
+		backups, err := filepath.Glob(configPath + ".bak-*")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(backups).To(HaveLen(1))
+
+		listed := run("keys", "list", "-config", configPath)
+		Expect(listed.ExitCode()).To(Equal(0))
+		Expect(string(listed.Out.Contents())).To(MatchRegexp(`^\* ` + rotatorConfig.ActiveKeyLabel + ` +\*REDACTED\*\n  ` + oldKey.Label + ` +\*REDACTED\*\n$`))
+	})
+})
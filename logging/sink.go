@@ -0,0 +1,287 @@
+package logging
+
+import (
+	"bytes"
+	"code.cloudfoundry.org/lager"
+	"encoding/json"
+	"fmt"
+	"github.com/cloudfoundry/uaa-key-rotator/config"
+	"github.com/pkg/errors"
+	"log/syslog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultSyslogTag = "uaa-key-rotator"
+
+// Sink is a lager.Sink that formats log lines as configured and writes them
+// to stdout, a file or syslog. File destinations can be reopened, so that
+// logrotate can move the file aside during long runs.
+type Sink struct {
+	minLogLevel lager.LogLevel
+	format      string
+	output      output
+	mutex       sync.Mutex
+}
+
+type output interface {
+	write(level lager.LogLevel, line []byte) error
+	reopen() error
+	close() error
+}
+
+func NewSink(loggingConfig config.LoggingConfig) (*Sink, error) {
+	if err := loggingConfig.Validate(); err != nil {
+		return nil, err
+	}
+
+	minLogLevel, err := ParseLevel(loggingConfig.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	var out output
+	switch loggingConfig.Destination {
+	case config.LogDestinationStdout:
+		out = writerOutput{file: os.Stdout}
+	case config.LogDestinationFile:
+		out, err = openFileOutput(loggingConfig.File)
+	case config.LogDestinationSyslog:
+		out, err = dialSyslogOutput(loggingConfig.SyslogAddress, loggingConfig.SyslogTag)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Sink{
+		minLogLevel: minLogLevel,
+		format:      loggingConfig.Format,
+		output:      out,
+	}, nil
+}
+
+func ParseLevel(level string) (lager.LogLevel, error) {
+	switch level {
+	case config.LogLevelDebug:
+		return lager.DEBUG, nil
+	case config.LogLevelInfo:
+		return lager.INFO, nil
+	case config.LogLevelError:
+		return lager.ERROR, nil
+	case config.LogLevelFatal:
+		return lager.FATAL, nil
+	default:
+		return lager.INFO, errors.Errorf("unknown log level '%s'", level)
+	}
+}
+
+func (s *Sink) Log(log lager.LogFormat) {
+	if log.LogLevel < s.minLogLevel {
+		return
+	}
+
+	var line []byte
+	switch s.format {
+	case config.LogFormatRFC3339:
+		line = formatRFC3339(log)
+	case config.LogFormatText:
+		line = formatText(log)
+	default:
+		line = log.ToJSON()
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.output.write(log.LogLevel, line)
+}
+
+// Reopen closes and reopens the log file. It does nothing for other
+// destinations.
+func (s *Sink) Reopen() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.output.reopen()
+}
+
+func (s *Sink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.output.close()
+}
+
+type writerOutput struct {
+	file *os.File
+}
+
+func (o writerOutput) write(level lager.LogLevel, line []byte) error {
+	_, err := o.file.Write(append(line, '\n'))
+	return err
+}
+
+func (o writerOutput) reopen() error { return nil }
+
+func (o writerOutput) close() error { return nil }
+
+type fileOutput struct {
+	path string
+	file *os.File
+}
+
+func openFileOutput(path string) (*fileOutput, error) {
+	out := &fileOutput{path: path}
+	if err := out.reopen(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (o *fileOutput) write(level lager.LogLevel, line []byte) error {
+	_, err := o.file.Write(append(line, '\n'))
+	return err
+}
+
+func (o *fileOutput) reopen() error {
+	file, err := os.OpenFile(o.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return errors.Wrapf(err, "unable to open log file %s", o.path)
+	}
+
+	previous := o.file
+	o.file = file
+	if previous != nil {
+		previous.Close()
+	}
+	return nil
+}
+
+func (o *fileOutput) close() error {
+	return o.file.Close()
+}
+
+type syslogOutput struct {
+	writer *syslog.Writer
+}
+
+// dialSyslogOutput connects to the local syslog daemon, or to the unix
+// socket at address when one is given.
+func dialSyslogOutput(address string, tag string) (*syslogOutput, error) {
+	if tag == "" {
+		tag = defaultSyslogTag
+	}
+
+	network := ""
+	if address != "" {
+		network = "unixgram"
+	}
+
+	writer, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to connect to syslog")
+	}
+	return &syslogOutput{writer: writer}, nil
+}
+
+func (o *syslogOutput) write(level lager.LogLevel, line []byte) error {
+	message := string(line)
+	switch level {
+	case lager.DEBUG:
+		return o.writer.Debug(message)
+	case lager.INFO:
+		return o.writer.Info(message)
+	case lager.ERROR:
+		return o.writer.Err(message)
+	default:
+		return o.writer.Crit(message)
+	}
+}
+
+func (o *syslogOutput) reopen() error { return nil }
+
+func (o *syslogOutput) close() error {
+	return o.writer.Close()
+}
+
+var levelNames = map[lager.LogLevel]string{
+	lager.DEBUG: "debug",
+	lager.INFO:  "info",
+	lager.ERROR: "error",
+	lager.FATAL: "fatal",
+}
+
+type rfc3339Format struct {
+	Timestamp string     `json:"timestamp"`
+	Level     string     `json:"level"`
+	Source    string     `json:"source"`
+	Message   string     `json:"message"`
+	Data      lager.Data `json:"data"`
+}
+
+func formatRFC3339(log lager.LogFormat) []byte {
+	content, err := json.Marshal(rfc3339Format{
+		Timestamp: parseTimestamp(log.Timestamp).Format(time.RFC3339Nano),
+		Level:     levelNames[log.LogLevel],
+		Source:    log.Source,
+		Message:   log.Message,
+		Data:      log.Data,
+	})
+	if err != nil {
+		// Fall back to lager's own handling of unserialisable data.
+		return log.ToJSON()
+	}
+	return content
+}
+
+func formatText(log lager.LogFormat) []byte {
+	var line bytes.Buffer
+	fmt.Fprintf(&line, "%s %-5s %s",
+		parseTimestamp(log.Timestamp).Format(time.RFC3339Nano),
+		strings.ToUpper(levelNames[log.LogLevel]),
+		log.Message,
+	)
+
+	keys := make([]string, 0, len(log.Data))
+	for key := range log.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fmt.Fprintf(&line, " %s=%s", key, formatTextValue(log.Data[key]))
+	}
+	return line.Bytes()
+}
+
+func formatTextValue(value interface{}) string {
+	if str, ok := value.(string); ok {
+		if str == "" || strings.ContainsAny(str, " \t\n\"=") {
+			return strconv.Quote(str)
+		}
+		return str
+	}
+
+	content, err := json.Marshal(value)
+	if err != nil {
+		return strconv.Quote(fmt.Sprintf("%v", value))
+	}
+	return string(content)
+}
+
+// parseTimestamp converts lager's "<seconds>.<nanoseconds>" timestamps.
+func parseTimestamp(timestamp string) time.Time {
+	parts := strings.SplitN(timestamp, ".", 2)
+	seconds, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Now().UTC()
+	}
+
+	var nanoseconds int64
+	if len(parts) == 2 {
+		fraction := (parts[1] + "000000000")[:9]
+		nanoseconds, _ = strconv.ParseInt(fraction, 10, 64)
+	}
+	return time.Unix(seconds, nanoseconds).UTC()
+}
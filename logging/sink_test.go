@@ -0,0 +1,185 @@
+package logging_test
+
+import (
+	"code.cloudfoundry.org/lager"
+	"encoding/json"
+	"errors"
+	"github.com/cloudfoundry/uaa-key-rotator/config"
+	. "github.com/cloudfoundry/uaa-key-rotator/logging"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var _ = Describe("Sink", func() {
+	var (
+		tempDir       string
+		logPath       string
+		loggingConfig config.LoggingConfig
+		sink          *Sink
+		logger        lager.Logger
+	)
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "logging")
+		Expect(err).NotTo(HaveOccurred())
+		logPath = filepath.Join(tempDir, "rotator.log")
+
+		loggingConfig = config.LoggingConfig{
+			Destination: config.LogDestinationFile,
+			File:        logPath,
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		sink, err = NewSink(loggingConfig)
+		Expect(err).NotTo(HaveOccurred())
+
+		logger = lager.NewLogger("rotator")
+		logger.RegisterSink(sink)
+	})
+
+	AfterEach(func() {
+		if sink != nil {
+			sink.Close()
+		}
+		os.RemoveAll(tempDir)
+	})
+
+	logLines := func() []string {
+		content, err := ioutil.ReadFile(logPath)
+		Expect(err).NotTo(HaveOccurred())
+		return strings.Split(strings.TrimSpace(string(content)), "\n")
+	}
+
+	It("defaults to lager JSON at info level", func() {
+		logger.Debug("hidden")
+		logger.Info("visible", lager.Data{"zone_id": "uaa"})
+
+		lines := logLines()
+		Expect(lines).To(HaveLen(1))
+
+		var line lager.LogFormat
+		Expect(json.Unmarshal([]byte(lines[0]), &line)).To(Succeed())
+		Expect(line.Message).To(Equal("rotator.visible"))
+		Expect(line.LogLevel).To(Equal(lager.INFO))
+		Expect(line.Data).To(Equal(lager.Data{"zone_id": "uaa"}))
+	})
+
+	Context("when the level is debug", func() {
+		BeforeEach(func() {
+			loggingConfig.Level = config.LogLevelDebug
+		})
+
+		It("logs debug lines", func() {
+			logger.Debug("shown")
+			Expect(logLines()).To(ConsistOf(ContainSubstring("rotator.shown")))
+		})
+	})
+
+	Context("when the format is rfc3339", func() {
+		BeforeEach(func() {
+			loggingConfig.Format = config.LogFormatRFC3339
+		})
+
+		It("logs JSON with RFC3339 timestamps and named levels", func() {
+			logger.Error("failed", errors.New("boom"))
+
+			var line map[string]interface{}
+			Expect(json.Unmarshal([]byte(logLines()[0]), &line)).To(Succeed())
+			Expect(line).To(HaveKeyWithValue("level", "error"))
+			Expect(line).To(HaveKeyWithValue("message", "rotator.failed"))
+			Expect(line).To(HaveKeyWithValue("data", HaveKeyWithValue("error", "boom")))
+
+			timestamp, err := time.Parse(time.RFC3339Nano, line["timestamp"].(string))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(timestamp).To(BeTemporally("~", time.Now(), time.Minute))
+		})
+	})
+
+	Context("when the format is text", func() {
+		BeforeEach(func() {
+			loggingConfig.Format = config.LogFormatText
+		})
+
+		It("logs human readable lines with sorted data", func() {
+			logger.Info("rotating", lager.Data{"zone_id": "uaa", "error": "some error", "count": 2})
+
+			line := logLines()[0]
+			Expect(line).To(MatchRegexp(`^\d{4}-\d{2}-\d{2}T\S+ INFO  rotator.rotating count=2 error="some error" zone_id=uaa$`))
+		})
+	})
+
+	It("reopens the log file", func() {
+		logger.Info("before")
+
+		rotatedPath := logPath + ".1"
+		Expect(os.Rename(logPath, rotatedPath)).To(Succeed())
+		logger.Info("still-old-file")
+
+		Expect(sink.Reopen()).To(Succeed())
+		logger.Info("after")
+
+		rotated, err := ioutil.ReadFile(rotatedPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(rotated)).To(ContainSubstring("rotator.before"))
+		Expect(string(rotated)).To(ContainSubstring("rotator.still-old-file"))
+
+		Expect(logLines()).To(ConsistOf(ContainSubstring("rotator.after")))
+	})
+
+	Context("when the destination is syslog", func() {
+		var listener *net.UnixConn
+
+		BeforeEach(func() {
+			socketPath := filepath.Join(tempDir, "syslog.sock")
+			var err error
+			listener, err = net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+			Expect(err).NotTo(HaveOccurred())
+
+			loggingConfig = config.LoggingConfig{
+				Destination:   config.LogDestinationSyslog,
+				SyslogAddress: socketPath,
+				SyslogTag:     "rotator-test",
+			}
+		})
+
+		AfterEach(func() {
+			listener.Close()
+		})
+
+		table.DescribeTable("maps log levels to syslog priorities",
+			func(log func(), priority string) {
+				log()
+
+				buf := make([]byte, 4096)
+				listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+				n, err := listener.Read(buf)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(buf[:n])).To(HavePrefix(priority))
+				Expect(string(buf[:n])).To(ContainSubstring("rotator-test"))
+				Expect(string(buf[:n])).To(ContainSubstring("rotator.message"))
+			},
+			table.Entry("info", func() { logger.Info("message") }, "<30>"),
+			table.Entry("error", func() { logger.Error("message", errors.New("boom")) }, "<27>"),
+		)
+	})
+
+	It("fails for an unknown level", func() {
+		_, err := NewSink(config.LoggingConfig{Level: "trace"})
+		Expect(err).To(MatchError("Logging.Level: unknown log level 'trace'"))
+	})
+
+	It("fails when the log file cannot be opened", func() {
+		_, err := NewSink(config.LoggingConfig{Destination: config.LogDestinationFile, File: filepath.Join(tempDir, "missing", "rotator.log")})
+		Expect(err).To(MatchError(ContainSubstring("unable to open log file")))
+	})
+})
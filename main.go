@@ -4,39 +4,90 @@ import (
 	"code.cloudfoundry.org/lager"
 	_ "code.cloudfoundry.org/lager"
 	"context"
-	"database/sql"
 	"flag"
 	"fmt"
 	"github.com/cloudfoundry/uaa-key-rotator/config"
-	"github.com/cloudfoundry/uaa-key-rotator/crypto"
-	db2 "github.com/cloudfoundry/uaa-key-rotator/db"
-	"github.com/cloudfoundry/uaa-key-rotator/rotator"
+	"github.com/cloudfoundry/uaa-key-rotator/logging"
+	"github.com/cloudfoundry/uaa-key-rotator/runner"
 	_ "github.com/go-sql-driver/mysql"
-	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 	"github.com/pkg/errors"
 	"log"
-	"net"
 	"os"
 	"os/signal"
 	"runtime"
-	"sync"
 	"syscall"
+	"time"
 )
 
 func main() {
-	sigChan := make(chan os.Signal)
+	if len(os.Args) > 1 && os.Args[1] == "audit" {
+		os.Exit(auditCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		os.Exit(restoreCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "encrypt":
+			os.Exit(encryptCommand(os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+		case "decrypt":
+			os.Exit(decryptCommand(os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+		case "inspect":
+			os.Exit(inspectCommand(os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+		case "keys":
+			os.Exit(keysCommand(os.Args[2:], os.Stdout, os.Stderr))
+		}
+	}
+
+	watch := len(os.Args) > 1 && os.Args[1] == "watch"
+	retryFailed := len(os.Args) > 1 && os.Args[1] == "retry-failed"
+	args := os.Args[1:]
+	if watch || retryFailed {
+		args = os.Args[2:]
+	}
+
+	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGTERM, os.Interrupt)
 
 	allowThreadDumpOnSigQUIT()
 
-	logger := lager.NewLogger(fmt.Sprintf("%s.%s", "rotator", "uaa-key-rotator"))
-	logger.RegisterSink(lager.NewWriterSink(os.Stdout, lager.INFO))
+	configPath := flag.String("config", "", "Path to uaa key rotator config file")
+	logLevel := flag.String("log-level", "", "Log level: debug, info, error or fatal (overrides config)")
+	logFormat := flag.String("log-format", "", "Log format: lager, rfc3339 or text (overrides config)")
+	logDestination := flag.String("log-destination", "", "Log destination: stdout, file or syslog (overrides config)")
+	logFile := flag.String("log-file", "", "Path to the log file when logging to a file (overrides config)")
+	var options runner.Options
+	rowFilter := &options.RowFilter
+	flag.Var((*stringSliceFlag)(&rowFilter.ZoneIDs), "zone-id", "Only rotate rows in this identity zone (repeatable)")
+	flag.Var((*stringSliceFlag)(&rowFilter.UserIDs), "user-id", "Only rotate rows for this user id (repeatable)")
+	userIDsFile := flag.String("user-ids-file", "", "Only rotate rows for the user ids in this file, one per line")
+	flag.Var((*stringSliceFlag)(&rowFilter.SourceLabels), "source-label", "Only rotate rows encrypted with this key label (repeatable)")
+	flag.IntVar(&rowFilter.Limit, "limit", 0, "Rotate at most this many rows (0 for no limit)")
+	flag.BoolVar(&options.TrialDecrypt, "trial-decrypt", false, "Try every configured key against each column and rotate with whichever key decrypts it")
+	flag.BoolVar(&options.VerifyAfterWrite, "verify-after-write", false, "Read each row back after writing it and check it decrypts to the original plaintext")
+	flag.BoolVar(&options.Legacy.PlaintextRows, "migrate-plaintext", false, "Encrypt rows with an empty or NULL encryption_key_label, which were never encrypted, instead of rotating")
+	flag.BoolVar(&options.Legacy.ValidationCodes, "migrate-validation-code", false, "Encrypt plaintext validation_code values into encrypted_validation_code and clear them, instead of rotating")
+	flag.BoolVar(&options.ConfirmMigration, "confirm-migration", false, "Write the changes made by -migrate-plaintext or -migrate-validation-code, which cannot be undone")
+	flag.BoolVar(&options.DryRun, "dry-run", false, "Log the rows that would be written without writing anything")
+	flag.BoolVar(&options.Strict, "strict", false, "Stop at the first row that fails, instead of allowing the failures of the failureBudget config")
+	flag.BoolVar(&options.Claim, "claim", false, "Claim and rotate rows in locked batches, so that several rotators can run at once")
+	flag.IntVar(&options.ClaimBatchSize, "claim-batch-size", runner.DefaultClaimBatchSize, "How many rows to claim and commit at a time with -claim")
+	flag.DurationVar(&options.WaitForLock, "wait-for-lock", 0, "How long to wait for another rotator to finish before giving up (0 to fail at once)")
+	flag.DurationVar(&options.WatchInterval, "interval", 5*time.Minute, "How often watch looks for rows to rotate")
+	flag.StringVar(&options.HealthAddress, "health-address", "127.0.0.1:8089", "Address watch serves its health endpoint on (empty to disable)")
+	dumpInput := flag.String("input", "", "Rotate this dump of user_google_mfa_credentials instead of the database")
+	dumpOutput := flag.String("output", "", "Where to write the rotated dump, which must not be the -input dump")
+	dumpFormat := flag.String("dump-format", "", "Format of the dumps: csv or jsonl (defaults to the -input file extension)")
+	flag.StringVar(&options.QuarantineFile, "quarantine-file", "", "Append each row that fails to this JSONL file")
+	retryFrom := flag.String("from", "", "Quarantine file whose rows retry-failed rotates")
+	flag.CommandLine.Parse(args)
+	options.Watch = watch
 
-	logger.Info("rotator has started")
+	redactor := &logging.Redactor{}
+	logger := lager.NewLogger(fmt.Sprintf("%s.%s", "rotator", "uaa-key-rotator"))
+	logger.RegisterSink(logging.NewRedactingSink(lager.NewWriterSink(os.Stdout, lager.INFO), redactor))
 
-	configPath := flag.String("config", "", "Path to uaa key rotator config file")
-	flag.Parse()
 	configFile, err := os.Open(*configPath)
 	if err != nil {
 		logger.Fatal("unable to open config", err)
@@ -46,134 +97,167 @@ func main() {
 	if err != nil {
 		logger.Fatal("unable to parse config", err)
 	}
-
-	var rotatorChan = make(chan struct{})
-	var rotatorChanErr = make(chan error)
-	parentCtx := context.Background()
-	rotatorCtx, cancelRotatorFunc := context.WithCancel(parentCtx)
-	go rotate(rotatorCtx, logger, rotatorConfig, rotatorChan, rotatorChanErr)
-
-	select {
-	case s := <-sigChan:
-		if s == os.Interrupt {
-			logger.Info("shutting down gracefully...")
-			cancelRotatorFunc()
+	redactor.HashUserIDs = rotatorConfig.Logging.HashUserIDs
+	redactor.Secrets = []string{rotatorConfig.DatabasePassword, rotatorConfig.KeyProvider.Token, rotatorConfig.Audit.HMACKey, rotatorConfig.Backup.Key}
+	for _, target := range rotatorConfig.Targets {
+		redactor.Secrets = append(redactor.Secrets, target.DatabasePassword)
+		if target.KeyProvider != nil {
+			redactor.Secrets = append(redactor.Secrets, target.KeyProvider.Token)
 		}
-	case <-rotatorChan:
-		os.Exit(0)
-	case err := <-rotatorChanErr:
-		logger.Error("rotator experienced an error. Exiting", err)
-		os.Exit(1)
 	}
-}
 
-func rotate(parentCtx context.Context, logger lager.Logger, rotatorConfig *config.RotatorConfig, rotatorChan chan struct{}, rotatorChanErr chan error) {
-	defer close(rotatorChan)
+	overrideString(&rotatorConfig.Logging.Level, *logLevel)
+	overrideString(&rotatorConfig.Logging.Format, *logFormat)
+	overrideString(&rotatorConfig.Logging.Destination, *logDestination)
+	overrideString(&rotatorConfig.Logging.File, *logFile)
 
-	dbURI, err := db2.ConnectionURI(rotatorConfig)
+	logSink, err := logging.NewSink(rotatorConfig.Logging)
 	if err != nil {
-		logger.Error("unable to get a DBconnection URI", err)
-		rotatorChanErr <- errors.New("unable to get a DBconnection URI")
-		return
+		logger.Fatal("unable to configure logging", err)
 	}
+	logger = lager.NewLogger(fmt.Sprintf("%s.%s", "rotator", "uaa-key-rotator"))
+	// Close the sink on every way out, so that the lines it buffers are not
+	// lost: deferred, for logger.Fatal, which panics, and through exit for
+	// os.Exit, which skips deferred calls.
+	defer logSink.Close()
+	logger.RegisterSink(logging.NewRedactingSink(logSink, redactor))
+	reopenLogsOnSIGHUP(logger, logSink)
 
-	db, err := getDbConn(rotatorConfig.DatabaseScheme, dbURI)
-	if err != nil {
-		logger.Error("unable to get a DB Connection", err)
-		rotatorChanErr <- errors.New("unable to get a DB Connection")
-		return
-	}
-	defer db.Close()
+	logger.Info("rotator has started")
 
-	credentialsDBFetcher := db2.GoogleMfaCredentialsDBFetcher{
-		DB:             db,
-		ActiveKeyLabel: rotatorConfig.ActiveKeyLabel,
+	if *userIDsFile != "" {
+		userIDs, err := readIDsFile(*userIDsFile)
+		if err != nil {
+			logger.Fatal("unable to read user ids file", err)
+		}
+		if len(userIDs) == 0 {
+			logger.Fatal("unable to read user ids file", errors.New("no user ids found"))
+		}
+		rowFilter.UserIDs = append(rowFilter.UserIDs, userIDs...)
 	}
-
-	var fetcherErrChan <-chan error
-	credentialsChan, fetcherErrChan := credentialsDBFetcher.RowsToRotate()
-	credentialsDBUpdater := db2.GoogleMfaCredentialsDBUpdater{
-		DB: db,
+	if retryFailed {
+		if *retryFrom == "" {
+			logger.Fatal("invalid flags", errors.New("retry-failed needs -from, the quarantine file to retry"))
+		}
+		userIDs, err := readQuarantineFile(*retryFrom)
+		if err != nil {
+			logger.Fatal("unable to read quarantine file", err)
+		}
+		if len(userIDs) == 0 {
+			logger.Info("quarantine file lists no rows, nothing to retry", lager.Data{"file": *retryFrom})
+			exit(logSink, 0)
+		}
+		rows := 0
+		for _, targetUserIDs := range userIDs {
+			rows += len(targetUserIDs)
+		}
+		logger.Info("retrying quarantined rows", lager.Data{"file": *retryFrom, "rows": rows})
+		options.TargetUserIDs = userIDs
+	} else if *retryFrom != "" {
+		logger.Fatal("invalid flags", errors.New("-from can only be used with retry-failed"))
 	}
-
-	keyService := rotator.UaaKeyService{
-		ActiveKeyLabel: rotatorConfig.ActiveKeyLabel,
-		EncryptionKeys: rotatorConfig.EncryptionKeys,
+	if options.ClaimBatchSize <= 0 {
+		logger.Fatal("invalid claim batch size", errors.New("claim batch size must be positive"))
 	}
-	r := rotator.UAARotator{
-		KeyService:     keyService,
-		SaltAccessor:   crypto.UaaSaltAccessor{},
-		NonceAccessor:  crypto.UaaNonceAccessor{},
-		CipherAccessor: crypto.UAACipherAccessor{},
-		DbMapper:       rotator.DbMapper{},
+	if *dumpInput != "" {
+		if *dumpOutput == "" && !options.DryRun {
+			logger.Fatal("invalid flags", errors.New("-output is required to rotate a dump"))
+		}
+		if *dumpOutput == *dumpInput {
+			logger.Fatal("invalid flags", errors.New("-output must not be the -input dump"))
+		}
+		options.Dump, err = loadDump(*dumpInput, *dumpFormat)
+		if err != nil {
+			logger.Fatal("unable to load dump", err)
+		}
+		logger.Info("rotating dump", lager.Data{"input": *dumpInput, "output": *dumpOutput})
+	}
+	options.Config = rotatorConfig
+	options.Logger = logger
+	options.Redactor = redactor
+	if err := options.Validate(); err != nil {
+		logger.Fatal("invalid flags", err)
 	}
 
-	ctx, cancel := context.WithCancel(parentCtx)
-
-	worker := func(wg *sync.WaitGroup) {
-		defer wg.Done()
+	type runResult struct {
+		report runner.Report
+		err    error
+	}
+	var rotatorChanResult = make(chan runResult, 1)
+	rotatorCtx, cancelRotatorFunc := context.WithCancel(context.Background())
+	go func() {
+		report, err := runner.Run(rotatorCtx, options)
+		if err == nil && options.Dump != nil && !options.DryRun {
+			if err = saveDump(options.Dump, *dumpOutput); err == nil {
+				logger.Info("rotated dump written", lager.Data{"output": *dumpOutput})
+			}
+		}
+		rotatorChanResult <- runResult{report: report, err: err}
+	}()
 
-		for {
-			select {
-			case cred, ok := <-credentialsChan:
-				if !ok {
-					logger.Debug("No more mfa credentials. Worker signing off...")
-					return
-				}
-
-				logger.Info("rotating mfa cred", lager.Data{"mfa_cred": cred})
-				rotatedCred, err := r.Rotate(cred)
-				if err != nil {
-					logger.Error("unable to rotate record... Skipping", err)
-					continue
-				}
-
-				err = credentialsDBUpdater.Write(rotatedCred)
-				if err != nil {
-					logger.Error("unable to update record... Skipping", err)
-					continue
-				}
-
-			case err := <-fetcherErrChan:
-				logger.Error("error during fetching a record...", err)
-				cancel()
-			case <-ctx.Done():
-				logger.Info("rotator worker has been cancelled")
-				rotatorChanErr <- errors.New("rotator worker has been cancelled")
-				return
+	select {
+	case s := <-sigChan:
+		if s == os.Interrupt || options.Watch {
+			logger.Info("shutting down gracefully...")
+			cancelRotatorFunc()
+		}
+		if options.Watch {
+			// Let the pass in progress finish its current rows, close the
+			// audit log and release the lock.
+			if result := <-rotatorChanResult; result.err != nil {
+				exit(logSink, exitWithError(logger, result.report, result.err))
 			}
 		}
+	case result := <-rotatorChanResult:
+		if result.err != nil {
+			exit(logSink, exitWithError(logger, result.report, result.err))
+		}
+		if result.report.DominantRowFailure() != "" {
+			exit(logSink, exitWithError(logger, result.report, errors.New("rows failed to rotate")))
+		}
+		exit(logSink, 0)
 	}
+}
 
-	wg := sync.WaitGroup{}
-
-	numWorkers := 4
-	wg.Add(numWorkers)
-	for i := 0; i < numWorkers; i++ {
-		go worker(&wg)
+// exitWithError logs the error a run failed with, and returns the exit code
+// of its category.
+func exitWithError(logger lager.Logger, report runner.Report, err error) int {
+	category := failureCategory(report, err)
+	data := lager.Data{"error_category": category}
+	if report.AuditHeadMAC != "" {
+		data["audit_head_mac"] = report.AuditHeadMAC
 	}
-
-	logger.Info("workers are unleahsed")
-	wg.Wait()
-	logger.Info("rotator has finished")
+	logger.Error("rotator experienced an error. Exiting", err, data)
+	return exitCode(category)
 }
 
-func getDbConn(scheme string, connectionString string) (db2.Queryer, error) {
-	nativeDBConn, err := sql.Open(scheme, connectionString)
-	if err != nil {
-		return nil, fmt.Errorf("unable to open database connection: %s", err)
+// exit closes the log sink before exiting with code, as os.Exit does not
+// run deferred calls.
+func exit(logSink *logging.Sink, code int) {
+	if err := logSink.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to close log sink: %s\n", err)
 	}
+	os.Exit(code)
+}
 
-	dbConn := sqlx.NewDb(nativeDBConn, scheme)
-	if err = dbConn.Ping(); err != nil {
-		dbConn.Close()
-		if netErr, ok := err.(*net.OpError); ok {
-			return nil, errors.Wrap(netErr, "unable to ping")
-		}
-		return nil, fmt.Errorf("unable to ping: %s", err)
+func overrideString(value *string, override string) {
+	if override != "" {
+		*value = override
 	}
+}
 
-	return db2.DbAwareQuerier{DB: dbConn, DBScheme: scheme}, nil
+func reopenLogsOnSIGHUP(logger lager.Logger, logSink *logging.Sink) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+	go func() {
+		for range sigs {
+			if err := logSink.Reopen(); err != nil {
+				logger.Error("unable to reopen log file", err)
+				continue
+			}
+			logger.Info("reopened log file")
+		}
+	}()
 }
 
 func allowThreadDumpOnSigQUIT() {
@@ -1,6 +1,7 @@
 package main_test
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/cloudfoundry/uaa-key-rotator/config"
 	dbRotator "github.com/cloudfoundry/uaa-key-rotator/db"
@@ -11,6 +12,7 @@ import (
 	"github.com/onsi/gomega/gbytes"
 	"github.com/onsi/gomega/gexec"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"os/exec"
 	"syscall"
@@ -22,11 +24,12 @@ var _ = Describe("Main", func() {
 	var rotatorConfig *config.RotatorConfig
 	var rotatorConfigFile *os.File
 	var activeKey config.EncryptionKey
+	var args []string
 
 	BeforeEach(func() {
 		activeKey = config.EncryptionKey{
 			Label:      "active-key",
-			Passphrase: "123",
+			Passphrase: config.Passphrase("123"),
 		}
 
 		rotatorConfig = &config.RotatorConfig{
@@ -43,6 +46,8 @@ var _ = Describe("Main", func() {
 			DatabasePassword: testutils.Password,
 		}
 
+		args = nil
+
 		jsonConfig, err := json.Marshal(rotatorConfig)
 		rotatorConfigFile, err = ioutil.TempFile(os.TempDir(), "rotator_config")
 		Expect(err).NotTo(HaveOccurred())
@@ -51,7 +56,7 @@ var _ = Describe("Main", func() {
 	})
 
 	JustBeforeEach(func() {
-		uaaRotatorCmd := exec.Command(uaaRotatorBuildPath, "-config", rotatorConfigFile.Name())
+		uaaRotatorCmd := exec.Command(uaaRotatorBuildPath, append(args, "-config", rotatorConfigFile.Name())...)
 
 		var err error
 		session, err = gexec.Start(uaaRotatorCmd, GinkgoWriter, GinkgoWriter)
@@ -73,7 +78,7 @@ var _ = Describe("Main", func() {
 		Eventually(session).ShouldNot(gbytes.Say("shutting down gracefully..."))
 
 		credentialsDBFetcher := dbRotator.GoogleMfaCredentialsDBFetcher{DB: dbRotator.DbAwareQuerier{DB: db, DBScheme: testutils.Scheme}, ActiveKeyLabel: ""}
-		mfaCredentialChan, errChan := credentialsDBFetcher.RowsToRotate()
+		mfaCredentialChan, errChan := credentialsDBFetcher.RowsToRotate(context.Background())
 		Eventually(errChan, 5*time.Second).ShouldNot(Receive())
 
 		var rotatedMfaCredential entity.MfaCredential
@@ -82,4 +87,114 @@ var _ = Describe("Main", func() {
 		decryptedRotatedSecretKey := decryptCipherValue(rotatedMfaCredential.SecretKey, string(activeKey.Passphrase))
 		Expect(decryptedRotatedSecretKey).To(Equal("secret-key"))
 	})
+
+	Context("when rotating several targets", func() {
+		BeforeEach(func() {
+			rotatorConfig.Targets = []config.TargetConfig{
+				{Name: "unreachable", DatabasePort: "1"},
+				{Name: "reachable"},
+			}
+			rotatorConfig.Retry.StartupTimeout = "1s"
+
+			jsonConfig, err := json.Marshal(rotatorConfig)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ioutil.WriteFile(rotatorConfigFile.Name(), jsonConfig, os.ModePerm)).To(Succeed())
+		})
+
+		It("should rotate the reachable target and report the unreachable one", func() {
+			Eventually(session, 2*time.Minute).Should(gbytes.Say("rotator has finished"))
+			Eventually(session, 5*time.Second).Should(gbytes.Say("rotation report"))
+			Eventually(session).Should(gexec.Exit(8))
+
+			Expect(session.Out.Contents()).To(ContainSubstring(`"target":"unreachable","succeeded":false`))
+			Expect(session.Out.Contents()).To(ContainSubstring(`"error_category":"transient_db"`))
+			Expect(session.Out.Contents()).To(ContainSubstring(`"target":"reachable","succeeded":true`))
+		})
+	})
+
+	Context("when the passphrase of the old key is wrong", func() {
+		BeforeEach(func() {
+			_, err := db.Exec(`delete from user_google_mfa_credentials`)
+			Expect(err).NotTo(HaveOccurred())
+			testFixtures()
+
+			rotatorConfig.EncryptionKeys = []config.EncryptionKey{
+				activeKey,
+				{Label: oldKey.Label, Passphrase: config.Passphrase("wrong-passphrase")},
+			}
+			jsonConfig, err := json.Marshal(rotatorConfig)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ioutil.WriteFile(rotatorConfigFile.Name(), jsonConfig, os.ModePerm)).To(Succeed())
+		})
+
+		It("should exit with the category of the failed rows, even without a failure budget", func() {
+			Eventually(session, 2*time.Minute).Should(gbytes.Say("rotator has finished"))
+			Eventually(session).Should(gexec.Exit(4))
+			Expect(session.Out.Contents()).To(ContainSubstring(`"error_category":"authentication_failed"`))
+		})
+	})
+
+	Context("when backing up rows", func() {
+		var backupPath string
+
+		BeforeEach(func() {
+			_, err := db.Exec(`delete from user_google_mfa_credentials`)
+			Expect(err).NotTo(HaveOccurred())
+			testFixtures()
+
+			backupFile, err := ioutil.TempFile(os.TempDir(), "rotator_backup")
+			Expect(err).NotTo(HaveOccurred())
+			backupFile.Close()
+			backupPath = backupFile.Name()
+			Expect(os.Remove(backupPath)).To(Succeed())
+
+			rotatorConfig.Backup = config.BackupConfig{File: backupPath, Key: "some-backup-key"}
+			jsonConfig, err := json.Marshal(rotatorConfig)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ioutil.WriteFile(rotatorConfigFile.Name(), jsonConfig, os.ModePerm)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			os.Remove(backupPath)
+		})
+
+		It("should restore the original values of the rows it rotated", func() {
+			Eventually(session, 2*time.Minute).Should(gexec.Exit(0))
+
+			restoreCmd := exec.Command(uaaRotatorBuildPath, "restore", "-zone-id", "zone_id", "-config", rotatorConfigFile.Name())
+			restoreSession, err := gexec.Start(restoreCmd, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(restoreSession, 2*time.Minute).Should(gexec.Exit(0))
+			Expect(restoreSession.Out.Contents()).To(ContainSubstring("restored 1 rows"))
+
+			credentialsDBFetcher := dbRotator.GoogleMfaCredentialsDBFetcher{DB: dbRotator.DbAwareQuerier{DB: db, DBScheme: testutils.Scheme}}
+			restoredMfaCredential, err := credentialsDBFetcher.Row(context.Background(), "user-id-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(restoredMfaCredential.EncryptionKeyLabel).To(Equal(oldKey.Label))
+			Expect(decryptCipherValue(restoredMfaCredential.SecretKey, string(oldKey.Passphrase))).To(Equal("secret-key"))
+		})
+	})
+
+	Context("when watching", func() {
+		BeforeEach(func() {
+			args = []string{"watch", "-interval", "1s", "-health-address", "127.0.0.1:18089"}
+		})
+
+		It("should keep rotating, report healthy and stop on SIGTERM", func() {
+			Eventually(session, 2*time.Minute).Should(gbytes.Say("rotation pass finished"))
+			Eventually(session, 5*time.Second).Should(gbytes.Say("rotation pass finished"))
+
+			response, err := http.Get("http://127.0.0.1:18089/")
+			Expect(err).NotTo(HaveOccurred())
+			defer response.Body.Close()
+			Expect(response.StatusCode).To(Equal(http.StatusOK))
+			var status map[string]interface{}
+			Expect(json.NewDecoder(response.Body).Decode(&status)).To(Succeed())
+			Expect(status["healthy"]).To(BeTrue())
+
+			session.Signal(syscall.SIGTERM)
+			Eventually(session, 5*time.Second).Should(gbytes.Say("shutting down gracefully..."))
+			Eventually(session, 10*time.Second).Should(gexec.Exit(0))
+		})
+	})
 })
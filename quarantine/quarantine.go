@@ -0,0 +1,144 @@
+// Package quarantine records the rows a run failed to rotate, one JSON line
+// each, so that they can be handed on and retried on their own.
+package quarantine
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/cloudfoundry/uaa-key-rotator/entity"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is a row that failed to rotate. EncryptionKeyLabel is the label the
+// row had, and Category the kind of error it failed with.
+type Entry struct {
+	Timestamp          string `json:"timestamp"`
+	RunID              string `json:"run_id,omitempty"`
+	Target             string `json:"target,omitempty"`
+	UserID             string `json:"user_id"`
+	ZoneID             string `json:"zone_id"`
+	MfaProviderID      string `json:"mfa_provider_id"`
+	EncryptionKeyLabel string `json:"encryption_key_label"`
+	Category           string `json:"category"`
+	Error              string `json:"error"`
+}
+
+// Writer appends entries to a quarantine file.
+type Writer struct {
+	RunID string
+
+	file  *os.File
+	mutex sync.Mutex
+	now   func() time.Time
+}
+
+// Open appends to the quarantine file at path, creating it if need be.
+func Open(path string, runID string) (*Writer, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open quarantine file %s", path)
+	}
+	return &Writer{RunID: runID, file: file, now: time.Now}, nil
+}
+
+// Record quarantines a row of target that failed with message, which must
+// already be scrubbed of secrets.
+func (w *Writer) Record(target string, credential entity.MfaCredential, category string, message string) error {
+	entry := Entry{
+		RunID:              w.RunID,
+		Target:             target,
+		UserID:             credential.UserId,
+		ZoneID:             string(credential.ZoneId),
+		MfaProviderID:      string(credential.MfaProviderId),
+		EncryptionKeyLabel: credential.EncryptionKeyLabel,
+		Category:           category,
+		Error:              message,
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	entry.Timestamp = w.now().UTC().Format(time.RFC3339Nano)
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "unable to serialize quarantine entry")
+	}
+	if _, err = w.file.Write(append(line, '\n')); err != nil {
+		return errors.Wrap(err, "unable to write quarantine entry")
+	}
+	return nil
+}
+
+// Close syncs and closes the file.
+func (w *Writer) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	err := w.file.Sync()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return errors.Wrap(err, "unable to close quarantine file")
+}
+
+// Read returns every entry of a quarantine file, in the order they were
+// written.
+func Read(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var entry Entry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return nil, errors.Wrapf(err, "quarantine line %d: malformed entry", lineNumber)
+		}
+		if entry.UserID == "" {
+			return nil, errors.Errorf("quarantine line %d: user_id is missing", lineNumber)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "unable to read quarantine file")
+	}
+	return entries, nil
+}
+
+// UserIDs returns the user id of every entry, once each, in the order they
+// were first quarantined.
+func UserIDs(entries []Entry) []string {
+	var userIDs []string
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		if !seen[entry.UserID] {
+			seen[entry.UserID] = true
+			userIDs = append(userIDs, entry.UserID)
+		}
+	}
+	return userIDs
+}
+
+// UserIDsByTarget returns the user ids of the entries of each target, as
+// UserIDs does. The entries of a config without targets are listed under
+// the empty name.
+func UserIDsByTarget(entries []Entry) map[string][]string {
+	entriesByTarget := map[string][]Entry{}
+	for _, entry := range entries {
+		entriesByTarget[entry.Target] = append(entriesByTarget[entry.Target], entry)
+	}
+
+	userIDs := map[string][]string{}
+	for target, targetEntries := range entriesByTarget {
+		userIDs[target] = UserIDs(targetEntries)
+	}
+	return userIDs
+}
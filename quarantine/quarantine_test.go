@@ -0,0 +1,96 @@
+package quarantine_test
+
+import (
+	"github.com/cloudfoundry/uaa-key-rotator/entity"
+	"github.com/cloudfoundry/uaa-key-rotator/quarantine"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var _ = Describe("Quarantine", func() {
+	var dir, path string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "quarantine")
+		Expect(err).NotTo(HaveOccurred())
+		path = filepath.Join(dir, "quarantine.jsonl")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	record := func(runID string, userID string) {
+		writer, err := quarantine.Open(path, runID)
+		Expect(err).NotTo(HaveOccurred())
+		credential := entity.MfaCredential{
+			UserId:             userID,
+			ZoneId:             "uaa",
+			MfaProviderId:      "provider",
+			EncryptionKeyLabel: "deleted-key",
+			SecretKey:          "ciphertext",
+		}
+		Expect(writer.Record("uaa-east", credential, "unknown_key", "unable to find key: deleted-key")).To(Succeed())
+		Expect(writer.Close()).To(Succeed())
+	}
+
+	It("should append the failed rows of each run to the file", func() {
+		record("run-1", "user-1")
+		record("run-2", "user-2")
+		record("run-3", "user-1")
+
+		info, err := os.Stat(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+
+		content, err := ioutil.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).NotTo(ContainSubstring("ciphertext"))
+
+		entries, err := quarantine.Read(strings.NewReader(string(content)))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(3))
+		Expect(entries[0].Timestamp).NotTo(BeEmpty())
+		entries[0].Timestamp = ""
+		Expect(entries[0]).To(Equal(quarantine.Entry{
+			RunID:              "run-1",
+			Target:             "uaa-east",
+			UserID:             "user-1",
+			ZoneID:             "uaa",
+			MfaProviderID:      "provider",
+			EncryptionKeyLabel: "deleted-key",
+			Category:           "unknown_key",
+			Error:              "unable to find key: deleted-key",
+		}))
+		Expect(entries[1].RunID).To(Equal("run-2"))
+
+		Expect(quarantine.UserIDs(entries)).To(Equal([]string{"user-1", "user-2"}))
+	})
+
+	It("should group the user ids of each target", func() {
+		entries := []quarantine.Entry{
+			{Target: "uaa-east", UserID: "user-1"},
+			{Target: "uaa-west", UserID: "user-2"},
+			{Target: "uaa-east", UserID: "user-3"},
+			{Target: "uaa-west", UserID: "user-1"},
+			{Target: "uaa-east", UserID: "user-1"},
+		}
+		Expect(quarantine.UserIDsByTarget(entries)).To(Equal(map[string][]string{
+			"uaa-east": {"user-1", "user-3"},
+			"uaa-west": {"user-2", "user-1"},
+		}))
+	})
+
+	It("should reject malformed lines", func() {
+		_, err := quarantine.Read(strings.NewReader("{\"user_id\": \"user-1\"}\n\nnot json\n"))
+		Expect(err).To(MatchError(ContainSubstring("quarantine line 3: malformed entry")))
+
+		_, err = quarantine.Read(strings.NewReader("{\"category\": \"other\"}\n"))
+		Expect(err).To(MatchError("quarantine line 1: user_id is missing"))
+	})
+})
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"code.cloudfoundry.org/lager"
+	"context"
+	"flag"
+	"fmt"
+	"github.com/cloudfoundry/uaa-key-rotator/config"
+	"github.com/cloudfoundry/uaa-key-rotator/logging"
+	"github.com/cloudfoundry/uaa-key-rotator/runner"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// restoreCommand implements "restore", which writes the original values of
+// backed up rows back to the database.
+func restoreCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := flags.String("config", "", "Path to uaa key rotator config file")
+	var options runner.RestoreOptions
+	flags.StringVar(&options.File, "file", "", "Path to the backup file (defaults to backup.file in the config)")
+	flags.Var((*stringSliceFlag)(&options.Filter.RunIDs), "run-id", "Only restore rows backed up by this run (repeatable)")
+	flags.Var((*stringSliceFlag)(&options.Filter.ZoneIDs), "zone-id", "Only restore rows in this identity zone (repeatable)")
+	flags.Var((*stringSliceFlag)(&options.Filter.Targets), "target", "Only restore rows of this target (repeatable)")
+	flags.BoolVar(&options.DryRun, "dry-run", false, "Log the rows that would be restored without writing anything")
+	flags.DurationVar(&options.WaitForLock, "wait-for-lock", 0, "How long to wait for a rotator to finish before giving up (0 to fail at once)")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	configFile, err := os.Open(*configPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to open config: %s\n", err)
+		return 1
+	}
+	defer configFile.Close()
+
+	rotatorConfig, err := config.New(configFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to parse config: %s\n", err)
+		return 1
+	}
+
+	redactor := &logging.Redactor{
+		HashUserIDs: rotatorConfig.Logging.HashUserIDs,
+		Secrets:     []string{rotatorConfig.DatabasePassword, rotatorConfig.KeyProvider.Token, rotatorConfig.Audit.HMACKey, rotatorConfig.Backup.Key},
+	}
+	for _, target := range rotatorConfig.Targets {
+		redactor.Secrets = append(redactor.Secrets, target.DatabasePassword)
+	}
+	logSink, err := logging.NewSink(rotatorConfig.Logging)
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to configure logging: %s\n", err)
+		return 1
+	}
+	defer logSink.Close()
+	logger := lager.NewLogger(fmt.Sprintf("%s.%s", "rotator", "uaa-key-rotator"))
+	logger.RegisterSink(logging.NewRedactingSink(logSink, redactor))
+
+	options.Config = rotatorConfig
+	options.Logger = logger
+	options.Redactor = redactor
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		<-sigChan
+		cancel()
+	}()
+
+	stats, err := runner.Restore(ctx, options)
+	fmt.Fprintf(stdout, "restored %d rows, %d already held their original values, %d had changed since they were rotated, %d failed\n", stats.Restored, stats.Unchanged, stats.Conflicts, stats.Failed)
+	if options.DryRun {
+		fmt.Fprintf(stdout, "dry run: %d rows would be restored\n", stats.Previewed)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "restore FAILED: %s\n", err)
+		return 1
+	}
+	return 0
+}
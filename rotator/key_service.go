@@ -1,54 +1,71 @@
 package rotator
 
 import (
+	"context"
 	"fmt"
-	"github.com/cloudfoundry/uaa-key-rotator/config"
 	"github.com/cloudfoundry/uaa-key-rotator/crypto"
+	"github.com/cloudfoundry/uaa-key-rotator/keyprovider"
 	"github.com/pkg/errors"
 )
 
 type UaaKeyService struct {
 	ActiveKeyLabel string
-	EncryptionKeys []config.EncryptionKey
+	KeyProvider    keyprovider.KeyProvider
 }
 
 var _ KeyService = UaaKeyService{}
 
-func (s UaaKeyService) Key(keyLabel string) (crypto.Decryptor, error) {
-	var key config.EncryptionKey
-	var found bool
+// ErrUnknownKey matches every UnknownKeyError with errors.Is.
+var ErrUnknownKey = errors.New("unknown key")
 
-	if found, key = s.getEncryptionKey(keyLabel); !found {
-		return crypto.UAADecryptor{}, errors.New(fmt.Sprintf("unable to find key: %s", keyLabel))
+// UnknownKeyError is returned for a key label that the key provider does not
+// have. It unwraps to keyprovider.ErrKeyNotFound.
+type UnknownKeyError struct {
+	Label  string
+	Active bool
+}
 
+func (e UnknownKeyError) Error() string {
+	if e.Active {
+		return fmt.Sprintf("unable to find active key: %s", e.Label)
 	}
+	return fmt.Sprintf("unable to find key: %s", e.Label)
+}
 
-	return crypto.UAADecryptor{
-		Passphrase: string(key.Passphrase),
-	}, nil
+func (e UnknownKeyError) Is(target error) bool {
+	return target == ErrUnknownKey
 }
 
-func (s UaaKeyService) ActiveKey() (string, crypto.Encryptor, error) {
-	var key config.EncryptionKey
-	var found bool
+func (e UnknownKeyError) Unwrap() error {
+	return keyprovider.ErrKeyNotFound
+}
 
-	if found, key = s.getEncryptionKey(s.ActiveKeyLabel); !found {
-		return "", nil, errors.New(fmt.Sprintf("unable to find active key: %s", s.ActiveKeyLabel))
+func (s UaaKeyService) Key(ctx context.Context, keyLabel string) (crypto.Decryptor, error) {
+	passphrase, err := s.KeyProvider.Passphrase(ctx, keyLabel)
+	if err != nil {
+		if errors.Is(err, keyprovider.ErrKeyNotFound) {
+			return crypto.UAADecryptor{}, UnknownKeyError{Label: keyLabel}
+		}
+		return crypto.UAADecryptor{}, errors.Wrap(err, fmt.Sprintf("unable to fetch key: %s", keyLabel))
 	}
 
-	return s.ActiveKeyLabel, crypto.UAAEncryptor{
-		Passphrase:     string(key.Passphrase),
-		SaltGenerator:  crypto.UaaSaltGenerator{},
-		NonceGenerator: crypto.UaaNonceGenerator{},
+	return crypto.UAADecryptor{
+		Passphrase: passphrase,
 	}, nil
 }
 
-func (s UaaKeyService) getEncryptionKey(label string) (bool, config.EncryptionKey) {
-	for _, key := range s.EncryptionKeys {
-		if key.Label == label {
-			return true, key
+func (s UaaKeyService) ActiveKey(ctx context.Context) (string, crypto.Encryptor, error) {
+	passphrase, err := s.KeyProvider.Passphrase(ctx, s.ActiveKeyLabel)
+	if err != nil {
+		if errors.Is(err, keyprovider.ErrKeyNotFound) {
+			return "", nil, UnknownKeyError{Label: s.ActiveKeyLabel, Active: true}
 		}
+		return "", nil, errors.Wrap(err, fmt.Sprintf("unable to fetch active key: %s", s.ActiveKeyLabel))
 	}
 
-	return false, config.EncryptionKey{}
+	return s.ActiveKeyLabel, crypto.UAAEncryptor{
+		Passphrase:     passphrase,
+		SaltGenerator:  crypto.UaaSaltGenerator{},
+		NonceGenerator: crypto.UaaNonceGenerator{},
+	}, nil
 }
@@ -1,7 +1,12 @@
 package rotator_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/cloudfoundry/uaa-key-rotator/config"
+	"github.com/cloudfoundry/uaa-key-rotator/keyprovider"
+	"github.com/cloudfoundry/uaa-key-rotator/keyprovider/keyproviderfakes"
 	"github.com/cloudfoundry/uaa-key-rotator/rotator"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -14,25 +19,27 @@ var _ = Describe("KeyService", func() {
 	BeforeEach(func() {
 		uaaKeyService = rotator.UaaKeyService{
 			ActiveKeyLabel: "active-key-label",
-			EncryptionKeys: []config.EncryptionKey{
-				{Label: "active-key-label", Passphrase: "passphrase1"},
-				{Label: "key-2", Passphrase: "passphrase2"},
+			KeyProvider: keyprovider.StaticKeyProvider{
+				EncryptionKeys: []config.EncryptionKey{
+					{Label: "active-key-label", Passphrase: config.Passphrase("passphrase1")},
+					{Label: "key-2", Passphrase: config.Passphrase("passphrase2")},
+				},
 			},
 		}
 	})
 
 	It("should return the correct active key", func() {
-		activeKeyLabel, _, err := uaaKeyService.ActiveKey()
+		activeKeyLabel, _, err := uaaKeyService.ActiveKey(context.Background())
 		Expect(err).NotTo(HaveOccurred())
 		Expect(activeKeyLabel).To(Equal(activeKeyLabel))
 	})
 
 	It("should be the identity to encrypt and then decrypt", func() {
 		plainText := "some random plain text"
-		activeKeyLabel, activeKeyEncryptor, err := uaaKeyService.ActiveKey()
+		activeKeyLabel, activeKeyEncryptor, err := uaaKeyService.ActiveKey(context.Background())
 		Expect(err).NotTo(HaveOccurred())
 
-		activeKeyDecryptor, err := uaaKeyService.Key(activeKeyLabel)
+		activeKeyDecryptor, err := uaaKeyService.Key(context.Background(), activeKeyLabel)
 		Expect(err).NotTo(HaveOccurred())
 
 		encryptedValue, err := activeKeyEncryptor.Encrypt(plainText)
@@ -47,10 +54,10 @@ var _ = Describe("KeyService", func() {
 	Context("when encrypting / decrypting with different keys", func() {
 		It("should return a meaningful error", func() {
 			plainText := "some random plain text"
-			_, activeKeyEncryptor, err := uaaKeyService.ActiveKey()
+			_, activeKeyEncryptor, err := uaaKeyService.ActiveKey(context.Background())
 			Expect(err).NotTo(HaveOccurred())
 
-			activeKeyDecryptor, err := uaaKeyService.Key("key-2")
+			activeKeyDecryptor, err := uaaKeyService.Key(context.Background(), "key-2")
 			Expect(err).NotTo(HaveOccurred())
 
 			encryptedValue, err := activeKeyEncryptor.Encrypt(plainText)
@@ -64,9 +71,49 @@ var _ = Describe("KeyService", func() {
 
 	Context("when asking for a key that does not exist", func() {
 		It("should return a meaningful error", func() {
-			_, err := uaaKeyService.Key("key-does-not-exist")
+			_, err := uaaKeyService.Key(context.Background(), "key-does-not-exist")
 			Expect(err).To(HaveOccurred())
 			Expect(err).To(MatchError("unable to find key: key-does-not-exist"))
+			Expect(errors.Is(fmt.Errorf("unable to rotate: %w", err), rotator.ErrUnknownKey)).To(BeTrue())
+			Expect(errors.Is(err, keyprovider.ErrKeyNotFound)).To(BeTrue())
+		})
+	})
+
+	Context("when the key provider fails", func() {
+		BeforeEach(func() {
+			fakeKeyProvider := &keyproviderfakes.FakeKeyProvider{}
+			fakeKeyProvider.PassphraseReturns(nil, errors.New("credhub is unavailable"))
+			uaaKeyService.KeyProvider = fakeKeyProvider
+		})
+
+		It("should return a meaningful error", func() {
+			_, err := uaaKeyService.Key(context.Background(), "key-2")
+			Expect(err).To(MatchError("unable to fetch key: key-2: credhub is unavailable"))
+			Expect(errors.Is(err, rotator.ErrUnknownKey)).To(BeFalse())
+
+			_, _, err = uaaKeyService.ActiveKey(context.Background())
+			Expect(err).To(MatchError("unable to fetch active key: active-key-label: credhub is unavailable"))
+		})
+	})
+
+	Context("when the key provider resolves labels lazily", func() {
+		var fakeKeyProvider *keyproviderfakes.FakeKeyProvider
+
+		BeforeEach(func() {
+			fakeKeyProvider = &keyproviderfakes.FakeKeyProvider{}
+			fakeKeyProvider.PassphraseReturns([]byte("passphrase"), nil)
+			uaaKeyService.KeyProvider = fakeKeyProvider
+		})
+
+		It("should pass the context and label through", func() {
+			ctx := context.WithValue(context.Background(), "some-key", "some-value")
+			_, err := uaaKeyService.Key(ctx, "key-2")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeKeyProvider.PassphraseCallCount()).To(Equal(1))
+			passedCtx, label := fakeKeyProvider.PassphraseArgsForCall(0)
+			Expect(passedCtx).To(Equal(ctx))
+			Expect(label).To(Equal("key-2"))
 		})
 	})
 
@@ -74,9 +121,13 @@ var _ = Describe("KeyService", func() {
 		It("should return a meaningful error", func() {
 			missingActiveKey := "active-key-does-not-exist" + time.Now().String()
 			uaaKeyService.ActiveKeyLabel = missingActiveKey
-			_, _, err := uaaKeyService.ActiveKey()
+			_, _, err := uaaKeyService.ActiveKey(context.Background())
 			Expect(err).To(HaveOccurred())
 			Expect(err).To(MatchError("unable to find active key: " + missingActiveKey))
+
+			var unknownKey rotator.UnknownKeyError
+			Expect(errors.As(err, &unknownKey)).To(BeTrue())
+			Expect(unknownKey).To(Equal(rotator.UnknownKeyError{Label: missingActiveKey, Active: true}))
 		})
 	})
 })
@@ -0,0 +1,216 @@
+package rotator
+
+import (
+	"context"
+	"database/sql"
+	"github.com/cloudfoundry/uaa-key-rotator/crypto"
+	"github.com/cloudfoundry/uaa-key-rotator/entity"
+	"github.com/pkg/errors"
+	"strconv"
+)
+
+// ErrAlreadyEncrypted is the cause of the error returned when a row without
+// a key label holds a value that is already a valid envelope. Encrypting it
+// a second time would leave it unreadable by UAA, so the row is not migrated.
+var ErrAlreadyEncrypted = errors.New("value is already encrypted")
+
+const encryptedValidationCodeColumn = "encrypted_validation_code"
+
+// MigrationReport lists what Migrate changed in a credential.
+type MigrationReport struct {
+	// Encrypted names the columns that held plaintext.
+	Encrypted []string `json:"encrypted,omitempty"`
+	// Rotated names the columns re-encrypted from another key.
+	Rotated []string `json:"rotated,omitempty"`
+	// ValidationCodeCleared is set when validation_code was encrypted into
+	// encrypted_validation_code and then cleared.
+	ValidationCodeCleared bool `json:"validation_code_cleared,omitempty"`
+}
+
+// Migrate encrypts a legacy credential with the active key. A row with an
+// empty encryption_key_label was never encrypted, so each of its columns is
+// encrypted as it stands; any other row is rotated as by Rotate. When
+// migrateValidationCode is set, a plaintext validation_code is also
+// encrypted into an empty encrypted_validation_code and cleared. Every new
+// value is verified as by Rotate.
+func (r UAARotator) Migrate(ctx context.Context, credential entity.MfaCredential, migrateValidationCode bool) (entity.MfaCredential, MigrationReport, error) {
+	report := MigrationReport{}
+
+	activeKeyLabel, encryptor, err := r.KeyService.ActiveKey(ctx)
+	if err != nil {
+		return entity.MfaCredential{}, report, errors.Wrap(err, "Unable to migrate mfa record")
+	}
+	defer wipe(encryptor)
+
+	keys := &trialKeys{keyService: r.KeyService, decryptors: map[string]crypto.Decryptor{}, errors: map[string]error{}}
+	defer keys.wipe()
+
+	plaintexts, err := r.migrationPlaintexts(ctx, keys, credential, migrateValidationCode)
+	if err != nil {
+		return entity.MfaCredential{}, report, err
+	}
+	defer zeroAll(plaintexts)
+
+	columns := cipherColumns(&credential)
+	rotatedValues := make([]rotatedValue, 0, len(columns))
+	defer func() {
+		for _, rotated := range rotatedValues {
+			crypto.Zero(rotated.plaintext)
+		}
+	}()
+
+	for i := range columns {
+		if plaintexts[i] == nil {
+			rotatedValues = append(rotatedValues, rotatedValue{unchanged: true})
+			continue
+		}
+
+		plaintext := plaintexts[i]
+		plaintexts[i] = nil
+		rotated, err := r.encryptValue(encryptor, plaintext)
+		if err != nil {
+			return entity.MfaCredential{}, report, err
+		}
+		rotatedValues = append(rotatedValues, rotated)
+	}
+
+	activeDecryptor, err := keys.get(ctx, activeKeyLabel)
+	if err != nil {
+		return entity.MfaCredential{}, report, errors.Wrap(err, "Unable to verify mfa record")
+	}
+	if err = r.verifyRotatedValues(activeDecryptor, columns, rotatedValues); err != nil {
+		return entity.MfaCredential{}, report, err
+	}
+
+	for i, column := range columns {
+		switch {
+		case rotatedValues[i].unchanged:
+		case credential.EncryptionKeyLabel == "" || column.empty():
+			report.Encrypted = append(report.Encrypted, column.name)
+		default:
+			report.Rotated = append(report.Rotated, column.name)
+		}
+	}
+
+	setRotatedValues(columns, rotatedValues)
+	if migratesValidationCode(credential, migrateValidationCode) {
+		credential.ValidationCode = sql.NullInt64{}
+		report.ValidationCodeCleared = true
+	}
+	credential.EncryptionKeyLabel = activeKeyLabel
+
+	return credential, report, nil
+}
+
+// VerifyMigrated checks a credential read back after Migrate wrote it: it
+// must be labelled with the active key, each migrated column must decrypt
+// under that key to the plaintext of the original, and everything else must
+// be unchanged.
+func (r UAARotator) VerifyMigrated(ctx context.Context, original entity.MfaCredential, stored entity.MfaCredential, migrateValidationCode bool) error {
+	activeKeyLabel, encryptor, err := r.KeyService.ActiveKey(ctx)
+	if err != nil {
+		return errors.Wrap(err, "Unable to verify mfa record")
+	}
+	wipe(encryptor)
+
+	if stored.EncryptionKeyLabel != activeKeyLabel {
+		return errors.Wrapf(ErrVerificationFailed, "stored row is labelled %s, not the active key %s", stored.EncryptionKeyLabel, activeKeyLabel)
+	}
+
+	keys := &trialKeys{keyService: r.KeyService, decryptors: map[string]crypto.Decryptor{}, errors: map[string]error{}}
+	defer keys.wipe()
+
+	plaintexts, err := r.migrationPlaintexts(ctx, keys, original, migrateValidationCode)
+	if err != nil {
+		return errors.Wrap(err, "Unable to verify mfa record")
+	}
+	defer zeroAll(plaintexts)
+
+	activeDecryptor, err := keys.get(ctx, activeKeyLabel)
+	if err != nil {
+		return errors.Wrap(err, "Unable to verify mfa record")
+	}
+
+	originalColumns := cipherColumns(&original)
+	for i, storedColumn := range cipherColumns(&stored) {
+		if plaintexts[i] == nil {
+			if !storedColumn.equal(originalColumns[i]) {
+				return errors.Wrap(errors.Wrap(ErrVerificationFailed, "stored value should have been left unchanged"), storedColumn.name)
+			}
+			continue
+		}
+
+		if err = r.compareStoredValue(activeDecryptor, *storedColumn.value, plaintexts[i]); err != nil {
+			return errors.Wrap(err, storedColumn.name)
+		}
+	}
+
+	expectedValidationCode := original.ValidationCode
+	if migratesValidationCode(original, migrateValidationCode) {
+		expectedValidationCode = sql.NullInt64{}
+	}
+	if stored.ValidationCode != expectedValidationCode {
+		return errors.Wrap(ErrVerificationFailed, "stored validation_code is not what was written")
+	}
+
+	return nil
+}
+
+// migrationPlaintexts returns the plaintext each encrypted column of
+// credential will hold once migrated, or nil for a column left unchanged.
+// The caller owns the returned buffers and should zero them.
+func (r UAARotator) migrationPlaintexts(ctx context.Context, keys *trialKeys, credential entity.MfaCredential, migrateValidationCode bool) ([][]byte, error) {
+	columns := cipherColumns(&credential)
+	plaintexts := make([][]byte, len(columns))
+
+	for i, column := range columns {
+		plaintext, err := r.migrationPlaintext(ctx, keys, credential, column, migrateValidationCode)
+		if err != nil {
+			zeroAll(plaintexts)
+			return nil, errors.Wrap(err, column.name)
+		}
+		plaintexts[i] = plaintext
+	}
+
+	return plaintexts, nil
+}
+
+func (r UAARotator) migrationPlaintext(ctx context.Context, keys *trialKeys, credential entity.MfaCredential, column cipherColumn, migrateValidationCode bool) ([]byte, error) {
+	if column.name == encryptedValidationCodeColumn && migratesValidationCode(credential, migrateValidationCode) {
+		if !column.empty() {
+			return nil, errors.New("already set, refusing to replace it with validation_code")
+		}
+		return []byte(strconv.FormatInt(credential.ValidationCode.Int64, 10)), nil
+	}
+
+	if column.empty() {
+		return nil, nil
+	}
+
+	if credential.EncryptionKeyLabel == "" {
+		if _, err := r.Codec.Decode(*column.value); err == nil {
+			return nil, ErrAlreadyEncrypted
+		}
+		return []byte(*column.value), nil
+	}
+
+	decryptor, err := keys.get(ctx, credential.EncryptionKeyLabel)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to decrypt mfa record")
+	}
+	encryptedValue, err := r.decode(*column.value)
+	if err != nil {
+		return nil, err
+	}
+	return r.decrypt(decryptor, encryptedValue)
+}
+
+func migratesValidationCode(credential entity.MfaCredential, migrateValidationCode bool) bool {
+	return migrateValidationCode && credential.ValidationCode.Valid
+}
+
+func zeroAll(buffers [][]byte) {
+	for _, buffer := range buffers {
+		crypto.Zero(buffer)
+	}
+}
@@ -0,0 +1,136 @@
+package rotator_test
+
+import (
+	"context"
+	"database/sql"
+	"github.com/cloudfoundry/uaa-key-rotator/config"
+	"github.com/cloudfoundry/uaa-key-rotator/crypto"
+	"github.com/cloudfoundry/uaa-key-rotator/entity"
+	"github.com/cloudfoundry/uaa-key-rotator/keyprovider"
+	"github.com/cloudfoundry/uaa-key-rotator/rotator"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+var _ = Describe("UAARotator Migrate", func() {
+	var (
+		keyService            rotator.UaaKeyService
+		uaaRotator            rotator.UAARotator
+		credential            entity.MfaCredential
+		migrateValidationCode bool
+		migrated              entity.MfaCredential
+		report                rotator.MigrationReport
+		migrateError          error
+		activeDecryptor       crypto.Decryptor
+		oldKeyEncryptor       crypto.Encryptor
+	)
+
+	BeforeEach(func() {
+		keyService = rotator.UaaKeyService{
+			ActiveKeyLabel: "new-key",
+			KeyProvider: keyprovider.StaticKeyProvider{
+				EncryptionKeys: []config.EncryptionKey{
+					{Label: "old-key", Passphrase: config.Passphrase("old-passphrase")},
+					{Label: "new-key", Passphrase: config.Passphrase("new-passphrase")},
+				},
+			},
+		}
+		uaaRotator = rotator.UAARotator{KeyService: keyService, Codec: crypto.UAAEnvelopeCodec{}}
+		migrateValidationCode = false
+
+		credential = entity.MfaCredential{
+			UserId:         "some-user-id",
+			ScratchCodes:   sql.NullString{String: "12345678,23456789", Valid: true},
+			SecretKey:      "JBSWY3DPEHPK3PXP",
+			ValidationCode: sql.NullInt64{Int64: 123456, Valid: true},
+		}
+
+		var err error
+		activeDecryptor, err = rotator.UaaKeyService{KeyProvider: keyService.KeyProvider}.Key(context.Background(), "new-key")
+		Expect(err).NotTo(HaveOccurred())
+		_, oldKeyEncryptor, err = rotator.UaaKeyService{ActiveKeyLabel: "old-key", KeyProvider: keyService.KeyProvider}.ActiveKey(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	JustBeforeEach(func() {
+		migrated, report, migrateError = uaaRotator.Migrate(context.Background(), credential, migrateValidationCode)
+	})
+
+	It("should encrypt the plaintext columns of an unlabelled row with the active key", func() {
+		Expect(migrateError).NotTo(HaveOccurred())
+		Expect(migrated.EncryptionKeyLabel).To(Equal("new-key"))
+		Expect(decodeAndDecrypt(activeDecryptor, migrated.ScratchCodes.String)).To(Equal("12345678,23456789"))
+		Expect(decodeAndDecrypt(activeDecryptor, migrated.SecretKey)).To(Equal("JBSWY3DPEHPK3PXP"))
+		Expect(migrated.EncryptedValidationCode).To(Equal(sql.NullString{}))
+		Expect(migrated.ValidationCode).To(Equal(credential.ValidationCode))
+		Expect(report).To(Equal(rotator.MigrationReport{Encrypted: []string{"scratch_codes", "secret_key"}}))
+	})
+
+	It("should verify once written", func() {
+		Expect(uaaRotator.VerifyMigrated(context.Background(), credential, migrated, migrateValidationCode)).To(Succeed())
+	})
+
+	Context("when an unlabelled row holds a value that is already encrypted", func() {
+		BeforeEach(func() {
+			credential.SecretKey = encryptAndEncode(oldKeyEncryptor, "secret-key")
+		})
+
+		It("should refuse to encrypt it again", func() {
+			Expect(migrateError).To(MatchError("secret_key: value is already encrypted"))
+			Expect(errors.Cause(migrateError)).To(Equal(rotator.ErrAlreadyEncrypted))
+			Expect(migrated).To(Equal(entity.MfaCredential{}))
+		})
+	})
+
+	Context("when migrating the validation code", func() {
+		BeforeEach(func() {
+			migrateValidationCode = true
+		})
+
+		It("should encrypt it into encrypted_validation_code and clear it", func() {
+			Expect(migrateError).NotTo(HaveOccurred())
+			Expect(migrated.EncryptedValidationCode.Valid).To(BeTrue())
+			Expect(decodeAndDecrypt(activeDecryptor, migrated.EncryptedValidationCode.String)).To(Equal("123456"))
+			Expect(migrated.ValidationCode).To(Equal(sql.NullInt64{}))
+			Expect(report.Encrypted).To(ConsistOf("scratch_codes", "secret_key", "encrypted_validation_code"))
+			Expect(report.ValidationCodeCleared).To(BeTrue())
+		})
+
+		It("should verify once written", func() {
+			Expect(uaaRotator.VerifyMigrated(context.Background(), credential, migrated, migrateValidationCode)).To(Succeed())
+
+			migrated.ValidationCode = credential.ValidationCode
+			err := uaaRotator.VerifyMigrated(context.Background(), credential, migrated, migrateValidationCode)
+			Expect(err).To(MatchError("stored validation_code is not what was written: round trip verification failed"))
+		})
+
+		Context("when the row is already encrypted with another key", func() {
+			BeforeEach(func() {
+				credential.EncryptionKeyLabel = "old-key"
+				credential.ScratchCodes = sql.NullString{String: encryptAndEncode(oldKeyEncryptor, "scratch-codes"), Valid: true}
+				credential.SecretKey = encryptAndEncode(oldKeyEncryptor, "secret-key")
+			})
+
+			It("should rotate the encrypted columns as well", func() {
+				Expect(migrateError).NotTo(HaveOccurred())
+				Expect(migrated.EncryptionKeyLabel).To(Equal("new-key"))
+				Expect(decodeAndDecrypt(activeDecryptor, migrated.SecretKey)).To(Equal("secret-key"))
+				Expect(decodeAndDecrypt(activeDecryptor, migrated.EncryptedValidationCode.String)).To(Equal("123456"))
+				Expect(report.Rotated).To(Equal([]string{"scratch_codes", "secret_key"}))
+				Expect(report.Encrypted).To(Equal([]string{"encrypted_validation_code"}))
+				Expect(uaaRotator.VerifyMigrated(context.Background(), credential, migrated, migrateValidationCode)).To(Succeed())
+			})
+		})
+
+		Context("when encrypted_validation_code is already set", func() {
+			BeforeEach(func() {
+				credential.EncryptedValidationCode = sql.NullString{String: "654321", Valid: true}
+			})
+
+			It("should refuse to replace it", func() {
+				Expect(migrateError).To(MatchError("encrypted_validation_code: already set, refusing to replace it with validation_code"))
+			})
+		})
+	})
+})
@@ -0,0 +1,223 @@
+package rotator
+
+import (
+	"context"
+	"fmt"
+	"github.com/cloudfoundry/uaa-key-rotator/crypto"
+	"github.com/cloudfoundry/uaa-key-rotator/entity"
+	"github.com/pkg/errors"
+)
+
+const (
+	KeyDecrypted            = "decrypted"
+	KeyAuthenticationFailed = "authentication_failed"
+	KeyUnavailable          = "key_unavailable"
+	KeyDecryptFailed        = "decrypt_failed"
+)
+
+type KeyAttempt struct {
+	Label   string `json:"label"`
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ColumnRecovery records every key tried against one column and which of
+// them, if any, decrypted it.
+type ColumnRecovery struct {
+	Column        string       `json:"column"`
+	DecryptedWith string       `json:"decrypted_with,omitempty"`
+	Empty         bool         `json:"empty,omitempty"`
+	Malformed     bool         `json:"malformed,omitempty"`
+	Attempts      []KeyAttempt `json:"attempts"`
+}
+
+type RecoveryReport struct {
+	Columns []ColumnRecovery `json:"columns"`
+	// AlreadyActive is set when the row is labelled with the active key and
+	// every column decrypts with it, so there is nothing to recover and the
+	// row should be left alone.
+	AlreadyActive bool `json:"already_active,omitempty"`
+}
+
+// DecryptedWith maps each column to the label of the key that decrypted it.
+func (r RecoveryReport) DecryptedWith() map[string]string {
+	decryptedWith := map[string]string{}
+	for _, column := range r.Columns {
+		if column.DecryptedWith != "" {
+			decryptedWith[column.Column] = column.DecryptedWith
+		}
+	}
+	return decryptedWith
+}
+
+// Mislabelled reports whether any column was decrypted by a key other than
+// the one the row is labelled with.
+func (r RecoveryReport) Mislabelled(label string) bool {
+	for _, column := range r.Columns {
+		if column.DecryptedWith != "" && column.DecryptedWith != label {
+			return true
+		}
+	}
+	return false
+}
+
+// Recover rotates a credential whose columns may not be encrypted under the
+// key its label names. Each column is tried against the labelled key first
+// and then against each of trialKeyLabels, and is rotated with whichever
+// key decrypts it. Columns that are not valid envelopes are reported as
+// malformed without trying any keys, and NULL or empty columns are reported
+// as empty and left unchanged. A row labelled with the active key whose
+// columns all decrypt with it is returned unchanged, with AlreadyActive set.
+func (r UAARotator) Recover(ctx context.Context, credential entity.MfaCredential, trialKeyLabels []string) (entity.MfaCredential, RecoveryReport, error) {
+	report := RecoveryReport{}
+
+	activeKeyLabel, encryptor, err := r.KeyService.ActiveKey(ctx)
+	if err != nil {
+		return entity.MfaCredential{}, report, errors.Wrap(err, "Unable to decrypt mfa record")
+	}
+	defer wipe(encryptor)
+
+	keys := &trialKeys{keyService: r.KeyService, decryptors: map[string]crypto.Decryptor{}, errors: map[string]error{}}
+	defer keys.wipe()
+
+	labels := uniqueLabels(append([]string{credential.EncryptionKeyLabel}, trialKeyLabels...))
+
+	columns := cipherColumns(&credential)
+	rotatedValues := make([]rotatedValue, 0, len(columns))
+	defer func() {
+		for _, rotated := range rotatedValues {
+			crypto.Zero(rotated.plaintext)
+		}
+	}()
+
+	for _, column := range columns {
+		if column.empty() {
+			report.Columns = append(report.Columns, ColumnRecovery{Column: column.name, Empty: true, Attempts: []KeyAttempt{}})
+			rotatedValues = append(rotatedValues, rotatedValue{unchanged: true})
+			continue
+		}
+
+		columnRecovery, plaintext, err := r.trialDecrypt(ctx, keys, labels, column)
+		report.Columns = append(report.Columns, columnRecovery)
+		if err != nil {
+			return entity.MfaCredential{}, report, errors.Wrap(err, column.name)
+		}
+
+		rotated, err := r.encryptValue(encryptor, plaintext)
+		if err != nil {
+			return entity.MfaCredential{}, report, err
+		}
+		rotatedValues = append(rotatedValues, rotated)
+	}
+
+	if credential.EncryptionKeyLabel == activeKeyLabel && !report.Mislabelled(activeKeyLabel) {
+		report.AlreadyActive = true
+		return credential, report, nil
+	}
+
+	activeDecryptor, err := keys.get(ctx, activeKeyLabel)
+	if err != nil {
+		return entity.MfaCredential{}, report, errors.Wrap(err, "Unable to verify mfa record")
+	}
+	if err = r.verifyRotatedValues(activeDecryptor, columns, rotatedValues); err != nil {
+		return entity.MfaCredential{}, report, err
+	}
+
+	setRotatedValues(columns, rotatedValues)
+	credential.EncryptionKeyLabel = activeKeyLabel
+
+	return credential, report, nil
+}
+
+func (r UAARotator) trialDecrypt(ctx context.Context, keys *trialKeys, labels []string, column cipherColumn) (ColumnRecovery, []byte, error) {
+	columnRecovery := ColumnRecovery{Column: column.name, Attempts: []KeyAttempt{}}
+
+	encryptedValue, err := r.decode(*column.value)
+	if err != nil {
+		columnRecovery.Malformed = true
+		return columnRecovery, nil, err
+	}
+
+	for _, label := range labels {
+		decryptor, err := keys.get(ctx, label)
+		if err != nil {
+			columnRecovery.Attempts = append(columnRecovery.Attempts, KeyAttempt{Label: label, Outcome: KeyUnavailable, Error: err.Error()})
+			continue
+		}
+
+		plaintext, err := decryptor.DecryptBytes(encryptedValue)
+		switch {
+		case err == nil:
+			columnRecovery.Attempts = append(columnRecovery.Attempts, KeyAttempt{Label: label, Outcome: KeyDecrypted})
+			columnRecovery.DecryptedWith = label
+			return columnRecovery, plaintext, nil
+		case crypto.IsMalformedEnvelope(err):
+			columnRecovery.Malformed = true
+			return columnRecovery, nil, errors.Wrap(err, "unable to decrypt cipher value provided")
+		case crypto.IsAuthenticationFailure(err):
+			columnRecovery.Attempts = append(columnRecovery.Attempts, KeyAttempt{Label: label, Outcome: KeyAuthenticationFailed})
+		default:
+			columnRecovery.Attempts = append(columnRecovery.Attempts, KeyAttempt{Label: label, Outcome: KeyDecryptFailed, Error: err.Error()})
+		}
+	}
+
+	return columnRecovery, nil, undecryptableError{labels: labels}
+}
+
+// undecryptableError is returned when none of the keys tried decrypts a
+// value. It matches crypto.ErrAuthenticationFailed with errors.Is.
+type undecryptableError struct {
+	labels []string
+}
+
+func (e undecryptableError) Error() string {
+	return fmt.Sprintf("no key decrypts this value, tried %v", e.labels)
+}
+
+func (e undecryptableError) Is(target error) bool {
+	return target == crypto.ErrAuthenticationFailed
+}
+
+// trialKeys fetches each key at most once per record and wipes them all
+// once the record is done.
+type trialKeys struct {
+	keyService KeyService
+	decryptors map[string]crypto.Decryptor
+	errors     map[string]error
+}
+
+func (k *trialKeys) get(ctx context.Context, label string) (crypto.Decryptor, error) {
+	if decryptor, ok := k.decryptors[label]; ok {
+		return decryptor, nil
+	}
+	if err, ok := k.errors[label]; ok {
+		return nil, err
+	}
+
+	decryptor, err := k.keyService.Key(ctx, label)
+	if err != nil {
+		k.errors[label] = err
+		return nil, err
+	}
+	k.decryptors[label] = decryptor
+	return decryptor, nil
+}
+
+func (k *trialKeys) wipe() {
+	for _, decryptor := range k.decryptors {
+		wipe(decryptor)
+	}
+}
+
+func uniqueLabels(labels []string) []string {
+	seen := map[string]bool{}
+	var unique []string
+	for _, label := range labels {
+		if label == "" || seen[label] {
+			continue
+		}
+		seen[label] = true
+		unique = append(unique, label)
+	}
+	return unique
+}
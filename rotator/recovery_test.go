@@ -0,0 +1,227 @@
+package rotator_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/cloudfoundry/uaa-key-rotator/config"
+	"github.com/cloudfoundry/uaa-key-rotator/crypto"
+	"github.com/cloudfoundry/uaa-key-rotator/entity"
+	"github.com/cloudfoundry/uaa-key-rotator/keyprovider"
+	"github.com/cloudfoundry/uaa-key-rotator/rotator"
+	"github.com/cloudfoundry/uaa-key-rotator/rotator/rotatorfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("UAARotator Recover", func() {
+	var (
+		keyService      rotator.UaaKeyService
+		uaaRotator      rotator.UAARotator
+		credential      entity.MfaCredential
+		trialKeyLabels  []string
+		recovered       entity.MfaCredential
+		report          rotator.RecoveryReport
+		recoverError    error
+		activeDecryptor crypto.Decryptor
+	)
+
+	encryptorFor := func(label string) crypto.Encryptor {
+		_, encryptor, err := rotator.UaaKeyService{ActiveKeyLabel: label, KeyProvider: keyService.KeyProvider}.ActiveKey(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		return encryptor
+	}
+
+	BeforeEach(func() {
+		keyService = rotator.UaaKeyService{
+			ActiveKeyLabel: "new-key",
+			KeyProvider: keyprovider.StaticKeyProvider{
+				EncryptionKeys: []config.EncryptionKey{
+					{Label: "old-key", Passphrase: config.Passphrase("old-passphrase")},
+					{Label: "other-key", Passphrase: config.Passphrase("other-passphrase")},
+					{Label: "new-key", Passphrase: config.Passphrase("new-passphrase")},
+				},
+			},
+		}
+		uaaRotator = rotator.UAARotator{KeyService: keyService, Codec: crypto.UAAEnvelopeCodec{}}
+		trialKeyLabels = []string{"new-key", "old-key", "other-key"}
+
+		credential = entity.MfaCredential{
+			UserId:                  "some-user-id",
+			EncryptionKeyLabel:      "old-key",
+			ScratchCodes:            sql.NullString{String: encryptAndEncode(encryptorFor("old-key"), "scratch-codes"), Valid: true},
+			SecretKey:               encryptAndEncode(encryptorFor("other-key"), "secret-key"),
+			EncryptedValidationCode: sql.NullString{String: encryptAndEncode(encryptorFor("old-key"), "validation-code"), Valid: true},
+		}
+
+		var err error
+		activeDecryptor, err = rotator.UaaKeyService{KeyProvider: keyService.KeyProvider}.Key(context.Background(), "new-key")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	JustBeforeEach(func() {
+		recovered, report, recoverError = uaaRotator.Recover(context.Background(), credential, trialKeyLabels)
+	})
+
+	It("should rotate each column with whichever key decrypts it", func() {
+		Expect(recoverError).NotTo(HaveOccurred())
+		Expect(recovered.EncryptionKeyLabel).To(Equal("new-key"))
+		Expect(decodeAndDecrypt(activeDecryptor, recovered.ScratchCodes.String)).To(Equal("scratch-codes"))
+		Expect(decodeAndDecrypt(activeDecryptor, recovered.SecretKey)).To(Equal("secret-key"))
+		Expect(decodeAndDecrypt(activeDecryptor, recovered.EncryptedValidationCode.String)).To(Equal("validation-code"))
+	})
+
+	It("should report which key decrypted each column", func() {
+		Expect(report.DecryptedWith()).To(Equal(map[string]string{
+			"scratch_codes":             "old-key",
+			"secret_key":                "other-key",
+			"encrypted_validation_code": "old-key",
+		}))
+		Expect(report.Mislabelled("old-key")).To(BeTrue())
+
+		Expect(report.Columns[1]).To(Equal(rotator.ColumnRecovery{
+			Column:        "secret_key",
+			DecryptedWith: "other-key",
+			Attempts: []rotator.KeyAttempt{
+				{Label: "old-key", Outcome: rotator.KeyAuthenticationFailed},
+				{Label: "new-key", Outcome: rotator.KeyAuthenticationFailed},
+				{Label: "other-key", Outcome: rotator.KeyDecrypted},
+			},
+		}))
+	})
+
+	It("should verify the recovered row against the keys that decrypted it", func() {
+		Expect(recoverError).NotTo(HaveOccurred())
+		Expect(uaaRotator.VerifyStoredWith(context.Background(), credential, recovered, report.DecryptedWith())).To(Succeed())
+		Expect(uaaRotator.VerifyStored(context.Background(), credential, recovered)).To(MatchError(ContainSubstring("secret_key")))
+	})
+
+	Context("when the row label names a key that is not configured", func() {
+		BeforeEach(func() {
+			credential.EncryptionKeyLabel = "deleted-key"
+		})
+
+		It("should record the missing key and rotate with the keys that work", func() {
+			Expect(recoverError).NotTo(HaveOccurred())
+			Expect(report.Columns[0].Attempts[0]).To(Equal(rotator.KeyAttempt{
+				Label:   "deleted-key",
+				Outcome: rotator.KeyUnavailable,
+				Error:   "unable to find key: deleted-key",
+			}))
+			Expect(report.Columns[0].DecryptedWith).To(Equal("old-key"))
+		})
+	})
+
+	Context("when the row is labelled with the active key but a column is under the old key", func() {
+		BeforeEach(func() {
+			credential.EncryptionKeyLabel = "new-key"
+			credential.ScratchCodes = sql.NullString{String: encryptAndEncode(encryptorFor("new-key"), "scratch-codes"), Valid: true}
+			credential.SecretKey = encryptAndEncode(encryptorFor("old-key"), "secret-key")
+			credential.EncryptedValidationCode = sql.NullString{}
+		})
+
+		It("should recover the column and rotate it to the active key", func() {
+			Expect(recoverError).NotTo(HaveOccurred())
+			Expect(report.AlreadyActive).To(BeFalse())
+			Expect(report.Mislabelled("new-key")).To(BeTrue())
+			Expect(report.DecryptedWith()).To(Equal(map[string]string{
+				"scratch_codes": "new-key",
+				"secret_key":    "old-key",
+			}))
+			Expect(recovered.EncryptionKeyLabel).To(Equal("new-key"))
+			Expect(decodeAndDecrypt(activeDecryptor, recovered.SecretKey)).To(Equal("secret-key"))
+		})
+	})
+
+	Context("when the row is labelled with the active key and every column decrypts with it", func() {
+		BeforeEach(func() {
+			credential.EncryptionKeyLabel = "new-key"
+			credential.ScratchCodes = sql.NullString{String: encryptAndEncode(encryptorFor("new-key"), "scratch-codes"), Valid: true}
+			credential.SecretKey = encryptAndEncode(encryptorFor("new-key"), "secret-key")
+			credential.EncryptedValidationCode = sql.NullString{}
+		})
+
+		It("should leave the row alone", func() {
+			Expect(recoverError).NotTo(HaveOccurred())
+			Expect(report.AlreadyActive).To(BeTrue())
+			Expect(recovered).To(Equal(credential))
+		})
+	})
+
+	Context("when a column is not a valid envelope", func() {
+		BeforeEach(func() {
+			credential.SecretKey = "bm90IGFuIGVudmVsb3Bl"
+		})
+
+		It("should report it as malformed without trying any keys", func() {
+			Expect(recoverError).To(MatchError("secret_key: Unable to decode mfa credential value: envelope should be at least 60 bytes in length but was 15"))
+			Expect(report.Columns[1].Malformed).To(BeTrue())
+			Expect(report.Columns[1].Attempts).To(BeEmpty())
+		})
+	})
+
+	Context("when a column is NULL", func() {
+		BeforeEach(func() {
+			credential.ScratchCodes = sql.NullString{}
+		})
+
+		It("should report it as empty and leave it unchanged", func() {
+			Expect(recoverError).NotTo(HaveOccurred())
+			Expect(recovered.ScratchCodes).To(Equal(sql.NullString{}))
+			Expect(report.Columns[0]).To(Equal(rotator.ColumnRecovery{Column: "scratch_codes", Empty: true, Attempts: []rotator.KeyAttempt{}}))
+			Expect(report.DecryptedWith()).NotTo(HaveKey("scratch_codes"))
+		})
+	})
+
+	Context("when no configured key decrypts a column", func() {
+		BeforeEach(func() {
+			trialKeyLabels = []string{"new-key"}
+		})
+
+		It("should return an error listing the keys tried", func() {
+			Expect(recoverError).To(MatchError("secret_key: no key decrypts this value, tried [old-key new-key]"))
+			Expect(report.Columns[1].DecryptedWith).To(BeEmpty())
+			Expect(recovered).To(Equal(entity.MfaCredential{}))
+		})
+	})
+
+	Context("when keys are fetched", func() {
+		var fakeKeyService *rotatorfakes.FakeKeyService
+		var issuedKeys []crypto.UAADecryptor
+
+		BeforeEach(func() {
+			issuedKeys = nil
+			fakeKeyService = &rotatorfakes.FakeKeyService{}
+			fakeKeyService.KeyStub = func(ctx context.Context, label string) (crypto.Decryptor, error) {
+				decryptor, err := keyService.Key(ctx, label)
+				if err != nil {
+					return nil, err
+				}
+				issuedKeys = append(issuedKeys, decryptor.(crypto.UAADecryptor))
+				return decryptor, nil
+			}
+			fakeKeyService.ActiveKeyStub = keyService.ActiveKey
+			uaaRotator.KeyService = fakeKeyService
+		})
+
+		It("should fetch each key once and wipe them all", func() {
+			Expect(recoverError).NotTo(HaveOccurred())
+			Expect(fakeKeyService.KeyCallCount()).To(Equal(3))
+			for _, key := range issuedKeys {
+				Expect(key.Passphrase).To(Equal(make([]byte, len(key.Passphrase))))
+			}
+		})
+	})
+
+	Context("when the active key cannot be fetched", func() {
+		BeforeEach(func() {
+			fakeKeyService := &rotatorfakes.FakeKeyService{}
+			fakeKeyService.ActiveKeyReturns("", nil, errors.New("credhub unavailable"))
+			uaaRotator.KeyService = fakeKeyService
+		})
+
+		It("should return a meaningful error", func() {
+			Expect(recoverError).To(MatchError("Unable to decrypt mfa record: credhub unavailable"))
+		})
+	})
+})
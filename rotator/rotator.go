@@ -1,6 +1,8 @@
 package rotator
 
 import (
+	"bytes"
+	"context"
 	"github.com/cloudfoundry/uaa-key-rotator/crypto"
 	"github.com/cloudfoundry/uaa-key-rotator/entity"
 	"github.com/pkg/errors"
@@ -8,141 +10,315 @@ import (
 
 //go:generate counterfeiter . KeyService
 type KeyService interface {
-	Key(keyLabel string) (crypto.Decryptor, error)
-	ActiveKey() (string, crypto.Encryptor, error)
+	Key(ctx context.Context, keyLabel string) (crypto.Decryptor, error)
+	ActiveKey(ctx context.Context) (string, crypto.Encryptor, error)
 }
 
-//go:generate counterfeiter . MapEncryptedValueToDB
-type MapEncryptedValueToDB interface {
-	Map(value crypto.EncryptedValue) ([]byte, error)
-	MapBase64ToCipherValue(value string) ([]byte, error)
-}
+// ErrVerificationFailed is the cause of every error returned when a rotated
+// value does not decrypt back to the original plaintext.
+var ErrVerificationFailed = errors.New("round trip verification failed")
 
 type UAARotator struct {
-	KeyService     KeyService
-	SaltAccessor   crypto.CipherSaltAccessor
-	NonceAccessor  crypto.CipherNonceAccessor
-	CipherAccessor crypto.CipherAccessor
-	DbMapper       MapEncryptedValueToDB
+	KeyService KeyService
+	Codec      crypto.EnvelopeCodec
+}
+
+// cipherColumn points at an encrypted column of a credential. valid is nil
+// for columns that cannot be NULL.
+type cipherColumn struct {
+	name  string
+	value *string
+	valid *bool
 }
 
-func (r UAARotator) Rotate(credential entity.MfaCredential) (entity.MfaCredential, error) {
-	decryptor, err := r.KeyService.Key(credential.EncryptionKeyLabel)
+func cipherColumns(credential *entity.MfaCredential) []cipherColumn {
+	return []cipherColumn{
+		{name: "scratch_codes", value: &credential.ScratchCodes.String, valid: &credential.ScratchCodes.Valid},
+		{name: "secret_key", value: &credential.SecretKey},
+		{name: "encrypted_validation_code", value: &credential.EncryptedValidationCode.String, valid: &credential.EncryptedValidationCode.Valid},
+	}
+}
+
+// empty reports whether the column is NULL or empty, in which case it holds
+// nothing to rotate and is passed through unchanged.
+func (c cipherColumn) empty() bool {
+	return (c.valid != nil && !*c.valid) || *c.value == ""
+}
+
+func (c cipherColumn) equal(other cipherColumn) bool {
+	return *c.value == *other.value && (c.valid == nil || *c.valid == *other.valid)
+}
+
+type rotatedValue struct {
+	unchanged      bool
+	storedValue    string
+	encryptedValue crypto.EncryptedValue
+	plaintext      []byte
+}
+
+// Rotate re-encrypts every encrypted column of credential with the active
+// key, leaving NULL and empty columns as they are. Before returning, each new
+// value is decoded and decrypted with the active key and compared to the
+// original plaintext, so that a value which cannot be read back is never
+// written.
+func (r UAARotator) Rotate(ctx context.Context, credential entity.MfaCredential) (entity.MfaCredential, error) {
+	decryptor, err := r.KeyService.Key(ctx, credential.EncryptionKeyLabel)
 	if err != nil {
 		return entity.MfaCredential{}, errors.Wrap(err, "Unable to decrypt mfa record")
 	}
+	defer wipe(decryptor)
 
-	activeKeyLabel, encryptor, err := r.KeyService.ActiveKey()
+	activeKeyLabel, encryptor, err := r.KeyService.ActiveKey(ctx)
 	if err != nil {
 		return entity.MfaCredential{}, errors.Wrap(err, "Unable to decrypt mfa record")
 	}
+	defer wipe(encryptor)
 
-	rotatedScratchCodes, err := r.rotateCipherValue(encryptor, decryptor, credential.ScratchCodes)
+	activeDecryptor, err := r.KeyService.Key(ctx, activeKeyLabel)
 	if err != nil {
-		return entity.MfaCredential{}, err
+		return entity.MfaCredential{}, errors.Wrap(err, "Unable to verify mfa record")
 	}
+	defer wipe(activeDecryptor)
 
-	rotatedSecretKey, err := r.rotateCipherValue(encryptor, decryptor, credential.SecretKey)
-	if err != nil {
-		return entity.MfaCredential{}, err
+	columns := cipherColumns(&credential)
+	rotatedValues := make([]rotatedValue, 0, len(columns))
+	defer func() {
+		for _, rotated := range rotatedValues {
+			crypto.Zero(rotated.plaintext)
+		}
+	}()
+
+	for _, column := range columns {
+		if column.empty() {
+			rotatedValues = append(rotatedValues, rotatedValue{unchanged: true})
+			continue
+		}
+
+		rotated, err := r.rotateCipherValue(encryptor, decryptor, *column.value)
+		if err != nil {
+			return entity.MfaCredential{}, err
+		}
+		rotatedValues = append(rotatedValues, rotated)
 	}
 
-	rotatedEncryptedValidationCode, err := r.rotateCipherValue(encryptor, decryptor, credential.EncryptedValidationCode)
-	if err != nil {
+	if err = r.verifyRotatedValues(activeDecryptor, columns, rotatedValues); err != nil {
 		return entity.MfaCredential{}, err
 	}
 
-	credential.ScratchCodes = string(rotatedScratchCodes)
-	credential.SecretKey = string(rotatedSecretKey)
-	credential.EncryptedValidationCode = string(rotatedEncryptedValidationCode)
+	setRotatedValues(columns, rotatedValues)
 	credential.EncryptionKeyLabel = activeKeyLabel
 
 	return credential, nil
 }
 
-func (r UAARotator) rotateCipherValue(encryptor crypto.Encryptor, decryptor crypto.Decryptor, encodedCipherValue string) ([]byte, error) {
-	uaaCipherValue, err := r.base64DecodeCipher(encodedCipherValue)
+// VerifyStored checks a credential read back after it was written: it must
+// be labelled with the active key, and each column must decrypt under that
+// key to the same plaintext as the original credential.
+func (r UAARotator) VerifyStored(ctx context.Context, original entity.MfaCredential, stored entity.MfaCredential) error {
+	return r.VerifyStoredWith(ctx, original, stored, nil)
+}
+
+// VerifyStoredWith is VerifyStored for credentials rotated by Recover, where
+// originalKeyLabels names the key that decrypted each original column.
+// Columns missing from it are decrypted with the original row's label.
+func (r UAARotator) VerifyStoredWith(ctx context.Context, original entity.MfaCredential, stored entity.MfaCredential, originalKeyLabels map[string]string) error {
+	activeKeyLabel, encryptor, err := r.KeyService.ActiveKey(ctx)
 	if err != nil {
-		return nil, err
+		return errors.Wrap(err, "Unable to verify mfa record")
+	}
+	wipe(encryptor)
+
+	if stored.EncryptionKeyLabel != activeKeyLabel {
+		return errors.Wrapf(ErrVerificationFailed, "stored row is labelled %s, not the active key %s", stored.EncryptionKeyLabel, activeKeyLabel)
 	}
 
-	salt, err := r.getSalt(uaaCipherValue)
+	keys := &trialKeys{keyService: r.KeyService, decryptors: map[string]crypto.Decryptor{}, errors: map[string]error{}}
+	defer keys.wipe()
+
+	activeDecryptor, err := keys.get(ctx, activeKeyLabel)
 	if err != nil {
-		return nil, err
+		return errors.Wrap(err, "Unable to verify mfa record")
+	}
+
+	originalColumns := cipherColumns(&original)
+	for i, storedColumn := range cipherColumns(&stored) {
+		originalKeyLabel, ok := originalKeyLabels[storedColumn.name]
+		if !ok {
+			originalKeyLabel = original.EncryptionKeyLabel
+		}
+
+		if originalColumns[i].empty() {
+			if !storedColumn.equal(originalColumns[i]) {
+				return errors.Wrap(errors.Wrap(ErrVerificationFailed, "stored value should have been left unchanged"), storedColumn.name)
+			}
+			continue
+		}
+
+		originalDecryptor, err := keys.get(ctx, originalKeyLabel)
+		if err != nil {
+			return errors.Wrap(err, "Unable to verify mfa record")
+		}
+
+		err = r.compareCipherValues(originalDecryptor, *originalColumns[i].value, activeDecryptor, *storedColumn.value)
+		if err != nil {
+			return errors.Wrap(err, storedColumn.name)
+		}
 	}
 
-	nonce, err := r.getNonce(uaaCipherValue)
+	return nil
+}
+
+func (r UAARotator) rotateCipherValue(encryptor crypto.Encryptor, decryptor crypto.Decryptor, storedValue string) (rotatedValue, error) {
+	encryptedValue, err := r.decode(storedValue)
 	if err != nil {
-		return nil, err
+		return rotatedValue{}, err
 	}
 
-	cipherValue, err := r.getCipherValue(uaaCipherValue)
+	decryptedValue, err := r.decrypt(decryptor, encryptedValue)
 	if err != nil {
-		return nil, err
+		return rotatedValue{}, err
 	}
-	decryptedValue, err := r.decrypt(decryptor, cipherValue, salt, nonce)
+
+	return r.encryptValue(encryptor, decryptedValue)
+}
+
+// encryptValue encrypts and encodes plaintext, which the returned value then
+// owns. plaintext is zeroed if it cannot be encrypted.
+func (r UAARotator) encryptValue(encryptor crypto.Encryptor, plaintext []byte) (rotatedValue, error) {
+	encryptedValue, err := r.encrypt(encryptor, plaintext)
 	if err != nil {
-		return nil, err
+		crypto.Zero(plaintext)
+		return rotatedValue{}, err
 	}
 
-	reEncryptedValue, err := r.encrypt(encryptor, decryptedValue)
+	storedValue, err := r.encode(encryptedValue)
 	if err != nil {
-		return nil, err
+		crypto.Zero(plaintext)
+		return rotatedValue{}, err
 	}
 
-	return r.DbMapper.Map(reEncryptedValue)
+	return rotatedValue{
+		storedValue:    storedValue,
+		encryptedValue: encryptedValue,
+		plaintext:      plaintext,
+	}, nil
 }
 
-func (r UAARotator) encrypt(activeKey crypto.Encryptor, decryptedValue string) (crypto.EncryptedValue, error) {
-	reEncryptedValue, err := activeKey.Encrypt(decryptedValue)
+func (r UAARotator) verifyRotatedValues(activeDecryptor crypto.Decryptor, columns []cipherColumn, rotatedValues []rotatedValue) error {
+	for i, column := range columns {
+		if rotatedValues[i].unchanged {
+			continue
+		}
+		if err := r.verifyRotatedValue(activeDecryptor, rotatedValues[i]); err != nil {
+			return errors.Wrap(err, column.name)
+		}
+	}
+	return nil
+}
+
+func setRotatedValues(columns []cipherColumn, rotatedValues []rotatedValue) {
+	for i, column := range columns {
+		if rotatedValues[i].unchanged {
+			continue
+		}
+		*column.value = rotatedValues[i].storedValue
+		if column.valid != nil {
+			*column.valid = true
+		}
+	}
+}
+
+func (r UAARotator) verifyRotatedValue(activeDecryptor crypto.Decryptor, rotated rotatedValue) error {
+	decodedValue, err := r.Codec.Decode(rotated.storedValue)
 	if err != nil {
-		return crypto.EncryptedValue{}, errors.Wrap(err, "unable to encrypt value provided")
+		return errors.Wrapf(ErrVerificationFailed, "rotated value does not decode: %s", err)
 	}
-	return reEncryptedValue, nil
+	if !bytes.Equal(decodedValue.Nonce, rotated.encryptedValue.Nonce) ||
+		!bytes.Equal(decodedValue.Salt, rotated.encryptedValue.Salt) ||
+		!bytes.Equal(decodedValue.CipherValue, rotated.encryptedValue.CipherValue) {
+		return errors.Wrap(ErrVerificationFailed, "rotated value does not decode to what was encrypted")
+	}
+
+	plaintext, err := activeDecryptor.DecryptBytes(decodedValue)
+	if err != nil {
+		return errors.Wrapf(ErrVerificationFailed, "rotated value does not decrypt with the active key: %s", err)
+	}
+	defer crypto.Zero(plaintext)
+
+	if !bytes.Equal(plaintext, rotated.plaintext) {
+		return errors.Wrap(ErrVerificationFailed, "rotated value does not decrypt to the original plaintext")
+	}
+	return nil
+}
+
+func (r UAARotator) compareCipherValues(originalDecryptor crypto.Decryptor, originalValue string, storedDecryptor crypto.Decryptor, storedValue string) error {
+	originalEncryptedValue, err := r.decode(originalValue)
+	if err != nil {
+		return err
+	}
+	originalPlaintext, err := r.decrypt(originalDecryptor, originalEncryptedValue)
+	if err != nil {
+		return err
+	}
+	defer crypto.Zero(originalPlaintext)
+
+	return r.compareStoredValue(storedDecryptor, storedValue, originalPlaintext)
 }
 
-func (r UAARotator) decrypt(decryptor crypto.Decryptor, cipherValue []byte, salt []byte, nonce []byte) (string, error) {
-	decrpytedValue, err := decryptor.Decrypt(
-		crypto.EncryptedValue{
-			CipherValue: []byte(cipherValue),
-			Salt:        salt,
-			Nonce:       nonce,
-		})
+func (r UAARotator) compareStoredValue(storedDecryptor crypto.Decryptor, storedValue string, originalPlaintext []byte) error {
+	storedEncryptedValue, err := r.Codec.Decode(storedValue)
+	if err != nil {
+		return errors.Wrapf(ErrVerificationFailed, "stored value does not decode: %s", err)
+	}
+	storedPlaintext, err := storedDecryptor.DecryptBytes(storedEncryptedValue)
 	if err != nil {
-		return "", errors.Wrap(err, "unable to decrypt cipher value provided")
+		return errors.Wrapf(ErrVerificationFailed, "stored value does not decrypt with the active key: %s", err)
 	}
+	defer crypto.Zero(storedPlaintext)
 
-	return decrpytedValue, nil
+	if !bytes.Equal(originalPlaintext, storedPlaintext) {
+		return errors.Wrap(ErrVerificationFailed, "stored value does not decrypt to the original plaintext")
+	}
+	return nil
 }
 
-func (r UAARotator) getSalt(cipherValue []byte) ([]byte, error) {
-	scratchCodeSalt, err := r.SaltAccessor.GetSalt(cipherValue)
+func (r UAARotator) encrypt(activeKey crypto.Encryptor, decryptedValue []byte) (crypto.EncryptedValue, error) {
+	reEncryptedValue, err := activeKey.EncryptBytes(decryptedValue)
 	if err != nil {
-		return nil, errors.Wrap(err, "unable to access salt from cipher value provided")
+		return crypto.EncryptedValue{}, errors.Wrap(err, "unable to encrypt value provided")
 	}
-	return scratchCodeSalt, err
+	return reEncryptedValue, nil
 }
 
-func (r UAARotator) getNonce(cipherValue []byte) ([]byte, error) {
-	nonce, err := r.NonceAccessor.GetNonce(cipherValue)
+func (r UAARotator) decrypt(decryptor crypto.Decryptor, encryptedValue crypto.EncryptedValue) ([]byte, error) {
+	decrpytedValue, err := decryptor.DecryptBytes(encryptedValue)
 	if err != nil {
-		return nil, errors.Wrap(err, "unable to access nonce from cipher value provided")
+		return nil, errors.Wrap(err, "unable to decrypt cipher value provided")
 	}
-	return nonce, nil
+
+	return decrpytedValue, nil
 }
 
-func (r UAARotator) getCipherValue(uaaCipherValue []byte) ([]byte, error) {
-	cipherValue, err := r.CipherAccessor.GetCipher(uaaCipherValue)
+func (r UAARotator) decode(storedValue string) (crypto.EncryptedValue, error) {
+	encryptedValue, err := r.Codec.Decode(storedValue)
 	if err != nil {
-		return nil, errors.Wrap(err, "unable to access cipher value from 'uaa' cipher value provided")
+		return crypto.EncryptedValue{}, errors.Wrap(err, "Unable to decode mfa credential value")
 	}
-	return cipherValue, nil
+	return encryptedValue, nil
 }
 
-func (r UAARotator) base64DecodeCipher(cipher string) ([]byte, error) {
-	scratchCodes, err := r.DbMapper.MapBase64ToCipherValue(cipher)
+func (r UAARotator) encode(encryptedValue crypto.EncryptedValue) (string, error) {
+	storedValue, err := r.Codec.Encode(encryptedValue)
 	if err != nil {
-		return nil, errors.Wrap(err, "Unable to decode mfa credential value")
+		return "", errors.Wrap(err, "Unable to encode mfa credential value")
+	}
+	return storedValue, nil
+}
+
+// wipe zeroes the passphrase held by a key once a record has been rotated,
+// for key implementations that support it.
+func wipe(key interface{}) {
+	if wiper, ok := key.(crypto.Wiper); ok {
+		wiper.Wipe()
 	}
-	return scratchCodes, nil
 }
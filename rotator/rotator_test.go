@@ -1,10 +1,13 @@
 package rotator_test
 
 import (
+	"context"
 	"database/sql"
+	"github.com/cloudfoundry/uaa-key-rotator/config"
 	"github.com/cloudfoundry/uaa-key-rotator/crypto"
 	"github.com/cloudfoundry/uaa-key-rotator/crypto/cryptofakes"
 	"github.com/cloudfoundry/uaa-key-rotator/entity"
+	"github.com/cloudfoundry/uaa-key-rotator/keyprovider"
 	"github.com/cloudfoundry/uaa-key-rotator/rotator"
 	"github.com/cloudfoundry/uaa-key-rotator/rotator/rotatorfakes"
 	. "github.com/onsi/ginkgo"
@@ -20,36 +23,30 @@ var _ = Describe("UAARotator", func() {
 	var updatedCredential entity.MfaCredential
 	var rotatorError error
 
-	var scratchCodes string
-	var secretKey string
-	var encryptedValidationCode string
-
-	var base64ScratchCodes string
-	var base64SecretKey string
-	var base64EncryptedValidationCode string
+	var storedScratchCodes string
+	var storedSecretKey string
+	var storedEncryptedValidationCode string
 
 	var activeKeyLabel string
 	var fakeKeyService *rotatorfakes.FakeKeyService
 
 	var fakeDecryptor *cryptofakes.FakeDecryptor
 	var fakeEncryptor *cryptofakes.FakeEncryptor
+	var fakeActiveDecryptor *cryptofakes.FakeDecryptor
 
-	var fakeSaltAccessor *cryptofakes.FakeCipherSaltAccessor
-	var fakeNonceAccessor *cryptofakes.FakeCipherNonceAccessor
-	var fakeCipherAccessor *cryptofakes.FakeCipherAccessor
+	var fakeCodec *cryptofakes.FakeEnvelopeCodec
 
-	var fakeDbMapper *rotatorfakes.FakeMapEncryptedValueToDB
+	var decodedScratchCodes crypto.EncryptedValue
+	var decodedSecretKey crypto.EncryptedValue
+	var decodedEncryptedValidationCode crypto.EncryptedValue
 
 	var fakeDecrpytedScratchCodes string
 	var fakeDecryptedSecretKey string
 	var fakeDecryptedValidationCode string
 
-	var secretKeySalt string
-	var encryptedValidationCodeSalt string
-	var scratchCodesSalt string
-	var scratchCodesNonce string
-	var encryptedValidationCodeNonce string
-	var secretKeyNonce string
+	var decryptedScratchCodesBuffer []byte
+	var decryptedSecretKeyBuffer []byte
+	var decryptedValidationCodeBuffer []byte
 
 	var fakeEncryptedScratchCode crypto.EncryptedValue
 	var fakeEncryptedSecretKey crypto.EncryptedValue
@@ -58,143 +55,155 @@ var _ = Describe("UAARotator", func() {
 	var fakeRotatedSecretKey string
 	var fakeRotatedEncryptedValidationCode string
 
+	var credentialToRotate entity.MfaCredential
+
 	BeforeEach(func() {
 		fakeKeyService = &rotatorfakes.FakeKeyService{}
 		fakeDecryptor = &cryptofakes.FakeDecryptor{}
-		fakeSaltAccessor = &cryptofakes.FakeCipherSaltAccessor{}
-		fakeNonceAccessor = &cryptofakes.FakeCipherNonceAccessor{}
-		fakeCipherAccessor = &cryptofakes.FakeCipherAccessor{}
+		fakeCodec = &cryptofakes.FakeEnvelopeCodec{}
 
-		base64ScratchCodes = "base64-encrypted-scratch-codes" + time.Now().String()
-		scratchCodes = "encrypted-scratch-codes" + time.Now().String()
-		base64SecretKey = "base64-secret-key" + time.Now().String()
-		secretKey = "secret-key" + time.Now().String()
-		base64EncryptedValidationCode = "base64-encrypted-validation-code" + time.Now().String()
-		encryptedValidationCode = "encrypted-validation-code" + time.Now().String()
+		storedScratchCodes = "base64-encrypted-scratch-codes" + time.Now().String()
+		storedSecretKey = "base64-secret-key" + time.Now().String()
+		storedEncryptedValidationCode = "base64-encrypted-validation-code" + time.Now().String()
+
+		decodedScratchCodes = crypto.EncryptedValue{
+			Nonce:       []byte("scratch-codes-nonce" + time.Now().String()),
+			Salt:        []byte("scratch-codes-salt" + time.Now().String()),
+			CipherValue: []byte("encrypted-scratch-codes" + time.Now().String()),
+		}
+		decodedSecretKey = crypto.EncryptedValue{
+			Nonce:       []byte("secret-key-nonce" + time.Now().String()),
+			Salt:        []byte("secret-key-salt" + time.Now().String()),
+			CipherValue: []byte("secret-key" + time.Now().String()),
+		}
+		decodedEncryptedValidationCode = crypto.EncryptedValue{
+			Nonce:       []byte("encrypted-validation-codes-nonce" + time.Now().String()),
+			Salt:        []byte("encrypted-validation-code-salt" + time.Now().String()),
+			CipherValue: []byte("encrypted-validation-code" + time.Now().String()),
+		}
+		fakeCodec.DecodeReturnsOnCall(0, decodedScratchCodes, nil)
+		fakeCodec.DecodeReturnsOnCall(1, decodedSecretKey, nil)
+		fakeCodec.DecodeReturnsOnCall(2, decodedEncryptedValidationCode, nil)
 
 		fakeKeyService.KeyReturns(fakeDecryptor, nil)
 		fakeDecrpytedScratchCodes = "whatever-we-return-in-our-fake" + time.Now().String()
 		fakeDecryptedSecretKey = "decrypted secret key" + time.Now().String()
 		fakeDecryptedValidationCode = "validation code secret key" + time.Now().String()
 
-		fakeDecryptor.DecryptReturnsOnCall(0, fakeDecrpytedScratchCodes, nil)
-		fakeDecryptor.DecryptReturnsOnCall(1, fakeDecryptedSecretKey, nil)
-		fakeDecryptor.DecryptReturnsOnCall(2, fakeDecryptedValidationCode, nil)
-
-		scratchCodesSalt = "scratch-codes-salt" + time.Now().String()
-		fakeSaltAccessor.GetSaltReturnsOnCall(0, []byte(scratchCodesSalt), nil)
-		secretKeySalt = "secret-key-salt" + time.Now().String()
-		fakeSaltAccessor.GetSaltReturnsOnCall(1, []byte(secretKeySalt), nil)
-		encryptedValidationCodeSalt = "encrypted-validation-code-salt" + time.Now().String()
-		fakeSaltAccessor.GetSaltReturnsOnCall(2, []byte(encryptedValidationCodeSalt), nil)
-
-		scratchCodesNonce = "scratch-codes-nonce" + time.Now().String()
-		fakeNonceAccessor.GetNonceReturnsOnCall(0, []byte(scratchCodesNonce), nil)
-		secretKeyNonce = "secret-key-nonce" + time.Now().String()
-		fakeNonceAccessor.GetNonceReturnsOnCall(1, []byte(secretKeyNonce), nil)
-		encryptedValidationCodeNonce = "encrypted-validation-codes-nonce" + time.Now().String()
-		fakeNonceAccessor.GetNonceReturnsOnCall(2, []byte(encryptedValidationCodeNonce), nil)
-
-		fakeCipherAccessor.GetCipherReturnsOnCall(0, []byte(scratchCodes), nil)
-		fakeCipherAccessor.GetCipherReturnsOnCall(1, []byte(secretKey), nil)
-		fakeCipherAccessor.GetCipherReturnsOnCall(2, []byte(encryptedValidationCode), nil)
+		decryptedScratchCodesBuffer = []byte(fakeDecrpytedScratchCodes)
+		decryptedSecretKeyBuffer = []byte(fakeDecryptedSecretKey)
+		decryptedValidationCodeBuffer = []byte(fakeDecryptedValidationCode)
+		fakeDecryptor.DecryptBytesReturnsOnCall(0, decryptedScratchCodesBuffer, nil)
+		fakeDecryptor.DecryptBytesReturnsOnCall(1, decryptedSecretKeyBuffer, nil)
+		fakeDecryptor.DecryptBytesReturnsOnCall(2, decryptedValidationCodeBuffer, nil)
 
 		fakeEncryptor = &cryptofakes.FakeEncryptor{}
 		activeKeyLabel = "key-2"
 		fakeKeyService.ActiveKeyReturns(activeKeyLabel, fakeEncryptor, nil)
 
-		fakeDbMapper = &rotatorfakes.FakeMapEncryptedValueToDB{}
-
-		fakeDbMapper.MapBase64ToCipherValueReturnsOnCall(0, []byte(scratchCodes), nil)
-		fakeDbMapper.MapBase64ToCipherValueReturnsOnCall(1, []byte(secretKey), nil)
-		fakeDbMapper.MapBase64ToCipherValueReturnsOnCall(2, []byte(encryptedValidationCode), nil)
-
 		fakeEncryptedScratchCode = crypto.EncryptedValue{CipherValue: []byte("rotated_scratch_code")}
-		fakeEncryptor.EncryptReturnsOnCall(0, fakeEncryptedScratchCode, nil)
+		fakeEncryptor.EncryptBytesReturnsOnCall(0, fakeEncryptedScratchCode, nil)
 		fakeEncryptedSecretKey = crypto.EncryptedValue{CipherValue: []byte("rotated_secret_key")}
-		fakeEncryptor.EncryptReturnsOnCall(1, fakeEncryptedSecretKey, nil)
+		fakeEncryptor.EncryptBytesReturnsOnCall(1, fakeEncryptedSecretKey, nil)
 		fakeEncryptedEncryptedValidationCode = crypto.EncryptedValue{CipherValue: []byte("rotated_validation_code")}
-		fakeEncryptor.EncryptReturnsOnCall(2, fakeEncryptedEncryptedValidationCode, nil)
+		fakeEncryptor.EncryptBytesReturnsOnCall(2, fakeEncryptedEncryptedValidationCode, nil)
 
 		fakeRotatedScratchCode = "encrypted scratch code"
-		fakeDbMapper.MapReturnsOnCall(0, []byte(fakeRotatedScratchCode), nil)
+		fakeCodec.EncodeReturnsOnCall(0, fakeRotatedScratchCode, nil)
 
 		fakeRotatedSecretKey = "rotated secret key"
-		fakeDbMapper.MapReturnsOnCall(1, []byte(fakeRotatedSecretKey), nil)
+		fakeCodec.EncodeReturnsOnCall(1, fakeRotatedSecretKey, nil)
 
 		fakeRotatedEncryptedValidationCode = "rotated encrypted validation code"
-		fakeDbMapper.MapReturnsOnCall(2, []byte(fakeRotatedEncryptedValidationCode), nil)
-
+		fakeCodec.EncodeReturnsOnCall(2, fakeRotatedEncryptedValidationCode, nil)
+
+		fakeActiveDecryptor = &cryptofakes.FakeDecryptor{}
+		fakeKeyService.KeyReturnsOnCall(1, fakeActiveDecryptor, nil)
+		fakeCodec.DecodeReturnsOnCall(3, fakeEncryptedScratchCode, nil)
+		fakeCodec.DecodeReturnsOnCall(4, fakeEncryptedSecretKey, nil)
+		fakeCodec.DecodeReturnsOnCall(5, fakeEncryptedEncryptedValidationCode, nil)
+		fakeActiveDecryptor.DecryptBytesReturnsOnCall(0, []byte(fakeDecrpytedScratchCodes), nil)
+		fakeActiveDecryptor.DecryptBytesReturnsOnCall(1, []byte(fakeDecryptedSecretKey), nil)
+		fakeActiveDecryptor.DecryptBytesReturnsOnCall(2, []byte(fakeDecryptedValidationCode), nil)
+
+		credentialToRotate = entity.MfaCredential{
+			UserId:                  "some-user-id",
+			MfaProviderId:           "some-provider-id",
+			ZoneId:                  "some-zone-id",
+			EncryptionKeyLabel:      "key-1",
+			ValidationCode:          sql.NullInt64{Int64: 1},
+			ScratchCodes:            sql.NullString{String: storedScratchCodes, Valid: true},
+			SecretKey:               storedSecretKey,
+			EncryptedValidationCode: sql.NullString{String: storedEncryptedValidationCode, Valid: true},
+		}
 	})
 
-	Context("rotator is configured correctly", func() {
-		JustBeforeEach(func() {
-			uaaRotator = rotator.UAARotator{
-				KeyService:     fakeKeyService,
-				SaltAccessor:   fakeSaltAccessor,
-				NonceAccessor:  fakeNonceAccessor,
-				CipherAccessor: fakeCipherAccessor,
-				DbMapper:       fakeDbMapper,
-			}
-			updatedCredential, rotatorError = uaaRotator.Rotate(
-				entity.MfaCredential{
-					UserId:                  "some-user-id",
-					MfaProviderId:           "some-provider-id",
-					ZoneId:                  "some-zone-id",
-					EncryptionKeyLabel:      "key-1",
-					ValidationCode:          sql.NullInt64{Int64: 1},
-					ScratchCodes:            base64ScratchCodes,
-					SecretKey:               base64SecretKey,
-					EncryptedValidationCode: base64EncryptedValidationCode,
-				},
-			)
-
-			Expect(updatedCredential.ValidationCode).To(Equal(sql.NullInt64{Int64: 1}))
-			Expect(updatedCredential.EncryptionKeyLabel).To(Equal(activeKeyLabel))
-			Expect(updatedCredential.UserId).To(Equal("some-user-id"))
-			Expect(string(updatedCredential.MfaProviderId)).To(Equal("some-provider-id"))
-			Expect(string(updatedCredential.ZoneId)).To(Equal("some-zone-id"))
+	rotate := func() {
+		uaaRotator = rotator.UAARotator{
+			KeyService: fakeKeyService,
+			Codec:      fakeCodec,
+		}
+		updatedCredential, rotatorError = uaaRotator.Rotate(context.Background(), credentialToRotate)
+	}
 
-		})
+	Context("rotator is configured correctly", func() {
+		JustBeforeEach(rotate)
 
 		It("should rotate encrypted values from using one key to another", func() {
 			Expect(rotatorError).NotTo(HaveOccurred())
-			Expect(fakeKeyService.KeyCallCount()).To(Equal(1))
-
-			decryptArgsForScratchCodes := fakeDecryptor.DecryptArgsForCall(0)
-			Expect(string(decryptArgsForScratchCodes.CipherValue)).To(Equal(scratchCodes))
-			Expect(string(decryptArgsForScratchCodes.Salt)).To(Equal(scratchCodesSalt))
-			Expect(string(decryptArgsForScratchCodes.Nonce)).To(Equal(scratchCodesNonce))
+			Expect(fakeKeyService.KeyCallCount()).To(Equal(2))
+			_, sourceKeyLabel := fakeKeyService.KeyArgsForCall(0)
+			Expect(sourceKeyLabel).To(Equal("key-1"))
 
-			decryptArgsForSecretKey := fakeDecryptor.DecryptArgsForCall(1)
-			Expect(string(decryptArgsForSecretKey.CipherValue)).To(Equal(secretKey))
-			Expect(string(decryptArgsForSecretKey.Salt)).To(Equal(secretKeySalt))
-			Expect(string(decryptArgsForSecretKey.Nonce)).To(Equal(secretKeyNonce))
+			Expect(fakeCodec.DecodeCallCount()).To(Equal(6))
+			Expect(fakeCodec.DecodeArgsForCall(0)).To(Equal(storedScratchCodes))
+			Expect(fakeCodec.DecodeArgsForCall(1)).To(Equal(storedSecretKey))
+			Expect(fakeCodec.DecodeArgsForCall(2)).To(Equal(storedEncryptedValidationCode))
 
-			decryptArgsForEncryptedValidationCode := fakeDecryptor.DecryptArgsForCall(2)
-			Expect(string(decryptArgsForEncryptedValidationCode.CipherValue)).To(Equal(encryptedValidationCode))
-			Expect(string(decryptArgsForEncryptedValidationCode.Salt)).To(Equal(encryptedValidationCodeSalt))
-			Expect(string(decryptArgsForEncryptedValidationCode.Nonce)).To(Equal(encryptedValidationCodeNonce))
+			Expect(fakeDecryptor.DecryptBytesArgsForCall(0)).To(Equal(decodedScratchCodes))
+			Expect(fakeDecryptor.DecryptBytesArgsForCall(1)).To(Equal(decodedSecretKey))
+			Expect(fakeDecryptor.DecryptBytesArgsForCall(2)).To(Equal(decodedEncryptedValidationCode))
 
 			Expect(fakeKeyService.ActiveKeyCallCount()).To(Equal(1))
-			Expect(fakeEncryptor.EncryptCallCount()).To(Equal(3))
-
-			Expect(fakeEncryptor.EncryptArgsForCall(0)).To(Equal(fakeDecrpytedScratchCodes))
-			Expect(fakeEncryptor.EncryptArgsForCall(1)).To(Equal(fakeDecryptedSecretKey))
-			Expect(fakeEncryptor.EncryptArgsForCall(2)).To(Equal(fakeDecryptedValidationCode))
-
-			Expect(fakeDbMapper.MapCallCount()).To(Equal(3))
-			Expect(fakeDbMapper.MapArgsForCall(0)).To(Equal(fakeEncryptedScratchCode))
-			Expect(fakeDbMapper.MapArgsForCall(1)).To(Equal(fakeEncryptedSecretKey))
-			Expect(fakeDbMapper.MapArgsForCall(2)).To(Equal(fakeEncryptedEncryptedValidationCode))
+			Expect(fakeEncryptor.EncryptBytesCallCount()).To(Equal(3))
+
+			Expect(string(fakeEncryptor.EncryptBytesArgsForCall(0))).To(Equal(fakeDecrpytedScratchCodes))
+			Expect(string(fakeEncryptor.EncryptBytesArgsForCall(1))).To(Equal(fakeDecryptedSecretKey))
+			Expect(string(fakeEncryptor.EncryptBytesArgsForCall(2))).To(Equal(fakeDecryptedValidationCode))
+
+			Expect(fakeCodec.EncodeCallCount()).To(Equal(3))
+			Expect(fakeCodec.EncodeArgsForCall(0)).To(Equal(fakeEncryptedScratchCode))
+			Expect(fakeCodec.EncodeArgsForCall(1)).To(Equal(fakeEncryptedSecretKey))
+			Expect(fakeCodec.EncodeArgsForCall(2)).To(Equal(fakeEncryptedEncryptedValidationCode))
+
+			_, verificationKeyLabel := fakeKeyService.KeyArgsForCall(1)
+			Expect(verificationKeyLabel).To(Equal(activeKeyLabel))
+			Expect(fakeCodec.DecodeArgsForCall(3)).To(Equal(fakeRotatedScratchCode))
+			Expect(fakeCodec.DecodeArgsForCall(4)).To(Equal(fakeRotatedSecretKey))
+			Expect(fakeCodec.DecodeArgsForCall(5)).To(Equal(fakeRotatedEncryptedValidationCode))
+			Expect(fakeActiveDecryptor.DecryptBytesArgsForCall(0)).To(Equal(fakeEncryptedScratchCode))
+			Expect(fakeActiveDecryptor.DecryptBytesArgsForCall(1)).To(Equal(fakeEncryptedSecretKey))
+			Expect(fakeActiveDecryptor.DecryptBytesArgsForCall(2)).To(Equal(fakeEncryptedEncryptedValidationCode))
 
 			Expect(updatedCredential).To(MatchFields(IgnoreExtras, Fields{
-				"ScratchCodes":            Equal(fakeRotatedScratchCode),
+				"ScratchCodes":            Equal(sql.NullString{String: fakeRotatedScratchCode, Valid: true}),
 				"SecretKey":               Equal(fakeRotatedSecretKey),
-				"EncryptedValidationCode": Equal(fakeRotatedEncryptedValidationCode),
+				"EncryptedValidationCode": Equal(sql.NullString{String: fakeRotatedEncryptedValidationCode, Valid: true}),
 			}))
+
+			Expect(updatedCredential.ValidationCode).To(Equal(sql.NullInt64{Int64: 1}))
+			Expect(updatedCredential.EncryptionKeyLabel).To(Equal(activeKeyLabel))
+			Expect(updatedCredential.UserId).To(Equal("some-user-id"))
+			Expect(string(updatedCredential.MfaProviderId)).To(Equal("some-provider-id"))
+			Expect(string(updatedCredential.ZoneId)).To(Equal("some-zone-id"))
 		})
 
+		It("should zero the plaintext once it has been re-encrypted", func() {
+			Expect(rotatorError).NotTo(HaveOccurred())
+			Expect(decryptedScratchCodesBuffer).To(Equal(make([]byte, len(fakeDecrpytedScratchCodes))))
+			Expect(decryptedSecretKeyBuffer).To(Equal(make([]byte, len(fakeDecryptedSecretKey))))
+			Expect(decryptedValidationCodeBuffer).To(Equal(make([]byte, len(fakeDecryptedValidationCode))))
+		})
 	})
 
 	Context("Attempting to rotate with an unknown key", func() {
@@ -202,27 +211,7 @@ var _ = Describe("UAARotator", func() {
 			fakeKeyService.KeyReturns(nil, errors.New("Couldn't find key with label=key-1"))
 		})
 
-		JustBeforeEach(func() {
-			uaaRotator = rotator.UAARotator{
-				KeyService:     fakeKeyService,
-				SaltAccessor:   fakeSaltAccessor,
-				NonceAccessor:  fakeNonceAccessor,
-				CipherAccessor: fakeCipherAccessor,
-				DbMapper:       fakeDbMapper,
-			}
-			updatedCredential, rotatorError = uaaRotator.Rotate(
-				entity.MfaCredential{
-					UserId:                  "some-user-id",
-					MfaProviderId:           "some-provider-id",
-					ZoneId:                  "some-zone-id",
-					EncryptionKeyLabel:      "key-1",
-					ValidationCode:          sql.NullInt64{Int64: 1},
-					ScratchCodes:            base64ScratchCodes,
-					SecretKey:               base64SecretKey,
-					EncryptedValidationCode: base64EncryptedValidationCode,
-				},
-			)
-		})
+		JustBeforeEach(rotate)
 
 		It("Should return a meaningful error", func() {
 			Expect(rotatorError).To(HaveOccurred())
@@ -235,27 +224,7 @@ var _ = Describe("UAARotator", func() {
 			fakeKeyService.ActiveKeyReturns("", nil, errors.New("Configured active key is missing or invalid"))
 		})
 
-		JustBeforeEach(func() {
-			uaaRotator = rotator.UAARotator{
-				KeyService:     fakeKeyService,
-				SaltAccessor:   fakeSaltAccessor,
-				NonceAccessor:  fakeNonceAccessor,
-				CipherAccessor: fakeCipherAccessor,
-				DbMapper:       fakeDbMapper,
-			}
-			updatedCredential, rotatorError = uaaRotator.Rotate(
-				entity.MfaCredential{
-					UserId:                  "some-user-id",
-					MfaProviderId:           "some-provider-id",
-					ZoneId:                  "some-zone-id",
-					EncryptionKeyLabel:      "key-1",
-					ValidationCode:          sql.NullInt64{Int64: 1},
-					ScratchCodes:            base64ScratchCodes,
-					SecretKey:               base64SecretKey,
-					EncryptedValidationCode: base64EncryptedValidationCode,
-				},
-			)
-		})
+		JustBeforeEach(rotate)
 
 		It("Should return a meaningful error", func() {
 			Expect(rotatorError).To(HaveOccurred())
@@ -263,149 +232,252 @@ var _ = Describe("UAARotator", func() {
 		})
 	})
 
-	table.DescribeTable("Attempting to base64 decode", func(errorIndex int) {
-		fakeDbMapper.MapBase64ToCipherValueReturnsOnCall(errorIndex, []byte(scratchCodes), errors.New("some base64 decode error"))
+	Context("when rotating real UAA values", func() {
+		var oldKeyEncryptor crypto.Encryptor
+		var codec crypto.UAAEnvelopeCodec
+		var issuedKeys []crypto.UAADecryptor
+		var issuedActiveKeys []crypto.UAAEncryptor
 
-		uaaRotator = rotator.UAARotator{
-			KeyService:     fakeKeyService,
-			SaltAccessor:   fakeSaltAccessor,
-			NonceAccessor:  fakeNonceAccessor,
-			CipherAccessor: fakeCipherAccessor,
-			DbMapper:       fakeDbMapper,
-		}
-		updatedCredential, rotatorError = uaaRotator.Rotate(
-			entity.MfaCredential{
-				UserId:                  "some-user-id",
-				MfaProviderId:           "some-provider-id",
-				ZoneId:                  "some-zone-id",
-				EncryptionKeyLabel:      "key-1",
-				ValidationCode:          sql.NullInt64{Int64: 1},
-				ScratchCodes:            base64ScratchCodes,
-				SecretKey:               base64SecretKey,
-				EncryptedValidationCode: base64EncryptedValidationCode,
-			},
-		)
+		BeforeEach(func() {
+			keyService := rotator.UaaKeyService{
+				ActiveKeyLabel: "new-key",
+				KeyProvider: keyprovider.StaticKeyProvider{
+					EncryptionKeys: []config.EncryptionKey{
+						{Label: "old-key", Passphrase: config.Passphrase("old-passphrase")},
+						{Label: "new-key", Passphrase: config.Passphrase("new-passphrase")},
+					},
+				},
+			}
+			_, oldKeyEncryptor, _ = rotator.UaaKeyService{
+				ActiveKeyLabel: "old-key",
+				KeyProvider:    keyService.KeyProvider,
+			}.ActiveKey(context.Background())
+
+			credentialToRotate.ScratchCodes = sql.NullString{String: encryptAndEncode(oldKeyEncryptor, "scratch-codes"), Valid: true}
+			credentialToRotate.SecretKey = encryptAndEncode(oldKeyEncryptor, "secret-key")
+			credentialToRotate.EncryptedValidationCode = sql.NullString{String: encryptAndEncode(oldKeyEncryptor, "validation-code"), Valid: true}
+			credentialToRotate.EncryptionKeyLabel = "old-key"
+
+			issuedKeys = nil
+			issuedActiveKeys = nil
+			fakeKeyService.KeyStub = func(ctx context.Context, keyLabel string) (crypto.Decryptor, error) {
+				decryptor, err := keyService.Key(ctx, keyLabel)
+				issuedKeys = append(issuedKeys, decryptor.(crypto.UAADecryptor))
+				return decryptor, err
+			}
+			fakeKeyService.ActiveKeyStub = func(ctx context.Context) (string, crypto.Encryptor, error) {
+				label, encryptor, err := keyService.ActiveKey(ctx)
+				issuedActiveKeys = append(issuedActiveKeys, encryptor.(crypto.UAAEncryptor))
+				return label, encryptor, err
+			}
+			fakeCodec.DecodeStub = codec.Decode
+			fakeCodec.EncodeStub = codec.Encode
+		})
 
-		Expect(rotatorError).To(HaveOccurred())
-		Expect(rotatorError).To(MatchError("Unable to decode mfa credential value: some base64 decode error"))
-	},
-		table.Entry("when base64 decoding ScratchCodes fails", 0),
-		table.Entry("when base64 decoding SecretKey fails", 1),
-		table.Entry("when base64 decoding EncryptedValidationCode fails", 2),
-	)
+		JustBeforeEach(rotate)
 
-	table.DescribeTable("when accessing the salt returns an error", func(errorIndex int) {
-		fakeSaltAccessor = &cryptofakes.FakeCipherSaltAccessor{}
-		var errorStr = "some error" + time.Now().String()
-		fakeSaltAccessor.GetSaltReturnsOnCall(errorIndex, nil, errors.New(errorStr))
+		It("should produce values that decrypt under the active key", func() {
+			Expect(rotatorError).NotTo(HaveOccurred())
+			Expect(updatedCredential.EncryptionKeyLabel).To(Equal("new-key"))
 
-		uaaRotator = rotator.UAARotator{
-			KeyService:     fakeKeyService,
-			SaltAccessor:   fakeSaltAccessor,
-			NonceAccessor:  fakeNonceAccessor,
-			CipherAccessor: fakeCipherAccessor,
-			DbMapper:       fakeDbMapper,
-		}
+			newKeyDecryptor, err := rotator.UaaKeyService{KeyProvider: keyprovider.StaticKeyProvider{
+				EncryptionKeys: []config.EncryptionKey{{Label: "new-key", Passphrase: config.Passphrase("new-passphrase")}},
+			}}.Key(context.Background(), "new-key")
+			Expect(err).NotTo(HaveOccurred())
 
-		updatedCredential, rotatorError = uaaRotator.Rotate(
-			entity.MfaCredential{
-				EncryptionKeyLabel:      "key-1",
-				ScratchCodes:            base64ScratchCodes,
-				SecretKey:               base64SecretKey,
-				EncryptedValidationCode: base64EncryptedValidationCode,
-			},
-		)
+			Expect(decodeAndDecrypt(newKeyDecryptor, updatedCredential.ScratchCodes.String)).To(Equal("scratch-codes"))
+			Expect(decodeAndDecrypt(newKeyDecryptor, updatedCredential.SecretKey)).To(Equal("secret-key"))
+			Expect(decodeAndDecrypt(newKeyDecryptor, updatedCredential.EncryptedValidationCode.String)).To(Equal("validation-code"))
+		})
 
-		Expect(rotatorError).To(HaveOccurred())
-		Expect(rotatorError).To(MatchError("unable to access salt from cipher value provided: " + errorStr))
-	},
-		table.Entry("when accessing salt for scratch codes fails", 0),
-		table.Entry("when accessing salt for secret key fails", 1),
-		table.Entry("when accessing salt for encrypted validation codes fails", 2),
-	)
+		Context("when encrypted columns are NULL or empty", func() {
+			BeforeEach(func() {
+				credentialToRotate.ScratchCodes = sql.NullString{}
+				credentialToRotate.EncryptedValidationCode = sql.NullString{String: "", Valid: true}
+			})
+
+			It("should pass them through unchanged and rotate the rest of the row", func() {
+				Expect(rotatorError).NotTo(HaveOccurred())
+				Expect(updatedCredential.EncryptionKeyLabel).To(Equal("new-key"))
+				Expect(updatedCredential.ScratchCodes).To(Equal(sql.NullString{}))
+				Expect(updatedCredential.EncryptedValidationCode).To(Equal(sql.NullString{String: "", Valid: true}))
+
+				newKeyDecryptor, err := rotator.UaaKeyService{KeyProvider: keyprovider.StaticKeyProvider{
+					EncryptionKeys: []config.EncryptionKey{{Label: "new-key", Passphrase: config.Passphrase("new-passphrase")}},
+				}}.Key(context.Background(), "new-key")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(decodeAndDecrypt(newKeyDecryptor, updatedCredential.SecretKey)).To(Equal("secret-key"))
+			})
+
+			It("should never decode them", func() {
+				Expect(rotatorError).NotTo(HaveOccurred())
+				for i := 0; i < fakeCodec.DecodeCallCount(); i++ {
+					Expect(fakeCodec.DecodeArgsForCall(i)).NotTo(BeEmpty())
+				}
+			})
+
+			It("should verify once written", func() {
+				Expect(uaaRotator.VerifyStored(context.Background(), credentialToRotate, updatedCredential)).To(Succeed())
+			})
+		})
 
-	table.DescribeTable("when accessing the uaa cipher returns an error", func(errorIndex int) {
-		fakeCipherAccessor = &cryptofakes.FakeCipherAccessor{}
-		var errorStr = "some error" + time.Now().String()
-		fakeCipherAccessor.GetCipherReturnsOnCall(errorIndex, nil, errors.New(errorStr))
+		It("should wipe the passphrases it was given once the record is rotated", func() {
+			Expect(rotatorError).NotTo(HaveOccurred())
+			Expect(issuedKeys).To(HaveLen(2))
+			Expect(issuedKeys[0].Passphrase).To(Equal(make([]byte, len("old-passphrase"))))
+			Expect(issuedKeys[1].Passphrase).To(Equal(make([]byte, len("new-passphrase"))))
+			Expect(issuedActiveKeys).To(HaveLen(1))
+			Expect(issuedActiveKeys[0].Passphrase).To(Equal(make([]byte, len("new-passphrase"))))
+		})
+	})
 
-		uaaRotator = rotator.UAARotator{
-			KeyService:     fakeKeyService,
-			SaltAccessor:   fakeSaltAccessor,
-			NonceAccessor:  fakeNonceAccessor,
-			CipherAccessor: fakeCipherAccessor,
-			DbMapper:       fakeDbMapper,
-		}
+	Context("when the active key cannot be fetched for verification", func() {
+		BeforeEach(func() {
+			fakeKeyService.KeyReturnsOnCall(1, nil, errors.New("credhub unavailable"))
+		})
 
-		updatedCredential, rotatorError = uaaRotator.Rotate(
-			entity.MfaCredential{
-				EncryptionKeyLabel:      "key-1",
-				ScratchCodes:            base64ScratchCodes,
-				SecretKey:               base64SecretKey,
-				EncryptedValidationCode: base64EncryptedValidationCode,
-			},
-		)
+		JustBeforeEach(rotate)
 
-		Expect(rotatorError).To(HaveOccurred())
-		Expect(rotatorError).To(MatchError("unable to access cipher value from 'uaa' cipher value provided: " + errorStr))
+		It("Should return a meaningful error", func() {
+			Expect(rotatorError).To(MatchError("Unable to verify mfa record: credhub unavailable"))
+		})
+	})
+
+	table.DescribeTable("when the rotated value fails round trip verification", func(column string, errorIndex int, expectedError string, breakRoundTrip func(int)) {
+		breakRoundTrip(errorIndex)
+
+		rotate()
+
+		Expect(rotatorError).To(MatchError(column + ": " + expectedError))
+		Expect(errors.Cause(rotatorError)).To(Equal(rotator.ErrVerificationFailed))
+		Expect(updatedCredential).To(Equal(entity.MfaCredential{}))
 	},
-		table.Entry("when accessing uaa cipher for scratch codes fails", 0),
-		table.Entry("when accessing uaa cipher for secret key fails", 1),
-		table.Entry("when accessing uaa cipher for encrypted validation codes fails", 2),
+		table.Entry("when the rotated scratch codes do not decode", "scratch_codes", 0,
+			"rotated value does not decode: truncated envelope: round trip verification failed",
+			func(i int) {
+				fakeCodec.DecodeReturnsOnCall(3+i, crypto.EncryptedValue{}, errors.New("truncated envelope"))
+			}),
+		table.Entry("when the rotated secret key decodes to something else", "secret_key", 1,
+			"rotated value does not decode to what was encrypted: round trip verification failed",
+			func(i int) {
+				fakeCodec.DecodeReturnsOnCall(3+i, crypto.EncryptedValue{CipherValue: []byte("something else")}, nil)
+			}),
+		table.Entry("when the rotated validation code does not decrypt", "encrypted_validation_code", 2,
+			"rotated value does not decrypt with the active key: cipher: message authentication failed: round trip verification failed",
+			func(i int) {
+				fakeActiveDecryptor.DecryptBytesReturnsOnCall(i, nil, errors.New("cipher: message authentication failed"))
+			}),
+		table.Entry("when the rotated secret key decrypts to a different plaintext", "secret_key", 1,
+			"rotated value does not decrypt to the original plaintext: round trip verification failed",
+			func(i int) { fakeActiveDecryptor.DecryptBytesReturnsOnCall(i, []byte("corrupted"), nil) }),
 	)
 
-	table.DescribeTable("when accessing the nonce returns an error", func(errorIndex int) {
-		fakeNonceAccessor = &cryptofakes.FakeCipherNonceAccessor{}
-		var errorStr = "some error" + time.Now().String()
-		fakeNonceAccessor.GetNonceReturnsOnCall(errorIndex, nil, errors.New(errorStr))
+	Describe("VerifyStored", func() {
+		var keyService rotator.UaaKeyService
+		var original entity.MfaCredential
+		var stored entity.MfaCredential
+		var verifyError error
 
-		uaaRotator = rotator.UAARotator{
-			KeyService:     fakeKeyService,
-			SaltAccessor:   fakeSaltAccessor,
-			NonceAccessor:  fakeNonceAccessor,
-			CipherAccessor: fakeCipherAccessor,
-			DbMapper:       fakeDbMapper,
-		}
+		BeforeEach(func() {
+			keyService = rotator.UaaKeyService{
+				ActiveKeyLabel: "new-key",
+				KeyProvider: keyprovider.StaticKeyProvider{
+					EncryptionKeys: []config.EncryptionKey{
+						{Label: "old-key", Passphrase: config.Passphrase("old-passphrase")},
+						{Label: "new-key", Passphrase: config.Passphrase("new-passphrase")},
+					},
+				},
+			}
+			_, oldKeyEncryptor, err := rotator.UaaKeyService{ActiveKeyLabel: "old-key", KeyProvider: keyService.KeyProvider}.ActiveKey(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+
+			original = credentialToRotate
+			original.EncryptionKeyLabel = "old-key"
+			original.ScratchCodes = sql.NullString{String: encryptAndEncode(oldKeyEncryptor, "scratch-codes"), Valid: true}
+			original.SecretKey = encryptAndEncode(oldKeyEncryptor, "secret-key")
+			original.EncryptedValidationCode = sql.NullString{String: encryptAndEncode(oldKeyEncryptor, "validation-code"), Valid: true}
+
+			uaaRotator = rotator.UAARotator{KeyService: keyService, Codec: crypto.UAAEnvelopeCodec{}}
+			stored, err = uaaRotator.Rotate(context.Background(), original)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		JustBeforeEach(func() {
+			verifyError = uaaRotator.VerifyStored(context.Background(), original, stored)
+		})
+
+		It("should accept a row that decrypts to the original plaintext", func() {
+			Expect(verifyError).NotTo(HaveOccurred())
+		})
 
-		updatedCredential, rotatorError = uaaRotator.Rotate(
-			entity.MfaCredential{
-				EncryptionKeyLabel:      "key-1",
-				ScratchCodes:            base64ScratchCodes,
-				SecretKey:               base64SecretKey,
-				EncryptedValidationCode: base64EncryptedValidationCode,
-			},
-		)
+		Context("when the stored row is not labelled with the active key", func() {
+			BeforeEach(func() {
+				stored.EncryptionKeyLabel = "old-key"
+			})
+
+			It("should fail verification", func() {
+				Expect(verifyError).To(MatchError("stored row is labelled old-key, not the active key new-key: round trip verification failed"))
+			})
+		})
+
+		Context("when a stored column holds a different value", func() {
+			BeforeEach(func() {
+				_, activeEncryptor, err := keyService.ActiveKey(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+				stored.SecretKey = encryptAndEncode(activeEncryptor, "another-secret-key")
+			})
+
+			It("should fail verification", func() {
+				Expect(verifyError).To(MatchError("secret_key: stored value does not decrypt to the original plaintext: round trip verification failed"))
+				Expect(errors.Cause(verifyError)).To(Equal(rotator.ErrVerificationFailed))
+			})
+		})
+
+		Context("when a NULL column was written as empty", func() {
+			BeforeEach(func() {
+				original.ScratchCodes = sql.NullString{}
+				var err error
+				stored, err = uaaRotator.Rotate(context.Background(), original)
+				Expect(err).NotTo(HaveOccurred())
+				stored.ScratchCodes = sql.NullString{String: "", Valid: true}
+			})
+
+			It("should fail verification", func() {
+				Expect(verifyError).To(MatchError("scratch_codes: stored value should have been left unchanged: round trip verification failed"))
+				Expect(errors.Cause(verifyError)).To(Equal(rotator.ErrVerificationFailed))
+			})
+		})
+
+		Context("when a stored column was not re-encrypted", func() {
+			BeforeEach(func() {
+				stored.ScratchCodes = original.ScratchCodes
+			})
+
+			It("should fail verification", func() {
+				Expect(verifyError).To(MatchError(ContainSubstring("scratch_codes: stored value does not decrypt with the active key")))
+				Expect(errors.Cause(verifyError)).To(Equal(rotator.ErrVerificationFailed))
+			})
+		})
+	})
+
+	table.DescribeTable("when decoding the stored value returns an error", func(errorIndex int) {
+		fakeCodec.DecodeReturnsOnCall(errorIndex, crypto.EncryptedValue{}, errors.New("envelope is not valid base64"))
+
+		rotate()
 
 		Expect(rotatorError).To(HaveOccurred())
-		Expect(rotatorError).To(MatchError("unable to access nonce from cipher value provided: " + errorStr))
+		Expect(rotatorError).To(MatchError("Unable to decode mfa credential value: envelope is not valid base64"))
 	},
-		table.Entry("when accessing nonce for scratch codes fails", 0),
-		table.Entry("when accessing nonce for secret key fails", 1),
-		table.Entry("when accessing nonce for encrypted validation codes fails", 2),
+		table.Entry("when decoding ScratchCodes fails", 0),
+		table.Entry("when decoding SecretKey fails", 1),
+		table.Entry("when decoding EncryptedValidationCode fails", 2),
 	)
 
 	table.DescribeTable("when decrypting returns an error", func(errorIndex int) {
-		fakeDecryptor = &cryptofakes.FakeDecryptor{}
-		fakeKeyService.KeyReturns(fakeDecryptor, nil)
 		var errorStr = "some error" + time.Now().String()
-		fakeDecryptor.DecryptReturnsOnCall(errorIndex, "", errors.New(errorStr))
-
-		uaaRotator = rotator.UAARotator{
-			KeyService:     fakeKeyService,
-			SaltAccessor:   fakeSaltAccessor,
-			NonceAccessor:  fakeNonceAccessor,
-			CipherAccessor: fakeCipherAccessor,
-			DbMapper:       fakeDbMapper,
-		}
+		fakeDecryptor.DecryptBytesReturnsOnCall(errorIndex, nil, errors.New(errorStr))
 
-		updatedCredential, rotatorError = uaaRotator.Rotate(
-			entity.MfaCredential{
-				EncryptionKeyLabel:      "key-1",
-				ScratchCodes:            base64ScratchCodes,
-				SecretKey:               base64SecretKey,
-				EncryptedValidationCode: base64EncryptedValidationCode,
-			},
-		)
+		rotate()
 
 		Expect(rotatorError).To(HaveOccurred())
 		Expect(rotatorError).To(MatchError("unable to decrypt cipher value provided: " + errorStr))
@@ -416,28 +488,10 @@ var _ = Describe("UAARotator", func() {
 	)
 
 	table.DescribeTable("when encrypting returns an error", func(errorIndex int) {
-		fakeEncryptor = &cryptofakes.FakeEncryptor{}
-		fakeKeyService.ActiveKeyReturns(activeKeyLabel, fakeEncryptor, nil)
-
 		var errorStr = "some error" + time.Now().String()
-		fakeEncryptor.EncryptReturnsOnCall(errorIndex, crypto.EncryptedValue{}, errors.New(errorStr))
-
-		uaaRotator = rotator.UAARotator{
-			KeyService:     fakeKeyService,
-			SaltAccessor:   fakeSaltAccessor,
-			NonceAccessor:  fakeNonceAccessor,
-			CipherAccessor: fakeCipherAccessor,
-			DbMapper:       fakeDbMapper,
-		}
+		fakeEncryptor.EncryptBytesReturnsOnCall(errorIndex, crypto.EncryptedValue{}, errors.New(errorStr))
 
-		updatedCredential, rotatorError = uaaRotator.Rotate(
-			entity.MfaCredential{
-				EncryptionKeyLabel:      "key-1",
-				ScratchCodes:            scratchCodes,
-				SecretKey:               secretKey,
-				EncryptedValidationCode: encryptedValidationCode,
-			},
-		)
+		rotate()
 
 		Expect(rotatorError).To(HaveOccurred())
 		Expect(rotatorError).To(MatchError("unable to encrypt value provided: " + errorStr))
@@ -446,4 +500,33 @@ var _ = Describe("UAARotator", func() {
 		table.Entry("when encrypting secret key fails", 1),
 		table.Entry("when encrypting encrypted validation codes fails", 2),
 	)
+
+	table.DescribeTable("when encoding the rotated value returns an error", func(errorIndex int) {
+		fakeCodec.EncodeReturnsOnCall(errorIndex, "", errors.New("salt should be exactly 32 bytes in length but was 0"))
+
+		rotate()
+
+		Expect(rotatorError).To(HaveOccurred())
+		Expect(rotatorError).To(MatchError("Unable to encode mfa credential value: salt should be exactly 32 bytes in length but was 0"))
+	},
+		table.Entry("when encoding scratch codes fails", 0),
+		table.Entry("when encoding secret key fails", 1),
+		table.Entry("when encoding encrypted validation codes fails", 2),
+	)
 })
+
+func encryptAndEncode(encryptor crypto.Encryptor, plainText string) string {
+	encryptedValue, err := encryptor.Encrypt(plainText)
+	Expect(err).NotTo(HaveOccurred())
+	storedValue, err := crypto.UAAEnvelopeCodec{}.Encode(encryptedValue)
+	Expect(err).NotTo(HaveOccurred())
+	return storedValue
+}
+
+func decodeAndDecrypt(decryptor crypto.Decryptor, storedValue string) string {
+	encryptedValue, err := crypto.UAAEnvelopeCodec{}.Decode(storedValue)
+	Expect(err).NotTo(HaveOccurred())
+	plainText, err := decryptor.Decrypt(encryptedValue)
+	Expect(err).NotTo(HaveOccurred())
+	return plainText
+}
@@ -2,6 +2,7 @@
 package rotatorfakes
 
 import (
+	"context"
 	"sync"
 
 	"github.com/cloudfoundry/uaa-key-rotator/crypto"
@@ -9,9 +10,10 @@ import (
 )
 
 type FakeKeyService struct {
-	KeyStub        func(keyLabel string) (crypto.Decryptor, error)
+	KeyStub        func(ctx context.Context, keyLabel string) (crypto.Decryptor, error)
 	keyMutex       sync.RWMutex
 	keyArgsForCall []struct {
+		ctx      context.Context
 		keyLabel string
 	}
 	keyReturns struct {
@@ -22,10 +24,12 @@ type FakeKeyService struct {
 		result1 crypto.Decryptor
 		result2 error
 	}
-	ActiveKeyStub        func() (string, crypto.Encryptor, error)
+	ActiveKeyStub        func(ctx context.Context) (string, crypto.Encryptor, error)
 	activeKeyMutex       sync.RWMutex
-	activeKeyArgsForCall []struct{}
-	activeKeyReturns     struct {
+	activeKeyArgsForCall []struct {
+		ctx context.Context
+	}
+	activeKeyReturns struct {
 		result1 string
 		result2 crypto.Encryptor
 		result3 error
@@ -39,16 +43,17 @@ type FakeKeyService struct {
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *FakeKeyService) Key(keyLabel string) (crypto.Decryptor, error) {
+func (fake *FakeKeyService) Key(ctx context.Context, keyLabel string) (crypto.Decryptor, error) {
 	fake.keyMutex.Lock()
 	ret, specificReturn := fake.keyReturnsOnCall[len(fake.keyArgsForCall)]
 	fake.keyArgsForCall = append(fake.keyArgsForCall, struct {
+		ctx      context.Context
 		keyLabel string
-	}{keyLabel})
-	fake.recordInvocation("Key", []interface{}{keyLabel})
+	}{ctx, keyLabel})
+	fake.recordInvocation("Key", []interface{}{ctx, keyLabel})
 	fake.keyMutex.Unlock()
 	if fake.KeyStub != nil {
-		return fake.KeyStub(keyLabel)
+		return fake.KeyStub(ctx, keyLabel)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2
@@ -62,10 +67,10 @@ func (fake *FakeKeyService) KeyCallCount() int {
 	return len(fake.keyArgsForCall)
 }
 
-func (fake *FakeKeyService) KeyArgsForCall(i int) string {
+func (fake *FakeKeyService) KeyArgsForCall(i int) (context.Context, string) {
 	fake.keyMutex.RLock()
 	defer fake.keyMutex.RUnlock()
-	return fake.keyArgsForCall[i].keyLabel
+	return fake.keyArgsForCall[i].ctx, fake.keyArgsForCall[i].keyLabel
 }
 
 func (fake *FakeKeyService) KeyReturns(result1 crypto.Decryptor, result2 error) {
@@ -90,14 +95,16 @@ func (fake *FakeKeyService) KeyReturnsOnCall(i int, result1 crypto.Decryptor, re
 	}{result1, result2}
 }
 
-func (fake *FakeKeyService) ActiveKey() (string, crypto.Encryptor, error) {
+func (fake *FakeKeyService) ActiveKey(ctx context.Context) (string, crypto.Encryptor, error) {
 	fake.activeKeyMutex.Lock()
 	ret, specificReturn := fake.activeKeyReturnsOnCall[len(fake.activeKeyArgsForCall)]
-	fake.activeKeyArgsForCall = append(fake.activeKeyArgsForCall, struct{}{})
-	fake.recordInvocation("ActiveKey", []interface{}{})
+	fake.activeKeyArgsForCall = append(fake.activeKeyArgsForCall, struct {
+		ctx context.Context
+	}{ctx})
+	fake.recordInvocation("ActiveKey", []interface{}{ctx})
 	fake.activeKeyMutex.Unlock()
 	if fake.ActiveKeyStub != nil {
-		return fake.ActiveKeyStub()
+		return fake.ActiveKeyStub(ctx)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2, ret.result3
@@ -111,6 +118,12 @@ func (fake *FakeKeyService) ActiveKeyCallCount() int {
 	return len(fake.activeKeyArgsForCall)
 }
 
+func (fake *FakeKeyService) ActiveKeyArgsForCall(i int) context.Context {
+	fake.activeKeyMutex.RLock()
+	defer fake.activeKeyMutex.RUnlock()
+	return fake.activeKeyArgsForCall[i].ctx
+}
+
 func (fake *FakeKeyService) ActiveKeyReturns(result1 string, result2 crypto.Encryptor, result3 error) {
 	fake.ActiveKeyStub = nil
 	fake.activeKeyReturns = struct {
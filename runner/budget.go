@@ -0,0 +1,129 @@
+package runner
+
+import (
+	"fmt"
+	"github.com/cloudfoundry/uaa-key-rotator/config"
+	"github.com/pkg/errors"
+	"sync"
+)
+
+// DefaultFailureWindow is how many rows the failure percentage of a budget
+// is taken over unless the config says otherwise.
+const DefaultFailureWindow = 100
+
+// ErrFailureBudgetExceeded matches every FailureBudgetError with errors.Is.
+var ErrFailureBudgetExceeded = errors.New("failure budget exceeded")
+
+// FailureBudgetError stops a pass whose rows failed more often than its
+// failure budget allows. Dominant is the category most of them failed with.
+type FailureBudgetError struct {
+	Reason   string
+	Dominant FailureCategory
+	Count    int64
+	Failed   int64
+}
+
+func (e FailureBudgetError) Error() string {
+	return fmt.Sprintf("%s: %s; %d of the %d failed rows were %s", ErrFailureBudgetExceeded, e.Reason, e.Count, e.Failed, e.Dominant)
+}
+
+func (e FailureBudgetError) Is(target error) bool {
+	return target == ErrFailureBudgetExceeded
+}
+
+// failureBudget tracks the rows of a pass against its failure budget. It is
+// shared by the workers of the pass.
+type failureBudget struct {
+	limits config.FailureBudgetConfig
+	strict bool
+
+	mutex       sync.Mutex
+	failed      int64
+	categories  map[FailureCategory]int64
+	consecutive int
+	window      []bool
+	next        int
+	filled      bool
+	windowFails int
+	stopped     bool
+}
+
+func newFailureBudget(limits config.FailureBudgetConfig, strict bool) *failureBudget {
+	budget := &failureBudget{limits: limits, strict: strict, categories: map[FailureCategory]int64{}}
+	if limits.MaxFailurePercent > 0 {
+		size := limits.Window
+		if size == 0 {
+			size = DefaultFailureWindow
+		}
+		budget.window = make([]bool, size)
+	}
+	return budget
+}
+
+// succeeded records a row that was handled without failing.
+func (b *failureBudget) succeeded() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.consecutive = 0
+	b.slide(false)
+}
+
+// failedWith records a row that failed with category. It returns an error for
+// the row that exceeds the budget, and only for that one.
+func (b *failureBudget) failedWith(category FailureCategory) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.failed++
+	b.categories[category]++
+	b.consecutive++
+	b.slide(true)
+	if b.stopped {
+		return nil
+	}
+
+	switch {
+	case b.strict:
+		return b.exceeded("a row failed and the run is strict")
+	case b.limits.MaxFailures > 0 && b.failed > int64(b.limits.MaxFailures):
+		return b.exceeded(fmt.Sprintf("%d rows failed, more than the %d allowed", b.failed, b.limits.MaxFailures))
+	case b.limits.MaxConsecutiveFailures > 0 && b.consecutive > b.limits.MaxConsecutiveFailures:
+		return b.exceeded(fmt.Sprintf("%d rows failed in a row, more than the %d allowed", b.consecutive, b.limits.MaxConsecutiveFailures))
+	case b.window != nil && b.filled:
+		percent := 100 * float64(b.windowFails) / float64(len(b.window))
+		if percent > b.limits.MaxFailurePercent {
+			return b.exceeded(fmt.Sprintf("%.1f%% of the last %d rows failed, more than the %g%% allowed", percent, len(b.window), b.limits.MaxFailurePercent))
+		}
+	}
+	return nil
+}
+
+// slide adds a row to the window of the last rows handled.
+func (b *failureBudget) slide(failed bool) {
+	if b.window == nil {
+		return
+	}
+	if b.window[b.next] {
+		b.windowFails--
+	}
+	b.window[b.next] = failed
+	if failed {
+		b.windowFails++
+	}
+	b.next++
+	if b.next == len(b.window) {
+		b.next = 0
+		b.filled = true
+	}
+}
+
+func (b *failureBudget) exceeded(reason string) error {
+	b.stopped = true
+	budgetErr := FailureBudgetError{Reason: reason, Failed: b.failed}
+	for _, category := range failureCategories {
+		if count := b.categories[category]; count > budgetErr.Count {
+			budgetErr.Dominant = category
+			budgetErr.Count = count
+		}
+	}
+	return budgetErr
+}
@@ -0,0 +1,268 @@
+package runner_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/cloudfoundry/uaa-key-rotator/audit"
+	"github.com/cloudfoundry/uaa-key-rotator/config"
+	"github.com/cloudfoundry/uaa-key-rotator/crypto"
+	"github.com/cloudfoundry/uaa-key-rotator/dump"
+	"github.com/cloudfoundry/uaa-key-rotator/entity"
+	"github.com/cloudfoundry/uaa-key-rotator/quarantine"
+	"github.com/cloudfoundry/uaa-key-rotator/runner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var _ = Describe("Run with a dump", func() {
+	var (
+		dumpTable *dump.Table
+		options   runner.Options
+	)
+
+	encrypt := func(plainText string, passphrase string) string {
+		encryptor := crypto.UAAEncryptor{
+			Passphrase:     []byte(passphrase),
+			SaltGenerator:  crypto.UaaSaltGenerator{},
+			NonceGenerator: crypto.UaaNonceGenerator{},
+		}
+		encryptedValue, err := encryptor.Encrypt(plainText)
+		Expect(err).NotTo(HaveOccurred())
+		envelope, err := crypto.UAAEnvelopeCodec{}.Encode(encryptedValue)
+		Expect(err).NotTo(HaveOccurred())
+		return envelope
+	}
+
+	decrypt := func(envelope string, passphrase string) string {
+		encryptedValue, err := crypto.UAAEnvelopeCodec{}.Decode(envelope)
+		Expect(err).NotTo(HaveOccurred())
+		plainText, err := crypto.UAADecryptor{Passphrase: []byte(passphrase)}.Decrypt(encryptedValue)
+		Expect(err).NotTo(HaveOccurred())
+		return plainText
+	}
+
+	BeforeEach(func() {
+		content := "user_id,mfa_provider_id,zone_id,validation_code,scratch_codes,secret_key,encryption_key_label,encrypted_validation_code\n" +
+			"user-1,provider,uaa,\\N," + encrypt("scratch-codes", "old-passphrase") + "," + encrypt("secret-key", "old-passphrase") + ",old-key,\\N\n" +
+			"user-2,provider,uaa,\\N,\\N," + encrypt("other-secret-key", "active-passphrase") + ",active-key,\\N\n"
+
+		var err error
+		dumpTable, err = dump.Read(strings.NewReader(content), dump.FormatCSV)
+		Expect(err).NotTo(HaveOccurred())
+
+		rotatorConfig, err := config.New(strings.NewReader(`{
+			"activeKeyLabel": "active-key",
+			"encryptionKeys": [
+				{"label": "active-key", "passphrase": "active-passphrase"},
+				{"label": "old-key", "passphrase": "old-passphrase"}
+			],
+			"databaseHostname": "unused",
+			"databasePort": "5432",
+			"databaseScheme": "postgres",
+			"databaseName": "uaa",
+			"databaseUsername": "uaa"
+		}`))
+		Expect(err).NotTo(HaveOccurred())
+		options = runner.Options{Config: rotatorConfig, Dump: dumpTable, VerifyAfterWrite: true}
+	})
+
+	It("should rotate the rows of the dump without a database", func() {
+		report, err := runner.Run(context.Background(), options)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Targets[0].RowsWritten).To(Equal(int64(1)))
+
+		credentials := dumpTable.Credentials()
+		Expect(credentials[0].EncryptionKeyLabel).To(Equal("active-key"))
+		Expect(decrypt(credentials[0].SecretKey, "active-passphrase")).To(Equal("secret-key"))
+		Expect(decrypt(credentials[0].ScratchCodes.String, "active-passphrase")).To(Equal("scratch-codes"))
+		Expect(credentials[0].EncryptedValidationCode.Valid).To(BeFalse())
+		Expect(decrypt(credentials[1].SecretKey, "active-passphrase")).To(Equal("other-secret-key"))
+	})
+
+	It("should count the rows that fail by category", func() {
+		content := "user_id,mfa_provider_id,zone_id,validation_code,scratch_codes,secret_key,encryption_key_label,encrypted_validation_code\n" +
+			"user-1,provider,uaa,\\N,\\N," + encrypt("secret-key", "old-passphrase") + ",deleted-key,\\N\n" +
+			"user-2,provider,uaa,\\N,\\N,not-an-envelope,old-key,\\N\n" +
+			"user-3,provider,uaa,\\N,\\N," + encrypt("secret-key", "another-passphrase") + ",old-key,\\N\n"
+		var err error
+		options.Dump, err = dump.Read(strings.NewReader(content), dump.FormatCSV)
+		Expect(err).NotTo(HaveOccurred())
+
+		var categories []runner.FailureCategory
+		var mutex sync.Mutex
+		options.Progress.RowFailed = func(target string, credential entity.MfaCredential, err error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			categories = append(categories, runner.Categorize(err))
+		}
+
+		report, err := runner.Run(context.Background(), options)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Targets[0].RowsFailed).To(Equal(int64(3)))
+		Expect(report.Targets[0].RowsFailedByCategory).To(Equal(map[runner.FailureCategory]int64{
+			runner.FailureUnknownKey:           1,
+			runner.FailureMalformedEnvelope:    1,
+			runner.FailureAuthenticationFailed: 1,
+		}))
+		Expect(categories).To(ConsistOf(runner.FailureUnknownKey, runner.FailureMalformedEnvelope, runner.FailureAuthenticationFailed))
+	})
+
+	It("should quarantine the rows that fail, so that they can be retried on their own", func() {
+		content := "user_id,mfa_provider_id,zone_id,validation_code,scratch_codes,secret_key,encryption_key_label,encrypted_validation_code\n" +
+			"user-1,provider,uaa,\\N,\\N," + encrypt("secret-key", "deleted-passphrase") + ",deleted-key,\\N\n" +
+			"user-2,provider,uaa,\\N,\\N," + encrypt("other-secret-key", "old-passphrase") + ",old-key,\\N\n"
+		var err error
+		options.Dump, err = dump.Read(strings.NewReader(content), dump.FormatCSV)
+		Expect(err).NotTo(HaveOccurred())
+
+		dir, err := ioutil.TempDir("", "quarantine")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		options.QuarantineFile = filepath.Join(dir, "quarantine.jsonl")
+
+		report, err := runner.Run(context.Background(), options)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Targets[0].RowsWritten).To(Equal(int64(1)))
+		Expect(report.Targets[0].RowsFailed).To(Equal(int64(1)))
+
+		quarantineFile, err := os.Open(options.QuarantineFile)
+		Expect(err).NotTo(HaveOccurred())
+		defer quarantineFile.Close()
+		entries, err := quarantine.Read(quarantineFile)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].UserID).To(Equal("user-1"))
+		Expect(entries[0].EncryptionKeyLabel).To(Equal("deleted-key"))
+		Expect(entries[0].Category).To(Equal(string(runner.FailureUnknownKey)))
+		Expect(entries[0].Error).To(Equal("Unable to decrypt mfa record: unable to find key: deleted-key"))
+		Expect(entries[0].RunID).NotTo(BeEmpty())
+
+		options.Config, err = config.New(strings.NewReader(`{
+			"activeKeyLabel": "active-key",
+			"encryptionKeys": [
+				{"label": "active-key", "passphrase": "active-passphrase"},
+				{"label": "deleted-key", "passphrase": "deleted-passphrase"}
+			],
+			"databaseHostname": "unused",
+			"databasePort": "5432",
+			"databaseScheme": "postgres",
+			"databaseName": "uaa",
+			"databaseUsername": "uaa"
+		}`))
+		Expect(err).NotTo(HaveOccurred())
+		options.QuarantineFile = ""
+		options.RowFilter.UserIDs = quarantine.UserIDs(entries)
+
+		report, err = runner.Run(context.Background(), options)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Targets[0].RowsWritten).To(Equal(int64(1)))
+		Expect(report.Targets[0].RowsFailed).To(BeZero())
+		Expect(decrypt(options.Dump.Credentials()[0].SecretKey, "active-passphrase")).To(Equal("secret-key"))
+	})
+
+	Context("with a failure budget", func() {
+		BeforeEach(func() {
+			content := "user_id,mfa_provider_id,zone_id,validation_code,scratch_codes,secret_key,encryption_key_label,encrypted_validation_code\n"
+			for i := 0; i < 50; i++ {
+				content += fmt.Sprintf("user-%d,provider,uaa,\\N,\\N,%s,old-key,\\N\n", i, encrypt("secret-key", "wrong-passphrase"))
+			}
+			var err error
+			options.Dump, err = dump.Read(strings.NewReader(content), dump.FormatCSV)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should stop once more rows fail in a row than the budget allows", func() {
+			options.Config.FailureBudget.MaxConsecutiveFailures = 5
+
+			report, err := runner.Run(context.Background(), options)
+			Expect(errors.Is(err, runner.ErrFailureBudgetExceeded)).To(BeTrue())
+			Expect(err).To(MatchError(ContainSubstring("rows failed in a row, more than the 5 allowed")))
+			Expect(report.Targets[0].ErrorCategory).To(Equal(runner.FailureAuthenticationFailed))
+			Expect(report.Targets[0].RowsFailed).To(BeNumerically(">", 5))
+			Expect(report.Targets[0].RowsFailed).To(BeNumerically("<", 50))
+		})
+
+		It("should stop once too many of the last rows fail", func() {
+			options.Config.FailureBudget.MaxFailurePercent = 50
+			options.Config.FailureBudget.Window = 10
+
+			report, err := runner.Run(context.Background(), options)
+			Expect(err).To(MatchError(ContainSubstring("100.0% of the last 10 rows failed, more than the 50% allowed")))
+			Expect(report.Targets[0].RowsFailed).To(BeNumerically("<", 50))
+		})
+
+		It("should stop at the first row that fails when strict", func() {
+			options.Strict = true
+
+			report, err := runner.Run(context.Background(), options)
+			Expect(err).To(MatchError(ContainSubstring("a row failed and the run is strict")))
+			Expect(report.Targets[0].ErrorCategory).To(Equal(runner.FailureAuthenticationFailed))
+			Expect(report.Targets[0].RowsFailed).To(BeNumerically("<", 50))
+		})
+
+		It("should let every row fail without a budget", func() {
+			report, err := runner.Run(context.Background(), options)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Targets[0].RowsFailed).To(Equal(int64(50)))
+		})
+	})
+
+	It("should recover a row labelled with the active key whose column is under the old key when trial decrypting", func() {
+		content := "user_id,mfa_provider_id,zone_id,validation_code,scratch_codes,secret_key,encryption_key_label,encrypted_validation_code\n" +
+			"user-1,provider,uaa,\\N," + encrypt("scratch-codes", "active-passphrase") + "," + encrypt("secret-key", "old-passphrase") + ",active-key,\\N\n" +
+			"user-2,provider,uaa,\\N,\\N," + encrypt("other-secret-key", "active-passphrase") + ",active-key,\\N\n"
+		var err error
+		options.Dump, err = dump.Read(strings.NewReader(content), dump.FormatCSV)
+		Expect(err).NotTo(HaveOccurred())
+		original := options.Dump.Credentials()
+		options.TrialDecrypt = true
+
+		report, err := runner.Run(context.Background(), options)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Targets[0].RowsWritten).To(Equal(int64(1)))
+		Expect(report.Targets[0].RowsFailed).To(BeZero())
+
+		credentials := options.Dump.Credentials()
+		Expect(decrypt(credentials[0].SecretKey, "active-passphrase")).To(Equal("secret-key"))
+		Expect(decrypt(credentials[0].ScratchCodes.String, "active-passphrase")).To(Equal("scratch-codes"))
+		Expect(credentials[1]).To(Equal(original[1]))
+	})
+
+	It("should report the MAC the audit log ends at", func() {
+		dir, err := ioutil.TempDir("", "audit")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		options.Config.Audit = config.AuditConfig{File: filepath.Join(dir, "audit.jsonl"), HMACKey: "some-hmac-key"}
+
+		report, err := runner.Run(context.Background(), options)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.AuditHeadMAC).NotTo(BeEmpty())
+
+		auditFile, err := os.Open(filepath.Join(dir, "audit.jsonl"))
+		Expect(err).NotTo(HaveOccurred())
+		defer auditFile.Close()
+		summary, err := audit.Verify(auditFile, []byte("some-hmac-key"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(summary.HeadMAC).To(Equal(report.AuditHeadMAC))
+	})
+
+	It("should leave the dump alone on a dry run", func() {
+		options.DryRun = true
+		report, err := runner.Run(context.Background(), options)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Targets[0].RowsPreviewed).To(Equal(int64(1)))
+		Expect(dumpTable.Credentials()[0].EncryptionKeyLabel).To(Equal("old-key"))
+	})
+
+	It("should refuse to claim or watch a dump", func() {
+		options.Claim = true
+		_, err := runner.Run(context.Background(), options)
+		Expect(err).To(MatchError("invalid options: a dump cannot be claimed or watched"))
+	})
+})
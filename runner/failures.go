@@ -0,0 +1,108 @@
+package runner
+
+import (
+	"github.com/cloudfoundry/uaa-key-rotator/crypto"
+	db2 "github.com/cloudfoundry/uaa-key-rotator/db"
+	"github.com/cloudfoundry/uaa-key-rotator/rotator"
+	"github.com/pkg/errors"
+	"sync/atomic"
+)
+
+// FailureCategory is the kind of error a row or target failed with.
+type FailureCategory string
+
+const (
+	FailureUnknownKey           FailureCategory = "unknown_key"
+	FailureAuthenticationFailed FailureCategory = "authentication_failed"
+	FailureMalformedEnvelope    FailureCategory = "malformed_envelope"
+	FailureVerificationFailed   FailureCategory = "verification_failed"
+	FailureWriteConflict        FailureCategory = "write_conflict"
+	FailureTransientDB          FailureCategory = "transient_db"
+	FailureWriteFailed          FailureCategory = "write_failed"
+	FailureOther                FailureCategory = "other"
+)
+
+var failureCategories = []FailureCategory{
+	FailureUnknownKey,
+	FailureAuthenticationFailed,
+	FailureMalformedEnvelope,
+	FailureVerificationFailed,
+	FailureWriteConflict,
+	FailureTransientDB,
+	FailureWriteFailed,
+	FailureOther,
+}
+
+// Categorize returns the category of err. A database error that could have
+// been retried is transient even when it wraps a failed write, and a pass
+// that exceeded its failure budget takes the category most of its rows
+// failed with.
+func Categorize(err error) FailureCategory {
+	var budgetErr FailureBudgetError
+	switch {
+	case errors.As(err, &budgetErr):
+		return budgetErr.Dominant
+	case errors.Is(err, db2.ErrTransientDB), db2.IsRetryable(err):
+		return FailureTransientDB
+	case errors.Is(err, db2.ErrWriteConflict):
+		return FailureWriteConflict
+	case errors.Is(err, db2.ErrWriteFailed):
+		return FailureWriteFailed
+	case errors.Is(err, rotator.ErrUnknownKey):
+		return FailureUnknownKey
+	case errors.Is(err, rotator.ErrVerificationFailed):
+		return FailureVerificationFailed
+	case errors.Is(err, crypto.ErrMalformedEnvelope):
+		return FailureMalformedEnvelope
+	case errors.Is(err, crypto.ErrAuthenticationFailed):
+		return FailureAuthenticationFailed
+	}
+	return FailureOther
+}
+
+// FailureCounts counts the failed rows of a pass by category.
+type FailureCounts struct {
+	UnknownKey           int64
+	AuthenticationFailed int64
+	MalformedEnvelope    int64
+	VerificationFailed   int64
+	WriteConflict        int64
+	TransientDB          int64
+	WriteFailed          int64
+	Other                int64
+}
+
+func (c *FailureCounts) add(category FailureCategory) {
+	atomic.AddInt64(c.counter(category), 1)
+}
+
+func (c *FailureCounts) counter(category FailureCategory) *int64 {
+	switch category {
+	case FailureUnknownKey:
+		return &c.UnknownKey
+	case FailureAuthenticationFailed:
+		return &c.AuthenticationFailed
+	case FailureMalformedEnvelope:
+		return &c.MalformedEnvelope
+	case FailureVerificationFailed:
+		return &c.VerificationFailed
+	case FailureWriteConflict:
+		return &c.WriteConflict
+	case FailureTransientDB:
+		return &c.TransientDB
+	case FailureWriteFailed:
+		return &c.WriteFailed
+	}
+	return &c.Other
+}
+
+// ByCategory returns the counts that are not zero, keyed by category.
+func (c FailureCounts) ByCategory() map[FailureCategory]int64 {
+	counts := map[FailureCategory]int64{}
+	for _, category := range failureCategories {
+		if count := *c.counter(category); count > 0 {
+			counts[category] = count
+		}
+	}
+	return counts
+}
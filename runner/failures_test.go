@@ -0,0 +1,49 @@
+package runner_test
+
+import (
+	"fmt"
+	"github.com/cloudfoundry/uaa-key-rotator/crypto"
+	"github.com/cloudfoundry/uaa-key-rotator/db"
+	"github.com/cloudfoundry/uaa-key-rotator/rotator"
+	"github.com/cloudfoundry/uaa-key-rotator/runner"
+	"github.com/go-sql-driver/mysql"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+var _ = Describe("Categorize", func() {
+	table.DescribeTable("should categorize wrapped errors",
+		func(err error, category runner.FailureCategory) {
+			Expect(runner.Categorize(errors.Wrap(err, "unable to rotate"))).To(Equal(category))
+			Expect(runner.Categorize(fmt.Errorf("unable to rotate: %w", err))).To(Equal(category))
+		},
+		table.Entry("unknown key", rotator.UnknownKeyError{Label: "deleted-key"}, runner.FailureUnknownKey),
+		table.Entry("authentication failure", crypto.ErrAuthenticationFailed, runner.FailureAuthenticationFailed),
+		table.Entry("malformed envelope", crypto.MalformedEnvelopeError{Reason: "not base64"}, runner.FailureMalformedEnvelope),
+		table.Entry("verification failure", errors.Wrap(rotator.ErrVerificationFailed, "stored row is labelled old-key"), runner.FailureVerificationFailed),
+		table.Entry("write conflict", db.ErrWriteConflict, runner.FailureWriteConflict),
+		table.Entry("retryable database error", &mysql.MySQLError{Number: 1213, Message: "Deadlock found"}, runner.FailureTransientDB),
+		table.Entry("exceeded failure budget", runner.FailureBudgetError{Reason: "3 rows failed in a row", Dominant: runner.FailureAuthenticationFailed}, runner.FailureAuthenticationFailed),
+		table.Entry("other error", errors.New("something else"), runner.FailureOther),
+	)
+
+	It("should count failures by category", func() {
+		stats := runner.FailureCounts{UnknownKey: 2, Other: 1}
+		Expect(stats.ByCategory()).To(Equal(map[runner.FailureCategory]int64{
+			runner.FailureUnknownKey: 2,
+			runner.FailureOther:      1,
+		}))
+	})
+
+	It("should find the category most failed rows of a run had", func() {
+		report := runner.Report{Targets: []runner.TargetReport{
+			{RowsFailedByCategory: map[runner.FailureCategory]int64{runner.FailureWriteConflict: 2, runner.FailureUnknownKey: 1}},
+			{RowsFailedByCategory: map[runner.FailureCategory]int64{runner.FailureAuthenticationFailed: 3}},
+			{},
+		}}
+		Expect(report.DominantRowFailure()).To(Equal(runner.FailureAuthenticationFailed))
+		Expect(runner.Report{Targets: []runner.TargetReport{{}}}.DominantRowFailure()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,267 @@
+package runner
+
+import (
+	"code.cloudfoundry.org/lager"
+	"context"
+	"database/sql"
+	"github.com/cloudfoundry/uaa-key-rotator/audit"
+	"github.com/cloudfoundry/uaa-key-rotator/backup"
+	"github.com/cloudfoundry/uaa-key-rotator/config"
+	db2 "github.com/cloudfoundry/uaa-key-rotator/db"
+	"github.com/cloudfoundry/uaa-key-rotator/logging"
+	"github.com/pkg/errors"
+	"os"
+	"strings"
+	"time"
+)
+
+// RestoreOptions configures a restore from a backup file. Config is
+// required, and its backup key decrypts the file.
+type RestoreOptions struct {
+	Config   *config.RotatorConfig
+	Logger   lager.Logger
+	Redactor *logging.Redactor
+
+	// Queryer, or else DB, is used instead of connecting to the database in
+	// Config, as for Options.
+	Queryer db2.Queryer
+	DB      *sql.DB
+
+	// File defaults to Config.Backup.File.
+	File        string
+	Filter      backup.Filter
+	DryRun      bool
+	WaitForLock time.Duration
+}
+
+// RestoreStats counts the rows of a restore. Unchanged rows already held
+// their original values, for example because their update was rolled back.
+// Conflicts are rows that have changed since they were rotated, and were
+// left alone.
+type RestoreStats struct {
+	Restored  int64 `json:"rows_restored"`
+	Unchanged int64 `json:"rows_unchanged"`
+	Conflicts int64 `json:"rows_conflicting"`
+	Failed    int64 `json:"rows_failed"`
+	Previewed int64 `json:"rows_previewed,omitempty"`
+}
+
+func (s *RestoreStats) add(other RestoreStats) {
+	s.Restored += other.Restored
+	s.Unchanged += other.Unchanged
+	s.Conflicts += other.Conflicts
+	s.Failed += other.Failed
+	s.Previewed += other.Previewed
+}
+
+// Restore writes the original values of the backed up rows selected by
+// Filter back to their databases, undoing their rotation. Each row is only
+// written while it still holds the values it was rotated to, so that a row
+// UAA or a later rotation has written since is never overwritten. Restore
+// takes the rotator lock of each database, as a rotation does. It fails if
+// any selected row could not be restored.
+func Restore(ctx context.Context, options RestoreOptions) (RestoreStats, error) {
+	if options.Config == nil {
+		return RestoreStats{}, errors.New("invalid options: a config is required")
+	}
+	if (options.Queryer != nil || options.DB != nil) && len(options.Config.Targets) > 0 {
+		return RestoreStats{}, errors.New("invalid options: a database can only be passed in with a config that does not list targets")
+	}
+	if options.Logger == nil {
+		options.Logger = lager.NewLogger("uaa-key-rotator")
+	}
+	if options.Redactor == nil {
+		options.Redactor = &logging.Redactor{}
+	}
+	if options.File == "" {
+		options.File = options.Config.Backup.File
+	}
+	logger := options.Logger
+	rotatorConfig := options.Config
+	defer rotatorConfig.WipePassphrases()
+
+	if options.File == "" || rotatorConfig.Backup.Key == "" {
+		return RestoreStats{}, errors.New("a backup file and backup.key must be configured")
+	}
+
+	entries, err := readBackup(options.File, []byte(rotatorConfig.Backup.Key))
+	if err != nil {
+		logger.Error("unable to read backup", err)
+		return RestoreStats{}, err
+	}
+	entries = backup.Latest(entries)
+
+	targets := rotatorConfig.ResolveTargets()
+	entriesByTarget := map[string][]backup.Entry{}
+	for _, entry := range entries {
+		if options.Filter.Matches(entry) {
+			entriesByTarget[entry.Target] = append(entriesByTarget[entry.Target], entry)
+		}
+	}
+	for name := range entriesByTarget {
+		if !hasTarget(targets, name) {
+			return RestoreStats{}, errors.Errorf("the backup holds rows of target '%s', which the config does not list", name)
+		}
+	}
+
+	var auditWriter *audit.Writer
+	if rotatorConfig.Audit.File != "" && !options.DryRun {
+		runID, err := audit.NewRunID()
+		if err != nil {
+			return RestoreStats{}, errors.Wrap(err, "unable to generate run id")
+		}
+		auditWriter, err = audit.Open(rotatorConfig.Audit.File, []byte(rotatorConfig.Audit.HMACKey), runID)
+		if err != nil {
+			logger.Error("unable to start audit log", err)
+			return RestoreStats{}, errors.Wrap(err, "unable to start audit log")
+		}
+		defer func() {
+			if err := auditWriter.Close(); err != nil {
+				logger.Error("unable to close audit log", err)
+			}
+		}()
+		logger.Info("audit log started", lager.Data{"run_id": runID, "file": rotatorConfig.Audit.File})
+	}
+
+	var total RestoreStats
+	var failedTargets []string
+	var targetErr error
+	for _, target := range targets {
+		targetEntries := entriesByTarget[target.Name]
+		if len(targetEntries) == 0 {
+			continue
+		}
+
+		targetLogger := logger
+		if target.Name != "" {
+			targetLogger = logger.WithData(lager.Data{"target": target.Name})
+		}
+		stats, err := restoreTarget(ctx, targetLogger, options, target, targetEntries, auditWriter)
+		total.add(stats)
+		if err != nil {
+			targetLogger.Error("unable to restore target", err)
+			failedTargets = append(failedTargets, target.Name)
+			targetErr = err
+		}
+	}
+
+	logger.Info("restore has finished", lager.Data{"stats": total, "dry_run": options.DryRun})
+	if len(failedTargets) > 0 {
+		if len(rotatorConfig.Targets) == 0 {
+			return total, targetErr
+		}
+		return total, errors.Errorf("unable to restore targets %s", strings.Join(failedTargets, ", "))
+	}
+	if unrestored := total.Conflicts + total.Failed; unrestored > 0 {
+		return total, errors.Errorf("%d rows could not be restored", unrestored)
+	}
+	return total, nil
+}
+
+func readBackup(path string, key []byte) ([]backup.Entry, error) {
+	backupFile, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open backup file")
+	}
+	defer backupFile.Close()
+
+	entries, err := backup.Read(backupFile, key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read backup file %s", path)
+	}
+	return entries, nil
+}
+
+func hasTarget(targets []config.Target, name string) bool {
+	for _, target := range targets {
+		if target.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreTarget restores the entries of one database, latest first, so that
+// a row rotated several times is walked back through each of its rotations.
+func restoreTarget(ctx context.Context, logger lager.Logger, options RestoreOptions, target config.Target, entries []backup.Entry, auditWriter *audit.Writer) (RestoreStats, error) {
+	rotatorConfig := target.Config
+	defer rotatorConfig.WipePassphrases()
+	redactor := options.Redactor
+	var stats RestoreStats
+
+	retryPolicy := db2.NewRetryPolicy(rotatorConfig.Retry)
+	onRetry := func(attempt int, err error) {
+		logger.Info("retrying after a transient database error", lager.Data{"attempt": attempt, "error": err.Error()})
+	}
+
+	dbConn, err := connect(ctx, logger, options.Queryer, options.DB, rotatorConfig, retryPolicy, onRetry)
+	if err != nil {
+		return stats, err
+	}
+	if options.Queryer == nil && options.DB == nil {
+		defer dbConn.Close()
+	}
+	db := db2.RetryingQueryer{Queryer: dbConn, Policy: retryPolicy, OnRetry: onRetry}
+
+	lock, err := db.AdvisoryLock(ctx, db2.RotatorLockName(rotatorConfig.DatabaseName), options.WaitForLock)
+	if err != nil {
+		logger.Error("unable to take the rotator lock, is a rotator running?", err)
+		return stats, errors.Wrap(err, "unable to take the rotator lock")
+	}
+	defer func() {
+		if err := lock.Release(); err != nil {
+			logger.Error("unable to release the rotator lock", err)
+		}
+	}()
+
+	fetcher := db2.GoogleMfaCredentialsDBFetcher{DB: db}
+	updater := db2.GoogleMfaCredentialsDBUpdater{DB: db}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		if ctx.Err() != nil {
+			return stats, errors.New("restore was cancelled")
+		}
+
+		entry := entries[i]
+		rotated := entry.Rotated.Credential()
+		original := entry.Original.Credential()
+		credData := redactor.MfaCredential(rotated)
+		credData["run_id"] = entry.RunID
+		credData["original_encryption_key_label"] = original.EncryptionKeyLabel
+
+		if options.DryRun {
+			stats.Previewed++
+			logger.Info("dry run: record would be restored", credData)
+			continue
+		}
+
+		err := updater.Swap(ctx, rotated, original)
+		if errors.Is(err, db2.ErrWriteConflict) {
+			stored, readErr := fetcher.Row(ctx, original.UserId)
+			if readErr == nil && stored.EncryptionKeyLabel == original.EncryptionKeyLabel && stored.SecretKey == original.SecretKey {
+				stats.Unchanged++
+				logger.Info("record already holds its original values", credData)
+				continue
+			}
+			stats.Conflicts++
+			logger.Error("RECORD HAS CHANGED SINCE IT WAS ROTATED. Not restored", err, credData)
+			continue
+		}
+
+		if auditWriter != nil {
+			if auditErr := auditWriter.RecordTarget(target.Name, db2.GoogleMfaCredentialsTable, rotated, original, err); auditErr != nil {
+				logger.Error("unable to record audit entry. Stopping", auditErr, credData)
+				return stats, errors.Wrap(auditErr, "unable to record audit entry")
+			}
+		}
+		if err != nil {
+			stats.Failed++
+			logger.Error("unable to restore record... Skipping", err, credData)
+			continue
+		}
+		stats.Restored++
+		logger.Info("restored mfa cred", credData)
+	}
+
+	return stats, nil
+}
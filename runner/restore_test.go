@@ -0,0 +1,156 @@
+package runner_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"github.com/cloudfoundry/uaa-key-rotator/backup"
+	"github.com/cloudfoundry/uaa-key-rotator/config"
+	"github.com/cloudfoundry/uaa-key-rotator/db/dbfakes"
+	"github.com/cloudfoundry/uaa-key-rotator/entity"
+	"github.com/cloudfoundry/uaa-key-rotator/runner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type fakeLock struct {
+	released int
+}
+
+func (l *fakeLock) Release() error {
+	l.released++
+	return nil
+}
+
+var _ = Describe("Restore", func() {
+	var (
+		tempDir    string
+		backupPath string
+		queryer    *dbfakes.FakeQueryer
+		lock       *fakeLock
+		options    runner.RestoreOptions
+	)
+
+	credential := func(userID string, zoneID string, label string) entity.MfaCredential {
+		return entity.MfaCredential{
+			UserId:             userID,
+			MfaProviderId:      "some-provider-id",
+			ZoneId:             entity.Char(zoneID),
+			EncryptionKeyLabel: label,
+			SecretKey:          label + "-secret-key",
+		}
+	}
+
+	writeRun := func(runID string, rows ...entity.MfaCredential) {
+		writer, err := backup.Open(backupPath, []byte("some-backup-key"), runID)
+		Expect(err).NotTo(HaveOccurred())
+		for _, row := range rows {
+			rotated := row
+			rotated.EncryptionKeyLabel = "active-key"
+			rotated.SecretKey = "active-key-secret-key"
+			Expect(writer.Record("", row, rotated)).To(Succeed())
+		}
+		Expect(writer.Close()).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "restore")
+		Expect(err).NotTo(HaveOccurred())
+		backupPath = filepath.Join(tempDir, "backup.enc")
+
+		rotatorConfig, err := config.New(strings.NewReader(`{
+			"activeKeyLabel": "active-key",
+			"encryptionKeys": [{"label": "active-key", "passphrase": "secret"}],
+			"databaseHostname": "127.0.0.1",
+			"databasePort": "1",
+			"databaseScheme": "postgres",
+			"databaseName": "uaa",
+			"databaseUsername": "uaa",
+			"backup": {"file": "` + backupPath + `", "key": "some-backup-key"}
+		}`))
+		Expect(err).NotTo(HaveOccurred())
+
+		lock = &fakeLock{}
+		queryer = &dbfakes.FakeQueryer{}
+		queryer.AdvisoryLockReturns(lock, nil)
+		queryer.ExecContextReturns(driver.RowsAffected(1), nil)
+		options = runner.RestoreOptions{Config: rotatorConfig, Queryer: queryer}
+
+		writeRun("run-1", credential("user-1", "zone-1", "old-key"), credential("user-2", "zone-2", "old-key"))
+		writeRun("run-2", credential("user-3", "zone-1", "other-key"))
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tempDir)
+	})
+
+	restoredUserIDs := func() []string {
+		var userIDs []string
+		for i := 0; i < queryer.ExecContextCallCount(); i++ {
+			_, _, args := queryer.ExecContextArgsForCall(i)
+			userIDs = append(userIDs, args[5].(string))
+		}
+		return userIDs
+	}
+
+	It("should write back the original values of every row, latest first, under the rotator lock", func() {
+		stats, err := runner.Restore(context.Background(), options)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stats).To(Equal(runner.RestoreStats{Restored: 3}))
+		Expect(restoredUserIDs()).To(Equal([]string{"user-3", "user-2", "user-1"}))
+		Expect(lock.released).To(Equal(1))
+
+		_, query, args := queryer.ExecContextArgsForCall(0)
+		Expect(query).To(ContainSubstring("where user_id = ?\nand coalesce(encryption_key_label, '') = ?\nand secret_key = ?"))
+		Expect(args[0]).To(Equal("other-key-secret-key"))
+		Expect(args[2]).To(Equal("other-key"))
+		Expect(args[5:]).To(Equal([]interface{}{"user-3", "active-key", "active-key-secret-key"}))
+	})
+
+	It("should restore a row backed up twice in one run once", func() {
+		writeRun("run-2", credential("user-3", "zone-1", "other-key"))
+
+		stats, err := runner.Restore(context.Background(), options)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stats).To(Equal(runner.RestoreStats{Restored: 3}))
+		Expect(restoredUserIDs()).To(Equal([]string{"user-3", "user-2", "user-1"}))
+	})
+
+	It("should only restore the rows selected by the filter", func() {
+		options.Filter = backup.Filter{RunIDs: []string{"run-1"}, ZoneIDs: []string{"zone-1"}}
+		stats, err := runner.Restore(context.Background(), options)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stats.Restored).To(Equal(int64(1)))
+		Expect(restoredUserIDs()).To(Equal([]string{"user-1"}))
+	})
+
+	It("should write nothing on a dry run", func() {
+		options.DryRun = true
+		stats, err := runner.Restore(context.Background(), options)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stats).To(Equal(runner.RestoreStats{Previewed: 3}))
+		Expect(queryer.ExecContextCallCount()).To(BeZero())
+	})
+
+	It("should fail without touching the database when the key is wrong", func() {
+		options.Config.Backup.Key = "some-other-key"
+		_, err := runner.Restore(context.Background(), options)
+		Expect(err).To(MatchError(ContainSubstring("the backup key does not match the key the file was written with")))
+		Expect(queryer.AdvisoryLockCallCount()).To(BeZero())
+	})
+
+	It("should refuse rows of a target the config does not list", func() {
+		writer, err := backup.Open(backupPath, []byte("some-backup-key"), "run-3")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(writer.Record("uaa-west", credential("user-4", "zone-1", "old-key"), credential("user-4", "zone-1", "active-key"))).To(Succeed())
+		Expect(writer.Close()).To(Succeed())
+
+		_, err = runner.Restore(context.Background(), options)
+		Expect(err).To(MatchError("the backup holds rows of target 'uaa-west', which the config does not list"))
+		Expect(queryer.ExecContextCallCount()).To(BeZero())
+	})
+})
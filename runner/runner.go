@@ -0,0 +1,985 @@
+// Package runner rotates the MFA credentials of one or more UAA databases to
+// the active key. It is what the uaa-key-rotator command runs, and can be
+// embedded in other tools.
+package runner
+
+import (
+	"code.cloudfoundry.org/lager"
+	"context"
+	"database/sql"
+	"github.com/cloudfoundry/uaa-key-rotator/audit"
+	"github.com/cloudfoundry/uaa-key-rotator/backup"
+	"github.com/cloudfoundry/uaa-key-rotator/config"
+	"github.com/cloudfoundry/uaa-key-rotator/crypto"
+	db2 "github.com/cloudfoundry/uaa-key-rotator/db"
+	"github.com/cloudfoundry/uaa-key-rotator/dump"
+	"github.com/cloudfoundry/uaa-key-rotator/entity"
+	"github.com/cloudfoundry/uaa-key-rotator/keyprovider"
+	"github.com/cloudfoundry/uaa-key-rotator/logging"
+	"github.com/cloudfoundry/uaa-key-rotator/quarantine"
+	"github.com/cloudfoundry/uaa-key-rotator/rotator"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Options configures a run. Config is required. The rest default to a
+// single rotation of every row not encrypted with the active key.
+type Options struct {
+	// Config is the rotator config, which must have been validated by
+	// config.New. Run wipes its passphrases once it is done.
+	Config *config.RotatorConfig
+	// Logger receives the logs of the run. It defaults to a logger without
+	// sinks, which discards them.
+	Logger lager.Logger
+	// Redactor hides user ids and secrets in the logs of the run.
+	Redactor *logging.Redactor
+
+	// Queryer, or else DB, is used instead of connecting to the database in
+	// Config. DB is queried in the dialect of Config.DatabaseScheme. Either
+	// is left open for the caller to close, and can only be used with a
+	// config that does not list targets.
+	Queryer db2.Queryer
+	DB      *sql.DB
+	// Dump is rotated instead of a database, and is written to as rows are
+	// rotated. The caller saves it once Run returns. It can only be used
+	// with a config that does not list targets, and cannot be claimed or
+	// watched.
+	Dump *dump.Table
+	// QuarantineFile, if set, is appended a line for each row that fails,
+	// which Read in package quarantine reads back to retry them.
+	QuarantineFile string
+	// KeyService is used instead of the key set in Config, for every target.
+	KeyService rotator.KeyService
+	// Progress is told about rows and passes as they are handled.
+	Progress Progress
+
+	RowFilter db2.RowFilter
+	// TargetUserIDs, if set, adds the user ids listed under the name of each
+	// target to its RowFilter, as retrying a quarantine file does. A target
+	// with none, and no user ids in RowFilter, is skipped.
+	TargetUserIDs    map[string][]string
+	VerifyAfterWrite bool
+	TrialDecrypt     bool
+	Legacy           db2.LegacySelection
+	// ConfirmMigration must be set to write a migration, which cannot be
+	// undone, unless it is a DryRun.
+	ConfirmMigration bool
+	DryRun           bool
+	// Strict stops a pass at the first row that fails, whatever the failure
+	// budget of Config allows.
+	Strict      bool
+	WaitForLock time.Duration
+	Claim       bool
+	// ClaimBatchSize defaults to DefaultClaimBatchSize.
+	ClaimBatchSize int
+	// Watch keeps rotating every WatchInterval until the context of the run
+	// is cancelled, serving its health on HealthAddress unless it is empty.
+	Watch         bool
+	WatchInterval time.Duration
+	HealthAddress string
+}
+
+// DefaultClaimBatchSize is how many rows a claiming worker locks at a time
+// unless Options say otherwise.
+const DefaultClaimBatchSize = 50
+
+// Progress is told about each row and pass of a run as it is handled, with
+// the name of its target, which is empty for a config without targets. Any
+// callback may be nil. Callbacks are called from several goroutines at once.
+type Progress struct {
+	RowWritten   func(target string, credential entity.MfaCredential)
+	RowFailed    func(target string, credential entity.MfaCredential, err error)
+	RowPreviewed func(target string, credential entity.MfaCredential)
+	PassFinished func(target string, stats PassStats, err error)
+}
+
+func (p Progress) rowWritten(target string, credential entity.MfaCredential) {
+	if p.RowWritten != nil {
+		p.RowWritten(target, credential)
+	}
+}
+
+func (p Progress) rowFailed(target string, credential entity.MfaCredential, err error) {
+	if p.RowFailed != nil {
+		p.RowFailed(target, credential, err)
+	}
+}
+
+func (p Progress) rowPreviewed(target string, credential entity.MfaCredential) {
+	if p.RowPreviewed != nil {
+		p.RowPreviewed(target, credential)
+	}
+}
+
+func (p Progress) passFinished(target string, stats PassStats, err error) {
+	if p.PassFinished != nil {
+		p.PassFinished(target, stats, err)
+	}
+}
+
+// Validate checks that the options make sense together.
+func (o Options) Validate() error {
+	if o.Config == nil {
+		return errors.New("a config is required")
+	}
+	if (o.Queryer != nil || o.DB != nil) && len(o.Config.Targets) > 0 {
+		return errors.New("a database can only be passed in with a config that does not list targets")
+	}
+	if o.Dump != nil {
+		if o.Queryer != nil || o.DB != nil || len(o.Config.Targets) > 0 {
+			return errors.New("a dump can only be rotated on its own, without a database or targets")
+		}
+		if o.Claim || o.Watch {
+			return errors.New("a dump cannot be claimed or watched")
+		}
+	}
+	if o.TargetUserIDs != nil {
+		names := map[string]bool{}
+		for _, target := range o.Config.Targets {
+			names[target.Name] = true
+		}
+		if len(o.Config.Targets) == 0 {
+			names[""] = true
+		}
+		for name := range o.TargetUserIDs {
+			if !names[name] {
+				return errors.Errorf("user ids are listed for target '%s', which the config does not list", name)
+			}
+		}
+	}
+	if o.RowFilter.Limit < 0 {
+		return errors.New("limit must not be negative")
+	}
+	if o.ClaimBatchSize < 0 {
+		return errors.New("claim batch size must be positive")
+	}
+	if o.Watch {
+		if o.WatchInterval <= 0 {
+			return errors.New("interval must be positive")
+		}
+		if o.Legacy.Enabled() {
+			return errors.New("watch cannot run a migration")
+		}
+	}
+	if o.Legacy.Enabled() {
+		if o.TrialDecrypt {
+			return errors.New("trial decryption cannot be combined with a migration")
+		}
+		if !o.DryRun && !o.ConfirmMigration {
+			return errors.New("a migration cannot be undone; preview it with a dry run, then run it again confirmed")
+		}
+	}
+	return nil
+}
+
+// Run rotates every target of the config, at most Config.Parallelism at a
+// time, and reports on them all once they are done. A target that fails
+// does not stop the others, but makes Run return an error along with the
+// report. In watch mode Run returns once ctx is cancelled.
+func Run(ctx context.Context, options Options) (report Report, err error) {
+	if err := options.Validate(); err != nil {
+		return Report{}, errors.Wrap(err, "invalid options")
+	}
+	if options.Logger == nil {
+		options.Logger = lager.NewLogger("uaa-key-rotator")
+	}
+	if options.Redactor == nil {
+		options.Redactor = &logging.Redactor{}
+	}
+	if options.ClaimBatchSize == 0 {
+		options.ClaimBatchSize = DefaultClaimBatchSize
+	}
+	logger := options.Logger
+	rotatorConfig := options.Config
+	defer rotatorConfig.WipePassphrases()
+
+	var runID string
+	if ((rotatorConfig.Audit.File != "" || rotatorConfig.Backup.File != "") && !options.DryRun) || options.QuarantineFile != "" {
+		runID, err = audit.NewRunID()
+		if err != nil {
+			logger.Error("unable to start run", err)
+			return Report{}, errors.Wrap(err, "unable to generate run id")
+		}
+	}
+
+	var auditWriter *audit.Writer
+	if rotatorConfig.Audit.File != "" && !options.DryRun {
+		auditWriter, err = audit.Open(rotatorConfig.Audit.File, []byte(rotatorConfig.Audit.HMACKey), runID)
+		if err != nil {
+			logger.Error("unable to start audit log", err)
+			return Report{}, errors.Wrap(err, "unable to start audit log")
+		}
+		defer func() {
+			if closeErr := auditWriter.Close(); closeErr != nil {
+				logger.Error("unable to close audit log", closeErr)
+				return
+			}
+			report.AuditHeadMAC = auditWriter.HeadMAC()
+			logger.Info("audit log closed", lager.Data{"run_id": runID, "head_mac": report.AuditHeadMAC})
+		}()
+		logger.Info("audit log started", lager.Data{"run_id": runID, "file": rotatorConfig.Audit.File})
+	}
+
+	var backupWriter *backup.Writer
+	if rotatorConfig.Backup.File != "" && !options.DryRun {
+		backupWriter, err = backup.Open(rotatorConfig.Backup.File, []byte(rotatorConfig.Backup.Key), runID)
+		if err != nil {
+			logger.Error("unable to start backup", err)
+			return Report{}, errors.Wrap(err, "unable to start backup")
+		}
+		defer func() {
+			if err := backupWriter.Close(); err != nil {
+				logger.Error("unable to close backup file", err)
+			}
+		}()
+		logger.Info("backing up rows before rotating them", lager.Data{"run_id": runID, "file": rotatorConfig.Backup.File})
+	}
+
+	var quarantineWriter *quarantine.Writer
+	if options.QuarantineFile != "" {
+		quarantineWriter, err = quarantine.Open(options.QuarantineFile, runID)
+		if err != nil {
+			logger.Error("unable to open quarantine file", err)
+			return Report{}, errors.Wrap(err, "unable to open quarantine file")
+		}
+		defer func() {
+			if err := quarantineWriter.Close(); err != nil {
+				logger.Error("unable to close quarantine file", err)
+			}
+		}()
+		logger.Info("quarantining rows that fail", lager.Data{"run_id": runID, "file": options.QuarantineFile})
+	}
+
+	targets := rotatorConfig.ResolveTargets()
+	health := newTargetsHealth(targets, options.WatchInterval)
+	if options.Watch && options.HealthAddress != "" {
+		listener, err := net.Listen("tcp", options.HealthAddress)
+		if err != nil {
+			logger.Error("unable to serve health endpoint", err)
+			return Report{}, errors.Wrap(err, "unable to serve health endpoint")
+		}
+		server := &http.Server{Handler: health}
+		go server.Serve(listener)
+		defer server.Close()
+		logger.Info("serving health endpoint", lager.Data{"address": listener.Addr().String()})
+	}
+
+	// A watch never finishes, so every target is watched at once.
+	parallelism := rotatorConfig.Parallelism
+	if parallelism <= 0 {
+		parallelism = 1
+	}
+	if options.Watch || parallelism > len(targets) {
+		parallelism = len(targets)
+	}
+
+	indexes := make(chan int, len(targets))
+	for i := range targets {
+		indexes <- i
+	}
+	close(indexes)
+
+	report = Report{Targets: make([]TargetReport, len(targets))}
+	wg := sync.WaitGroup{}
+	wg.Add(parallelism)
+	for i := 0; i < parallelism; i++ {
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				target := targets[i]
+				targetLogger := logger
+				if target.Name != "" {
+					targetLogger = logger.WithData(lager.Data{"target": target.Name})
+				}
+
+				var stats PassStats
+				err := errors.New("rotator was cancelled before the target was started")
+				if ctx.Err() == nil {
+					stats, err = rotateTarget(ctx, targetLogger, options, target, auditWriter, backupWriter, quarantineWriter, health.targets[i])
+				}
+				if err != nil {
+					health.targets[i].passFinished(time.Now(), stats, err)
+				}
+				report.Targets[i] = newTargetReport(target.Name, stats, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(targets) == 1 && targets[0].Name == "" {
+		return report, report.Targets[0].err
+	}
+
+	failed := report.Failed()
+	logger.Info("rotation report", lager.Data{"targets": report.Targets, "failed": failed})
+	if failed > 0 {
+		return report, errors.Errorf("%d of %d targets failed", failed, len(report.Targets))
+	}
+	return report, nil
+}
+
+// connect returns the Queryer for a target: the queryer or DB passed in by
+// the caller, or a new connection to the database in its config, once it is
+// reachable.
+func connect(ctx context.Context, logger lager.Logger, queryer db2.Queryer, nativeDB *sql.DB, rotatorConfig *config.RotatorConfig, retryPolicy db2.RetryPolicy, onRetry func(attempt int, err error)) (db2.Queryer, error) {
+	if queryer != nil {
+		return queryer, nil
+	}
+
+	nativeDBConn := nativeDB
+	if nativeDBConn == nil {
+		dbURI, err := db2.ConnectionURI(rotatorConfig)
+		if err != nil {
+			logger.Error("unable to get a DBconnection URI", err)
+			return nil, errors.Wrap(err, "unable to get a DBconnection URI")
+		}
+
+		nativeDBConn, err = sql.Open(rotatorConfig.DatabaseScheme, dbURI)
+		if err != nil {
+			logger.Error("unable to get a DB Connection", err)
+			return nil, errors.Wrap(err, "unable to get a DB Connection: unable to open database connection")
+		}
+	}
+
+	dbConn := sqlx.NewDb(nativeDBConn, rotatorConfig.DatabaseScheme)
+	if nativeDB == nil {
+		db2.ConfigurePool(dbConn, rotatorConfig)
+	}
+	if err := retryPolicy.WaitForStartup(ctx, dbConn.Ping, onRetry); err != nil {
+		if nativeDB == nil {
+			dbConn.Close()
+		}
+		logger.Error("unable to get a DB Connection", err)
+		return nil, errors.Wrap(err, "unable to get a DB Connection: unable to ping")
+	}
+
+	return db2.DbAwareQuerier{DB: dbConn, DBScheme: rotatorConfig.DatabaseScheme, StatementTimeout: db2.StatementTimeout(rotatorConfig)}, nil
+}
+
+// openDatabase connects to the database of a target and takes the rotator
+// lock: shared when rows are claimed, as claiming rotators cooperate through
+// row locks, and exclusive otherwise. The returned func releases both.
+func openDatabase(ctx context.Context, logger lager.Logger, options Options, rotatorConfig *config.RotatorConfig) (db2.Queryer, func(), error) {
+	retryPolicy := db2.NewRetryPolicy(rotatorConfig.Retry)
+	onRetry := func(attempt int, err error) {
+		logger.Info("retrying after a transient database error", lager.Data{"attempt": attempt, "error": err.Error()})
+	}
+
+	dbConn, err := connect(ctx, logger, options.Queryer, options.DB, rotatorConfig, retryPolicy, onRetry)
+	if err != nil {
+		return nil, nil, err
+	}
+	closeConn := func() {
+		if options.Queryer == nil && options.DB == nil {
+			dbConn.Close()
+		}
+	}
+	db := db2.RetryingQueryer{Queryer: dbConn, Policy: retryPolicy, OnRetry: onRetry}
+
+	takeLock := db.AdvisoryLock
+	if options.Claim {
+		takeLock = db.SharedAdvisoryLock
+	}
+	lockName := db2.RotatorLockName(rotatorConfig.DatabaseName)
+	lock, err := takeLock(ctx, lockName, options.WaitForLock)
+	if err != nil {
+		closeConn()
+		logger.Error("unable to take the rotator lock, is another rotator running?", err, lager.Data{"shared": options.Claim})
+		return nil, nil, errors.Wrap(err, "unable to take the rotator lock")
+	}
+	logger.Info("rotator lock taken", lager.Data{"lock": lockName, "shared": options.Claim})
+
+	return db, func() {
+		if err := lock.Release(); err != nil {
+			logger.Error("unable to release the rotator lock", err)
+		}
+		closeConn()
+	}, nil
+}
+
+// rotateTarget rotates the rows of one database, or of a dump. In watch mode it keeps
+// rotating until parentCtx is cancelled. It returns the stats of the last
+// pass.
+func rotateTarget(parentCtx context.Context, logger lager.Logger, options Options, target config.Target, auditWriter *audit.Writer, backupWriter *backup.Writer, quarantineWriter *quarantine.Writer, health *watchHealth) (PassStats, error) {
+	rotatorConfig := target.Config
+	defer rotatorConfig.WipePassphrases()
+	redactor := options.Redactor
+
+	rowFilter := options.RowFilter
+	if options.TargetUserIDs != nil {
+		userIDs := options.TargetUserIDs[target.Name]
+		if len(userIDs) == 0 && len(rowFilter.UserIDs) == 0 {
+			logger.Info("no user ids listed for target, skipping")
+			return PassStats{}, nil
+		}
+		rowFilter.UserIDs = append(append([]string(nil), rowFilter.UserIDs...), userIDs...)
+	}
+
+	var db db2.Queryer
+	var credentialsFetcher db2.Fetcher
+	var credentialsUpdater db2.Updater
+	if options.Dump != nil {
+		credentialsFetcher = dump.Fetcher{
+			Table:            options.Dump,
+			ActiveKeyLabel:   rotatorConfig.ActiveKeyLabel,
+			IncludeActiveKey: options.TrialDecrypt,
+			Filter:           rowFilter,
+			Legacy:           options.Legacy,
+		}
+		credentialsUpdater = options.Dump
+	} else {
+		queryer, closeDatabase, err := openDatabase(parentCtx, logger, options, rotatorConfig)
+		if err != nil {
+			return PassStats{}, err
+		}
+		defer closeDatabase()
+
+		db = queryer
+		credentialsFetcher = db2.GoogleMfaCredentialsDBFetcher{
+			DB:               db,
+			ActiveKeyLabel:   rotatorConfig.ActiveKeyLabel,
+			IncludeActiveKey: options.TrialDecrypt,
+			Filter:           rowFilter,
+			Legacy:           options.Legacy,
+		}
+		credentialsUpdater = db2.GoogleMfaCredentialsDBUpdater{
+			DB: db,
+		}
+	}
+
+	keyService := options.KeyService
+	if keyService == nil {
+		keyProvider, err := keyprovider.FromConfig(rotatorConfig)
+		if err != nil {
+			logger.Error("unable to configure key provider", err)
+			return PassStats{}, errors.Wrap(err, "unable to configure key provider")
+		}
+		if wiper, ok := keyProvider.(crypto.Wiper); ok {
+			defer wiper.Wipe()
+		}
+
+		keyService = rotator.UaaKeyService{
+			ActiveKeyLabel: rotatorConfig.ActiveKeyLabel,
+			KeyProvider:    keyProvider,
+		}
+	}
+
+	r := rotator.UAARotator{
+		KeyService: keyService,
+		Codec:      crypto.UAAEnvelopeCodec{},
+	}
+
+	ctx, cancel := context.WithCancel(parentCtx)
+
+	// stats counts the rows of the pass in progress, and budget checks them
+	// against the failure budget of the run.
+	var stats *PassStats
+	var budget *failureBudget
+
+	var credentialsChan <-chan entity.MfaCredential
+	var fetcherErrChan <-chan error
+	var passCtx context.Context
+	var passCancel context.CancelFunc
+
+	// stopPass cancels the pass in progress because of err, the first of
+	// which becomes the cause of the error the pass fails with.
+	var passErr error
+	var passErrMutex sync.Mutex
+	stopPass := func(err error) {
+		passErrMutex.Lock()
+		if passErr == nil {
+			passErr = err
+		}
+		passErrMutex.Unlock()
+		passCancel()
+	}
+
+	// failRow counts a row that failed by the category of its error, which
+	// it adds to the log data of the row, and quarantines it.
+	failRow := func(cred entity.MfaCredential, err error, credData lager.Data) {
+		category := Categorize(err)
+		credData["error_category"] = category
+		atomic.AddInt64(&stats.Failed, 1)
+		stats.Failures.add(category)
+		options.Progress.rowFailed(target.Name, cred, err)
+
+		if budgetErr := budget.failedWith(category); budgetErr != nil {
+			logger.Error("FAILURE BUDGET EXCEEDED. Stopping", budgetErr, lager.Data{"error_category": Categorize(budgetErr)})
+			stopPass(budgetErr)
+		}
+
+		if quarantineWriter != nil {
+			if quarantineErr := quarantineWriter.Record(target.Name, cred, string(category), redactor.Scrub(err.Error())); quarantineErr != nil {
+				logger.Error("unable to quarantine record", quarantineErr, credData)
+			}
+		}
+	}
+
+	// readBack re-reads a written row and checks it decrypts to the original
+	// plaintext. A row that does not is restored to its original values,
+	// unless it has been written again since, which is reported as a
+	// conflict instead.
+	readBack := func(fetcher db2.Fetcher, updater db2.Updater, cred entity.MfaCredential, rotatedCred entity.MfaCredential, verify func(stored entity.MfaCredential) error, credData lager.Data) error {
+		stored, err := fetcher.Row(ctx, cred.UserId)
+		if err != nil {
+			return errors.Wrap(err, "unable to read back record")
+		}
+
+		err = verify(stored)
+		if errors.Is(err, rotator.ErrVerificationFailed) {
+			logger.Error("READ-BACK VERIFICATION FAILED. Restoring original record", err, credData)
+			restoreErr := updater.Swap(ctx, rotatedCred, cred)
+			if errors.Is(restoreErr, db2.ErrWriteConflict) {
+				logger.Error("RECORD CHANGED SINCE IT WAS WRITTEN. Original record not restored", restoreErr, credData)
+				return errors.Wrap(restoreErr, "unable to restore record that failed read-back verification")
+			}
+			if restoreErr != nil {
+				logger.Error("UNABLE TO RESTORE ORIGINAL RECORD", restoreErr, credData)
+			}
+		}
+		return err
+	}
+
+	trialKeyLabels := keyprovider.Labels(rotatorConfig)
+
+	// recoverCredential rotates using whichever configured key decrypts each
+	// column, and reports which key that was.
+	recoverCredential := func(cred entity.MfaCredential, credData lager.Data) (entity.MfaCredential, rotator.RecoveryReport, error) {
+		rotatedCred, report, err := r.Recover(ctx, cred, trialKeyLabels)
+
+		recoveryData := lager.Data{"decrypted_with": report.DecryptedWith(), "columns": report.Columns}
+		for key, value := range credData {
+			recoveryData[key] = value
+		}
+
+		switch {
+		case report.Mislabelled(cred.EncryptionKeyLabel):
+			logger.Info("COLUMNS DECRYPTED WITH A KEY OTHER THAN THE ROW LABEL", recoveryData)
+		case report.AlreadyActive:
+			logger.Info("trial decryption: row is already under the active key, leaving it alone", recoveryData)
+		default:
+			logger.Info("trial decryption", recoveryData)
+		}
+		return rotatedCred, report, err
+	}
+
+	// migrateCredential encrypts the plaintext left in a legacy credential.
+	migrateCredential := func(cred entity.MfaCredential, credData lager.Data) (entity.MfaCredential, error) {
+		migratedCred, migration, err := r.Migrate(ctx, cred, options.Legacy.ValidationCodes)
+		if err == nil {
+			migrationData := lager.Data{"migration": migration}
+			for key, value := range credData {
+				migrationData[key] = value
+			}
+			logger.Info("migrating legacy mfa cred", migrationData)
+		}
+		return migratedCred, err
+	}
+
+	// rotateCredential rotates, migrates or recovers a credential as the
+	// options ask, and logs why when it cannot.
+	rotateCredential := func(cred entity.MfaCredential, credData lager.Data) (entity.MfaCredential, rotator.RecoveryReport, error) {
+		logger.Info("rotating mfa cred", credData)
+		var rotatedCred entity.MfaCredential
+		var report rotator.RecoveryReport
+		var err error
+		switch {
+		case options.Legacy.Enabled():
+			rotatedCred, err = migrateCredential(cred, credData)
+		case options.TrialDecrypt:
+			rotatedCred, report, err = recoverCredential(cred, credData)
+		default:
+			rotatedCred, err = r.Rotate(ctx, cred)
+		}
+		if err != nil {
+			failRow(cred, err, credData)
+			if errors.Is(err, rotator.ErrVerificationFailed) {
+				logger.Error("ROUND TRIP VERIFICATION FAILED. Record not written... Skipping", err, credData)
+			} else {
+				logger.Error("unable to rotate record... Skipping", err, credData)
+			}
+		}
+		return rotatedCred, report, err
+	}
+
+	// writeCredential writes a rotated credential over the row it was read
+	// from, unless the row has been written since, and with
+	// -verify-after-write reads it back.
+	writeCredential := func(fetcher db2.Fetcher, updater db2.Updater, cred entity.MfaCredential, rotatedCred entity.MfaCredential, report rotator.RecoveryReport, credData lager.Data) error {
+		err := updater.Swap(ctx, cred, rotatedCred)
+		if err == nil && options.VerifyAfterWrite {
+			verify := func(stored entity.MfaCredential) error {
+				return r.VerifyStoredWith(ctx, cred, stored, report.DecryptedWith())
+			}
+			if options.Legacy.Enabled() {
+				verify = func(stored entity.MfaCredential) error {
+					return r.VerifyMigrated(ctx, cred, stored, options.Legacy.ValidationCodes)
+				}
+			}
+			err = readBack(fetcher, updater, cred, rotatedCred, verify, credData)
+		}
+		return err
+	}
+
+	// recordWrite adds a write to the audit log, stopping the run if it
+	// cannot, and logs a write that failed.
+	recordWrite := func(cred entity.MfaCredential, rotatedCred entity.MfaCredential, err error, credData lager.Data) {
+		if auditWriter != nil {
+			if auditErr := auditWriter.RecordTarget(target.Name, db2.GoogleMfaCredentialsTable, cred, rotatedCred, err); auditErr != nil {
+				logger.Error("unable to record audit entry. Stopping", auditErr, credData)
+				cancel()
+				return
+			}
+		}
+		if err != nil {
+			failRow(cred, err, credData)
+			logger.Error("unable to update record... Skipping", err, credData)
+			return
+		}
+		atomic.AddInt64(&stats.Written, 1)
+		options.Progress.rowWritten(target.Name, cred)
+		budget.succeeded()
+	}
+
+	// backUp records the original values of a credential before it is
+	// written, stopping the run if it cannot, as a row that is not backed up
+	// must not be written.
+	backUp := func(cred entity.MfaCredential, rotatedCred entity.MfaCredential, credData lager.Data) bool {
+		if backupWriter == nil {
+			return true
+		}
+		if err := backupWriter.Record(target.Name, cred, rotatedCred); err != nil {
+			failRow(cred, err, credData)
+			logger.Error("unable to back up record. Stopping", err, credData)
+			cancel()
+			return false
+		}
+		return true
+	}
+
+	previewCredential := func(cred entity.MfaCredential, credData lager.Data) {
+		atomic.AddInt64(&stats.Previewed, 1)
+		options.Progress.rowPreviewed(target.Name, cred)
+		budget.succeeded()
+		logger.Info("dry run: record would be written", credData)
+	}
+
+	worker := func(wg *sync.WaitGroup) {
+		defer wg.Done()
+
+		for {
+			select {
+			case cred, ok := <-credentialsChan:
+				if !ok {
+					logger.Debug("No more mfa credentials. Worker signing off...")
+					return
+				}
+
+				credData := redactor.MfaCredential(cred)
+				rotatedCred, report, err := rotateCredential(cred, credData)
+				if err != nil {
+					continue
+				}
+				if report.AlreadyActive {
+					budget.succeeded()
+					continue
+				}
+
+				if options.DryRun {
+					previewCredential(cred, credData)
+					continue
+				}
+
+				if !backUp(cred, rotatedCred, credData) {
+					continue
+				}
+
+				err = writeCredential(credentialsFetcher, credentialsUpdater, cred, rotatedCred, report, credData)
+				recordWrite(cred, rotatedCred, err, credData)
+
+			case err := <-fetcherErrChan:
+				logger.Error("error during fetching a record...", err, lager.Data{"error_category": Categorize(err)})
+				stopPass(err)
+			case <-passCtx.Done():
+				logger.Info("rotator worker has been cancelled")
+				return
+			}
+		}
+	}
+
+	claimer := db2.GoogleMfaCredentialsDBClaimer{
+		DB:               db,
+		ActiveKeyLabel:   rotatorConfig.ActiveKeyLabel,
+		IncludeActiveKey: options.TrialDecrypt,
+		Filter:           rowFilter,
+		Legacy:           options.Legacy,
+	}
+	var claims *claimState
+
+	// rotateClaim rotates and writes a claimed batch in the transaction that
+	// claimed it, then commits. If a row cannot be written the whole batch is
+	// rolled back, and the other rows are claimed again. If the pass is
+	// stopped, by the failure budget or otherwise, the batch is rolled back
+	// and nothing more of it is written.
+	rotateClaim := func(claim db2.Claim) {
+		fetcher := db2.GoogleMfaCredentialsDBFetcher{DB: claim.Tx}
+		updater := db2.GoogleMfaCredentialsDBUpdater{DB: claim.Tx}
+
+		rollBack := func() {
+			if err := claim.Tx.Rollback(); err != nil {
+				logger.Error("unable to roll back claimed rows", err)
+			}
+		}
+		stopped := func() bool {
+			if passCtx.Err() == nil {
+				return false
+			}
+			rollBack()
+			logger.Info("pass stopped, claimed rows rolled back", lager.Data{"rows": len(claim.Rows)})
+			return true
+		}
+
+		type claimedWrite struct {
+			cred        entity.MfaCredential
+			rotatedCred entity.MfaCredential
+			credData    lager.Data
+		}
+		var writes []claimedWrite
+
+		for i, cred := range claim.Rows {
+			credData := redactor.MfaCredential(cred)
+			rotatedCred, report, err := rotateCredential(cred, credData)
+			if err != nil {
+				continue
+			}
+			if report.AlreadyActive {
+				budget.succeeded()
+				continue
+			}
+
+			if options.DryRun {
+				previewCredential(cred, credData)
+				continue
+			}
+
+			if stopped() {
+				return
+			}
+
+			if !backUp(cred, rotatedCred, credData) {
+				rollBack()
+				return
+			}
+
+			err = writeCredential(fetcher, updater, cred, rotatedCred, report, credData)
+			if err != nil {
+				recordWrite(cred, rotatedCred, err, credData)
+				rollBack()
+				logger.Info("claimed rows rolled back", lager.Data{"rows": len(claim.Rows)})
+
+				var retryIDs []string
+				for _, write := range writes {
+					retryIDs = append(retryIDs, write.cred.UserId)
+				}
+				for _, unhandled := range claim.Rows[i+1:] {
+					retryIDs = append(retryIDs, unhandled.UserId)
+				}
+				claims.retry(retryIDs)
+				return
+			}
+			writes = append(writes, claimedWrite{cred: cred, rotatedCred: rotatedCred, credData: credData})
+		}
+
+		if len(writes) == 0 {
+			rollBack()
+			return
+		}
+		if stopped() {
+			return
+		}
+
+		err := claim.Tx.Commit()
+		if err != nil {
+			err = errors.Wrap(err, "unable to commit claimed rows")
+		}
+		for _, write := range writes {
+			recordWrite(write.cred, write.rotatedCred, err, write.credData)
+		}
+	}
+
+	claimWorker := func(wg *sync.WaitGroup) {
+		defer wg.Done()
+
+		for {
+			if passCtx.Err() != nil {
+				logger.Info("rotator worker has been cancelled")
+				return
+			}
+
+			claim, err := claims.claim(passCtx, claimer, options.ClaimBatchSize)
+			if err != nil {
+				logger.Error("error during claiming records...", err, lager.Data{"error_category": Categorize(err)})
+				stopPass(err)
+				continue
+			}
+			if len(claim.Rows) == 0 {
+				logger.Debug("No more mfa credentials. Worker signing off...")
+				return
+			}
+
+			rotateClaim(claim)
+		}
+	}
+
+	// rotatePass rotates the rows selected now, once. It fails if it had to
+	// stop early.
+	rotatePass := func() (PassStats, error) {
+		stats = &PassStats{}
+		budget = newFailureBudget(rotatorConfig.FailureBudget, options.Strict)
+		passErr = nil
+		passCtx, passCancel = context.WithCancel(ctx)
+		defer passCancel()
+
+		if options.Claim {
+			claims = &claimState{limited: rowFilter.Limit > 0, remaining: rowFilter.Limit}
+		} else {
+			credentialsChan, fetcherErrChan = credentialsFetcher.RowsToRotate(passCtx)
+		}
+
+		wg := sync.WaitGroup{}
+
+		numWorkers := 4
+		wg.Add(numWorkers)
+		for i := 0; i < numWorkers; i++ {
+			if options.Claim {
+				go claimWorker(&wg)
+			} else {
+				go worker(&wg)
+			}
+		}
+
+		logger.Info("workers are unleahsed")
+		wg.Wait()
+
+		if passCtx.Err() != nil {
+			if errors.Is(passErr, ErrFailureBudgetExceeded) {
+				return *stats, passErr
+			}
+			if passErr != nil {
+				return *stats, errors.Wrap(passErr, "rotator worker has been cancelled")
+			}
+			return *stats, errors.New("rotator worker has been cancelled")
+		}
+		return *stats, nil
+	}
+
+	if !options.Watch {
+		stats, err := rotatePass()
+		options.Progress.passFinished(target.Name, stats, err)
+		if err != nil {
+			return stats, err
+		}
+		if options.DryRun {
+			logger.Info("dry run has finished, nothing was written", lager.Data{"rows": stats.Previewed})
+		}
+		logger.Info("rotator has finished", lager.Data{"rows_written": stats.Written, "rows_failed": stats.Failed, "rows_failed_by_category": stats.Failures.ByCategory()})
+		return stats, nil
+	}
+
+	logger.Info("watching for rows to rotate", lager.Data{"interval": options.WatchInterval.String()})
+	for {
+		health.passStarted(time.Now())
+		stats, err := rotatePass()
+		health.passFinished(time.Now(), stats, err)
+		options.Progress.passFinished(target.Name, stats, err)
+
+		if parentCtx.Err() != nil {
+			logger.Info("rotator has stopped watching")
+			return stats, nil
+		}
+		if ctx.Err() != nil {
+			return stats, err
+		}
+
+		passData := lager.Data{"rows_written": stats.Written, "rows_failed": stats.Failed, "rows_failed_by_category": stats.Failures.ByCategory()}
+		if err != nil {
+			logger.Error("rotation pass failed, retrying at the next interval", err, passData)
+		} else {
+			logger.Info("rotation pass finished", passData)
+		}
+
+		select {
+		case <-time.After(options.WatchInterval):
+		case <-ctx.Done():
+		}
+	}
+}
+
+// claimState is shared by the claim workers of a run. It tracks how many
+// rows RowFilter.Limit still allows, the cursor past the rows already
+// claimed, and the rows of rolled back batches, which are claimed again and
+// so are given back to the limit until they are. Rows that fail are left
+// behind the cursor, so they are not claimed again.
+type claimState struct {
+	mutex     sync.Mutex
+	limited   bool
+	remaining int
+	cursor    db2.ClaimCursor
+	retryIDs  []string
+}
+
+// claim claims the next batch, retrying the rows of rolled back batches
+// first. Batches are claimed one at a time, so that each starts where the
+// last left off.
+func (c *claimState) claim(ctx context.Context, claimer db2.GoogleMfaCredentialsDBClaimer, batchSize int) (db2.Claim, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	limit := batchSize
+	if c.limited && limit > c.remaining {
+		limit = c.remaining
+	}
+	if limit == 0 {
+		return db2.Claim{}, nil
+	}
+
+	var claim db2.Claim
+	var err error
+	if len(c.retryIDs) > 0 {
+		retryIDs := c.retryIDs
+		if len(retryIDs) > limit {
+			retryIDs = retryIDs[:limit]
+		}
+		c.retryIDs = c.retryIDs[len(retryIDs):]
+
+		retryClaimer := claimer
+		retryClaimer.Filter.UserIDs = retryIDs
+		claim, err = retryClaimer.Claim(ctx, limit, db2.ClaimCursor{})
+	}
+	if err == nil && len(claim.Rows) == 0 {
+		claim, err = claimer.Claim(ctx, limit, c.cursor)
+		if len(claim.Rows) > 0 {
+			c.cursor = claim.Next
+		}
+	}
+
+	if c.limited {
+		c.remaining -= len(claim.Rows)
+	}
+	return claim, err
+}
+
+// retry has the rows of a rolled back batch claimed again.
+func (c *claimState) retry(userIDs []string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.retryIDs = append(c.retryIDs, userIDs...)
+	if c.limited {
+		c.remaining += len(userIDs)
+	}
+}
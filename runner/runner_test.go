@@ -0,0 +1,168 @@
+package runner_test
+
+import (
+	"context"
+	"github.com/cloudfoundry/uaa-key-rotator/config"
+	"github.com/cloudfoundry/uaa-key-rotator/db"
+	"github.com/cloudfoundry/uaa-key-rotator/db/dbfakes"
+	"github.com/cloudfoundry/uaa-key-rotator/rotator/rotatorfakes"
+	"github.com/cloudfoundry/uaa-key-rotator/runner"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+var _ = Describe("Run", func() {
+	var (
+		rotatorConfig *config.RotatorConfig
+		options       runner.Options
+	)
+
+	newConfig := func(content string) *config.RotatorConfig {
+		rotatorConfig, err := config.New(strings.NewReader(content))
+		Expect(err).NotTo(HaveOccurred())
+		return rotatorConfig
+	}
+
+	BeforeEach(func() {
+		rotatorConfig = newConfig(`{
+			"activeKeyLabel": "active-key",
+			"encryptionKeys": [{"label": "active-key", "passphrase": "secret"}],
+			"databaseHostname": "127.0.0.1",
+			"databasePort": "1",
+			"databaseScheme": "postgres",
+			"databaseName": "uaa",
+			"databaseUsername": "uaa",
+			"retry": {"startupTimeout": "1ms"}
+		}`)
+		options = runner.Options{Config: rotatorConfig}
+	})
+
+	table.DescribeTable("invalid options", func(mutate func(options *runner.Options), errorDescription string) {
+		mutate(&options)
+		Expect(options.Validate()).To(MatchError(errorDescription))
+
+		_, err := runner.Run(context.Background(), options)
+		Expect(err).To(MatchError("invalid options: " + errorDescription))
+	},
+		table.Entry("no config", func(options *runner.Options) {
+			options.Config = nil
+		}, "a config is required"),
+		table.Entry("negative limit", func(options *runner.Options) {
+			options.RowFilter.Limit = -1
+		}, "limit must not be negative"),
+		table.Entry("watch without interval", func(options *runner.Options) {
+			options.Watch = true
+		}, "interval must be positive"),
+		table.Entry("watch migrating", func(options *runner.Options) {
+			options.Watch = true
+			options.WatchInterval = time.Minute
+			options.Legacy.PlaintextRows = true
+			options.ConfirmMigration = true
+		}, "watch cannot run a migration"),
+		table.Entry("unconfirmed migration", func(options *runner.Options) {
+			options.Legacy.ValidationCodes = true
+		}, "a migration cannot be undone; preview it with a dry run, then run it again confirmed"),
+		table.Entry("trial decrypting a migration", func(options *runner.Options) {
+			options.Legacy.PlaintextRows = true
+			options.DryRun = true
+			options.TrialDecrypt = true
+		}, "trial decryption cannot be combined with a migration"),
+		table.Entry("a database with targets", func(options *runner.Options) {
+			options.Config.Targets = []config.TargetConfig{{Name: "uaa-east"}}
+			options.Queryer = &dbfakes.FakeQueryer{}
+		}, "a database can only be passed in with a config that does not list targets"),
+	)
+
+	Context("with an injected database and key service", func() {
+		var queryer *dbfakes.FakeQueryer
+
+		BeforeEach(func() {
+			queryer = &dbfakes.FakeQueryer{}
+			queryer.AdvisoryLockReturns(nil, errors.Wrap(db.ErrLockHeld, "advisory lock uaa-key-rotator is held by connection 7"))
+			options.Queryer = queryer
+			options.KeyService = &rotatorfakes.FakeKeyService{}
+		})
+
+		It("should use them, and leave the database open", func() {
+			report, err := runner.Run(context.Background(), options)
+			Expect(err).To(MatchError("unable to take the rotator lock: advisory lock uaa-key-rotator is held by connection 7: lock is held by another session"))
+			Expect(errors.Cause(err)).To(Equal(db.ErrLockHeld))
+			Expect(report.Targets).To(HaveLen(1))
+			Expect(report.Failed()).To(Equal(1))
+
+			Expect(queryer.AdvisoryLockCallCount()).To(Equal(1))
+			_, name, _ := queryer.AdvisoryLockArgsForCall(0)
+			Expect(name).To(Equal(db.RotatorLockName("uaa")))
+			Expect(queryer.CloseCallCount()).To(BeZero())
+		})
+
+		It("should take the lock shared when claiming rows, and be refused while a run holds it", func() {
+			queryer.SharedAdvisoryLockReturns(nil, errors.Wrap(db.ErrLockHeld, "advisory lock uaa-key-rotator is held by connection 7"))
+			options.Claim = true
+
+			_, err := runner.Run(context.Background(), options)
+			Expect(err).To(MatchError("unable to take the rotator lock: advisory lock uaa-key-rotator is held by connection 7: lock is held by another session"))
+
+			Expect(queryer.AdvisoryLockCallCount()).To(BeZero())
+			Expect(queryer.SharedAdvisoryLockCallCount()).To(Equal(1))
+			_, name, _ := queryer.SharedAdvisoryLockArgsForCall(0)
+			Expect(name).To(Equal(db.RotatorLockName("uaa")))
+		})
+	})
+
+	Context("when several targets are unreachable", func() {
+		BeforeEach(func() {
+			rotatorConfig = newConfig(`{
+				"activeKeyLabel": "active-key",
+				"encryptionKeys": [{"label": "active-key", "passphrase": "secret"}],
+				"databaseHostname": "127.0.0.1",
+				"databasePort": "1",
+				"databaseScheme": "postgres",
+				"databaseUsername": "uaa",
+				"retry": {"startupTimeout": "1ms"},
+				"targets": [{"name": "uaa-east", "databaseName": "uaa"}, {"name": "uaa-west", "databaseName": "uaa"}]
+			}`)
+			options = runner.Options{Config: rotatorConfig}
+		})
+
+		It("should try every target and report on each", func() {
+			var finished []string
+			options.Progress.PassFinished = func(target string, stats runner.PassStats, err error) {
+				finished = append(finished, target)
+			}
+
+			report, err := runner.Run(context.Background(), options)
+			Expect(err).To(MatchError("2 of 2 targets failed"))
+			Expect(report.Targets).To(HaveLen(2))
+			Expect(report.Targets[0].Target).To(Equal("uaa-east"))
+			Expect(report.Targets[0].Succeeded).To(BeFalse())
+			Expect(report.Targets[0].Error).To(ContainSubstring("unable to get a DB Connection"))
+			Expect(report.Targets[0].ErrorCategory).To(Equal(runner.FailureTransientDB))
+			Expect(report.Targets[1].Target).To(Equal("uaa-west"))
+			Expect(finished).To(BeEmpty())
+		})
+
+		It("should only retry the user ids listed for each target", func() {
+			options.TargetUserIDs = map[string][]string{"uaa-west": {"user-1"}}
+
+			report, err := runner.Run(context.Background(), options)
+			Expect(err).To(MatchError("1 of 2 targets failed"))
+			Expect(report.Targets[0].Target).To(Equal("uaa-east"))
+			Expect(report.Targets[0].Succeeded).To(BeTrue())
+			Expect(report.Targets[0].RowsWritten).To(BeZero())
+			Expect(report.Targets[1].Target).To(Equal("uaa-west"))
+			Expect(report.Targets[1].Error).To(ContainSubstring("unable to get a DB Connection"))
+		})
+
+		It("should refuse user ids listed for a target the config does not list", func() {
+			options.TargetUserIDs = map[string][]string{"uaa-east": {"user-1"}, "uaa-north": {"user-2"}}
+
+			_, err := runner.Run(context.Background(), options)
+			Expect(err).To(MatchError("invalid options: user ids are listed for target 'uaa-north', which the config does not list"))
+		})
+	})
+})
@@ -0,0 +1,127 @@
+package runner
+
+import (
+	"encoding/json"
+	"github.com/cloudfoundry/uaa-key-rotator/config"
+	"net/http"
+	"time"
+)
+
+// Report is the outcome of a run, with one TargetReport per target in the
+// order they are listed.
+type Report struct {
+	Targets []TargetReport
+	// AuditHeadMAC is the MAC of the run_finished entry of the audit log,
+	// which `audit verify -head` checks the log still ends at.
+	AuditHeadMAC string
+}
+
+// Failed counts the targets that failed.
+func (r Report) Failed() int {
+	failed := 0
+	for _, target := range r.Targets {
+		if !target.Succeeded {
+			failed++
+		}
+	}
+	return failed
+}
+
+// DominantRowFailure returns the category most of the failed rows of every
+// target had, or an empty category when no row failed.
+func (r Report) DominantRowFailure() FailureCategory {
+	counts := map[FailureCategory]int64{}
+	for _, target := range r.Targets {
+		for category, count := range target.RowsFailedByCategory {
+			counts[category] += count
+		}
+	}
+
+	var dominant FailureCategory
+	var dominantCount int64
+	for _, category := range failureCategories {
+		if counts[category] > dominantCount {
+			dominant = category
+			dominantCount = counts[category]
+		}
+	}
+	return dominant
+}
+
+// TargetReport is the outcome of rotating one target. For a watch, the row
+// counts are those of its last pass.
+type TargetReport struct {
+	Target        string `json:"target"`
+	Succeeded     bool   `json:"succeeded"`
+	RowsWritten   int64  `json:"rows_written"`
+	RowsFailed    int64  `json:"rows_failed"`
+	RowsPreviewed int64  `json:"rows_previewed,omitempty"`
+	Error         string `json:"error,omitempty"`
+	// ErrorCategory is the category of Error, and RowsFailedByCategory
+	// that of each failed row.
+	ErrorCategory        FailureCategory           `json:"error_category,omitempty"`
+	RowsFailedByCategory map[FailureCategory]int64 `json:"rows_failed_by_category,omitempty"`
+
+	err error
+}
+
+func newTargetReport(name string, stats PassStats, err error) TargetReport {
+	report := TargetReport{
+		Target:        name,
+		Succeeded:     err == nil,
+		RowsWritten:   stats.Written,
+		RowsFailed:    stats.Failed,
+		RowsPreviewed: stats.Previewed,
+		err:           err,
+	}
+	if stats.Failed > 0 {
+		report.RowsFailedByCategory = stats.Failures.ByCategory()
+	}
+	if err != nil {
+		report.Error = err.Error()
+		report.ErrorCategory = Categorize(err)
+	}
+	return report
+}
+
+// targetsHealth reports on the watches of every target. It is healthy while
+// each of them is. A config without targets reports on its one watch alone.
+type targetsHealth struct {
+	names   []string
+	targets []*watchHealth
+}
+
+type targetsStatus struct {
+	Healthy bool                   `json:"healthy"`
+	Targets map[string]watchStatus `json:"targets"`
+}
+
+func newTargetsHealth(targets []config.Target, interval time.Duration) *targetsHealth {
+	health := &targetsHealth{}
+	for _, target := range targets {
+		health.names = append(health.names, target.Name)
+		health.targets = append(health.targets, &watchHealth{interval: interval})
+	}
+	return health
+}
+
+func (h *targetsHealth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if len(h.targets) == 1 && h.names[0] == "" {
+		h.targets[0].ServeHTTP(w, r)
+		return
+	}
+
+	now := time.Now()
+	status := targetsStatus{Healthy: true, Targets: map[string]watchStatus{}}
+	for i, target := range h.targets {
+		targetStatus := target.status(now)
+		status.Healthy = status.Healthy && targetStatus.Healthy
+		status.Targets[h.names[i]] = targetStatus
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,99 @@
+package runner
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// PassStats counts the rows handled by one pass of the rotation.
+type PassStats struct {
+	Written   int64
+	Failed    int64
+	Previewed int64
+	// Failures counts the Failed rows by the category of their error.
+	Failures FailureCounts
+}
+
+// watchHealth tracks the passes of a watch and reports on them over HTTP.
+// A watch is healthy while its last pass succeeded and passes keep coming:
+// one is running, or the last one finished within two intervals.
+type watchHealth struct {
+	mutex        sync.Mutex
+	interval     time.Duration
+	passes       int
+	running      bool
+	lastStarted  time.Time
+	lastFinished time.Time
+	lastStats    PassStats
+	lastErr      error
+}
+
+type watchStatus struct {
+	Healthy             bool   `json:"healthy"`
+	Passes              int    `json:"passes"`
+	Running             bool   `json:"running"`
+	LastPassStarted     string `json:"last_pass_started,omitempty"`
+	LastPassFinished    string `json:"last_pass_finished,omitempty"`
+	LastPassRowsWritten int64  `json:"last_pass_rows_written"`
+	LastPassRowsFailed  int64  `json:"last_pass_rows_failed"`
+	LastPassError       string `json:"last_pass_error,omitempty"`
+
+	LastPassRowsFailedByCategory map[FailureCategory]int64 `json:"last_pass_rows_failed_by_category,omitempty"`
+	LastPassErrorCategory        FailureCategory           `json:"last_pass_error_category,omitempty"`
+}
+
+func (h *watchHealth) passStarted(now time.Time) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.running = true
+	h.lastStarted = now
+}
+
+func (h *watchHealth) passFinished(now time.Time, stats PassStats, err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.running = false
+	h.passes++
+	h.lastFinished = now
+	h.lastStats = stats
+	h.lastErr = err
+}
+
+func (h *watchHealth) status(now time.Time) watchStatus {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	status := watchStatus{
+		Healthy:             h.lastErr == nil && (h.running || now.Sub(h.lastFinished) <= 2*h.interval),
+		Passes:              h.passes,
+		Running:             h.running,
+		LastPassRowsWritten: h.lastStats.Written,
+		LastPassRowsFailed:  h.lastStats.Failed,
+	}
+	if !h.lastStarted.IsZero() {
+		status.LastPassStarted = h.lastStarted.UTC().Format(time.RFC3339)
+	}
+	if !h.lastFinished.IsZero() {
+		status.LastPassFinished = h.lastFinished.UTC().Format(time.RFC3339)
+	}
+	if h.lastStats.Failed > 0 {
+		status.LastPassRowsFailedByCategory = h.lastStats.Failures.ByCategory()
+	}
+	if h.lastErr != nil {
+		status.LastPassError = h.lastErr.Error()
+		status.LastPassErrorCategory = Categorize(h.lastErr)
+	}
+	return status
+}
+
+func (h *watchHealth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := h.status(time.Now())
+
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
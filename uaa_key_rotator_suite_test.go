@@ -41,7 +41,7 @@ var _ = BeforeSuite(func() {
 
 	By("migrating UAA database", testutils.MigrateUaaDatabase)
 
-	activeKey = config.EncryptionKey{Label: "active-key", Passphrase: "123"}
+	activeKey = config.EncryptionKey{Label: "active-key", Passphrase: config.Passphrase("123")}
 
 	By("clearing database of any records", func() {
 		_, err := db.Exec(`truncate user_google_mfa_credentials;`)
@@ -54,7 +54,7 @@ var _ = BeforeSuite(func() {
 func testFixtures() {
 	oldKey = config.EncryptionKey{
 		Label:      "old-key-label",
-		Passphrase: "321",
+		Passphrase: config.Passphrase("321"),
 	}
 
 	secretKeyCipherValue := encryptPlainText("secret-key", string(oldKey.Passphrase))
@@ -64,11 +64,11 @@ func testFixtures() {
 	mfaCredential := entity.MfaCredential{
 		UserId:                  "user-id-1",
 		SecretKey:               secretKeyCipherValue,
-		ScratchCodes:            scratchCodesCipherValue,
+		ScratchCodes:            sql.NullString{String: scratchCodesCipherValue, Valid: true},
 		MfaProviderId:           "mfa_provider_id",
 		ZoneId:                  "zone_id",
 		EncryptionKeyLabel:      oldKey.Label,
-		EncryptedValidationCode: encryptedValidationCodesCipherValue,
+		EncryptedValidationCode: sql.NullString{String: encryptedValidationCodesCipherValue, Valid: true},
 		ValidationCode:          sql.NullInt64{Int64: 1234, Valid: true},
 	}
 
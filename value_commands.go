@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"github.com/cloudfoundry/uaa-key-rotator/config"
+	"github.com/cloudfoundry/uaa-key-rotator/crypto"
+	"github.com/cloudfoundry/uaa-key-rotator/keyprovider"
+	"github.com/cloudfoundry/uaa-key-rotator/rotator"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// encryptCommand implements "encrypt", which encrypts plaintext read from
+// stdin into a stored value, for example to craft a test fixture.
+func encryptCommand(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("encrypt", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := flags.String("config", "", "Path to uaa key rotator config file")
+	label := flags.String("label", "", "Label of the key to encrypt with (defaults to the active key)")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	keyService, _, wipe, err := commandKeyService(*configPath)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer wipe()
+	if *label != "" {
+		keyService.ActiveKeyLabel = *label
+	}
+
+	plainText, err := ioutil.ReadAll(stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to read plaintext: %s\n", err)
+		return 1
+	}
+	defer crypto.Zero(plainText)
+	plainText = bytes.TrimSuffix(bytes.TrimSuffix(plainText, []byte("\n")), []byte("\r"))
+
+	keyLabel, encryptor, err := keyService.ActiveKey(context.Background())
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	encryptedValue, err := encryptor.EncryptBytes(plainText)
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to encrypt: %s\n", err)
+		return 1
+	}
+	storedValue, err := crypto.UAAEnvelopeCodec{}.Encode(encryptedValue)
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to encrypt: %s\n", err)
+		return 1
+	}
+
+	fmt.Fprintf(stderr, "encrypted with key %s\n", keyLabel)
+	fmt.Fprintln(stdout, storedValue)
+	return 0
+}
+
+// decryptCommand implements "decrypt", which reports which configured key a
+// stored value decrypts with. The plaintext is only printed with
+// -show-plaintext.
+func decryptCommand(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := flags.String("config", "", "Path to uaa key rotator config file")
+	label := flags.String("label", "", "Label of the key to decrypt with (defaults to trying every configured key)")
+	showPlainText := flags.Bool("show-plaintext", false, "Print the decrypted plaintext")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	storedValue, err := commandValue(flags.Args(), stdin)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	keyService, labels, wipe, err := commandKeyService(*configPath)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer wipe()
+
+	encryptedValue, err := crypto.UAAEnvelopeCodec{}.Decode(storedValue)
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to decode value: %s\n", err)
+		return 1
+	}
+
+	if *label != "" {
+		labels = []string{*label}
+	}
+
+	for _, keyLabel := range labels {
+		decryptor, err := keyService.Key(context.Background(), keyLabel)
+		if err != nil {
+			fmt.Fprintf(stderr, "key %s: %s\n", keyLabel, err)
+			continue
+		}
+
+		plainText, err := decryptor.DecryptBytes(encryptedValue)
+		if wiper, ok := decryptor.(crypto.Wiper); ok {
+			wiper.Wipe()
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "key %s: %s\n", keyLabel, err)
+			continue
+		}
+
+		fmt.Fprintf(stderr, "decrypts with key %s: %d bytes of plaintext\n", keyLabel, len(plainText))
+		if *showPlainText {
+			fmt.Fprintf(stdout, "%s\n", plainText)
+		}
+		crypto.Zero(plainText)
+		return 0
+	}
+
+	fmt.Fprintln(stderr, "value does not decrypt with any of the keys tried")
+	return 1
+}
+
+// inspectCommand implements "inspect", which describes the layout of a
+// stored value without a key.
+func inspectCommand(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	storedValue, err := commandValue(flags.Args(), stdin)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	inspection := crypto.InspectEnvelope(storedValue)
+	fmt.Fprintf(stdout, "envelope:     %d bytes\n", inspection.Length)
+	fmt.Fprintf(stdout, "nonce:        %d bytes %s\n", len(inspection.Nonce), hex.EncodeToString(inspection.Nonce))
+	fmt.Fprintf(stdout, "salt:         %d bytes %s\n", len(inspection.Salt), hex.EncodeToString(inspection.Salt))
+	fmt.Fprintf(stdout, "ciphertext:   %d bytes, including the %d byte GCM tag\n", inspection.CipherValueLength, crypto.GCMTagLength)
+	fmt.Fprintf(stdout, "plaintext:    %d bytes\n", inspection.PlainTextLength)
+
+	if !inspection.Valid() {
+		for _, problem := range inspection.Problems {
+			fmt.Fprintf(stdout, "problem:      %s\n", problem)
+		}
+		return 1
+	}
+	fmt.Fprintln(stdout, "valid UAA envelope")
+	return 0
+}
+
+// commandValue returns the stored value given as the only argument, or else
+// read from the first line of stdin.
+func commandValue(args []string, stdin io.Reader) (string, error) {
+	switch len(args) {
+	case 0:
+		line, err := bufio.NewReader(stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", errors.Wrap(err, "unable to read value")
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	case 1:
+		return args[0], nil
+	}
+	return "", errors.New("expected a single value")
+}
+
+// commandKeyService returns the key service of a config, along with the
+// labels of its keys, active key first, and a func that wipes them.
+func commandKeyService(configPath string) (rotator.UaaKeyService, []string, func(), error) {
+	configFile, err := os.Open(configPath)
+	if err != nil {
+		return rotator.UaaKeyService{}, nil, nil, errors.Wrap(err, "unable to open config")
+	}
+	defer configFile.Close()
+
+	rotatorConfig, err := config.New(configFile)
+	if err != nil {
+		return rotator.UaaKeyService{}, nil, nil, errors.Wrap(err, "unable to parse config")
+	}
+
+	keyProvider, err := keyprovider.FromConfig(rotatorConfig)
+	if err != nil {
+		rotatorConfig.WipePassphrases()
+		return rotator.UaaKeyService{}, nil, nil, errors.Wrap(err, "unable to configure key provider")
+	}
+
+	wipe := func() {
+		if wiper, ok := keyProvider.(crypto.Wiper); ok {
+			wiper.Wipe()
+		}
+		rotatorConfig.WipePassphrases()
+	}
+	return rotator.UaaKeyService{
+		ActiveKeyLabel: rotatorConfig.ActiveKeyLabel,
+		KeyProvider:    keyProvider,
+	}, keyprovider.Labels(rotatorConfig), wipe, nil
+}
@@ -0,0 +1,83 @@
+package main_test
+
+import (
+	"encoding/json"
+	"github.com/cloudfoundry/uaa-key-rotator/config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gexec"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var _ = Describe("Value commands", func() {
+	var configPath string
+
+	BeforeEach(func() {
+		jsonConfig, err := json.Marshal(config.RotatorConfig{
+			ActiveKeyLabel:   "active-key",
+			EncryptionKeys:   []config.EncryptionKey{{Label: "active-key", Passphrase: config.Passphrase("123")}, oldKey},
+			DatabaseHostname: "unused",
+			DatabasePort:     "1",
+			DatabaseScheme:   "postgres",
+			DatabaseName:     "uaa",
+			DatabaseUsername: "uaa",
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		configFile, err := ioutil.TempFile(os.TempDir(), "rotator_config")
+		Expect(err).NotTo(HaveOccurred())
+		configPath = configFile.Name()
+		configFile.Close()
+		Expect(ioutil.WriteFile(configPath, jsonConfig, 0600)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.Remove(configPath)
+	})
+
+	run := func(stdin string, args ...string) *gexec.Session {
+		cmd := exec.Command(uaaRotatorBuildPath, args...)
+		cmd.Stdin = strings.NewReader(stdin)
+		session, err := gexec.Start(cmd, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(session, 10).Should(gexec.Exit())
+		return session
+	}
+
+	It("should encrypt a value that decrypts with the same key", func() {
+		encrypted := run("some-secret\n", "encrypt", "-config", configPath, "-label", oldKey.Label)
+		Expect(encrypted.ExitCode()).To(Equal(0))
+		storedValue := strings.TrimSpace(string(encrypted.Out.Contents()))
+		Expect(decryptCipherValue(storedValue, string(oldKey.Passphrase))).To(Equal("some-secret"))
+
+		decrypted := run("", "decrypt", "-config", configPath, storedValue)
+		Expect(decrypted.ExitCode()).To(Equal(0))
+		Expect(string(decrypted.Err.Contents())).To(ContainSubstring("decrypts with key " + oldKey.Label))
+		Expect(decrypted.Out.Contents()).To(BeEmpty())
+
+		decrypted = run(storedValue+"\n", "decrypt", "-config", configPath, "-show-plaintext")
+		Expect(decrypted.ExitCode()).To(Equal(0))
+		Expect(string(decrypted.Out.Contents())).To(Equal("some-secret\n"))
+
+		decrypted = run("", "decrypt", "-config", configPath, "-label", "active-key", storedValue)
+		Expect(decrypted.ExitCode()).To(Equal(1))
+		Expect(string(decrypted.Err.Contents())).To(ContainSubstring("value does not decrypt with any of the keys tried"))
+	})
+
+	It("should inspect a value without a key", func() {
+		encrypted := run("some-secret", "encrypt", "-config", configPath)
+		storedValue := strings.TrimSpace(string(encrypted.Out.Contents()))
+
+		inspected := run("", "inspect", storedValue)
+		Expect(inspected.ExitCode()).To(Equal(0))
+		Expect(string(inspected.Out.Contents())).To(ContainSubstring("plaintext:    11 bytes"))
+		Expect(string(inspected.Out.Contents())).To(ContainSubstring("valid UAA envelope"))
+
+		inspected = run("", "inspect", "c2hvcnQ=")
+		Expect(inspected.ExitCode()).To(Equal(1))
+		Expect(string(inspected.Out.Contents())).To(ContainSubstring("problem:      envelope should be at least 60 bytes in length but was 5"))
+	})
+})